@@ -10,8 +10,10 @@ import (
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
 )
 
+const pathToEnv = "./init/.env"
+
 func main() {
-	cfg, err := config.NewConfig("./init/.env")
+	cfg, err := config.NewConfig(pathToEnv)
 	if err != nil {
 		log.Fatalf("main: config error - {%v};", err)
 	}
@@ -24,8 +26,16 @@ func main() {
 	application.Run()
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	// SIGHUP - reload keys for jwt
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if err := application.Reload(pathToEnv); err != nil {
+			log.Printf("main: reload error - {%v};", err)
+		}
+	}
 
 	application.Stop()
 }
@@ -3,37 +3,53 @@ package app
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"net/http"
 	"time"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
 	"google.golang.org/grpc"
 
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db/migration"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/jwks"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/mailer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/listen"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service"
 )
 
 // Application - contains a server, server listener,business service, an interface for working with the store
+// jwksSrv - http server with public keys for jwt, nil if disabled
 type Application struct {
 	userRepository db.Provider
 	userService    service.Service
 	srv            *grpc.Server
 	listener       net.Listener
+	jwksSrv        *http.Server
 }
 
 // NewApplication
-// create: secretKey for jwt,do migration, net.Listener for server, open pgx.pool, service.NewService, grpc.NewServer
+// create: keyring for jwt, hasher of passwords, do migration, net.Listener for server, open pgx.pool, service.NewService, grpc.NewServer
 // save all main variables inside &Application{}
 func NewApplication(cfg *config.Config) (*Application, error) {
 	log.Print("app: NewApplication start")
 	ctx := context.Background()
 
-	if err := jwtsign.NewSecretKey(cfg); err != nil {
+	if err := jwtsign.LoadKeyring(&cfg.JWT); err != nil {
+		return nil, err
+	}
+	if err := password.Configure(&cfg.Password); err != nil {
+		return nil, err
+	}
+	mail, err := mailer.New(&cfg.Mail)
+	if err != nil {
 		return nil, err
 	}
 
@@ -54,20 +70,66 @@ func NewApplication(cfg *config.Config) (*Application, error) {
 
 	app := &Application{}
 	app.userRepository = dbProvider
-	app.userService = service.NewService(service.NewDepends(dbProvider))
-	app.srv = grpc.NewServer(grpc.UnaryInterceptor(service.Authorization))
+	dep := service.NewDepends(dbProvider)
+	dep.AdminKey = cfg.Admin.APIKey
+	dep.Login = cfg.Login
+	dep.RateLimit = cfg.RateLimit
+	dep.User = cfg.User
+	dep.Idempotency = cfg.Idempotency
+	dep.Mailer = mail
+	dep.Mail = cfg.Mail
+	dep.MFA = cfg.MFA
+	userService, err := service.NewService(dep)
+	if err != nil {
+		return nil, err
+	}
+	app.userService = userService
+	app.srv = grpc.NewServer(grpc.ChainUnaryInterceptor(
+		app.userService.ErrorStatus,
+		app.userService.Authorization,
+		app.userService.RateLimit,
+		app.userService.Idempotency,
+	))
 	app.listener = listener
+	if cfg.Server.JWKSPort != 0 {
+		app.jwksSrv = jwks.NewServer(&cfg.Server)
+	}
 
 	log.Print("app: NewApplication is created")
 
 	return app, nil
 }
 
-// Run - registers server then start server inside go func()
+// Reload - read config again and replace keyring for jwt and parameters of password hashes without restart
+// new keyring and parameters are built first, error of any of them leaves the old config in effect
+func (a *Application) Reload(pathToEnv string) error {
+	log.Print("app: Reload")
+
+	cfg, err := config.ReloadConfig(pathToEnv)
+	if err != nil {
+		return err
+	}
+	kr, err := jwtsign.NewKeyring(&cfg.JWT)
+	if err != nil {
+		return err
+	}
+	settings, err := password.Prepare(&cfg.Password)
+	if err != nil {
+		return err
+	}
+
+	jwtsign.SetKeyring(kr)
+	password.Apply(settings)
+	return nil
+}
+
+// Run - registers servers then start server inside go func()
 func (a *Application) Run() {
 	log.Print("app: Run")
 
 	user.RegisterUserServiceServer(a.srv, a.userService)
+	auth.RegisterAuthServiceServer(a.srv, a.userService)
+	admin.RegisterAdminServiceServer(a.srv, a.userService)
 
 	go func() {
 		log.Print("go app: start server")
@@ -77,9 +139,18 @@ func (a *Application) Run() {
 		}
 		log.Print("go app: stopped serving")
 	}()
+
+	if a.jwksSrv != nil {
+		go func() {
+			log.Printf("go app: start jwks server - {%s};", a.jwksSrv.Addr)
+			if err := a.jwksSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("go app: jwks server error - {%v};", err)
+			}
+		}()
+	}
 }
 
-// Stop - close pgx.pool, call GracefulStop() with select {<- ctx, time.After}
+// Stop - close pgx.pool after background jobs of service, stop jwks server, call GracefulStop() with select {<- ctx, time.After}
 func (a *Application) Stop() {
 	log.Print("app: Stop")
 
@@ -91,7 +162,15 @@ func (a *Application) Stop() {
 	})
 	defer func() {
 		timer.Stop()
+		if a.jwksSrv != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := a.jwksSrv.Shutdown(ctx); err != nil {
+				log.Printf("app: jwks server shutdown error - {%v};", err)
+			}
+		}
 		_ = a.listener.Close()
+		a.userService.Wait()
 		a.userRepository.ClosePool()
 	}()
 
@@ -2,9 +2,13 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v11"
@@ -13,15 +17,26 @@ import (
 	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
 )
 
-var ErrConfigEmpty = errors.New("empty")
+var (
+	ErrConfigEmpty = errors.New("empty")
 
-// Config - contains url for database, server port with server network, secret key for jwt
-type Config struct {
-	DB         DataBaseConfig  `envPrefix:"DB_"`
-	Migrations MigrationConfig `envPrefix:"MIGRATION_"`
-	Server     ServerConfig    `envPrefix:"SRV_"`
+	ErrConfigInvalid = errors.New("invalid")
+)
 
-	JWTSecretKey string `env:"JWT_SECRET"`
+// Config - contains url for database, server port with server network, keys for jwt
+type Config struct {
+	DB          DataBaseConfig    `envPrefix:"DB_"`
+	Migrations  MigrationConfig   `envPrefix:"MIGRATION_"`
+	Server      ServerConfig      `envPrefix:"SRV_"`
+	JWT         JWTConfig         `envPrefix:"JWT_"`
+	Password    PasswordConfig    `envPrefix:"PASSWORD_"`
+	Admin       AdminConfig       `envPrefix:"ADMIN_"`
+	Login       LoginConfig       `envPrefix:"LOGIN_"`
+	RateLimit   RateLimitConfig   `envPrefix:"RATE_LIMIT_"`
+	User        UserConfig        `envPrefix:"USER_"`
+	Idempotency IdempotencyConfig `envPrefix:"IDEMPOTENCY_"`
+	Mail        MailConfig        `envPrefix:"MAIL_"`
+	MFA         MFAConfig         `envPrefix:"MFA_"`
 
 	msgErr utils.Message `env:"-"`
 }
@@ -31,7 +46,7 @@ func NewConfig(pathToEnv string) (*Config, error) {
 	log.Print("config: config start")
 
 	cfg := &Config{msgErr: utils.Message{}}
-	if err := cfg.parse(pathToEnv); err != nil {
+	if err := cfg.parse(pathToEnv, godotenv.Load); err != nil {
 		return nil, fmt.Errorf("config: env.Parse error - {%w};", err)
 	}
 
@@ -44,8 +59,27 @@ func NewConfig(pathToEnv string) (*Config, error) {
 	return cfg, nil
 }
 
-func (cfg *Config) parse(pathToEnv string) error {
-	if err := godotenv.Load(pathToEnv); err != nil {
+// ReloadConfig - same as NewConfig, but values from .env file override ENV variables
+// used to apply changes of the file to a running application
+func ReloadConfig(pathToEnv string) (*Config, error) {
+	log.Print("config: reload start")
+
+	cfg := &Config{msgErr: utils.Message{}}
+	if err := cfg.parse(pathToEnv, godotenv.Overload); err != nil {
+		return nil, fmt.Errorf("config: env.Parse error - {%w};", err)
+	}
+
+	if !cfg.validConfig() {
+		return nil, fmt.Errorf("config: invalid config - {%s}", cfg.msgErr.String())
+	}
+
+	log.Print("config: config reloaded")
+
+	return cfg, nil
+}
+
+func (cfg *Config) parse(pathToEnv string, load func(filenames ...string) error) error {
+	if err := load(pathToEnv); err != nil {
 		// work with ENV
 		log.Printf("config: .env file error - {%v};", err)
 	}
@@ -64,10 +98,15 @@ func (cfg *Config) validConfig() bool {
 	cfg.DB.validConfig(cfg.msgErr)
 	cfg.Migrations.validConfig(cfg.msgErr)
 	cfg.Server.validConfig(cfg.msgErr)
+	cfg.JWT.validConfig(cfg.msgErr)
+	cfg.Password.validConfig(cfg.msgErr)
+	cfg.Login.validConfig(cfg.msgErr)
+	cfg.RateLimit.validConfig(cfg.msgErr)
+	cfg.Idempotency.validConfig(cfg.msgErr)
+	cfg.User.validConfig(cfg.msgErr)
+	cfg.Mail.validConfig(cfg.msgErr)
+	cfg.MFA.validConfig(cfg.msgErr)
 
-	if cfg.JWTSecretKey == "" {
-		cfg.msgErr["jwt-secret-key"] = ErrConfigEmpty
-	}
 	return len(cfg.msgErr) == 0
 }
 
@@ -86,6 +125,11 @@ type DataBaseConfig struct {
 	ConnMaxIdleTime   time.Duration `env:"CONN_MAX_IDLE_TIME"`
 	ConnTime          time.Duration `env:"CONN_TIMEOUT"`
 	HealthCheckPeriod time.Duration `env:"HEALTH_CHECK_PERIOD"`
+
+	// TxIsolation - isolation level of transactions: "read committed", "repeatable read", "serializable" (default)
+	// TxMaxRetries - retries of transaction after serialization failure or deadlock (3)
+	TxIsolation  string `env:"TX_ISOLATION"`
+	TxMaxRetries uint8  `env:"TX_MAX_RETRIES"`
 }
 
 func (cfgDB *DataBaseConfig) url() string {
@@ -132,6 +176,11 @@ func (cfgDB *DataBaseConfig) validConfig(msgErr utils.Message) {
 	if cfgDB.HealthCheckPeriod == 0 {
 		msgErr["db-health-check-period"] = ErrConfigEmpty
 	}
+	switch cfgDB.TxIsolation {
+	case "", "read committed", "repeatable read", "serializable":
+	default:
+		msgErr["db-tx-isolation"] = ErrConfigInvalid
+	}
 }
 
 type MigrationConfig struct {
@@ -145,9 +194,11 @@ func (cfgMig *MigrationConfig) validConfig(msgErr utils.Message) {
 	}
 }
 
+// ServerConfig - JWKSPort is port of http server with public keys for jwt, 0 - server is not started
 type ServerConfig struct {
-	Port    uint16 `env:"PORT"`
-	Network string `env:"NETWORK"`
+	Port     uint16 `env:"PORT"`
+	Network  string `env:"NETWORK"`
+	JWKSPort uint16 `env:"JWKS_PORT"`
 }
 
 func (cfgSrv *ServerConfig) validConfig(msgErr utils.Message) {
@@ -157,4 +208,352 @@ func (cfgSrv *ServerConfig) validConfig(msgErr utils.Message) {
 	if cfgSrv.Network == "" {
 		msgErr["srv-network"] = ErrConfigEmpty
 	}
+	if cfgSrv.JWKSPort != 0 && cfgSrv.JWKSPort == cfgSrv.Port {
+		msgErr["srv-jwks-port"] = ErrConfigInvalid
+	}
+}
+
+// DefaultKeyID - "kid" of 'JWTConfig.Secret', tokens without "kid" are checked by this key
+const DefaultKeyID = "default"
+
+// JWTConfig - keys for signing and verification of tokens
+// Secret - single key (first version of config), gets "kid" 'DefaultKeyID'
+// Keys - "kid:secret" pairs separated by comma, all of them verify tokens
+// KeyFiles - "kid:path" pairs separated by comma, path to PEM file with private key (RSA, EC, Ed25519)
+// or public key (verification only)
+// CurrentKeyID - "kid" of key which signs new tokens, key is retired by removing it from Keys or KeyFiles
+// Issuer - "iss" of tokens, token of other issuer is rejected
+// Audience - "aud" of tokens separated by comma, first is this service, token without it is rejected
+// AccessTTL - life of access token (15m if not set)
+// IdleTimeout - life of refresh token, session without refresh for this time is over (720h if not set)
+// SessionMaxAge - absolute length of session from login, 0 - without limit
+// SlidingSession - access token is renewed in response header while the client is active
+type JWTConfig struct {
+	Secret       string            `env:"SECRET"`
+	Keys         map[string]string `env:"KEYS"`
+	KeyFiles     map[string]string `env:"KEY_FILES"`
+	CurrentKeyID string            `env:"CURRENT_KID"`
+	Issuer       string            `env:"ISSUER"`
+	Audience     []string          `env:"AUDIENCE"`
+
+	AccessTTL      time.Duration `env:"ACCESS_TTL"`
+	IdleTimeout    time.Duration `env:"IDLE_TIMEOUT"`
+	SessionMaxAge  time.Duration `env:"SESSION_MAX_AGE"`
+	SlidingSession bool          `env:"SLIDING_SESSION"`
+}
+
+// AllKeys - Keys with Secret under 'DefaultKeyID'
+func (cfgJWT *JWTConfig) AllKeys() map[string]string {
+	keys := make(map[string]string, len(cfgJWT.Keys)+1)
+	for kid, secret := range cfgJWT.Keys {
+		keys[kid] = secret
+	}
+	if cfgJWT.Secret != "" {
+		keys[DefaultKeyID] = cfgJWT.Secret
+	}
+	return keys
+}
+
+// SignerKeyID - CurrentKeyID, 'DefaultKeyID' if not set
+func (cfgJWT *JWTConfig) SignerKeyID() string {
+	if cfgJWT.CurrentKeyID == "" {
+		return DefaultKeyID
+	}
+	return cfgJWT.CurrentKeyID
+}
+
+func (cfgJWT *JWTConfig) validConfig(msgErr utils.Message) {
+	if cfgJWT.Issuer == "" {
+		msgErr["jwt-issuer"] = ErrConfigEmpty
+	}
+	if len(cfgJWT.Audience) == 0 {
+		msgErr["jwt-audience"] = ErrConfigEmpty
+	}
+	for _, aud := range cfgJWT.Audience {
+		if aud == "" {
+			msgErr["jwt-audience"] = ErrConfigInvalid
+		}
+	}
+	if cfgJWT.AccessTTL < 0 {
+		msgErr["jwt-access-ttl"] = ErrConfigInvalid
+	}
+	if cfgJWT.IdleTimeout < 0 {
+		msgErr["jwt-idle-timeout"] = ErrConfigInvalid
+	}
+	if cfgJWT.SessionMaxAge < 0 || (cfgJWT.SessionMaxAge > 0 && cfgJWT.SessionMaxAge < cfgJWT.AccessTTL) {
+		msgErr["jwt-session-max-age"] = ErrConfigInvalid
+	}
+	keys := cfgJWT.AllKeys()
+	if len(keys) == 0 && len(cfgJWT.KeyFiles) == 0 {
+		msgErr["jwt-keys"] = ErrConfigEmpty
+		return
+	}
+	for kid, secret := range keys {
+		if kid == "" || secret == "" {
+			msgErr["jwt-keys"] = ErrConfigInvalid
+		}
+	}
+	for kid, path := range cfgJWT.KeyFiles {
+		if kid == "" || path == "" {
+			msgErr["jwt-key-files"] = ErrConfigInvalid
+		}
+		if _, ex := keys[kid]; ex {
+			msgErr["jwt-key-files"] = ErrConfigInvalid
+		}
+	}
+	current := cfgJWT.SignerKeyID()
+	_, exKey := keys[current]
+	_, exFile := cfgJWT.KeyFiles[current]
+	if !exKey && !exFile {
+		msgErr["jwt-current-kid"] = ErrConfigInvalid
+	}
+}
+
+// PasswordConfig - parameters of argon2id for new password hashes and policy of new passwords, default if not set
+// Argon2Memory - memory in KiB (65536), Argon2Time - number of iterations (3), Argon2Threads - parallelism (4)
+// hashes with other parameters are replaced on the next successful login
+// MinLength (8), MaxLength (128) - length of password in characters
+// MinClasses - number of character classes (lower, upper, digit, symbol) in password, 0 - not checked
+// MinStrength - score of strength from 0 (guessed at once) to 4 (very hard to guess), 0 - not checked
+// DisallowPersonal - password must not contain login, email or name of user
+// BreachedFile - path to sorted file with SHA-1 of breached passwords "<HASH>:<COUNT>", empty - not checked
+type PasswordConfig struct {
+	Argon2Memory  uint32 `env:"ARGON2_MEMORY"`
+	Argon2Time    uint32 `env:"ARGON2_TIME"`
+	Argon2Threads uint8  `env:"ARGON2_THREADS"`
+
+	MinLength        uint16 `env:"MIN_LENGTH"`
+	MaxLength        uint16 `env:"MAX_LENGTH"`
+	MinClasses       uint8  `env:"MIN_CLASSES"`
+	MinStrength      uint8  `env:"MIN_STRENGTH"`
+	DisallowPersonal bool   `env:"DISALLOW_PERSONAL"`
+	BreachedFile     string `env:"BREACHED_FILE"`
+}
+
+func (cfgPass *PasswordConfig) validConfig(msgErr utils.Message) {
+	if cfgPass.MaxLength != 0 && cfgPass.MaxLength < cfgPass.MinLength {
+		msgErr["password-max-length"] = ErrConfigInvalid
+	}
+	if cfgPass.MinClasses > 4 {
+		msgErr["password-min-classes"] = ErrConfigInvalid
+	}
+	if cfgPass.MinStrength > 4 {
+		msgErr["password-min-strength"] = ErrConfigInvalid
+	}
+}
+
+// AdminConfig - APIKey is key of AdminService from metadata "x-admin-key", empty - AdminService is disabled
+type AdminConfig struct {
+	APIKey string `env:"API_KEY"`
+}
+
+// UserConfig - ClientTimestamps - 'created_at' of UserRegister and 'updated_at' of UserUpdate are taken from request
+// false (default) - they are assigned by clock of database, values of request are ignored
+// RequireVerifiedEmail - UserLogin is rejected until email of user is verified
+// VerifyEmailTTL - life of token of email verification (24h)
+// ResetPasswordTTL - life of code of password reset (1h)
+// StepUpTTL - life of step-up token of StepUp (5m)
+// ChangeEmailTTL - life of token of confirmation of new email (24h)
+type UserConfig struct {
+	ClientTimestamps     bool          `env:"CLIENT_TIMESTAMPS"`
+	RequireVerifiedEmail bool          `env:"REQUIRE_VERIFIED_EMAIL"`
+	VerifyEmailTTL       time.Duration `env:"VERIFY_EMAIL_TTL"`
+	ResetPasswordTTL     time.Duration `env:"RESET_PASSWORD_TTL"`
+	StepUpTTL            time.Duration `env:"STEP_UP_TTL"`
+	ChangeEmailTTL       time.Duration `env:"CHANGE_EMAIL_TTL"`
+}
+
+func (cfgUser *UserConfig) validConfig(msgErr utils.Message) {
+	if cfgUser.VerifyEmailTTL < 0 {
+		msgErr["user-verify-email-ttl"] = ErrConfigInvalid
+	}
+	if cfgUser.ResetPasswordTTL < 0 {
+		msgErr["user-reset-password-ttl"] = ErrConfigInvalid
+	}
+	if cfgUser.StepUpTTL < 0 {
+		msgErr["user-step-up-ttl"] = ErrConfigInvalid
+	}
+	if cfgUser.ChangeEmailTTL < 0 {
+		msgErr["user-change-email-ttl"] = ErrConfigInvalid
+	}
+}
+
+// senders of mail
+const (
+	MailSenderMemory = "memory"
+	MailSenderFile   = "file"
+	MailSenderSMTP   = "smtp"
+)
+
+// MailConfig - Sender (required) - "smtp", "file" (every message is a file in Dir) or "memory" (messages are only logged)
+// From - address of sender, required for "smtp"
+// VerifyEmailURL - link in mail of email verification, token is added as query "token", empty - mail contains only token
+// ResetPasswordURL - link in mail of password reset, code is added as query "code", empty - mail contains only code
+// ConfirmEmailChangeURL - link in mail to the new email, token is added as query "token", empty - mail contains only token
+type MailConfig struct {
+	Sender           string `env:"SENDER"`
+	From             string `env:"FROM"`
+	SMTPHost         string `env:"SMTP_HOST"`
+	SMTPPort         uint16 `env:"SMTP_PORT"`
+	SMTPUser         string `env:"SMTP_USER"`
+	SMTPPassword     string `env:"SMTP_PASSWORD"`
+	Dir              string `env:"DIR"`
+	VerifyEmailURL   string `env:"VERIFY_EMAIL_URL"`
+	ResetPasswordURL string `env:"RESET_PASSWORD_URL"`
+
+	ConfirmEmailChangeURL string `env:"CONFIRM_EMAIL_CHANGE_URL"`
+}
+
+func (cfgMail *MailConfig) validConfig(msgErr utils.Message) {
+	switch cfgMail.Sender {
+	case "":
+		msgErr["mail-sender"] = ErrConfigEmpty
+	case MailSenderMemory:
+	case MailSenderFile:
+		if cfgMail.Dir == "" {
+			msgErr["mail-dir"] = ErrConfigEmpty
+		}
+	case MailSenderSMTP:
+		if cfgMail.SMTPHost == "" {
+			msgErr["mail-smtp-host"] = ErrConfigEmpty
+		}
+		if cfgMail.SMTPPort == 0 {
+			msgErr["mail-smtp-port"] = ErrConfigEmpty
+		}
+		if cfgMail.From == "" {
+			msgErr["mail-from"] = ErrConfigEmpty
+		}
+	default:
+		msgErr["mail-sender"] = ErrConfigInvalid
+	}
+	if !validLink(cfgMail.VerifyEmailURL) {
+		msgErr["mail-verify-email-url"] = ErrConfigInvalid
+	}
+	if !validLink(cfgMail.ResetPasswordURL) {
+		msgErr["mail-reset-password-url"] = ErrConfigInvalid
+	}
+	if !validLink(cfgMail.ConfirmEmailChangeURL) {
+		msgErr["mail-confirm-email-change-url"] = ErrConfigInvalid
+	}
+}
+
+// validLink - empty or absolute url
+func validLink(value string) bool {
+	if value == "" {
+		return true
+	}
+	link, err := url.Parse(value)
+	return err == nil && link.IsAbs()
+}
+
+// mfaKeySize - size of key of AES-256-GCM
+const mfaKeySize = 32
+
+// MFAConfig - two-factor authentication with TOTP
+// EncryptionKeys - "kid:key" pairs separated by comma, key is base64 of 32 bytes, TOTP secrets are encrypted by them,
+// all keys decrypt, without keys two-factor authentication can't be enabled
+// CurrentKeyID - "kid" of key which encrypts new secrets, may be empty if there is one key
+// Issuer - name of service in authenticator app (go-postgres-grpc-user-dir)
+// ChallengeTTL - life of challenge token of UserLogin (5m)
+// RecoveryCodes - number of recovery codes (10)
+type MFAConfig struct {
+	EncryptionKeys map[string]string `env:"ENCRYPTION_KEYS"`
+	CurrentKeyID   string            `env:"CURRENT_KID"`
+	Issuer         string            `env:"ISSUER"`
+	ChallengeTTL   time.Duration     `env:"CHALLENGE_TTL"`
+	RecoveryCodes  uint8             `env:"RECOVERY_CODES"`
+}
+
+func (cfgMFA *MFAConfig) validConfig(msgErr utils.Message) {
+	for kid, encoded := range cfgMFA.EncryptionKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if kid == "" || err != nil || len(key) != mfaKeySize {
+			msgErr["mfa-encryption-keys"] = ErrConfigInvalid
+		}
+	}
+	if _, ex := cfgMFA.EncryptionKeys[cfgMFA.CurrentKeyID]; !ex && (cfgMFA.CurrentKeyID != "" || len(cfgMFA.EncryptionKeys) > 1) {
+		msgErr["mfa-current-kid"] = ErrConfigInvalid
+	}
+	if cfgMFA.ChallengeTTL < 0 {
+		msgErr["mfa-challenge-ttl"] = ErrConfigInvalid
+	}
+}
+
+// IdempotencyConfig - TTL - time while response of request with "idempotency-key" is replayed (24h)
+type IdempotencyConfig struct {
+	TTL time.Duration `env:"TTL"`
+}
+
+func (cfgIdem *IdempotencyConfig) validConfig(msgErr utils.Message) {
+	if cfgIdem.TTL < 0 {
+		msgErr["idempotency-ttl"] = ErrConfigInvalid
+	}
+}
+
+// LoginConfig - protection against guessing of passwords, failed logins are counted per email in the database
+// MaxFailures - failed logins in a row before lock (5)
+// LockDuration - time of lock, failures older than it are forgotten (15m)
+// Backoff - delay before the next attempt after the second failure, doubled with every next failure (1s)
+type LoginConfig struct {
+	MaxFailures  uint16        `env:"MAX_FAILURES"`
+	LockDuration time.Duration `env:"LOCK_DURATION"`
+	Backoff      time.Duration `env:"BACKOFF"`
+}
+
+func (cfgLogin *LoginConfig) validConfig(msgErr utils.Message) {
+	if cfgLogin.LockDuration < 0 {
+		msgErr["login-lock-duration"] = ErrConfigInvalid
+	}
+	if cfgLogin.Backoff < 0 {
+		msgErr["login-backoff"] = ErrConfigInvalid
+	}
+}
+
+// RateLimitConfig - token bucket per client (IP of peer and ID of authenticated user) and method
+// limit is "<requests>/<period>" - bucket of <requests> tokens is refilled during <period> ("10/1m", "100/s")
+// Default - limit of methods without own limit ("100/s" if not set)
+// Methods - "method:limit" pairs separated by comma, method is the last part of the full name ("UserLogin:10/1m")
+// not set limits of UserLogin, UserRegister, RefreshToken are stricter than Default
+type RateLimitConfig struct {
+	Default string            `env:"DEFAULT"`
+	Methods map[string]string `env:"METHODS"`
+}
+
+func (cfgRate *RateLimitConfig) validConfig(msgErr utils.Message) {
+	if cfgRate.Default != "" {
+		if _, err := ParseRateLimit(cfgRate.Default); err != nil {
+			msgErr["rate-limit-default"] = ErrConfigInvalid
+		}
+	}
+	for method, limit := range cfgRate.Methods {
+		if _, err := ParseRateLimit(limit); method == "" || err != nil {
+			msgErr["rate-limit-methods"] = ErrConfigInvalid
+		}
+	}
+}
+
+// RateLimit - <Requests> in <Period>
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseRateLimit - "<requests>/<period>", period is a duration ("1m") or a unit ("s", "m", "h")
+func ParseRateLimit(limit string) (RateLimit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(limit), "/")
+	if !ok {
+		return RateLimit{}, ErrConfigInvalid
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return RateLimit{}, ErrConfigInvalid
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return RateLimit{}, ErrConfigInvalid
+	}
+	return RateLimit{Requests: n, Period: d}, nil
 }
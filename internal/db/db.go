@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
@@ -18,14 +21,75 @@ type Provider interface {
 	CreateUser(ctx context.Context, user *model.User) (uint, error)
 	FindUserByEmail(ctx context.Context, email string) (*model.User, error)
 	FindUserByID(ctx context.Context, id uint) (*model.User, error)
-	UpdateUser(ctx context.Context, user *model.User) error
-	RemoveUserByID(ctx context.Context, id uint) error
+	UpdateUser(ctx context.Context, user *model.User, fields ...string) error
+	UpdatePasswordHash(ctx context.Context, id uint, oldHash, newHash string) error
+	SetTokensValidAfter(ctx context.Context, id uint, validAfter time.Time) error
+	SetEmailVerified(ctx context.Context, id uint, email string, verifiedAt time.Time) error
+	RemoveUserByID(ctx context.Context, id, version uint) error
+
+	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	UseRefreshToken(ctx context.Context, tokenHash string, usedAt time.Time) (*model.RefreshToken, error)
+	FindRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeRefreshTokensByUserID(ctx context.Context, userID uint, revokedAt time.Time) error
+
+	CreateSession(ctx context.Context, session *model.Session) error
+	FindSessionByID(ctx context.Context, id string) (*model.Session, error)
+	ListSessionsByUserID(ctx context.Context, userID uint) ([]*model.Session, error)
+	TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error
+	RevokeSession(ctx context.Context, userID uint, id string, revokedAt time.Time) error
+	RevokeSessionsByUserID(ctx context.Context, userID uint, exceptID string, revokedAt time.Time) ([]string, error)
+
+	FindLoginAttempt(ctx context.Context, email string) (*model.LoginAttempt, error)
+	AddLoginFailure(ctx context.Context, email string, failedAt, resetBefore time.Time) (*model.LoginAttempt, error)
+	LockLogin(ctx context.Context, email string, lockedUntil time.Time) error
+	RemoveLoginFailure(ctx context.Context, email string) error
+	RemoveLoginAttempts(ctx context.Context, email string) error
+	RemoveExpiredLoginAttempts(ctx context.Context, before, now time.Time) error
+
+	ReserveIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, error)
+	SaveIdempotencyResponse(ctx context.Context, key *model.IdempotencyKey) error
+	RemoveIdempotencyKey(ctx context.Context, key, scope string) error
+	RemoveExpiredIdempotencyKeys(ctx context.Context, now time.Time) error
+
+	CreateOneTimeToken(ctx context.Context, token *model.OneTimeToken) error
+	UseOneTimeToken(ctx context.Context, id, purpose string, now time.Time) (*model.OneTimeToken, error)
+
+	FindMFA(ctx context.Context, userID uint) (*model.MFA, error)
+	SaveMFA(ctx context.Context, mfa *model.MFA) error
+	ConfirmMFA(ctx context.Context, userID uint, step int64, confirmedAt time.Time) error
+	UseMFAStep(ctx context.Context, userID uint, step int64) error
+	RemoveMFA(ctx context.Context, userID uint) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string, createdAt time.Time) error
+	UseRecoveryCode(ctx context.Context, userID uint, hash string) error
+
+	RevokeToken(ctx context.Context, token *model.RevokedToken) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	RemoveExpiredRevokedTokens(ctx context.Context, now time.Time) error
+
+	// WithTx - fn is called with Provider of one transaction, error of fn -> rollback, nil -> commit
+	// WithTx of Provider of transaction calls fn in the same transaction
+	WithTx(ctx context.Context, fn func(tx Provider) error) error
+
 	ClosePool()
 }
 
+// querier - methods of *pgxpool.Pool and pgx.Tx used by queries
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 // provider - wrapper for *pgxpool.Pool
+// conn - pool or transaction, all queries go through it
+// tx - options of transactions, inTx - provider is a part of transaction
 type provider struct {
 	dbPool *pgxpool.Pool
+	conn   querier
+
+	tx   txOptions
+	inTx bool
 }
 
 // OpenPool - call initPool to open pgx.pool, check Ping, create tables, indexes for the database if they do not exist
@@ -41,12 +105,16 @@ func OpenPool(ctx context.Context, cfg *config.DataBaseConfig) (*provider, error
 	}
 	log.Print("db: ping is successful")
 
-	provider := &provider{dbPool: dbPool}
+	provider := &provider{dbPool: dbPool, conn: dbPool, tx: newTxOptions(cfg)}
 
 	return provider, nil
 }
 
+// ClosePool - provider of transaction does not close pool
 func (p *provider) ClosePool() {
+	if p.inTx {
+		return
+	}
 	p.dbPool.Close()
 	log.Print("db: database is closed")
 }
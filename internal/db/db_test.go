@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -100,6 +103,26 @@ func TestProvider_CreateUser(t *testing.T) {
 			err:         nil,
 			msg:         `this must be valid, return uint > 0, error is nul`,
 		},
+		{
+			title: `valid create, created_at by database`,
+			logicOfTest: func(ctx context.Context, pr *provider) (uint, error) {
+				before := time.Now().Add(-time.Minute)
+				user := &model.User{
+					Login:     `clock`,
+					Password:  `qwert12345`,
+					FirstName: `Alex`,
+					Email:     `clock@example.com`,
+				}
+				id, err := pr.CreateUser(ctx, user)
+				if err == nil && user.CreatedAt.Before(before) {
+					return id, fmt.Errorf("created_at should be time of database - %v", user.CreatedAt)
+				}
+				return id, err
+			},
+			expectedRes: `^[1-9][0-9]*$`,
+			err:         nil,
+			msg:         `this must be valid, return uint > 0, error is nul`,
+		},
 		{
 			title: `wrong create, login already exist`,
 			logicOfTest: func(ctx context.Context, pr *provider) (uint, error) {
@@ -117,7 +140,7 @@ func TestProvider_CreateUser(t *testing.T) {
 				return pr.CreateUser(ctx, user)
 			},
 			expectedRes: `0`,
-			err:         errors.New("duplicate key value violates unique constraint \"users_login_key\""),
+			err:         errors.New(`^login already taken: .*duplicate key value violates unique constraint "users_login_key"`),
 			msg:         `wrong, return uint = 0, error is exist`,
 		},
 		{
@@ -137,7 +160,7 @@ func TestProvider_CreateUser(t *testing.T) {
 				return pr.CreateUser(ctx, user)
 			},
 			expectedRes: `0`,
-			err:         errors.New("duplicate key value violates unique constraint \"users_email_key\""),
+			err:         errors.New(`^email already taken: .*duplicate key value violates unique constraint "users_email_key"`),
 			msg:         `wrong, return uint = 0, error is exist`,
 		},
 	}
@@ -204,6 +227,20 @@ func TestProvider_FindUserByEmail(t *testing.T) {
 			err: nil,
 			msg: `this must be valid, return ptrUser, error is nul`,
 		},
+		{
+			title: `valid find with other case of email`,
+			logicOfTest: func(ctx context.Context, pr *provider) (*model.User, error) {
+				return pr.FindUserByEmail(ctx, `Alex@Example.COM`)
+			},
+			expectedRes: &model.User{
+				Login:     `alien`,
+				Password:  `avp`,
+				FirstName: `Alex`,
+				Email:     `alex@example.com`,
+			},
+			err: nil,
+			msg: `case of email is not significant`,
+		},
 		{
 			title: `wrong find not exist`,
 			logicOfTest: func(ctx context.Context, pr *provider) (*model.User, error) {
@@ -397,6 +434,107 @@ func TestProvider_UpdateUser(t *testing.T) {
 			err: pgx.ErrNoRows,
 			msg: `wrong update , error is exist`,
 		},
+		{
+			title: `valid update of masked fields, clear last name`,
+			logicOfTest: func(ctx context.Context, pr *provider) error {
+				create := time.Now()
+				update := create.Add(time.Hour)
+				user := &model.User{
+					Login:     `masked`,
+					Password:  `avp`,
+					FirstName: `Alex`,
+					LastName:  `Doe`,
+					Email:     `masked@example.com`,
+					CreatedAt: create,
+				}
+				id, err := pr.CreateUser(ctx, user)
+				if err != nil {
+					return err
+				}
+				if err := pr.UpdateUser(ctx, &model.User{
+					ID:        id,
+					FirstName: `Ignored`,
+					UpdatedAt: &update,
+					Version:   user.Version,
+				}, model.UserFieldLastName); err != nil {
+					return err
+				}
+				updated, err := pr.FindUserByID(ctx, id)
+				if err != nil {
+					return err
+				}
+				if updated.LastName != "" || updated.FirstName != user.FirstName || updated.Login != user.Login {
+					return fmt.Errorf("only last name should be changed - %+v", updated)
+				}
+				if updated.Version != user.Version+1 {
+					return fmt.Errorf("version should be incremented - %d", updated.Version)
+				}
+				return nil
+			},
+			err: nil,
+			msg: `masked update must be valid, error is nul`,
+		},
+		{
+			title: `invalid update, other version`,
+			logicOfTest: func(ctx context.Context, pr *provider) error {
+				create := time.Now()
+				update := create.Add(time.Hour)
+				user := &model.User{
+					Login:     `stale`,
+					Password:  `avp`,
+					FirstName: `Alex`,
+					Email:     `stale@example.com`,
+					CreatedAt: create,
+				}
+				id, err := pr.CreateUser(ctx, user)
+				if err != nil {
+					return err
+				}
+				user.ID = id
+				user.UpdatedAt = &update
+				if err := pr.UpdateUser(ctx, user); err != nil {
+					return err
+				}
+				user.Version--
+				return pr.UpdateUser(ctx, user)
+			},
+			err: ErrDBVersionConflict,
+			msg: `wrong update, error is exist`,
+		},
+		{
+			title: `valid update, updated_at by database`,
+			logicOfTest: func(ctx context.Context, pr *provider) error {
+				user := &model.User{
+					Login:     `clockupdate`,
+					Password:  `avp`,
+					FirstName: `Alex`,
+					Email:     `clockupdate@example.com`,
+				}
+				id, err := pr.CreateUser(ctx, user)
+				if err != nil {
+					return err
+				}
+				user.ID = id
+				if err := pr.UpdateUser(ctx, user, model.UserFieldFirstName); err != nil {
+					return err
+				}
+				if user.UpdatedAt == nil || user.UpdatedAt.Before(user.CreatedAt) {
+					return fmt.Errorf("updated_at should be time of database - %v", user.UpdatedAt)
+				}
+				return nil
+			},
+			err: nil,
+			msg: `update must be valid, error is nul`,
+		},
+		{
+			title: `invalid update, unknown field`,
+			logicOfTest: func(ctx context.Context, pr *provider) error {
+				update := time.Now()
+				return pr.UpdateUser(ctx, &model.User{ID: 1, UpdatedAt: &update}, "id")
+			},
+			err: ErrDBUserFieldUnknown,
+			msg: `wrong update, error is exist`,
+		},
 	}
 
 	ctx := context.Background()
@@ -446,7 +584,7 @@ func TestProvider_DeleteUser(t *testing.T) {
 				if err != nil {
 					return err
 				}
-				return pr.RemoveUserByID(ctx, id)
+				return pr.RemoveUserByID(ctx, id, user.Version)
 			},
 			err: nil,
 			msg: `update must be valid, error is nul`,
@@ -454,9 +592,28 @@ func TestProvider_DeleteUser(t *testing.T) {
 		{
 			title: `invalid delete, user not exist`,
 			logicOfTest: func(ctx context.Context, pr *provider) error {
-				return pr.RemoveUserByID(ctx, 1)
+				return pr.RemoveUserByID(ctx, 1, 1)
 			},
-			err: pgx.ErrNoRows,
+			err: ErrDBNotFound,
+			msg: `wrong delete, error is exist`,
+		},
+		{
+			title: `invalid delete, other version`,
+			logicOfTest: func(ctx context.Context, pr *provider) error {
+				user := &model.User{
+					Login:     `versioned`,
+					Password:  `avp`,
+					FirstName: `Alex`,
+					Email:     `versioned@example.com`,
+					CreatedAt: time.Now(),
+				}
+				id, err := pr.CreateUser(ctx, user)
+				if err != nil {
+					return err
+				}
+				return pr.RemoveUserByID(ctx, id, user.Version+1)
+			},
+			err: ErrDBVersionConflict,
 			msg: `wrong delete, error is exist`,
 		},
 	}
@@ -484,3 +641,635 @@ func TestProvider_DeleteUser(t *testing.T) {
 	}
 	log.Printf("db_test: TestProvider_DeleteUser - END")
 }
+
+func TestProvider_RefreshToken(t *testing.T) {
+	log.Printf("db_test: TestProvider_RefreshToken - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	userID, err := pr.CreateUser(ctx, &model.User{
+		Login:     `alien`,
+		Password:  `avp`,
+		FirstName: `Alex`,
+		Email:     `alex@example.com`,
+		CreatedAt: time.Now(),
+	})
+	requires.NoError(err, "wrong create user")
+
+	now := time.Now().UTC()
+	token := &model.RefreshToken{
+		UserID:    userID,
+		FamilyID:  `family`,
+		TokenHash: `hash`,
+		AuthTime:  now.Add(-time.Minute).Truncate(time.Second),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	requires.NoError(pr.CreateRefreshToken(ctx, token), "wrong create refresh token")
+	asserts.NotZero(token.ID, "id should be set")
+
+	log.Printf("\t1 valid use of token")
+	used, err := pr.UseRefreshToken(ctx, `hash`, now)
+	requires.NoError(err, "token should be spent")
+	asserts.Equal(userID, used.UserID, "different user")
+	asserts.NotNil(used.UsedAt, "used_at should be set")
+	asserts.True(token.AuthTime.Equal(used.AuthTime), "auth_time should be kept")
+
+	log.Printf("\t2 wrong second use of token")
+	_, err = pr.UseRefreshToken(ctx, `hash`, now)
+	asserts.ErrorIs(err, pgx.ErrNoRows, "spent token can't be used")
+
+	log.Printf("\t3 revoke family")
+	requires.NoError(pr.RevokeRefreshTokenFamily(ctx, `family`, now), "wrong revoke")
+	found, err := pr.FindRefreshToken(ctx, `hash`)
+	requires.NoError(err, "token should be found")
+	asserts.True(found.Reused(), "token should be marked as used")
+	asserts.NotNil(found.RevokedAt, "token should be revoked")
+
+	log.Printf("db_test: TestProvider_RefreshToken - END")
+}
+
+func TestProvider_RevokedToken(t *testing.T) {
+	log.Printf("db_test: TestProvider_RevokedToken - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	userID, err := pr.CreateUser(ctx, &model.User{
+		Login:     `alien`,
+		Password:  `avp`,
+		FirstName: `Alex`,
+		Email:     `alex@example.com`,
+		CreatedAt: time.Now(),
+	})
+	requires.NoError(err, "wrong create user")
+
+	now := time.Now().UTC()
+
+	log.Printf("\t1 revoke token, repeated revoke is valid")
+	token := &model.RevokedToken{JTI: `jti`, UserID: userID, ExpiresAt: now.Add(time.Hour), RevokedAt: now}
+	requires.NoError(pr.RevokeToken(ctx, token), "wrong revoke")
+	requires.NoError(pr.RevokeToken(ctx, token), "wrong repeated revoke")
+
+	revoked, err := pr.IsTokenRevoked(ctx, `jti`)
+	requires.NoError(err, "wrong check")
+	asserts.True(revoked, "token should be revoked")
+
+	revoked, err = pr.IsTokenRevoked(ctx, `other`)
+	requires.NoError(err, "wrong check")
+	asserts.False(revoked, "token should not be revoked")
+
+	log.Printf("\t2 remove expired")
+	requires.NoError(pr.RemoveExpiredRevokedTokens(ctx, now.Add(2*time.Hour)), "wrong remove")
+	revoked, err = pr.IsTokenRevoked(ctx, `jti`)
+	requires.NoError(err, "wrong check")
+	asserts.False(revoked, "expired token should be removed")
+
+	log.Printf("\t3 tokens valid after")
+	requires.NoError(pr.SetTokensValidAfter(ctx, userID, now), "wrong set tokens valid after")
+	user, err := pr.FindUserByID(ctx, userID)
+	requires.NoError(err, "wrong find user")
+	requires.NotNil(user.TokensValidAfter, "tokens valid after should be set")
+	asserts.True(user.TokenIssuedBeforeValid(now.Add(-time.Second)), "old token should be invalid")
+	asserts.ErrorIs(pr.SetTokensValidAfter(ctx, userID+1000, now), ErrDBNotFound, "not existing user")
+	asserts.ErrorIs(pr.UpdatePasswordHash(ctx, userID, "other hash", "new hash"), ErrDBNotFound, "other hash")
+
+	log.Printf("db_test: TestProvider_RevokedToken - END")
+}
+
+func TestProvider_Session(t *testing.T) {
+	log.Printf("db_test: TestProvider_Session - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	userID, err := pr.CreateUser(ctx, &model.User{
+		Login:     `alien`,
+		Password:  `avp`,
+		FirstName: `Alex`,
+		Email:     `alex@example.com`,
+		CreatedAt: time.Now(),
+	})
+	requires.NoError(err, "wrong create user")
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	log.Printf("\t1 create and find sessions")
+	for _, id := range []string{`first`, `second`} {
+		requires.NoError(pr.CreateSession(ctx, &model.Session{
+			ID:         id,
+			UserID:     userID,
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ClientIP:   `127.0.0.1`,
+			UserAgent:  `grpc-go`,
+		}), "wrong create session")
+	}
+	session, err := pr.FindSessionByID(ctx, `first`)
+	requires.NoError(err, "session should be found")
+	asserts.Equal(userID, session.UserID, "different user")
+	asserts.False(session.Revoked(), "session should be active")
+
+	log.Printf("\t2 touch session, the last seen first")
+	requires.NoError(pr.TouchSession(ctx, `second`, now.Add(time.Minute)), "wrong touch")
+	sessions, err := pr.ListSessionsByUserID(ctx, userID)
+	requires.NoError(err, "wrong list")
+	requires.Len(sessions, 2)
+	asserts.Equal(`second`, sessions[0].ID, "wrong order")
+
+	log.Printf("\t3 revoke session, repeated revoke is not valid")
+	requires.NoError(pr.RevokeSession(ctx, userID, `second`, now), "wrong revoke")
+	asserts.ErrorIs(pr.RevokeSession(ctx, userID, `second`, now), ErrDBNotFound, "revoked session can't be revoked")
+
+	log.Printf("\t4 revoke other sessions")
+	ids, err := pr.RevokeSessionsByUserID(ctx, userID, `second`, now)
+	requires.NoError(err, "wrong revoke")
+	asserts.Equal([]string{`first`}, ids)
+	sessions, err = pr.ListSessionsByUserID(ctx, userID)
+	requires.NoError(err, "wrong list")
+	asserts.Empty(sessions, "revoked sessions should not be listed")
+
+	log.Printf("db_test: TestProvider_Session - END")
+}
+
+func TestProvider_LoginAttempt(t *testing.T) {
+	log.Printf("db_test: TestProvider_LoginAttempt - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	const email = `locked@example.com`
+	now := time.Now().UTC().Truncate(time.Second)
+	requires.NoError(pr.RemoveLoginAttempts(ctx, email), "login_attempts is not cleared with users")
+
+	log.Printf("\t1 email without failures")
+	attempt, err := pr.FindLoginAttempt(ctx, email)
+	requires.NoError(err, "attempt without failures should be returned")
+	asserts.Zero(attempt.FailedCount)
+
+	log.Printf("\t2 count failures, old failures are forgotten")
+	for i := 1; i <= 2; i++ {
+		attempt, err = pr.AddLoginFailure(ctx, email, now, now.Add(-time.Hour))
+		requires.NoError(err, "wrong add failure")
+		asserts.Equal(i, attempt.FailedCount)
+	}
+	attempt, err = pr.AddLoginFailure(ctx, email, now.Add(2*time.Hour), now.Add(time.Hour))
+	requires.NoError(err, "wrong add failure")
+	asserts.Equal(1, attempt.FailedCount, "failures before 'resetBefore' should be forgotten")
+
+	log.Printf("\t3 lock login")
+	requires.NoError(pr.LockLogin(ctx, email, now.Add(3*time.Hour)), "wrong lock")
+	attempt, err = pr.FindLoginAttempt(ctx, email)
+	requires.NoError(err)
+	requires.NotNil(attempt.LockedUntil, "email should be locked")
+	asserts.True(attempt.LockedUntil.Equal(now.Add(3 * time.Hour)))
+	asserts.Equal(1, attempt.FailedCount, "failures are kept by lock")
+	attempt, err = pr.AddLoginFailure(ctx, email, now.Add(2*time.Hour), now.Add(time.Hour))
+	requires.NoError(err)
+	asserts.Equal(2, attempt.FailedCount, "failures are counted during lock")
+	requires.NoError(pr.RemoveLoginFailure(ctx, email), "wrong undo of failure")
+	attempt, err = pr.FindLoginAttempt(ctx, email)
+	requires.NoError(err)
+	asserts.Equal(1, attempt.FailedCount, "failure should be undone")
+
+	log.Printf("\t4 locked email is not removed as expired, then remove")
+	requires.NoError(pr.RemoveExpiredLoginAttempts(ctx, now.Add(4*time.Hour), now.Add(time.Hour)))
+	attempt, err = pr.FindLoginAttempt(ctx, email)
+	requires.NoError(err)
+	asserts.NotNil(attempt.LockedUntil, "lock should be kept")
+	requires.NoError(pr.RemoveLoginAttempts(ctx, email), "wrong remove")
+	attempt, err = pr.FindLoginAttempt(ctx, email)
+	requires.NoError(err)
+	asserts.Nil(attempt.LockedUntil, "lock should be removed")
+
+	log.Printf("\t5 failures are counted from zero after the end of lock")
+	_, err = pr.AddLoginFailure(ctx, email, now, now.Add(-time.Hour))
+	requires.NoError(err)
+	requires.NoError(pr.LockLogin(ctx, email, now.Add(time.Minute)))
+	attempt, err = pr.AddLoginFailure(ctx, email, now.Add(2*time.Minute), now.Add(-time.Hour))
+	requires.NoError(err)
+	asserts.Equal(1, attempt.FailedCount, "failures before the end of lock should be forgotten")
+	asserts.Nil(attempt.LockedUntil, "ended lock should be removed")
+	requires.NoError(pr.RemoveLoginAttempts(ctx, email))
+
+	log.Printf("\t6 email of the max length of users.email is counted")
+	longEmail := strings.Repeat("a", 500) + `@example.com`
+	attempt, err = pr.AddLoginFailure(ctx, longEmail, now, now.Add(-time.Hour))
+	requires.NoError(err, "long email should be counted")
+	asserts.Equal(1, attempt.FailedCount)
+	requires.NoError(pr.RemoveLoginAttempts(ctx, longEmail))
+
+	log.Printf("db_test: TestProvider_LoginAttempt - END")
+}
+
+func TestClassifyError(t *testing.T) {
+	log.Printf("db_test: TestClassifyError - START")
+
+	asserts := assert.New(t)
+
+	var testData = []struct {
+		title string
+		err   error
+		is    error
+	}{
+		{
+			title: `login taken`,
+			err:   &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: constraintUsersLogin},
+			is:    ErrDBLoginTaken,
+		},
+		{
+			title: `email taken`,
+			err:   &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: constraintUsersEmail},
+			is:    ErrDBEmailTaken,
+		},
+		{
+			title: `connection failure`,
+			err:   &pgconn.PgError{Code: "08006"},
+			is:    ErrDBUnavailable,
+		},
+		{
+			title: `server shutdown`,
+			err:   &pgconn.PgError{Code: "57P01"},
+			is:    ErrDBUnavailable,
+		},
+		{
+			title: `network error`,
+			err:   &net.OpError{Op: "read", Err: errors.New("connection reset by peer")},
+			is:    ErrDBUnavailable,
+		},
+		{
+			title: `not found`,
+			err:   pgx.ErrNoRows,
+			is:    ErrDBNotFound,
+		},
+	}
+
+	for i, test := range testData {
+		log.Printf("\t%d %s", i+1, test.title)
+
+		err := classifyError(test.err)
+
+		asserts.ErrorIs(err, test.is, test.title)
+		asserts.ErrorIs(err, test.err, "error of postgresql should be wrapped")
+	}
+	asserts.NoError(classifyError(nil))
+	asserts.NotErrorIs(
+		classifyError(&pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "sessions_pkey"}),
+		ErrDBLoginTaken,
+		"other constraints are not classified")
+
+	log.Printf("db_test: TestClassifyError - END")
+}
+
+func TestProvider_WithTx(t *testing.T) {
+	log.Printf("db_test: TestProvider_WithTx - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	newUser := func(login string) *model.User {
+		return &model.User{Login: login, Password: `avp`, FirstName: `Alex`, Email: login + `@example.com`}
+	}
+
+	log.Printf("\t1 commit")
+	err = pr.WithTx(ctx, func(tx Provider) error {
+		_, err := tx.CreateUser(ctx, newUser(`committed`))
+		return err
+	})
+	requires.NoError(err, "transaction should be committed")
+	_, err = pr.FindUserByEmail(ctx, `committed@example.com`)
+	asserts.NoError(err, "user should be found")
+
+	log.Printf("\t2 rollback after error of fn, nested WithTx is a part of transaction")
+	errFn := errors.New("fn error")
+	err = pr.WithTx(ctx, func(tx Provider) error {
+		return tx.WithTx(ctx, func(nested Provider) error {
+			if _, err := nested.CreateUser(ctx, newUser(`rolledback`)); err != nil {
+				return err
+			}
+			return errFn
+		})
+	})
+	asserts.ErrorIs(err, errFn)
+	_, err = pr.FindUserByEmail(ctx, `rolledback@example.com`)
+	asserts.ErrorIs(err, pgx.ErrNoRows, "user should be rolled back")
+
+	log.Printf("\t3 retry after serialization failure")
+	calls := 0
+	err = pr.WithTx(ctx, func(tx Provider) error {
+		calls++
+		if _, err := tx.CreateUser(ctx, newUser(fmt.Sprintf("retried%d", calls))); err != nil {
+			return err
+		}
+		if calls == 1 {
+			return &pgconn.PgError{Code: pgSerializationFailure}
+		}
+		return nil
+	})
+	requires.NoError(err, "transaction should be repeated")
+	asserts.Equal(2, calls)
+	_, err = pr.FindUserByEmail(ctx, `retried1@example.com`)
+	asserts.ErrorIs(err, pgx.ErrNoRows, "first attempt should be rolled back")
+	_, err = pr.FindUserByEmail(ctx, `retried2@example.com`)
+	asserts.NoError(err, "second attempt should be committed")
+
+	log.Printf("\t4 retries are limited")
+	calls = 0
+	err = pr.WithTx(ctx, func(tx Provider) error {
+		calls++
+		return &pgconn.PgError{Code: pgDeadlockDetected}
+	})
+	asserts.True(retryableTx(err))
+	asserts.Equal(defaultTxMaxRetries+1, calls)
+
+	log.Printf("db_test: TestProvider_WithTx - END")
+}
+
+func TestProvider_IdempotencyKey(t *testing.T) {
+	log.Printf("db_test: TestProvider_IdempotencyKey - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	key := &model.IdempotencyKey{
+		Key:         `key-1`,
+		Scope:       `UserRegister`,
+		Fingerprint: `fingerprint`,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+		LockedUntil: now.Add(time.Minute),
+	}
+
+	log.Printf("\t1 reserve")
+	stored, err := pr.ReserveIdempotencyKey(ctx, key)
+	requires.NoError(err)
+	asserts.Nil(stored, "key should be reserved")
+
+	stored, err = pr.ReserveIdempotencyKey(ctx, key)
+	requires.NoError(err)
+	requires.NotNil(stored, "reserved key should be returned")
+	asserts.True(stored.InProgress())
+
+	log.Printf("\t2 save response")
+	key.Response = []byte(`response`)
+	key.Header = map[string][]string{"etag": {"2"}}
+	requires.NoError(pr.SaveIdempotencyResponse(ctx, key))
+	stored, err = pr.ReserveIdempotencyKey(ctx, key)
+	requires.NoError(err)
+	requires.NotNil(stored)
+	asserts.Equal(key.Response, stored.Response)
+	asserts.Equal(key.Header, stored.Header)
+	asserts.Equal(key.Fingerprint, stored.Fingerprint)
+
+	log.Printf("\t3 other scope is other key")
+	otherScope := *key
+	otherScope.Scope = `UserUpdate 1`
+	stored, err = pr.ReserveIdempotencyKey(ctx, &otherScope)
+	requires.NoError(err)
+	asserts.Nil(stored)
+	requires.NoError(pr.RemoveIdempotencyKey(ctx, otherScope.Key, otherScope.Scope))
+	stored, err = pr.ReserveIdempotencyKey(ctx, &otherScope)
+	requires.NoError(err)
+	asserts.Nil(stored, "removed key should be reserved again")
+
+	log.Printf("\t4 expired key is replaced and removed")
+	expired := *key
+	expired.Fingerprint = `other`
+	expired.CreatedAt = now.Add(2 * time.Hour)
+	expired.ExpiresAt = now.Add(3 * time.Hour)
+	stored, err = pr.ReserveIdempotencyKey(ctx, &expired)
+	requires.NoError(err)
+	asserts.Nil(stored, "expired key should be reserved by other request")
+
+	requires.NoError(pr.RemoveExpiredIdempotencyKeys(ctx, now.Add(4*time.Hour)))
+	stored, err = pr.ReserveIdempotencyKey(ctx, key)
+	requires.NoError(err)
+	asserts.Nil(stored, "expired keys should be removed")
+
+	log.Printf("\t5 key in progress after lease")
+	later := *key
+	later.CreatedAt = now.Add(2 * time.Minute)
+	later.LockedUntil = now.Add(3 * time.Minute)
+	otherRequest := later
+	otherRequest.Fingerprint = `other`
+	stored, err = pr.ReserveIdempotencyKey(ctx, &otherRequest)
+	requires.NoError(err)
+	requires.NotNil(stored, "other request can't take the key after lease")
+	asserts.True(stored.InProgress())
+	asserts.True(stored.LockedUntil.Equal(key.LockedUntil))
+	stored, err = pr.ReserveIdempotencyKey(ctx, &later)
+	requires.NoError(err)
+	asserts.Nil(stored, "the same request should take the key after lease")
+	key.Response = []byte(`response`)
+	requires.NoError(pr.SaveIdempotencyResponse(ctx, key))
+	stored, err = pr.ReserveIdempotencyKey(ctx, &otherRequest)
+	requires.NoError(err)
+	requires.NotNil(stored)
+	asserts.False(stored.InProgress())
+	asserts.True(stored.LockedUntil.IsZero(), "stored response has no lease")
+
+	log.Printf("db_test: TestProvider_IdempotencyKey - END")
+}
+
+func TestProvider_OneTimeToken(t *testing.T) {
+	log.Printf("db_test: TestProvider_OneTimeToken - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	u := &model.User{Login: `verify`, Password: `avp`, FirstName: `Alex`, Email: `verify@example.com`}
+	u.ID, err = pr.CreateUser(ctx, u)
+	requires.NoError(err)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	newToken := func(id string) *model.OneTimeToken {
+		return &model.OneTimeToken{
+			ID:        id,
+			UserID:    u.ID,
+			Purpose:   model.PurposeVerifyEmail,
+			Email:     u.Email,
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Hour),
+		}
+	}
+
+	log.Printf("\t1 new token replaces the previous one")
+	requires.NoError(pr.CreateOneTimeToken(ctx, newToken(`first`)))
+	requires.NoError(pr.CreateOneTimeToken(ctx, newToken(`second`)))
+	_, err = pr.UseOneTimeToken(ctx, `first`, model.PurposeVerifyEmail, now)
+	asserts.ErrorIs(err, pgx.ErrNoRows, "replaced token")
+
+	log.Printf("\t2 token is used once, only for its purpose and before expiration")
+	_, err = pr.UseOneTimeToken(ctx, `second`, "other", now)
+	asserts.ErrorIs(err, pgx.ErrNoRows, "other purpose")
+	_, err = pr.UseOneTimeToken(ctx, `second`, model.PurposeVerifyEmail, now.Add(2*time.Hour))
+	asserts.ErrorIs(err, pgx.ErrNoRows, "expired token")
+	token, err := pr.UseOneTimeToken(ctx, `second`, model.PurposeVerifyEmail, now)
+	requires.NoError(err)
+	asserts.Equal(u.ID, token.UserID)
+	asserts.Equal(u.Email, token.Email)
+	_, err = pr.UseOneTimeToken(ctx, `second`, model.PurposeVerifyEmail, now)
+	asserts.ErrorIs(err, pgx.ErrNoRows, "used token")
+
+	log.Printf("\t3 verification of email")
+	asserts.ErrorIs(pr.SetEmailVerified(ctx, u.ID, `other@example.com`, now), pgx.ErrNoRows, "other email")
+	requires.NoError(pr.SetEmailVerified(ctx, u.ID, u.Email, now))
+	found, err := pr.FindUserByID(ctx, u.ID)
+	requires.NoError(err)
+	asserts.True(found.EmailVerified())
+
+	log.Printf("\t4 update of email removes verification")
+	found.FirstName = `Alexander`
+	requires.NoError(pr.UpdateUser(ctx, found, model.UserFieldFirstName, model.UserFieldEmail))
+	found, err = pr.FindUserByID(ctx, u.ID)
+	requires.NoError(err)
+	asserts.True(found.EmailVerified(), "the same email keeps verification")
+	found.Email = `new-verify@example.com`
+	requires.NoError(pr.UpdateUser(ctx, found, model.UserFieldEmail))
+	found, err = pr.FindUserByID(ctx, u.ID)
+	requires.NoError(err)
+	asserts.False(found.EmailVerified(), "new email is not verified")
+
+	log.Printf("\t5 tokens of other purpose are not replaced")
+	verifyToken := newToken(`verify`)
+	resetToken := newToken(`reset`)
+	resetToken.Purpose = model.PurposeResetPassword
+	requires.NoError(pr.CreateOneTimeToken(ctx, verifyToken))
+	requires.NoError(pr.CreateOneTimeToken(ctx, resetToken))
+	_, err = pr.UseOneTimeToken(ctx, `verify`, model.PurposeResetPassword, now)
+	asserts.ErrorIs(err, pgx.ErrNoRows, "token of verification does not reset password")
+	_, err = pr.UseOneTimeToken(ctx, `verify`, model.PurposeVerifyEmail, now)
+	asserts.NoError(err)
+	_, err = pr.UseOneTimeToken(ctx, `reset`, model.PurposeResetPassword, now)
+	asserts.NoError(err)
+
+	log.Printf("db_test: TestProvider_OneTimeToken - END")
+}
+
+func TestProvider_MFA(t *testing.T) {
+	log.Printf("db_test: TestProvider_MFA - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	ctx := context.Background()
+
+	err := newMigrations(ctx)
+	requires.NoError(err, "wrong migrations")
+
+	pr, err := newProviderForTest(ctx)
+	requires.NoError(err, "wrong connect to db")
+	defer pr.ClosePool()
+
+	u := &model.User{Login: `mfa`, Password: `avp`, FirstName: `Alex`, Email: `mfa@example.com`}
+	u.ID, err = pr.CreateUser(ctx, u)
+	requires.NoError(err)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	log.Printf("\t1 user without record is not enrolled")
+	mfa, err := pr.FindMFA(ctx, u.ID)
+	requires.NoError(err)
+	asserts.Empty(mfa.Secret)
+	asserts.False(mfa.Enabled())
+
+	log.Printf("\t2 not confirmed secret is replaced, confirmed one is not")
+	requires.NoError(pr.SaveMFA(ctx, &model.MFA{UserID: u.ID, Secret: `k1:first`, CreatedAt: now}))
+	requires.NoError(pr.SaveMFA(ctx, &model.MFA{UserID: u.ID, Secret: `k1:second`, CreatedAt: now}))
+	requires.NoError(pr.ConfirmMFA(ctx, u.ID, 100, now))
+	asserts.ErrorIs(pr.ConfirmMFA(ctx, u.ID, 101, now), pgx.ErrNoRows, "confirmed twice")
+	asserts.ErrorIs(pr.SaveMFA(ctx, &model.MFA{UserID: u.ID, Secret: `k1:third`, CreatedAt: now}), pgx.ErrNoRows)
+	mfa, err = pr.FindMFA(ctx, u.ID)
+	requires.NoError(err)
+	asserts.Equal(`k1:second`, mfa.Secret)
+	asserts.True(mfa.Enabled())
+	asserts.Equal(int64(100), mfa.LastUsedStep)
+
+	log.Printf("\t3 step is accepted only after the last used step")
+	asserts.ErrorIs(pr.UseMFAStep(ctx, u.ID, 100), pgx.ErrNoRows, "used step")
+	asserts.ErrorIs(pr.UseMFAStep(ctx, u.ID, 99), pgx.ErrNoRows, "earlier step")
+	requires.NoError(pr.UseMFAStep(ctx, u.ID, 101))
+
+	log.Printf("\t4 recovery code is used once, new codes replace the previous ones")
+	requires.NoError(pr.WithTx(ctx, func(tx Provider) error {
+		return tx.ReplaceRecoveryCodes(ctx, u.ID, []string{`hash1`, `hash2`}, now)
+	}))
+	requires.NoError(pr.UseRecoveryCode(ctx, u.ID, `hash1`))
+	asserts.ErrorIs(pr.UseRecoveryCode(ctx, u.ID, `hash1`), pgx.ErrNoRows, "used code")
+	requires.NoError(pr.ReplaceRecoveryCodes(ctx, u.ID, []string{`hash3`}, now))
+	asserts.ErrorIs(pr.UseRecoveryCode(ctx, u.ID, `hash2`), pgx.ErrNoRows, "replaced code")
+
+	log.Printf("\t5 removed TOTP removes recovery codes")
+	requires.NoError(pr.RemoveMFA(ctx, u.ID))
+	asserts.ErrorIs(pr.UseRecoveryCode(ctx, u.ID, `hash3`), pgx.ErrNoRows)
+	mfa, err = pr.FindMFA(ctx, u.ID)
+	requires.NoError(err)
+	asserts.False(mfa.Enabled())
+
+	log.Printf("db_test: TestProvider_MFA - END")
+}
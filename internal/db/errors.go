@@ -0,0 +1,72 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// errors of Provider, error of postgresql is wrapped, errors.Is finds both
+var (
+	ErrDBLoginTaken = errors.New("login already taken")
+
+	ErrDBEmailTaken = errors.New("email already taken")
+
+	ErrDBUnavailable = errors.New("database unavailable")
+
+	ErrDBUserFieldUnknown = errors.New("unknown field of user")
+
+	ErrDBVersionConflict = errors.New("version of user changed")
+
+	ErrDBNotFound = errors.New("record not found")
+)
+
+// names of unique constraints of table users, given by postgresql to 'UNIQUE' columns
+const (
+	constraintUsersLogin = "users_login_key"
+	constraintUsersEmail = "users_email_key"
+)
+
+// codes of postgresql errors
+const (
+	pgUniqueViolation = "23505"
+	// class 08 - connection exception
+	pgClassConnection = "08"
+	// 57P01 admin_shutdown, 57P02 crash_shutdown, 57P03 cannot_connect_now
+	pgClassShutdown = "57P0"
+)
+
+// classifyError - unique violation of login or email -> ErrDBLoginTaken, ErrDBEmailTaken
+// no row -> ErrDBNotFound
+// lost connection, timeout, shutdown of server -> ErrDBUnavailable
+// other errors are returned as is
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrDBNotFound, err)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == constraintUsersLogin:
+			return fmt.Errorf("%w: %w", ErrDBLoginTaken, err)
+		case pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == constraintUsersEmail:
+			return fmt.Errorf("%w: %w", ErrDBEmailTaken, err)
+		case strings.HasPrefix(pgErr.Code, pgClassConnection), strings.HasPrefix(pgErr.Code, pgClassShutdown):
+			return fmt.Errorf("%w: %w", ErrDBUnavailable, err)
+		}
+		return err
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) || errors.As(err, &netErr) || pgconn.Timeout(err) {
+		return fmt.Errorf("%w: %w", ErrDBUnavailable, err)
+	}
+	return err
+}
@@ -3,7 +3,12 @@ package mock
 import (
 	"context"
 	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
 )
 
@@ -15,6 +20,28 @@ type mockProvider struct {
 	userByID    map[uint]*model.User
 	userByEmail map[string]*model.User
 	userLogin   map[string]*model.User
+
+	refreshTokenID     uint
+	refreshTokenByHash map[string]*model.RefreshToken
+
+	revokedTokenByJTI map[string]*model.RevokedToken
+
+	sessionByID map[string]*model.Session
+
+	loginAttemptByEmail map[string]*model.LoginAttempt
+
+	// idempotencyKeyByKey - key is "<scope> <key>"
+	idempotencyKeyByKey map[string]*model.IdempotencyKey
+
+	oneTimeTokenByID map[string]*model.OneTimeToken
+
+	mfaByUserID map[uint]*model.MFA
+	// recoveryCodeByKey - key is "<user_id> <hash>"
+	recoveryCodeByKey map[string]*model.RecoveryCode
+
+	// inTx - fn of WithTx is running, commitErr - error of the next commit (FailCommit)
+	inTx      bool
+	commitErr error
 }
 
 func NewMockProvider() *mockProvider {
@@ -22,6 +49,21 @@ func NewMockProvider() *mockProvider {
 		userByID:    make(map[uint]*model.User),
 		userByEmail: make(map[string]*model.User),
 		userLogin:   make(map[string]*model.User),
+
+		refreshTokenByHash: make(map[string]*model.RefreshToken),
+
+		revokedTokenByJTI: make(map[string]*model.RevokedToken),
+
+		sessionByID: make(map[string]*model.Session),
+
+		loginAttemptByEmail: make(map[string]*model.LoginAttempt),
+
+		idempotencyKeyByKey: make(map[string]*model.IdempotencyKey),
+
+		oneTimeTokenByID: make(map[string]*model.OneTimeToken),
+
+		mfaByUserID:       make(map[uint]*model.MFA),
+		recoveryCodeByKey: make(map[string]*model.RecoveryCode),
 	}
 }
 
@@ -31,13 +73,17 @@ func (mp *mockProvider) incrementID() {
 
 func (mp *mockProvider) CreateUser(_ context.Context, user *model.User) (uint, error) {
 	if _, ex := mp.userLogin[user.Login]; ex {
-		return 0, ErrMockDB
+		return 0, db.ErrDBLoginTaken
 	}
 	if _, ex := mp.userByEmail[user.Email]; ex {
-		return 0, ErrMockDB
+		return 0, db.ErrDBEmailTaken
 	}
 	mp.incrementID()
 	user.ID = mp.id
+	user.Version = 1
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now().UTC()
+	}
 	mp.createUser(user)
 	return user.ID, nil
 }
@@ -49,8 +95,10 @@ func (mp *mockProvider) createUser(user *model.User) {
 }
 
 func (mp *mockProvider) FindUserByEmail(_ context.Context, email string) (*model.User, error) {
-	if user, ex := mp.userByEmail[email]; ex {
-		return user, nil
+	for userEmail, user := range mp.userByEmail {
+		if strings.EqualFold(userEmail, email) {
+			return user, nil
+		}
 	}
 	return nil, ErrMockDB
 }
@@ -62,31 +110,443 @@ func (mp *mockProvider) FindUserByID(_ context.Context, id uint) (*model.User, e
 	return nil, ErrMockDB
 }
 
-func (mp *mockProvider) UpdateUser(ctx context.Context, user *model.User) error {
-	if userEmail, ex := mp.userByEmail[user.Email]; ex && userEmail.ID != user.ID {
+func (mp *mockProvider) UpdateUser(_ context.Context, user *model.User, fields ...string) error {
+	oldUser, ex := mp.userByID[user.ID]
+	if !ex {
 		return ErrMockDB
 	}
-	if userLogin, ex := mp.userLogin[user.Login]; ex && userLogin.ID != user.ID {
-		return ErrMockDB
+	if oldUser.Version != user.Version {
+		return db.ErrDBVersionConflict
+	}
+	if len(fields) == 0 {
+		fields = model.UserUpdateFields
+	}
+	if user.UpdatedAt == nil {
+		now := time.Now().UTC()
+		user.UpdatedAt = &now
 	}
-	if _, ex := mp.userByID[user.ID]; ex {
-		mp.userByID[user.ID] = user
-		mp.userByEmail[user.Email] = user
-		mp.userLogin[user.Login] = user
+	newUser := *oldUser
+	newUser.UpdatedAt = user.UpdatedAt
+	for _, field := range fields {
+		switch field {
+		case model.UserFieldLogin:
+			newUser.Login = user.Login
+		case model.UserFieldFirstName:
+			newUser.FirstName = user.FirstName
+		case model.UserFieldLastName:
+			newUser.LastName = user.LastName
+		case model.UserFieldEmail:
+			if newUser.Email != user.Email {
+				newUser.EmailVerifiedAt = nil
+			}
+			newUser.Email = user.Email
+		case model.UserFieldPassword:
+			newUser.Password = user.Password
+		default:
+			return db.ErrDBUserFieldUnknown
+		}
+	}
+	if userLogin, ex := mp.userLogin[newUser.Login]; ex && userLogin.ID != user.ID {
+		return db.ErrDBLoginTaken
+	}
+	if userEmail, ex := mp.userByEmail[newUser.Email]; ex && userEmail.ID != user.ID {
+		return db.ErrDBEmailTaken
+	}
+	newUser.Version++
+	user.Version = newUser.Version
+	delete(mp.userByEmail, oldUser.Email)
+	delete(mp.userLogin, oldUser.Login)
+	mp.createUser(&newUser)
+	return nil
+}
+
+func (mp *mockProvider) SetTokensValidAfter(_ context.Context, id uint, validAfter time.Time) error {
+	if user, ex := mp.userByID[id]; ex {
+		user.TokensValidAfter = &validAfter
 		return nil
 	}
 	return ErrMockDB
 }
 
-func (mp *mockProvider) RemoveUserByID(ctx context.Context, id uint) error {
+func (mp *mockProvider) SetEmailVerified(_ context.Context, id uint, email string, verifiedAt time.Time) error {
+	user, ex := mp.userByID[id]
+	if !ex || user.Email != email {
+		return ErrMockDB
+	}
+	if user.EmailVerifiedAt == nil {
+		user.EmailVerifiedAt = &verifiedAt
+	}
+	return nil
+}
+
+func (mp *mockProvider) UpdatePasswordHash(_ context.Context, id uint, oldHash, newHash string) error {
+	user, ex := mp.userByID[id]
+	if !ex || user.Password != oldHash {
+		return ErrMockDB
+	}
+	user.Password = newHash
+	return nil
+}
+
+func (mp *mockProvider) RemoveUserByID(ctx context.Context, id, version uint) error {
 	if user, ex := mp.userByID[id]; ex {
+		if user.Version != version {
+			return db.ErrDBVersionConflict
+		}
 		delete(mp.userByID, id)
 		delete(mp.userByEmail, user.Email)
 		delete(mp.userLogin, user.Login)
+		for hash, token := range mp.refreshTokenByHash {
+			if token.UserID == id {
+				delete(mp.refreshTokenByHash, hash)
+			}
+		}
+		for jti, token := range mp.revokedTokenByJTI {
+			if token.UserID == id {
+				delete(mp.revokedTokenByJTI, jti)
+			}
+		}
+		for sessionID, session := range mp.sessionByID {
+			if session.UserID == id {
+				delete(mp.sessionByID, sessionID)
+			}
+		}
+		mp.removeMFA(id)
 		return nil
 	}
-	return ErrMockDB
+	return db.ErrDBNotFound
+}
+
+func (mp *mockProvider) CreateRefreshToken(_ context.Context, token *model.RefreshToken) error {
+	if _, ex := mp.userByID[token.UserID]; !ex {
+		return ErrMockDB
+	}
+	if _, ex := mp.refreshTokenByHash[token.TokenHash]; ex {
+		return ErrMockDB
+	}
+	mp.refreshTokenID++
+	token.ID = mp.refreshTokenID
+	tokenCopy := *token
+	mp.refreshTokenByHash[token.TokenHash] = &tokenCopy
+	return nil
+}
+
+func (mp *mockProvider) UseRefreshToken(_ context.Context, tokenHash string, usedAt time.Time) (*model.RefreshToken, error) {
+	token, ex := mp.refreshTokenByHash[tokenHash]
+	if !ex || token.UsedAt != nil || token.RevokedAt != nil || !token.ExpiresAt.After(usedAt) {
+		return nil, ErrMockDB
+	}
+	token.UsedAt = &usedAt
+	tokenCopy := *token
+	return &tokenCopy, nil
+}
+
+func (mp *mockProvider) FindRefreshToken(_ context.Context, tokenHash string) (*model.RefreshToken, error) {
+	if token, ex := mp.refreshTokenByHash[tokenHash]; ex {
+		tokenCopy := *token
+		return &tokenCopy, nil
+	}
+	return nil, ErrMockDB
+}
+
+func (mp *mockProvider) RevokeRefreshTokenFamily(_ context.Context, familyID string, revokedAt time.Time) error {
+	for _, token := range mp.refreshTokenByHash {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
 }
 
 func (mp *mockProvider) ClosePool() {
 }
+
+func (mp *mockProvider) RevokeRefreshTokensByUserID(_ context.Context, userID uint, revokedAt time.Time) error {
+	for _, token := range mp.refreshTokenByHash {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (mp *mockProvider) CreateSession(_ context.Context, session *model.Session) error {
+	if _, ex := mp.userByID[session.UserID]; !ex {
+		return ErrMockDB
+	}
+	if _, ex := mp.sessionByID[session.ID]; ex {
+		return ErrMockDB
+	}
+	sessionCopy := *session
+	mp.sessionByID[session.ID] = &sessionCopy
+	return nil
+}
+
+func (mp *mockProvider) FindSessionByID(_ context.Context, id string) (*model.Session, error) {
+	if session, ex := mp.sessionByID[id]; ex {
+		sessionCopy := *session
+		return &sessionCopy, nil
+	}
+	return nil, db.ErrDBNotFound
+}
+
+func (mp *mockProvider) ListSessionsByUserID(_ context.Context, userID uint) ([]*model.Session, error) {
+	sessions := []*model.Session{}
+	for _, session := range mp.sessionByID {
+		if session.UserID == userID && !session.Revoked() {
+			sessionCopy := *session
+			sessions = append(sessions, &sessionCopy)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (mp *mockProvider) TouchSession(_ context.Context, id string, lastSeenAt time.Time) error {
+	if session, ex := mp.sessionByID[id]; ex && session.LastSeenAt.Before(lastSeenAt) {
+		session.LastSeenAt = lastSeenAt
+	}
+	return nil
+}
+
+func (mp *mockProvider) RevokeSession(_ context.Context, userID uint, id string, revokedAt time.Time) error {
+	session, ex := mp.sessionByID[id]
+	if !ex || session.UserID != userID || session.Revoked() {
+		return db.ErrDBNotFound
+	}
+	session.RevokedAt = &revokedAt
+	return nil
+}
+
+func (mp *mockProvider) RevokeSessionsByUserID(_ context.Context, userID uint, exceptID string, revokedAt time.Time) ([]string, error) {
+	ids := []string{}
+	for id, session := range mp.sessionByID {
+		if session.UserID == userID && id != exceptID && !session.Revoked() {
+			session.RevokedAt = &revokedAt
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (mp *mockProvider) FindLoginAttempt(_ context.Context, email string) (*model.LoginAttempt, error) {
+	if attempt, ex := mp.loginAttemptByEmail[email]; ex {
+		attemptCopy := *attempt
+		return &attemptCopy, nil
+	}
+	return &model.LoginAttempt{Email: email}, nil
+}
+
+func (mp *mockProvider) AddLoginFailure(_ context.Context, email string, failedAt, resetBefore time.Time) (*model.LoginAttempt, error) {
+	attempt, ex := mp.loginAttemptByEmail[email]
+	if !ex {
+		attempt = &model.LoginAttempt{Email: email}
+		mp.loginAttemptByEmail[email] = attempt
+	}
+	if attempt.LockedUntil != nil && !attempt.LockedUntil.After(failedAt) {
+		attempt.FailedCount = 0
+		attempt.LockedUntil = nil
+	}
+	if attempt.LastFailedAt.Before(resetBefore) {
+		attempt.FailedCount = 0
+	}
+	attempt.FailedCount++
+	attempt.LastFailedAt = failedAt
+	attemptCopy := *attempt
+	return &attemptCopy, nil
+}
+
+func (mp *mockProvider) LockLogin(_ context.Context, email string, lockedUntil time.Time) error {
+	if attempt, ex := mp.loginAttemptByEmail[email]; ex {
+		attempt.LockedUntil = &lockedUntil
+	}
+	return nil
+}
+
+func (mp *mockProvider) RemoveLoginFailure(_ context.Context, email string) error {
+	if attempt, ex := mp.loginAttemptByEmail[email]; ex && attempt.FailedCount > 0 {
+		attempt.FailedCount--
+	}
+	return nil
+}
+
+func (mp *mockProvider) RemoveLoginAttempts(_ context.Context, email string) error {
+	delete(mp.loginAttemptByEmail, email)
+	return nil
+}
+
+func (mp *mockProvider) RemoveExpiredLoginAttempts(_ context.Context, before, now time.Time) error {
+	for email, attempt := range mp.loginAttemptByEmail {
+		if attempt.LastFailedAt.Before(before) && (attempt.LockedUntil == nil || attempt.LockedUntil.Before(now)) {
+			delete(mp.loginAttemptByEmail, email)
+		}
+	}
+	return nil
+}
+
+func (mp *mockProvider) RevokeToken(_ context.Context, token *model.RevokedToken) error {
+	if _, ex := mp.userByID[token.UserID]; !ex {
+		return ErrMockDB
+	}
+	if _, ex := mp.revokedTokenByJTI[token.JTI]; !ex {
+		tokenCopy := *token
+		mp.revokedTokenByJTI[token.JTI] = &tokenCopy
+	}
+	return nil
+}
+
+func (mp *mockProvider) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	_, ex := mp.revokedTokenByJTI[jti]
+	return ex, nil
+}
+
+func (mp *mockProvider) RemoveExpiredRevokedTokens(_ context.Context, now time.Time) error {
+	for jti, token := range mp.revokedTokenByJTI {
+		if token.ExpiresAt.Before(now) {
+			delete(mp.revokedTokenByJTI, jti)
+		}
+	}
+	return nil
+}
+
+func (mp *mockProvider) ReserveIdempotencyKey(_ context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	id := key.Scope + " " + key.Key
+	if stored, ex := mp.idempotencyKeyByKey[id]; ex && stored.ExpiresAt.After(key.CreatedAt) &&
+		!(stored.InProgress() && !stored.LockedUntil.After(key.CreatedAt) && stored.Fingerprint == key.Fingerprint) {
+		storedCopy := *stored
+		return &storedCopy, nil
+	}
+	keyCopy := *key
+	keyCopy.Response, keyCopy.Header = nil, nil
+	mp.idempotencyKeyByKey[id] = &keyCopy
+	return nil, nil
+}
+
+func (mp *mockProvider) SaveIdempotencyResponse(_ context.Context, key *model.IdempotencyKey) error {
+	if stored, ex := mp.idempotencyKeyByKey[key.Scope+" "+key.Key]; ex {
+		stored.Response = key.Response
+		stored.Header = key.Header
+		stored.LockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (mp *mockProvider) RemoveIdempotencyKey(_ context.Context, key, scope string) error {
+	delete(mp.idempotencyKeyByKey, scope+" "+key)
+	return nil
+}
+
+func (mp *mockProvider) RemoveExpiredIdempotencyKeys(_ context.Context, now time.Time) error {
+	for id, key := range mp.idempotencyKeyByKey {
+		if !key.ExpiresAt.After(now) {
+			delete(mp.idempotencyKeyByKey, id)
+		}
+	}
+	return nil
+}
+
+func (mp *mockProvider) CreateOneTimeToken(_ context.Context, token *model.OneTimeToken) error {
+	for id, other := range mp.oneTimeTokenByID {
+		if (other.UserID == token.UserID && other.Purpose == token.Purpose) || !other.ExpiresAt.After(token.CreatedAt) {
+			delete(mp.oneTimeTokenByID, id)
+		}
+	}
+	tokenCopy := *token
+	mp.oneTimeTokenByID[token.ID] = &tokenCopy
+	return nil
+}
+
+func (mp *mockProvider) UseOneTimeToken(_ context.Context, id, purpose string, now time.Time) (*model.OneTimeToken, error) {
+	token, ex := mp.oneTimeTokenByID[id]
+	if !ex || token.Purpose != purpose || !token.ExpiresAt.After(now) {
+		return nil, ErrMockDB
+	}
+	delete(mp.oneTimeTokenByID, id)
+	return token, nil
+}
+
+func (mp *mockProvider) FindMFA(_ context.Context, userID uint) (*model.MFA, error) {
+	if mfa, ex := mp.mfaByUserID[userID]; ex {
+		mfaCopy := *mfa
+		return &mfaCopy, nil
+	}
+	return &model.MFA{UserID: userID}, nil
+}
+
+func (mp *mockProvider) SaveMFA(_ context.Context, mfa *model.MFA) error {
+	if _, ex := mp.userByID[mfa.UserID]; !ex {
+		return ErrMockDB
+	}
+	if other, ex := mp.mfaByUserID[mfa.UserID]; ex && other.Enabled() {
+		return ErrMockDB
+	}
+	mp.mfaByUserID[mfa.UserID] = &model.MFA{UserID: mfa.UserID, Secret: mfa.Secret, CreatedAt: mfa.CreatedAt}
+	return nil
+}
+
+func (mp *mockProvider) ConfirmMFA(_ context.Context, userID uint, step int64, confirmedAt time.Time) error {
+	mfa, ex := mp.mfaByUserID[userID]
+	if !ex || mfa.Enabled() {
+		return ErrMockDB
+	}
+	mfa.ConfirmedAt = &confirmedAt
+	mfa.LastUsedStep = step
+	return nil
+}
+
+func (mp *mockProvider) UseMFAStep(_ context.Context, userID uint, step int64) error {
+	mfa, ex := mp.mfaByUserID[userID]
+	if !ex || !mfa.Enabled() || mfa.LastUsedStep >= step {
+		return ErrMockDB
+	}
+	mfa.LastUsedStep = step
+	return nil
+}
+
+func (mp *mockProvider) RemoveMFA(_ context.Context, userID uint) error {
+	mp.removeMFA(userID)
+	return nil
+}
+
+func (mp *mockProvider) removeMFA(userID uint) {
+	delete(mp.mfaByUserID, userID)
+	for key, code := range mp.recoveryCodeByKey {
+		if code.UserID == userID {
+			delete(mp.recoveryCodeByKey, key)
+		}
+	}
+}
+
+func (mp *mockProvider) ReplaceRecoveryCodes(_ context.Context, userID uint, hashes []string, createdAt time.Time) error {
+	if _, ex := mp.mfaByUserID[userID]; !ex {
+		return ErrMockDB
+	}
+	for key, code := range mp.recoveryCodeByKey {
+		if code.UserID == userID {
+			delete(mp.recoveryCodeByKey, key)
+		}
+	}
+	for _, hash := range hashes {
+		mp.recoveryCodeByKey[recoveryCodeKey(userID, hash)] = &model.RecoveryCode{
+			UserID:    userID,
+			Hash:      hash,
+			CreatedAt: createdAt,
+		}
+	}
+	return nil
+}
+
+func (mp *mockProvider) UseRecoveryCode(_ context.Context, userID uint, hash string) error {
+	key := recoveryCodeKey(userID, hash)
+	if _, ex := mp.recoveryCodeByKey[key]; !ex {
+		return ErrMockDB
+	}
+	delete(mp.recoveryCodeByKey, key)
+	return nil
+}
+
+func recoveryCodeKey(userID uint, hash string) string {
+	return strconv.FormatUint(uint64(userID), 10) + " " + hash
+}
@@ -0,0 +1,82 @@
+package mock
+
+import (
+	"context"
+	"maps"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// WithTx - imitation of transaction, data is copied before fn and restored after error of fn or commit
+// nested WithTx is a part of the outer transaction
+func (mp *mockProvider) WithTx(_ context.Context, fn func(tx db.Provider) error) error {
+	if mp.inTx {
+		return fn(mp)
+	}
+	saved := mp.snapshot()
+	mp.inTx = true
+	err := fn(mp)
+	mp.inTx = false
+	if err == nil && mp.commitErr != nil {
+		err, mp.commitErr = mp.commitErr, nil
+	}
+	if err != nil {
+		mp.restore(saved)
+	}
+	return err
+}
+
+// FailCommit - the next commit of WithTx returns err, the transaction is rolled back
+func (mp *mockProvider) FailCommit(err error) {
+	mp.commitErr = err
+}
+
+// snapshot - copy of all data, structs are copied, so changes of fields are not seen in the copy
+func (mp *mockProvider) snapshot() *mockProvider {
+	saved := &mockProvider{
+		id:                  mp.id,
+		userByID:            make(map[uint]*model.User, len(mp.userByID)),
+		userByEmail:         make(map[string]*model.User, len(mp.userByEmail)),
+		userLogin:           make(map[string]*model.User, len(mp.userLogin)),
+		refreshTokenID:      mp.refreshTokenID,
+		refreshTokenByHash:  copyValues(mp.refreshTokenByHash),
+		revokedTokenByJTI:   copyValues(mp.revokedTokenByJTI),
+		sessionByID:         copyValues(mp.sessionByID),
+		loginAttemptByEmail: copyValues(mp.loginAttemptByEmail),
+		idempotencyKeyByKey: copyValues(mp.idempotencyKeyByKey),
+		oneTimeTokenByID:    copyValues(mp.oneTimeTokenByID),
+		mfaByUserID:         copyValues(mp.mfaByUserID),
+		recoveryCodeByKey:   copyValues(mp.recoveryCodeByKey),
+	}
+	for _, user := range mp.userByID {
+		userCopy := *user
+		saved.createUser(&userCopy)
+	}
+	return saved
+}
+
+func (mp *mockProvider) restore(saved *mockProvider) {
+	mp.id = saved.id
+	mp.userByID = saved.userByID
+	mp.userByEmail = saved.userByEmail
+	mp.userLogin = saved.userLogin
+	mp.refreshTokenID = saved.refreshTokenID
+	mp.refreshTokenByHash = saved.refreshTokenByHash
+	mp.revokedTokenByJTI = saved.revokedTokenByJTI
+	mp.sessionByID = saved.sessionByID
+	mp.loginAttemptByEmail = saved.loginAttemptByEmail
+	mp.idempotencyKeyByKey = saved.idempotencyKeyByKey
+	mp.oneTimeTokenByID = saved.oneTimeTokenByID
+	mp.mfaByUserID = saved.mfaByUserID
+	mp.recoveryCodeByKey = saved.recoveryCodeByKey
+}
+
+func copyValues[K comparable, V any](m map[K]*V) map[K]*V {
+	copied := maps.Clone(m)
+	for k, v := range copied {
+		vCopy := *v
+		copied[k] = &vCopy
+	}
+	return copied
+}
@@ -3,15 +3,20 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
 )
 
+// CreateUser - zero user.CreatedAt -> time of database, written time is set to user.CreatedAt
 func (p *provider) CreateUser(ctx context.Context, user *model.User) (uint, error) {
 	userID := uint(0)
-	err := p.dbPool.QueryRow(ctx, `
+	err := p.conn.QueryRow(ctx, `
 INSERT INTO users (
                    login,
                    password,
@@ -20,65 +25,199 @@ INSERT INTO users (
                    email,
                    created_at
                    )
-VALUES ($1,$2,$3,$4,$5,$6)
-RETURNING id;`,
+VALUES ($1,$2,$3,$4,$5,COALESCE($6, now()))
+RETURNING id, version, created_at;`,
 		user.Login,                             //1
 		user.Password,                          //2
 		user.FirstName,                         //3
 		whenStringEmptyThenNULL(user.LastName), //4
 		user.Email,                             //5
-		user.CreatedAt,                         //6
-	).Scan(&userID)
-	return userID, err
+		whenTimeZeroThenNULL(user.CreatedAt),   //6
+	).Scan(&userID, &user.Version, &user.CreatedAt)
+	return userID, classifyError(err)
 }
 
+// FindUserByEmail - case of email is not significant
 func (p *provider) FindUserByEmail(ctx context.Context, email string) (*model.User, error) {
-	row := p.dbPool.QueryRow(ctx, `
-SELECT * 
+	row := p.conn.QueryRow(ctx, `
+SELECT id,
+       login,
+       password,
+       first_name,
+       last_name,
+       email,
+       created_at,
+       updated_at,
+       tokens_valid_after,
+       version,
+       email_verified_at
 FROM users
-WHERE email = $1
+WHERE lower(email) = lower($1)
 LIMIT 1;`, email)
-	return scanUser(row)
+	u, err := scanUser(row)
+	return u, classifyError(err)
 }
 
 func (p *provider) FindUserByID(ctx context.Context, id uint) (*model.User, error) {
-	row := p.dbPool.QueryRow(ctx, `
-SELECT * 
+	row := p.conn.QueryRow(ctx, `
+SELECT id,
+       login,
+       password,
+       first_name,
+       last_name,
+       email,
+       created_at,
+       updated_at,
+       tokens_valid_after,
+       version,
+       email_verified_at
 FROM users
 WHERE id = $1
 LIMIT 1;`, id)
-	return scanUser(row)
+	u, err := scanUser(row)
+	return u, classifyError(err)
+}
+
+// UpdateUser - write only 'fields' of user (model.UserFieldLogin ...) and updated_at, no fields - all of them
+// row is written only if its version is user.Version, then version is incremented and set to user.Version
+// other version -> ErrDBVersionConflict, empty last name is written as NULL
+// nil user.UpdatedAt -> time of database, written time is set to user.UpdatedAt
+func (p *provider) UpdateUser(ctx context.Context, user *model.User, fields ...string) error {
+	if len(fields) == 0 {
+		fields = model.UserUpdateFields
+	}
+	query, args, err := updateUserQuery(user, fields)
+	if err != nil {
+		return err
+	}
+	version := uint(0)
+	updatedAt := time.Time{}
+	err = p.conn.QueryRow(ctx, query, args...).Scan(&version, &updatedAt)
+	if err != nil {
+		return p.versionError(ctx, user.ID, classifyError(err))
+	}
+	user.Version = version
+	user.UpdatedAt = &updatedAt
+	return nil
 }
 
-func (p *provider) UpdateUser(ctx context.Context, user *model.User) error {
+// updateUserQuery - statement 'UPDATE users' with a column for every field, names of columns are not taken from 'fields'
+func updateUserQuery(user *model.User, fields []string) (string, []any, error) {
+	args := []any{
+		user.ID,        //1
+		user.UpdatedAt, //2
+		user.Version,   //3
+	}
+	set := []string{"updated_at = COALESCE($2::TIMESTAMPTZ, now())", "version = version + 1"}
+	for _, field := range fields {
+		var column string
+		var value any
+		switch field {
+		case model.UserFieldLogin:
+			column, value = "login", user.Login
+		case model.UserFieldFirstName:
+			column, value = "first_name", user.FirstName
+		case model.UserFieldLastName:
+			column, value = "last_name", whenStringEmptyThenNULL(user.LastName)
+		case model.UserFieldEmail:
+			column, value = "email", user.Email
+			// other email is not verified, the same email keeps its verification
+			set = append(set, fmt.Sprintf("email_verified_at = CASE WHEN email = $%d THEN email_verified_at END", len(args)+1))
+		case model.UserFieldPassword:
+			column, value = "password", user.Password
+		default:
+			return "", nil, fmt.Errorf("%w - %s", ErrDBUserFieldUnknown, field)
+		}
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	return fmt.Sprintf(`
+UPDATE users
+SET %s
+WHERE id = $1
+  AND version = $3
+RETURNING version, updated_at;`, strings.Join(set, ",\n    ")), args, nil
+}
+
+// UpdatePasswordHash - replace hash of password, only if the hash was not changed since it was read
+func (p *provider) UpdatePasswordHash(ctx context.Context, id uint, oldHash, newHash string) error {
 	upID := uint(0)
-	err := p.dbPool.QueryRow(ctx, `
+	err := p.conn.QueryRow(ctx, `
 UPDATE users
-SET login = $2,
-    password = $3,
-    first_name = $4,
-    last_name = $5,
-    email = $6,
-    updated_at = $7
+SET password = $3
 WHERE id = $1
+  AND password = $2
 RETURNING id;`,
-		user.ID,                                //1
-		user.Login,                             //2
-		user.Password,                          //3
-		user.FirstName,                         //4
-		whenStringEmptyThenNULL(user.LastName), //5
-		user.Email,                             //6
-		user.UpdatedAt,                         //7
+		id,      //1
+		oldHash, //2
+		newHash, //3
 	).Scan(&upID)
-	return err
+	return classifyError(err)
+}
+
+// SetTokensValidAfter - all tokens of user issued before 'validAfter' become invalid
+func (p *provider) SetTokensValidAfter(ctx context.Context, id uint, validAfter time.Time) error {
+	upID := uint(0)
+	err := p.conn.QueryRow(ctx, `
+UPDATE users
+SET tokens_valid_after = $2
+WHERE id = $1
+RETURNING id;`,
+		id,         //1
+		validAfter, //2
+	).Scan(&upID)
+	return classifyError(err)
 }
-func (p *provider) RemoveUserByID(ctx context.Context, id uint) error {
+
+// SetEmailVerified - email of user is confirmed, only if user still has this email
+// other email -> pgx.ErrNoRows, time of the first confirmation is kept
+func (p *provider) SetEmailVerified(ctx context.Context, id uint, email string, verifiedAt time.Time) error {
+	upID := uint(0)
+	err := p.conn.QueryRow(ctx, `
+UPDATE users
+SET email_verified_at = COALESCE(email_verified_at, $3)
+WHERE id = $1
+  AND email = $2
+RETURNING id;`,
+		id,         //1
+		email,      //2
+		verifiedAt, //3
+	).Scan(&upID)
+	return classifyError(err)
+}
+
+// RemoveUserByID - remove user only if version of row is 'version', other version -> ErrDBVersionConflict
+func (p *provider) RemoveUserByID(ctx context.Context, id, version uint) error {
 	delID := uint(0)
-	err := p.dbPool.QueryRow(ctx, `
+	err := p.conn.QueryRow(ctx, `
 DELETE
 FROM users
 WHERE id = $1
-RETURNING id;`, id).Scan(&delID)
+  AND version = $2
+RETURNING id;`,
+		id,      //1
+		version, //2
+	).Scan(&delID)
+	if err != nil {
+		return p.versionError(ctx, id, classifyError(err))
+	}
+	return nil
+}
+
+// versionError - user was not written with version of condition: user exists -> ErrDBVersionConflict
+// not existing user keeps ErrDBNotFound
+func (p *provider) versionError(ctx context.Context, id uint, err error) error {
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	exists := false
+	if errExists := p.conn.QueryRow(ctx, `
+SELECT EXISTS(SELECT 1 FROM users WHERE id = $1);`, id).Scan(&exists); errExists != nil {
+		return classifyError(errExists)
+	}
+	if exists {
+		return ErrDBVersionConflict
+	}
 	return err
 }
 
@@ -86,8 +225,10 @@ func scanUser(row pgx.Row) (*model.User, error) {
 	var (
 		user model.User
 
-		lastName  sql.NullString
-		updatedAt sql.NullTime
+		lastName         sql.NullString
+		updatedAt        sql.NullTime
+		tokensValidAfter sql.NullTime
+		emailVerifiedAt  sql.NullTime
 	)
 	if err := row.Scan(
 		&user.ID,
@@ -98,6 +239,9 @@ func scanUser(row pgx.Row) (*model.User, error) {
 		&user.Email,
 		&user.CreatedAt,
 		&updatedAt,
+		&tokensValidAfter,
+		&user.Version,
+		&emailVerifiedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -107,9 +251,22 @@ func scanUser(row pgx.Row) (*model.User, error) {
 	if updatedAt.Valid {
 		user.UpdatedAt = &updatedAt.Time
 	}
+	if tokensValidAfter.Valid {
+		user.TokensValidAfter = &tokensValidAfter.Time
+	}
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
 	return &user, nil
 }
 
+func whenTimeZeroThenNULL(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 func whenStringEmptyThenNULL(s string) *string {
 	if s == "" {
 		return nil
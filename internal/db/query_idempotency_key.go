@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// ReserveIdempotencyKey - write key without response and with lease (LockedUntil), expired key is replaced
+// key in progress with expired lease is taken by the same request (fingerprint), its first request is lost
+// nil - key is reserved for this request, otherwise the stored key is returned
+// one statement - requests with the same key at the same time can't reserve it both
+func (p *provider) ReserveIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	reservedKey := ""
+	err := p.conn.QueryRow(ctx, `
+INSERT INTO idempotency_keys (key, scope, fingerprint, created_at, expires_at, locked_until)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (key, scope) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint,
+    response = NULL,
+    header = NULL,
+    created_at = EXCLUDED.created_at,
+    expires_at = EXCLUDED.expires_at,
+    locked_until = EXCLUDED.locked_until
+WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+   OR (idempotency_keys.response IS NULL
+       AND idempotency_keys.locked_until <= EXCLUDED.created_at
+       AND idempotency_keys.fingerprint = EXCLUDED.fingerprint)
+RETURNING key;`,
+		key.Key,         //1
+		key.Scope,       //2
+		key.Fingerprint, //3
+		key.CreatedAt,   //4
+		key.ExpiresAt,   //5
+		key.LockedUntil, //6
+	).Scan(&reservedKey)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, classifyError(err)
+	}
+	row := p.conn.QueryRow(ctx, `
+SELECT key, scope, fingerprint, response, header, created_at, expires_at, locked_until
+FROM idempotency_keys
+WHERE key = $1
+  AND scope = $2
+LIMIT 1;`,
+		key.Key,   //1
+		key.Scope, //2
+	)
+	return scanIdempotencyKey(row)
+}
+
+// SaveIdempotencyResponse - response of the request which reserved the key, lease is not needed anymore
+func (p *provider) SaveIdempotencyResponse(ctx context.Context, key *model.IdempotencyKey) error {
+	_, err := p.conn.Exec(ctx, `
+UPDATE idempotency_keys
+SET response = $3,
+    header = $4,
+    locked_until = NULL
+WHERE key = $1
+  AND scope = $2;`,
+		key.Key,      //1
+		key.Scope,    //2
+		key.Response, //3
+		key.Header,   //4
+	)
+	return classifyError(err)
+}
+
+// RemoveIdempotencyKey - request with the key failed, the key can be used again
+func (p *provider) RemoveIdempotencyKey(ctx context.Context, key, scope string) error {
+	_, err := p.conn.Exec(ctx, `
+DELETE
+FROM idempotency_keys
+WHERE key = $1
+  AND scope = $2;`,
+		key,   //1
+		scope, //2
+	)
+	return classifyError(err)
+}
+
+// RemoveExpiredIdempotencyKeys - expired keys are not replayed anyway, keep table small
+func (p *provider) RemoveExpiredIdempotencyKeys(ctx context.Context, now time.Time) error {
+	_, err := p.conn.Exec(ctx, `
+DELETE
+FROM idempotency_keys
+WHERE expires_at <= $1;`, now)
+	return classifyError(err)
+}
+
+func scanIdempotencyKey(row pgx.Row) (*model.IdempotencyKey, error) {
+	var (
+		key model.IdempotencyKey
+
+		lockedUntil sql.NullTime
+	)
+	if err := row.Scan(
+		&key.Key,
+		&key.Scope,
+		&key.Fingerprint,
+		&key.Response,
+		&key.Header,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&lockedUntil,
+	); err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		key.LockedUntil = lockedUntil.Time
+	}
+	return &key, nil
+}
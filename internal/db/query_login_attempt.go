@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// FindLoginAttempt - attempt without failures if email has no failed logins
+func (p *provider) FindLoginAttempt(ctx context.Context, email string) (*model.LoginAttempt, error) {
+	row := p.conn.QueryRow(ctx, `
+SELECT email, failed_count, last_failed_at, locked_until
+FROM login_attempts
+WHERE email = $1
+LIMIT 1;`, email)
+	attempt, err := scanLoginAttempt(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &model.LoginAttempt{Email: email}, nil
+	}
+	return attempt, err
+}
+
+// AddLoginFailure - count a failed login, failures before resetBefore or before the end of lock are forgotten
+// and counting starts again, the ended lock is removed
+// one statement - replicas counting failures of the same email at the same time don't lose them
+func (p *provider) AddLoginFailure(ctx context.Context, email string, failedAt, resetBefore time.Time) (*model.LoginAttempt, error) {
+	row := p.conn.QueryRow(ctx, `
+INSERT INTO login_attempts (email, failed_count, last_failed_at)
+VALUES ($1, 1, $2)
+ON CONFLICT (email) DO UPDATE
+SET failed_count = CASE
+                       WHEN login_attempts.last_failed_at < $3 OR login_attempts.locked_until <= $2 THEN 1
+                       ELSE login_attempts.failed_count + 1
+                   END,
+    locked_until = CASE
+                       WHEN login_attempts.locked_until <= $2 THEN NULL
+                       ELSE login_attempts.locked_until
+                   END,
+    last_failed_at = $2
+RETURNING email, failed_count, last_failed_at, locked_until;`,
+		email,       //1
+		failedAt,    //2
+		resetBefore, //3
+	)
+	return scanLoginAttempt(row)
+}
+
+// LockLogin - reject logins with email until lockedUntil, failures are kept (see AddLoginFailure)
+func (p *provider) LockLogin(ctx context.Context, email string, lockedUntil time.Time) error {
+	_, err := p.conn.Exec(ctx, `
+UPDATE login_attempts
+SET locked_until = $2
+WHERE email = $1;`,
+		email,       //1
+		lockedUntil, //2
+	)
+	return err
+}
+
+// RemoveLoginFailure - undo one counted failure (attempt is counted before the password is checked)
+func (p *provider) RemoveLoginFailure(ctx context.Context, email string) error {
+	_, err := p.conn.Exec(ctx, `
+UPDATE login_attempts
+SET failed_count = GREATEST(failed_count - 1, 0)
+WHERE email = $1;`, email)
+	return err
+}
+
+// RemoveLoginAttempts - forget failures and lock of email (successful login or unlock by admin)
+func (p *provider) RemoveLoginAttempts(ctx context.Context, email string) error {
+	_, err := p.conn.Exec(ctx, `
+DELETE
+FROM login_attempts
+WHERE email = $1;`, email)
+	return err
+}
+
+// RemoveExpiredLoginAttempts - failures before 'before' are forgotten anyway, keep table small
+func (p *provider) RemoveExpiredLoginAttempts(ctx context.Context, before, now time.Time) error {
+	_, err := p.conn.Exec(ctx, `
+DELETE
+FROM login_attempts
+WHERE last_failed_at < $1
+  AND (locked_until IS NULL OR locked_until < $2);`,
+		before, //1
+		now,    //2
+	)
+	return err
+}
+
+func scanLoginAttempt(row pgx.Row) (*model.LoginAttempt, error) {
+	var (
+		attempt model.LoginAttempt
+
+		lockedUntil sql.NullTime
+	)
+	if err := row.Scan(
+		&attempt.Email,
+		&attempt.FailedCount,
+		&attempt.LastFailedAt,
+		&lockedUntil,
+	); err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		attempt.LockedUntil = &lockedUntil.Time
+	}
+	return &attempt, nil
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// FindMFA - TOTP of user, user without record gets MFA without secret (not enrolled)
+func (p *provider) FindMFA(ctx context.Context, userID uint) (*model.MFA, error) {
+	row := p.conn.QueryRow(ctx, `
+SELECT user_id, secret, confirmed_at, last_used_step, created_at
+FROM user_mfa
+WHERE user_id = $1
+LIMIT 1;`, userID)
+	mfa, err := scanMFA(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &model.MFA{UserID: userID}, nil
+	}
+	return mfa, classifyError(err)
+}
+
+// SaveMFA - write a new secret of user, secret of not confirmed enrollment is replaced
+// confirmed TOTP is not replaced -> pgx.ErrNoRows
+func (p *provider) SaveMFA(ctx context.Context, mfa *model.MFA) error {
+	upID := uint(0)
+	err := p.conn.QueryRow(ctx, `
+INSERT INTO user_mfa (user_id, secret, confirmed_at, last_used_step, created_at)
+VALUES ($1, $2, NULL, 0, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret,
+    last_used_step = 0,
+    created_at = EXCLUDED.created_at
+WHERE user_mfa.confirmed_at IS NULL
+RETURNING user_id;`,
+		mfa.UserID,    //1
+		mfa.Secret,    //2
+		mfa.CreatedAt, //3
+	).Scan(&upID)
+	return classifyError(err)
+}
+
+// ConfirmMFA - enable TOTP of user by the first code with time step
+// confirmed TOTP or not enrolled user -> pgx.ErrNoRows
+func (p *provider) ConfirmMFA(ctx context.Context, userID uint, step int64, confirmedAt time.Time) error {
+	upID := uint(0)
+	err := p.conn.QueryRow(ctx, `
+UPDATE user_mfa
+SET confirmed_at = $3,
+    last_used_step = $2
+WHERE user_id = $1
+  AND confirmed_at IS NULL
+RETURNING user_id;`,
+		userID,      //1
+		step,        //2
+		confirmedAt, //3
+	).Scan(&upID)
+	return classifyError(err)
+}
+
+// UseMFAStep - accept code of time step, the step must be after the last used one
+// in one statement - the same code at the same time is accepted only once, reused code -> pgx.ErrNoRows
+func (p *provider) UseMFAStep(ctx context.Context, userID uint, step int64) error {
+	upID := uint(0)
+	err := p.conn.QueryRow(ctx, `
+UPDATE user_mfa
+SET last_used_step = $2
+WHERE user_id = $1
+  AND confirmed_at IS NOT NULL
+  AND last_used_step < $2
+RETURNING user_id;`,
+		userID, //1
+		step,   //2
+	).Scan(&upID)
+	return classifyError(err)
+}
+
+// RemoveMFA - disable TOTP of user, recovery codes are removed by cascade
+func (p *provider) RemoveMFA(ctx context.Context, userID uint) error {
+	_, err := p.conn.Exec(ctx, `
+DELETE
+FROM user_mfa
+WHERE user_id = $1;`, userID)
+	return classifyError(err)
+}
+
+// ReplaceRecoveryCodes - hashes of new recovery codes, the previous codes of user are removed
+// two statements, call it in transaction (WithTx)
+func (p *provider) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string, createdAt time.Time) error {
+	if _, err := p.conn.Exec(ctx, `
+DELETE
+FROM mfa_recovery_codes
+WHERE user_id = $1;`, userID); err != nil {
+		return classifyError(err)
+	}
+	_, err := p.conn.Exec(ctx, `
+INSERT INTO mfa_recovery_codes (user_id, hash, created_at)
+SELECT $1, hash, $3
+FROM unnest($2::VARCHAR(64)[]) AS hash;`,
+		userID,    //1
+		hashes,    //2
+		createdAt, //3
+	)
+	return classifyError(err)
+}
+
+// UseRecoveryCode - remove record of code in one statement, code is used once
+// used or unknown code -> pgx.ErrNoRows
+func (p *provider) UseRecoveryCode(ctx context.Context, userID uint, hash string) error {
+	upID := uint(0)
+	err := p.conn.QueryRow(ctx, `
+DELETE
+FROM mfa_recovery_codes
+WHERE user_id = $1
+  AND hash = $2
+RETURNING user_id;`,
+		userID, //1
+		hash,   //2
+	).Scan(&upID)
+	return classifyError(err)
+}
+
+func scanMFA(row pgx.Row) (*model.MFA, error) {
+	var (
+		mfa model.MFA
+
+		confirmedAt sql.NullTime
+	)
+	if err := row.Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&confirmedAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if confirmedAt.Valid {
+		mfa.ConfirmedAt = &confirmedAt.Time
+	}
+	return &mfa, nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// CreateOneTimeToken - write record of token, other tokens of user with the same purpose are removed
+// only the last sent token is valid, expired tokens of all users are removed too
+func (p *provider) CreateOneTimeToken(ctx context.Context, token *model.OneTimeToken) error {
+	_, err := p.conn.Exec(ctx, `
+WITH removed AS (
+    DELETE
+    FROM one_time_tokens
+    WHERE (user_id = $2 AND purpose = $3)
+       OR expires_at <= $5
+)
+INSERT INTO one_time_tokens (id, user_id, purpose, email, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6);`,
+		token.ID,        //1
+		token.UserID,    //2
+		token.Purpose,   //3
+		token.Email,     //4
+		token.CreatedAt, //5
+		token.ExpiresAt, //6
+	)
+	return classifyError(err)
+}
+
+// UseOneTimeToken - remove record of token in one statement and return it
+// token is used once, used, replaced, expired token or token of other purpose -> pgx.ErrNoRows
+func (p *provider) UseOneTimeToken(ctx context.Context, id, purpose string, now time.Time) (*model.OneTimeToken, error) {
+	row := p.conn.QueryRow(ctx, `
+DELETE
+FROM one_time_tokens
+WHERE id = $1
+  AND purpose = $2
+  AND expires_at > $3
+RETURNING id, user_id, purpose, email, created_at, expires_at;`,
+		id,      //1
+		purpose, //2
+		now,     //3
+	)
+	return scanOneTimeToken(row)
+}
+
+func scanOneTimeToken(row pgx.Row) (*model.OneTimeToken, error) {
+	var token model.OneTimeToken
+	if err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.Email,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+func (p *provider) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	tokenID := uint(0)
+	err := p.conn.QueryRow(ctx, `
+INSERT INTO refresh_tokens (
+                            user_id,
+                            family_id,
+                            token_hash,
+                            created_at,
+                            expires_at,
+                            auth_time
+                            )
+VALUES ($1,$2,$3,$4,$5,$6)
+RETURNING id;`,
+		token.UserID,    //1
+		token.FamilyID,  //2
+		token.TokenHash, //3
+		token.CreatedAt, //4
+		token.ExpiresAt, //5
+		token.AuthTime,  //6
+	).Scan(&tokenID)
+	token.ID = tokenID
+	return err
+}
+
+// UseRefreshToken - mark the token as spent in one statement
+// only an active token (not used, not revoked, not expired) can be spent, otherwise pgx.ErrNoRows
+func (p *provider) UseRefreshToken(ctx context.Context, tokenHash string, usedAt time.Time) (*model.RefreshToken, error) {
+	row := p.conn.QueryRow(ctx, `
+UPDATE refresh_tokens
+SET used_at = $2
+WHERE token_hash = $1
+  AND used_at IS NULL
+  AND revoked_at IS NULL
+  AND expires_at > $2
+RETURNING id, user_id, family_id, token_hash, COALESCE(auth_time, created_at), created_at, expires_at, used_at, revoked_at;`,
+		tokenHash, //1
+		usedAt,    //2
+	)
+	return scanRefreshToken(row)
+}
+
+func (p *provider) FindRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	row := p.conn.QueryRow(ctx, `
+SELECT id, user_id, family_id, token_hash, COALESCE(auth_time, created_at), created_at, expires_at, used_at, revoked_at
+FROM refresh_tokens
+WHERE token_hash = $1
+LIMIT 1;`, tokenHash)
+	return scanRefreshToken(row)
+}
+
+func (p *provider) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	_, err := p.conn.Exec(ctx, `
+UPDATE refresh_tokens
+SET revoked_at = $2
+WHERE family_id = $1
+  AND revoked_at IS NULL;`,
+		familyID,  //1
+		revokedAt, //2
+	)
+	return err
+}
+
+func (p *provider) RevokeRefreshTokensByUserID(ctx context.Context, userID uint, revokedAt time.Time) error {
+	_, err := p.conn.Exec(ctx, `
+UPDATE refresh_tokens
+SET revoked_at = $2
+WHERE user_id = $1
+  AND revoked_at IS NULL;`,
+		userID,    //1
+		revokedAt, //2
+	)
+	return err
+}
+
+func scanRefreshToken(row pgx.Row) (*model.RefreshToken, error) {
+	var (
+		token model.RefreshToken
+
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+	if err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.AuthTime,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+	); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
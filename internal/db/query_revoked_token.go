@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// RevokeToken - write "jti" of token to revoked, repeated revoke is not an error
+func (p *provider) RevokeToken(ctx context.Context, token *model.RevokedToken) error {
+	_, err := p.conn.Exec(ctx, `
+INSERT INTO revoked_tokens (
+                            jti,
+                            user_id,
+                            expires_at,
+                            revoked_at
+                            )
+VALUES ($1,$2,$3,$4)
+ON CONFLICT (jti) DO NOTHING;`,
+		token.JTI,       //1
+		token.UserID,    //2
+		token.ExpiresAt, //3
+		token.RevokedAt, //4
+	)
+	return err
+}
+
+func (p *provider) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	revoked := false
+	err := p.conn.QueryRow(ctx, `
+SELECT EXISTS (
+    SELECT 1
+    FROM revoked_tokens
+    WHERE jti = $1
+);`, jti).Scan(&revoked)
+	return revoked, err
+}
+
+// RemoveExpiredRevokedTokens - expired token is rejected anyway, keep table small
+func (p *provider) RemoveExpiredRevokedTokens(ctx context.Context, now time.Time) error {
+	_, err := p.conn.Exec(ctx, `
+DELETE
+FROM revoked_tokens
+WHERE expires_at < $1;`, now)
+	return err
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+func (p *provider) CreateSession(ctx context.Context, session *model.Session) error {
+	_, err := p.conn.Exec(ctx, `
+INSERT INTO sessions (
+                      id,
+                      user_id,
+                      created_at,
+                      last_seen_at,
+                      client_ip,
+                      user_agent
+                      )
+VALUES ($1,$2,$3,$4,$5,$6);`,
+		session.ID,         //1
+		session.UserID,     //2
+		session.CreatedAt,  //3
+		session.LastSeenAt, //4
+		session.ClientIP,   //5
+		session.UserAgent,  //6
+	)
+	return err
+}
+
+func (p *provider) FindSessionByID(ctx context.Context, id string) (*model.Session, error) {
+	row := p.conn.QueryRow(ctx, `
+SELECT id, user_id, created_at, last_seen_at, client_ip, user_agent, revoked_at
+FROM sessions
+WHERE id = $1
+LIMIT 1;`, id)
+	session, err := scanSession(row)
+	return session, classifyError(err)
+}
+
+// ListSessionsByUserID - active (not revoked) sessions of user, the last seen first
+func (p *provider) ListSessionsByUserID(ctx context.Context, userID uint) ([]*model.Session, error) {
+	rows, err := p.conn.Query(ctx, `
+SELECT id, user_id, created_at, last_seen_at, client_ip, user_agent, revoked_at
+FROM sessions
+WHERE user_id = $1
+  AND revoked_at IS NULL
+ORDER BY last_seen_at DESC;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*model.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (p *provider) TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error {
+	_, err := p.conn.Exec(ctx, `
+UPDATE sessions
+SET last_seen_at = $2
+WHERE id = $1
+  AND last_seen_at < $2;`,
+		id,         //1
+		lastSeenAt, //2
+	)
+	return err
+}
+
+// RevokeSession - only an active session of the user can be revoked, otherwise ErrDBNotFound
+func (p *provider) RevokeSession(ctx context.Context, userID uint, id string, revokedAt time.Time) error {
+	revokedID := ""
+	err := p.conn.QueryRow(ctx, `
+UPDATE sessions
+SET revoked_at = $3
+WHERE id = $1
+  AND user_id = $2
+  AND revoked_at IS NULL
+RETURNING id;`,
+		id,        //1
+		userID,    //2
+		revokedAt, //3
+	).Scan(&revokedID)
+	return classifyError(err)
+}
+
+// RevokeSessionsByUserID - revoke all active sessions of the user except 'exceptID'
+// return IDs of revoked sessions
+func (p *provider) RevokeSessionsByUserID(ctx context.Context, userID uint, exceptID string, revokedAt time.Time) ([]string, error) {
+	rows, err := p.conn.Query(ctx, `
+UPDATE sessions
+SET revoked_at = $3
+WHERE user_id = $1
+  AND id <> $2
+  AND revoked_at IS NULL
+RETURNING id;`,
+		userID,    //1
+		exceptID,  //2
+		revokedAt, //3
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func scanSession(row pgx.Row) (*model.Session, error) {
+	var (
+		session model.Session
+
+		revokedAt sql.NullTime
+	)
+	if err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ClientIP,
+		&session.UserAgent,
+		&revokedAt,
+	); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return &session, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+// default options of transactions
+const (
+	defaultTxIsolation  = pgx.Serializable
+	defaultTxMaxRetries = 3
+)
+
+// txRetryDelay - wait before the first retry, doubled with every next retry
+const txRetryDelay = 10 * time.Millisecond
+
+// codes of postgresql errors after which transaction can be repeated
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+type txOptions struct {
+	isoLevel   pgx.TxIsoLevel
+	maxRetries int
+}
+
+func newTxOptions(cfg *config.DataBaseConfig) txOptions {
+	opts := txOptions{
+		isoLevel:   pgx.TxIsoLevel(cfg.TxIsolation),
+		maxRetries: int(cfg.TxMaxRetries),
+	}
+	if opts.isoLevel == "" {
+		opts.isoLevel = defaultTxIsolation
+	}
+	if opts.maxRetries == 0 {
+		opts.maxRetries = defaultTxMaxRetries
+	}
+	return opts
+}
+
+// WithTx - begin transaction with isolation level of config, call fn with provider of transaction
+// error of fn -> rollback, error is returned as is
+// serialization failure or deadlock (of queries or commit) -> the whole transaction is repeated, fn should not have
+// side effects outside of tx
+func (p *provider) WithTx(ctx context.Context, fn func(tx Provider) error) error {
+	if p.inTx {
+		return fn(p)
+	}
+	for retry := 0; ; retry++ {
+		err := p.runTx(ctx, fn)
+		if err == nil || !retryableTx(err) || retry >= p.tx.maxRetries {
+			return err
+		}
+		log.Printf("db: WithTx retry %d error - {%v};", retry+1, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(txRetryDelay << retry):
+		}
+	}
+}
+
+func (p *provider) runTx(ctx context.Context, fn func(tx Provider) error) error {
+	tx, err := p.dbPool.BeginTx(ctx, pgx.TxOptions{IsoLevel: p.tx.isoLevel})
+	if err != nil {
+		return classifyError(err)
+	}
+	// after commit rollback does nothing
+	defer func() {
+		if errRollback := tx.Rollback(ctx); errRollback != nil && !errors.Is(errRollback, pgx.ErrTxClosed) {
+			log.Printf("db: WithTx Rollback error - {%v};", errRollback)
+		}
+	}()
+
+	if err := fn(&provider{dbPool: p.dbPool, conn: tx, tx: p.tx, inTx: true}); err != nil {
+		return err
+	}
+	return classifyError(tx.Commit(ctx))
+}
+
+// retryableTx - transaction failed only because of concurrent transactions
+func retryableTx(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected)
+}
@@ -0,0 +1,44 @@
+// http server with public keys of jwt keyring (JSON Web Key Set)
+package jwks
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+)
+
+// Path - well-known location of JWKS document
+const Path = "/.well-known/jwks.json"
+
+// cacheControl - clients refresh keys at least once in 5 minutes, rotated key is published in time
+const cacheControl = "public, max-age=300"
+
+// NewServer - http.Server with single route 'Path'
+func NewServer(cfg *config.ServerConfig) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+Path, Handler)
+
+	return &http.Server{
+		Addr:              net.JoinHostPort("", strconv.FormatUint(uint64(cfg.JWKSPort), 10)),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+// Handler - write current JWKS, keys are read from keyring on every request (reload is seen at once)
+func Handler(w http.ResponseWriter, _ *http.Request) {
+	body, err := jwtsign.JWKS()
+	if err != nil {
+		log.Printf("jwks: JWKS error - {%v};", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", cacheControl)
+	_, _ = w.Write(body)
+}
@@ -1,107 +1,207 @@
-// contains a global non-exported variable 'secretKey' for working with jwt
-// sets secretKey during application startup from config.Config
+// contains a global non-exported variable 'keyring' for working with jwt
+// sets keyring during application startup (and reload) from config.JWTConfig
 // contains functions for generating and parsing jwt.Token
 package jwtsign
 
 import (
 	"errors"
-	"fmt"
-	"log"
-	"sync"
+	"math"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 
-	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
 )
 
 var (
 	ErrJWTSecretKeyEmpty = errors.New("secret key not found")
 
-	// ErrJWTContentInvalid - len('Content'(map[string]string)==0)
+	// ErrJWTContentInvalid - field of 'Content' is not a string or is a registered claim
 	ErrJWTContentInvalid = errors.New("invalid content")
+
+	// ErrJWTSubjectEmpty - token without "sub"
+	ErrJWTSubjectEmpty = errors.New("subject not found")
 )
 
-var (
-	// once - used in NewSecretKey
-	once sync.Once
+// registeredClaims - names of claims set by 'TokenGenerator', 'Content' can not contain them
+var registeredClaims = []string{"iss", "aud", "sub", "iat", "nbf", "exp", "jti", "auth_time"}
 
-	// SecretKey -  key for jwt.Token
-	secretKey = ""
-)
+// Content - private claims of token
+type Content map[string]string
 
-// NewSecretKey - call in Run -> during application startup
-func NewSecretKey(cfg *config.Config) error {
-	once.Do(func() {
-		secretKey = cfg.JWTSecretKey
-		log.Print("jwtsign: secret key initialized")
-	})
-	if secretKey == "" {
-		return fmt.Errorf("jwtsign: NewSecretKey error - {%w};", ErrJWTSecretKeyEmpty)
-	}
-	return nil
-}
+// SessionIDKey - key of 'Content' with ID of session
+const SessionIDKey = "sid"
 
-// time exploration for jwt.Token see 'TokenGenerator'
-const tokenLife = 7 * 24 * time.Hour
+// Claims - registered claims of token and the rest fields as 'Content'
+type Claims struct {
+	Content Content
 
-type Content map[string]string
+	// ID - "jti", unique id of token, used for revocation
+	ID string
 
-// TokenGenerator - create jwt token using specific key
-// set time of exploration in claims
-func TokenGenerator(content Content) (string, error) {
-	if secretKey == "" {
-		return "", ErrJWTSecretKeyEmpty
+	Issuer   string
+	Audience []string
+	// Subject - "sub", ID of user
+	Subject string
+
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+	// AuthTime - "auth_time", time of login which started the session
+	AuthTime time.Time
+}
+
+// TokenGenerator - create jwt token for subject using the current key of keyring (see signToken)
+// set time of login in claims, exploration is limited by 'Lifetime' of keyring
+func TokenGenerator(subject string, authTime time.Time, content Content) (string, error) {
+	expiresAt := func(now time.Time) (time.Time, error) {
+		return SessionLifetime().AccessExpiresAt(now, authTime)
 	}
-	if len(content) == 0 {
-		return "", ErrJWTContentInvalid
+	token, _, err := signToken(subject, content, expiresAt, jwt.MapClaims{"auth_time": authTime.Unix()})
+	return token, err
+}
+
+// signToken - create jwt token for subject using the current key of keyring, "kid" of the key is set in header
+// set issuer, audience, unique id, time of issue (in milliseconds), "nbf" and time of exploration in claims,
+// 'own' - claims of the kind of token ("auth_time", "purpose"), 'content' can't contain registered claims and "purpose"
+// "jti" of token is returned with it
+func signToken(subject string, content Content, expiresAt func(now time.Time) (time.Time, error), own jwt.MapClaims) (string, string, error) {
+	key, err := keyring.signer()
+	if err != nil {
+		return "", "", err
+	}
+	if subject == "" {
+		return "", "", ErrJWTSubjectEmpty
+	}
+	jti, err := randtoken.New()
+	if err != nil {
+		return "", "", err
 	}
 	claims := jwt.MapClaims{}
 	for key, val := range content {
 		claims[key] = val
 	}
-	claims["exp"] = time.Now().UTC().Add(tokenLife).Unix()
+	for _, name := range append(registeredClaims, PurposeKey) {
+		if _, ex := claims[name]; ex {
+			return "", "", ErrJWTContentInvalid
+		}
+	}
+	issuer, audience := keyring.registered()
+	now := time.Now().UTC()
+	exploration, err := expiresAt(now)
+	if err != nil {
+		return "", "", err
+	}
+	claims["iss"] = issuer
+	claims["aud"] = audience
+	claims["sub"] = subject
+	claims["jti"] = jti
+	claims["iat"] = float64(now.UnixMilli()) / 1000
+	claims["nbf"] = now.Unix()
+	claims["exp"] = exploration.Unix()
+	for name, val := range own {
+		claims[name] = val
+	}
 
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return jwtToken.SignedString([]byte(secretKey))
+	jwtToken := jwt.NewWithClaims(key.Method, claims)
+	jwtToken.Header["kid"] = key.ID
+	token, err := jwtToken.SignedString(key.signKey)
+	if err != nil {
+		return "", "", err
+	}
+	return token, jti, nil
 }
 
-// GetContentFromToken - get all fields without "exploration" from token
-func GetContentFromToken(token string) (Content, error) {
+// GetClaimsFromToken - get registered claims and all other fields as 'Content' from token
+// token must be issued by "iss" of keyring for the first "aud" of keyring (this service)
+// one-time token with "purpose" is not an access token
+func GetClaimsFromToken(token string) (*Claims, error) {
 	jwtToken, err := tokenRetrive(token)
 	if err != nil {
 		return nil, err
 	}
-	return receiveContentFromToken(jwtToken)
+	claims, err := receiveClaimsFromToken(jwtToken)
+	if err != nil {
+		return nil, err
+	}
+	if _, ex := claims.Content[PurposeKey]; ex {
+		return nil, ErrJWTPurposeInvalid
+	}
+	return claims, nil
 }
 
-// tokenRetrive - get jwt.Token from string
+// tokenRetrive - get jwt.Token from string, key for check is chosen by "kid" from header
+// "alg" of token must be the method of the key
+// "exp", "nbf", "iat", "iss" and "aud" are checked by parser
 func tokenRetrive(value string) (*jwt.Token, error) {
+	issuer, audience := keyring.registered()
+	if issuer == "" || len(audience) == 0 {
+		return nil, ErrJWTSecretKeyEmpty
+	}
 	return jwt.Parse(value, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrHashUnavailable
+		kid, _ := token.Header["kid"].(string)
+		key, err := keyring.verifier(kid)
+		if err != nil {
+			return nil, err
 		}
-		if secretKey == "" {
-			return nil, ErrJWTSecretKeyEmpty
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, jwt.ErrHashUnavailable
 		}
-		return []byte(secretKey), nil
-	})
+		return key.verifyKey, nil
+	},
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience[0]),
+	)
 }
 
-// receiveContentFromToken - check token expiration date and get date from jwt.MapClaims
-func receiveContentFromToken(token *jwt.Token) (Content, error) {
+// receiveClaimsFromToken - get registered claims and 'Content' from jwt.MapClaims
+func receiveClaimsFromToken(token *jwt.Token) (*Claims, error) {
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
 		return nil, jwt.ErrTokenInvalidClaims
 	}
-	exploration, ok := claims["exp"].(float64)
+	exploration, err := claims.GetExpirationTime()
+	if err != nil || exploration == nil {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	notBefore, err := claims.GetNotBefore()
+	if err != nil || notBefore == nil {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	issuedAt, ok := claims["iat"].(float64)
 	if !ok {
-		return nil, jwt.ErrInvalidKey
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return nil, jwt.ErrTokenInvalidId
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, jwt.ErrTokenInvalidSubject
 	}
-	if int64(exploration) < time.Now().UTC().Unix() {
-		return nil, jwt.ErrTokenExpired
+	issuer, err := claims.GetIssuer()
+	if err != nil {
+		return nil, jwt.ErrTokenInvalidIssuer
+	}
+	audience, err := claims.GetAudience()
+	if err != nil {
+		return nil, jwt.ErrTokenInvalidAudience
+	}
+	// token issued before "auth_time" was added - session started at "iat"
+	authTime := secondsToTime(issuedAt).Truncate(time.Second)
+	if value, ex := claims["auth_time"]; ex {
+		seconds, ok := value.(float64)
+		if !ok {
+			return nil, jwt.ErrTokenInvalidClaims
+		}
+		authTime = time.Unix(int64(seconds), 0).UTC()
+	}
+	for _, name := range registeredClaims {
+		delete(claims, name)
 	}
-	delete(claims, "exp")
 	contetn := Content{}
 	for key, val := range claims {
 		line, ok := val.(string)
@@ -110,8 +210,26 @@ func receiveContentFromToken(token *jwt.Token) (Content, error) {
 		}
 		contetn[key] = line
 	}
-	if len(contetn) == 0 {
-		return nil, ErrJWTContentInvalid
-	}
-	return contetn, nil
+	return &Claims{
+		Content:   contetn,
+		ID:        jti,
+		Issuer:    issuer,
+		Audience:  audience,
+		Subject:   subject,
+		IssuedAt:  secondsToTime(issuedAt),
+		NotBefore: notBefore.UTC(),
+		ExpiresAt: exploration.UTC(),
+		AuthTime:  authTime,
+	}, nil
+}
+
+// SessionID - ID of session from 'Content', empty for tokens issued before sessions
+func (c *Claims) SessionID() string {
+	return c.Content[SessionIDKey]
+}
+
+// secondsToTime - "iat" contains fraction of second
+func secondsToTime(seconds float64) time.Time {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e3))*int64(time.Millisecond)).UTC()
 }
@@ -0,0 +1,230 @@
+package jwtsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+const testIssuer = "user-dir"
+
+var testAudience = []string{"user-dir", "orders"}
+
+// writePEM - PEM file of key in temporary dir of test
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name+".pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func pkcs8(t *testing.T, key crypto.Signer) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return der
+}
+
+func pkix(t *testing.T, key crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return der
+}
+
+func decodeSegment(t *testing.T, s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestLoadKeyring_PEM(t *testing.T) {
+	log.Printf("jwtsign_test: TestLoadKeyring_PEM - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	requires.NoError(err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	requires.NoError(err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	requires.NoError(err)
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	requires.NoError(err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	requires.NoError(err)
+	sec1, err := x509.MarshalECPrivateKey(p256)
+	requires.NoError(err)
+
+	tests := []struct {
+		kid  string
+		path string
+		alg  string
+	}{
+		{kid: "rsa-pkcs1", path: writePEM(t, "rsa-pkcs1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), alg: "RS256"},
+		{kid: "rsa-pkcs8", path: writePEM(t, "rsa-pkcs8", "PRIVATE KEY", pkcs8(t, rsaKey)), alg: "RS256"},
+		{kid: "es256-sec1", path: writePEM(t, "es256-sec1", "EC PRIVATE KEY", sec1), alg: "ES256"},
+		{kid: "es384", path: writePEM(t, "es384", "PRIVATE KEY", pkcs8(t, p384)), alg: "ES384"},
+		{kid: "es512", path: writePEM(t, "es512", "PRIVATE KEY", pkcs8(t, p521)), alg: "ES512"},
+		{kid: "eddsa", path: writePEM(t, "eddsa", "PRIVATE KEY", pkcs8(t, edKey)), alg: "EdDSA"},
+	}
+	log.Printf("\t1 private keys sign and verify")
+	for _, test := range tests {
+		key, err := readPEMKey(test.kid, test.path)
+		requires.NoError(err, test.kid)
+		asserts.Equal(test.alg, key.Method.Alg(), test.kid)
+		asserts.NotNil(key.signKey, "private key signs - %s", test.kid)
+
+		requires.NoError(LoadKeyring(&config.JWTConfig{
+			KeyFiles:     map[string]string{test.kid: test.path},
+			CurrentKeyID: test.kid,
+			Issuer:       testIssuer,
+			Audience:     testAudience,
+		}), test.kid)
+		token, err := TokenGenerator("1", time.Now().UTC(), Content{"email": "test@example.com"})
+		requires.NoError(err, test.kid)
+		claims, err := GetClaimsFromToken(token)
+		requires.NoError(err, "token of %s", test.kid)
+		asserts.Equal("1", claims.Subject)
+		asserts.Equal("test@example.com", claims.Content["email"])
+	}
+
+	log.Printf("\t2 public key only verifies")
+	key, err := readPEMKey("public", writePEM(t, "public", "PUBLIC KEY", pkix(t, p256.Public())))
+	requires.NoError(err)
+	asserts.Equal("ES256", key.Method.Alg())
+	asserts.Nil(key.signKey)
+	_, err = NewKeyring(&config.JWTConfig{
+		KeyFiles:     map[string]string{"public": writePEM(t, "public", "PUBLIC KEY", pkix(t, p256.Public()))},
+		CurrentKeyID: "public",
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+	})
+	asserts.ErrorIs(err, ErrJWTKeyCanNotSign)
+
+	log.Printf("\t3 wrong files")
+	_, err = readPEMKey("text", writePEM(t, "text", "CERTIFICATE", []byte("data")))
+	asserts.ErrorIs(err, ErrJWTPEMInvalid, "unknown type of block")
+	path := filepath.Join(t.TempDir(), "empty.pem")
+	requires.NoError(os.WriteFile(path, []byte("not pem"), 0o600))
+	_, err = readPEMKey("empty", path)
+	asserts.ErrorIs(err, ErrJWTPEMInvalid, "no block")
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	requires.NoError(err)
+	_, err = readPEMKey("p224", writePEM(t, "p224", "PUBLIC KEY", pkix(t, p224.Public())))
+	asserts.ErrorIs(err, ErrJWTKeyTypeUnsupported, "curve without jwt method")
+
+	requires.NoError(LoadKeyring(&config.JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience}))
+
+	log.Printf("jwtsign_test: TestLoadKeyring_PEM - END")
+}
+
+func TestJWKS(t *testing.T) {
+	log.Printf("jwtsign_test: TestJWKS - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	requires.NoError(err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	requires.NoError(err)
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	requires.NoError(err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	requires.NoError(err)
+
+	requires.NoError(LoadKeyring(&config.JWTConfig{
+		Secret: "secret",
+		KeyFiles: map[string]string{
+			"a-rsa":   writePEM(t, "a-rsa", "PRIVATE KEY", pkcs8(t, rsaKey)),
+			"b-p256":  writePEM(t, "b-p256", "PUBLIC KEY", pkix(t, p256.Public())),
+			"c-p521":  writePEM(t, "c-p521", "PRIVATE KEY", pkcs8(t, p521)),
+			"d-eddsa": writePEM(t, "d-eddsa", "PRIVATE KEY", pkcs8(t, edKey)),
+		},
+		CurrentKeyID: "a-rsa",
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+	}))
+	defer func() {
+		requires.NoError(LoadKeyring(&config.JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience}))
+	}()
+
+	document, err := JWKS()
+	requires.NoError(err)
+	asserts.NotContains(string(document), base64.RawURLEncoding.EncodeToString([]byte("secret")), "secret of HMAC is not published")
+	asserts.NotContains(string(document), `"d"`, "private part is not published")
+
+	set := JWKSet{}
+	requires.NoError(json.Unmarshal(document, &set))
+	requires.Len(set.Keys, 4, "only asymmetric keys")
+
+	rsaJWK := set.Keys[0]
+	asserts.Equal(JWK{KeyType: "RSA", KeyID: "a-rsa", Use: "sig", Algorithm: "RS256", N: rsaJWK.N, E: "AQAB"}, rsaJWK)
+	asserts.Equal(rsaKey.N, new(big.Int).SetBytes(decodeSegment(t, rsaJWK.N)))
+
+	p256JWK := set.Keys[1]
+	asserts.Equal("EC", p256JWK.KeyType)
+	asserts.Equal("b-p256", p256JWK.KeyID)
+	asserts.Equal("ES256", p256JWK.Algorithm)
+	asserts.Equal("P-256", p256JWK.Curve)
+	asserts.Equal(p256.X, new(big.Int).SetBytes(decodeSegment(t, p256JWK.X)))
+	asserts.Equal(p256.Y, new(big.Int).SetBytes(decodeSegment(t, p256JWK.Y)))
+
+	p521JWK := set.Keys[2]
+	asserts.Equal("ES512", p521JWK.Algorithm)
+	asserts.Equal("P-521", p521JWK.Curve)
+	asserts.Len(decodeSegment(t, p521JWK.X), 66, "coordinate is padded to size of curve")
+	asserts.Len(decodeSegment(t, p521JWK.Y), 66)
+
+	edJWK := set.Keys[3]
+	asserts.Equal(JWK{KeyType: "OKP", KeyID: "d-eddsa", Use: "sig", Algorithm: "EdDSA", Curve: "Ed25519", X: edJWK.X}, edJWK)
+	asserts.Equal([]byte(edPublic), decodeSegment(t, edJWK.X))
+
+	log.Printf("jwtsign_test: TestJWKS - END")
+}
+
+func TestReload_Keyring(t *testing.T) {
+	log.Printf("jwtsign_test: TestReload_Keyring - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	requires.NoError(LoadKeyring(&config.JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience}))
+	token, err := TokenGenerator("1", time.Now().UTC(), nil)
+	requires.NoError(err)
+
+	_, err = NewKeyring(&config.JWTConfig{Secret: "other", CurrentKeyID: "missing", Issuer: testIssuer, Audience: testAudience})
+	asserts.ErrorIs(err, ErrJWTKeyNotFound)
+	_, err = NewKeyring(&config.JWTConfig{Secret: "other", Audience: testAudience})
+	asserts.ErrorIs(err, ErrJWTIssuerEmpty)
+
+	kr, err := NewKeyring(&config.JWTConfig{Keys: map[string]string{"k2": "other"}, CurrentKeyID: "k2", Issuer: testIssuer, Audience: testAudience})
+	requires.NoError(err)
+	_, err = GetClaimsFromToken(token)
+	asserts.NoError(err, "built keyring is not in use")
+
+	SetKeyring(kr)
+	_, err = GetClaimsFromToken(token)
+	asserts.ErrorIs(err, ErrJWTKeyNotFound, "token of removed key")
+
+	requires.NoError(LoadKeyring(&config.JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience}))
+
+	log.Printf("jwtsign_test: TestReload_Keyring - END")
+}
@@ -0,0 +1,170 @@
+package jwtsign
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+var (
+	// ErrJWTKeyNotFound - "kid" from token header is unknown or the key is retired
+	ErrJWTKeyNotFound = errors.New("key not found")
+
+	// ErrJWTKeyCanNotSign - current key is a public key
+	ErrJWTKeyCanNotSign = errors.New("key can not sign")
+
+	// ErrJWTIssuerEmpty - "iss" or "aud" for tokens is not set
+	ErrJWTIssuerEmpty = errors.New("issuer or audience not found")
+)
+
+// Key - key for signing and verification of tokens with its "kid"
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	// signKey - []byte for HMAC, private key for asymmetric methods, nil for public key
+	signKey any
+	// verifyKey - []byte for HMAC, public key for asymmetric methods
+	verifyKey any
+}
+
+// Keyring - all active keys with "iss", "aud" and lifetime of tokens
+// key 'current' signs new tokens, every key of the ring verifies tokens
+type Keyring struct {
+	mu sync.RWMutex
+
+	keys    map[string]Key
+	current string
+
+	issuer   string
+	audience []string
+
+	lifetime Lifetime
+}
+
+// keyring - used by TokenGenerator, GetClaimsFromToken and JWKS
+var keyring = &Keyring{}
+
+// NewKeyring - build keys from config.JWTConfig (secrets for HS256 and PEM files) without use
+// keyring of tokens is not changed until SetKeyring
+func NewKeyring(cfg *config.JWTConfig) (*Keyring, error) {
+	if cfg.Issuer == "" || len(cfg.Audience) == 0 || cfg.Audience[0] == "" {
+		return nil, fmt.Errorf("jwtsign: NewKeyring error - {%w};", ErrJWTIssuerEmpty)
+	}
+	keys := make(map[string]Key)
+	for kid, secret := range cfg.AllKeys() {
+		if kid == "" || secret == "" {
+			return nil, fmt.Errorf("jwtsign: NewKeyring error - {%w};", ErrJWTSecretKeyEmpty)
+		}
+		keys[kid] = Key{
+			ID:        kid,
+			Method:    jwt.SigningMethodHS256,
+			signKey:   []byte(secret),
+			verifyKey: []byte(secret),
+		}
+	}
+	for kid, path := range cfg.KeyFiles {
+		key, err := readPEMKey(kid, path)
+		if err != nil {
+			return nil, fmt.Errorf("jwtsign: NewKeyring file of kid {%s} error - {%w};", kid, err)
+		}
+		keys[kid] = key
+	}
+	current := cfg.SignerKeyID()
+	signer, ex := keys[current]
+	if !ex {
+		return nil, fmt.Errorf("jwtsign: NewKeyring current key error - {%w};", ErrJWTKeyNotFound)
+	}
+	if signer.signKey == nil {
+		return nil, fmt.Errorf("jwtsign: NewKeyring current key error - {%w};", ErrJWTKeyCanNotSign)
+	}
+	return &Keyring{
+		keys:     keys,
+		current:  current,
+		issuer:   cfg.Issuer,
+		audience: append([]string(nil), cfg.Audience...),
+		lifetime: newLifetime(cfg),
+	}, nil
+}
+
+// SetKeyring - replace keys of tokens by keys of 'kr' at once
+// tokens signed by a key which 'kr' doesn't contain become invalid
+func SetKeyring(kr *Keyring) {
+	kr.mu.RLock()
+	keys, current := kr.keys, kr.current
+	issuer, audience, lifetime := kr.issuer, kr.audience, kr.lifetime
+	kr.mu.RUnlock()
+
+	keyring.mu.Lock()
+	keyring.keys = keys
+	keyring.current = current
+	keyring.issuer = issuer
+	keyring.audience = audience
+	keyring.lifetime = lifetime
+	keyring.mu.Unlock()
+
+	log.Printf("jwtsign: keyring loaded - keys {%d}, current kid {%s}, alg {%s};", len(keys), current, keys[current].Method.Alg())
+}
+
+// LoadKeyring - set keys from config.JWTConfig (secrets for HS256 and PEM files)
+// call during application startup, keys are replaced at once
+// tokens signed by a key removed from config become invalid
+func LoadKeyring(cfg *config.JWTConfig) error {
+	kr, err := NewKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	SetKeyring(kr)
+	return nil
+}
+
+// signer - key for new tokens
+func (kr *Keyring) signer() (Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ex := kr.keys[kr.current]
+	if !ex {
+		return Key{}, ErrJWTSecretKeyEmpty
+	}
+	return key, nil
+}
+
+// registered - "iss" and "aud" of tokens
+func (kr *Keyring) registered() (string, []string) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.issuer, kr.audience
+}
+
+// verifier - key by "kid" from token header
+// token without "kid" was signed before keyring -> config.DefaultKeyID
+func (kr *Keyring) verifier(kid string) (Key, error) {
+	if kid == "" {
+		kid = config.DefaultKeyID
+	}
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ex := kr.keys[kid]
+	if !ex {
+		return Key{}, ErrJWTKeyNotFound
+	}
+	return key, nil
+}
+
+// publicKeys - keys of asymmetric methods, secrets of HMAC are never published
+func (kr *Keyring) publicKeys() []Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	keys := make([]Key, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		if _, ok := key.Method.(*jwt.SigningMethodHMAC); !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
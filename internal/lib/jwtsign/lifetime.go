@@ -0,0 +1,104 @@
+package jwtsign
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+// ErrJWTSessionExpired - maximum length of session is over, a new login is needed
+var ErrJWTSessionExpired = errors.New("session expired")
+
+const (
+	// defaultAccessLife - life of access token if config.JWTConfig.AccessTTL is not set
+	// token is short-lived, a long session is kept by refresh token
+	defaultAccessLife = 15 * time.Minute
+
+	// defaultIdleTimeout - life of refresh token if config.JWTConfig.IdleTimeout is not set
+	defaultIdleTimeout = 30 * 24 * time.Hour
+)
+
+// Lifetime - times of life for tokens of one session (from login to the last refresh)
+// Access - life of access token
+// Idle - life of refresh token, session without refresh for this time is over
+// MaxAge - absolute length of session from login, 0 - without limit
+// Sliding - access token is renewed while the client is active
+type Lifetime struct {
+	Access  time.Duration
+	Idle    time.Duration
+	MaxAge  time.Duration
+	Sliding bool
+}
+
+func newLifetime(cfg *config.JWTConfig) Lifetime {
+	lifetime := Lifetime{
+		Access:  cfg.AccessTTL,
+		Idle:    cfg.IdleTimeout,
+		MaxAge:  cfg.SessionMaxAge,
+		Sliding: cfg.SlidingSession,
+	}
+	if lifetime.Access <= 0 {
+		lifetime.Access = defaultAccessLife
+	}
+	if lifetime.Idle <= 0 {
+		lifetime.Idle = defaultIdleTimeout
+	}
+	if lifetime.MaxAge < 0 {
+		lifetime.MaxAge = 0
+	}
+	return lifetime
+}
+
+// SessionLifetime - lifetime of keyring
+func SessionLifetime() Lifetime {
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+	return keyring.lifetime
+}
+
+// sessionEnd - end of session started at authTime, zero time - without limit
+func (l Lifetime) sessionEnd(authTime time.Time) time.Time {
+	if l.MaxAge == 0 {
+		return time.Time{}
+	}
+	return authTime.Add(l.MaxAge)
+}
+
+// capToSession - expiration time not later than end of session
+func (l Lifetime) capToSession(exp, authTime time.Time) time.Time {
+	if end := l.sessionEnd(authTime); !end.IsZero() && end.Before(exp) {
+		return end
+	}
+	return exp
+}
+
+// AccessExpiresAt - exp of access token issued now for session started at authTime
+func (l Lifetime) AccessExpiresAt(now, authTime time.Time) (time.Time, error) {
+	exp := l.capToSession(now.Add(l.Access), authTime)
+	if !exp.After(now) {
+		return time.Time{}, ErrJWTSessionExpired
+	}
+	return exp, nil
+}
+
+// RefreshExpiresAt - expiration of refresh token issued now for session started at authTime
+func (l Lifetime) RefreshExpiresAt(now, authTime time.Time) (time.Time, error) {
+	exp := l.capToSession(now.Add(l.Idle), authTime)
+	if !exp.After(now) {
+		return time.Time{}, ErrJWTSessionExpired
+	}
+	return exp, nil
+}
+
+// NeedRenew - sliding session is on and less than half of token life is left
+func (l Lifetime) NeedRenew(now time.Time, claims *Claims) bool {
+	if !l.Sliding {
+		return false
+	}
+	if end := l.sessionEnd(claims.AuthTime); !end.IsZero() && !end.After(claims.ExpiresAt) {
+		// token already lives until the end of session
+		return false
+	}
+	return claims.ExpiresAt.Sub(now) < l.Access/2
+}
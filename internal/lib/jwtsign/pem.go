@@ -0,0 +1,99 @@
+package jwtsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrJWTPEMInvalid = errors.New("invalid pem")
+
+	ErrJWTKeyTypeUnsupported = errors.New("unsupported key type")
+)
+
+// readPEMKey - read private or public key from PEM file
+// method is chosen by type of key: RSA -> RS256, EC P-256/P-384/P-521 -> ES256/ES384/ES512, Ed25519 -> EdDSA
+// public key only verifies tokens (key retired from signing on another replica)
+func readPEMKey(kid, path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, ErrJWTPEMInvalid
+	}
+
+	var (
+		signKey   crypto.Signer
+		verifyKey crypto.PublicKey
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return Key{}, ErrJWTKeyTypeUnsupported
+		}
+		signKey = signer
+	case "RSA PRIVATE KEY":
+		if signKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return Key{}, err
+		}
+	case "EC PRIVATE KEY":
+		if signKey, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return Key{}, err
+		}
+	case "PUBLIC KEY":
+		if verifyKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return Key{}, err
+		}
+	default:
+		return Key{}, ErrJWTPEMInvalid
+	}
+	if signKey != nil {
+		verifyKey = signKey.Public()
+	}
+
+	method, err := methodForKey(verifyKey)
+	if err != nil {
+		return Key{}, err
+	}
+
+	key := Key{ID: kid, Method: method, verifyKey: verifyKey}
+	if signKey != nil {
+		key.signKey = signKey
+	}
+	return key, nil
+}
+
+func methodForKey(publicKey crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, ErrJWTKeyTypeUnsupported
+}
@@ -0,0 +1,43 @@
+package jwtsign
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrJWTPurposeInvalid - token of other purpose, access token is not a purpose token and vice versa
+var ErrJWTPurposeInvalid = errors.New("invalid purpose of token")
+
+// PurposeKey - private claim of one-time token with its purpose ("verify_email" ...)
+// access tokens have no purpose, 'GetClaimsFromToken' rejects token with it
+const PurposeKey = "purpose"
+
+// PurposeTokenGenerator - create one-time token of purpose for subject, it lives 'ttl' and does not depend on session
+// "jti" of token is returned with it, single use is checked by owner of the token
+func PurposeTokenGenerator(subject, purpose string, ttl time.Duration, content Content) (string, string, error) {
+	if purpose == "" {
+		return "", "", ErrJWTPurposeInvalid
+	}
+	expiresAt := func(now time.Time) (time.Time, error) {
+		return now.Add(ttl), nil
+	}
+	return signToken(subject, content, expiresAt, jwt.MapClaims{PurposeKey: purpose})
+}
+
+// GetClaimsFromPurposeToken - claims of one-time token, its "purpose" must be 'purpose'
+func GetClaimsFromPurposeToken(token, purpose string) (*Claims, error) {
+	jwtToken, err := tokenRetrive(token)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := receiveClaimsFromToken(jwtToken)
+	if err != nil {
+		return nil, err
+	}
+	if purpose == "" || claims.Content[PurposeKey] != purpose {
+		return nil, ErrJWTPurposeInvalid
+	}
+	return claims, nil
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
+)
+
+// File - every message is written to directory as file "<time>-<random>.eml", for local start
+type File struct {
+	dir  string
+	from string
+}
+
+func NewFile(dir, from string) *File {
+	return &File{dir: dir, from: from}
+}
+
+func (f *File) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.dir, 0o750); err != nil {
+		return fmt.Errorf("mailer: os.MkdirAll error - {%w};", err)
+	}
+	suffix, err := randtoken.New()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	name := filepath.Join(f.dir, now.Format("20060102T150405.000000000")+"-"+suffix[:8]+".eml")
+	if err := os.WriteFile(name, msg.format(f.from, now), 0o600); err != nil {
+		return fmt.Errorf("mailer: os.WriteFile error - {%w};", err)
+	}
+	return nil
+}
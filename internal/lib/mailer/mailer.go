@@ -0,0 +1,59 @@
+// contains interface 'Mailer' for sending of mail to users and its implementations (smtp, file, memory)
+// implementation is chosen by config.MailConfig during application startup
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+var ErrMailerSenderInvalid = errors.New("invalid sender of mail")
+
+// Message - plain text mail for one recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer - send a message, error - message is not sent
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New - mailer of config.MailConfig.Sender, sender must be set explicitly
+// "memory" mailer only logs messages, warning is logged to catch it outside of local start
+func New(cfg *config.MailConfig) (Mailer, error) {
+	switch cfg.Sender {
+	case config.MailSenderMemory:
+		log.Print("mailer: MAIL_SENDER is memory, messages are not delivered to users")
+		return NewMemory(), nil
+	case config.MailSenderFile:
+		return NewFile(cfg.Dir, cfg.From), nil
+	case config.MailSenderSMTP:
+		return NewSMTP(cfg), nil
+	}
+	return nil, ErrMailerSenderInvalid
+}
+
+// format - message in format of RFC 5322, lines of header can't be injected by values
+func (msg Message) format(from string, date time.Time) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	b := &strings.Builder{}
+	if from != "" {
+		fmt.Fprintf(b, "From: %s\r\n", clean.Replace(from))
+	}
+	fmt.Fprintf(b, "To: %s\r\n", clean.Replace(msg.To))
+	fmt.Fprintf(b, "Subject: %s\r\n", clean.Replace(msg.Subject))
+	fmt.Fprintf(b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,197 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	log.Printf("mailer_test: TestNew - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	mail, err := New(&config.MailConfig{Sender: config.MailSenderMemory})
+	requires.NoError(err)
+	asserts.IsType(&Memory{}, mail)
+
+	mail, err = New(&config.MailConfig{Sender: config.MailSenderFile, Dir: t.TempDir()})
+	requires.NoError(err)
+	asserts.IsType(&File{}, mail)
+
+	mail, err = New(&config.MailConfig{Sender: config.MailSenderSMTP, SMTPHost: "localhost", SMTPPort: 25, From: "no-reply@example.com"})
+	requires.NoError(err)
+	asserts.IsType(&SMTP{}, mail)
+
+	_, err = New(&config.MailConfig{})
+	asserts.ErrorIs(err, ErrMailerSenderInvalid, "sender must be set explicitly")
+	_, err = New(&config.MailConfig{Sender: "pigeon"})
+	asserts.ErrorIs(err, ErrMailerSenderInvalid)
+
+	log.Printf("mailer_test: TestNew - END")
+}
+
+func TestMessage_Format(t *testing.T) {
+	log.Printf("mailer_test: TestMessage_Format - START")
+
+	asserts := assert.New(t)
+
+	date := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	data := string(Message{
+		To:      "user@example.com\r\nBcc: other@example.com",
+		Subject: "Verify\nemail",
+		Body:    "line 1\nline 2\r\nline 3",
+	}.format("no-reply@example.com", date))
+
+	asserts.Equal("From: no-reply@example.com\r\n"+
+		"To: user@example.comBcc: other@example.com\r\n"+
+		"Subject: Verifyemail\r\n"+
+		"Date: Wed, 01 May 2024 10:00:00 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n"+
+		"line 1\r\nline 2\r\nline 3", data, "header can't be injected, lines of body end with CRLF")
+
+	data = string(Message{To: "user@example.com"}.format("", date))
+	asserts.True(strings.HasPrefix(data, "To: "), "no From without sender")
+
+	log.Printf("mailer_test: TestMessage_Format - END")
+}
+
+func TestMemory_Send(t *testing.T) {
+	log.Printf("mailer_test: TestMemory_Send - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	memory := NewMemory()
+	for i := 0; i < memoryLimit+5; i++ {
+		requires.NoError(memory.Send(context.Background(), Message{To: "user@example.com", Subject: strconv.Itoa(i)}))
+	}
+	messages := memory.Messages()
+	requires.Len(messages, memoryLimit, "only the last messages are kept")
+	asserts.Equal("5", messages[0].Subject)
+	asserts.Equal(strconv.Itoa(memoryLimit+4), messages[memoryLimit-1].Subject)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	asserts.ErrorIs(memory.Send(ctx, Message{}), context.Canceled)
+
+	log.Printf("mailer_test: TestMemory_Send - END")
+}
+
+func TestFile_Send(t *testing.T) {
+	log.Printf("mailer_test: TestFile_Send - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dir := filepath.Join(t.TempDir(), "mail")
+	file := NewFile(dir, "no-reply@example.com")
+	requires.NoError(file.Send(context.Background(), Message{To: "user@example.com", Subject: "first", Body: "token"}))
+	requires.NoError(file.Send(context.Background(), Message{To: "user@example.com", Subject: "second", Body: "token"}))
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	requires.NoError(err)
+	requires.Len(names, 2, "file for every message")
+	data, err := os.ReadFile(names[0])
+	requires.NoError(err)
+	asserts.Contains(string(data), "From: no-reply@example.com\r\n")
+	asserts.Contains(string(data), "To: user@example.com\r\n")
+
+	log.Printf("mailer_test: TestFile_Send - END")
+}
+
+// serveSMTP - smtp server for one message without extensions, received envelope and data are sent to 'received'
+func serveSMTP(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = fmt.Fprintf(conn, "%s\r\n", line)
+	}
+	reply("220 localhost ready")
+	envelope := &strings.Builder{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM"), strings.HasPrefix(command, "RCPT TO"):
+			envelope.WriteString(strings.TrimSpace(line) + "\n")
+			reply("250 ok")
+		case command == "DATA":
+			reply("354 end with .")
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				envelope.WriteString(dataLine)
+			}
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			received <- envelope.String()
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTP_Send(t *testing.T) {
+	log.Printf("mailer_test: TestSMTP_Send - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	requires.NoError(err)
+	defer listener.Close()
+	received := make(chan string, 1)
+	go serveSMTP(listener, received)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	sender := NewSMTP(&config.MailConfig{SMTPHost: "127.0.0.1", SMTPPort: uint16(port), From: "no-reply@example.com"})
+	requires.NoError(sender.Send(context.Background(), Message{To: "user@example.com", Subject: "Verify email", Body: "token"}))
+
+	select {
+	case envelope := <-received:
+		asserts.Contains(envelope, "MAIL FROM:<no-reply@example.com>")
+		asserts.Contains(envelope, "RCPT TO:<user@example.com>")
+		asserts.Contains(envelope, "Subject: Verify email\r\n")
+		asserts.Contains(envelope, "\r\n\r\ntoken")
+	case <-time.After(5 * time.Second):
+		requires.FailNow("message is not received")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	asserts.ErrorIs(sender.Send(ctx, Message{To: "user@example.com"}), context.Canceled, "canceled ctx is not sent")
+
+	log.Printf("mailer_test: TestSMTP_Send - END")
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// memoryLimit - only the last messages are kept
+const memoryLimit = 100
+
+// Memory - messages are kept in process and logged without body (it contains tokens), for tests and local start
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	if len(m.messages) > memoryLimit {
+		m.messages = m.messages[len(m.messages)-memoryLimit:]
+	}
+	log.Printf("mailer: message kept in memory - {%s};", msg.Subject)
+	return nil
+}
+
+// Messages - copy of the last sent messages in order of sending
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+// SMTP - send mail by server of config.MailConfig, STARTTLS is used if server supports it
+// without user mail is sent without authentication
+type SMTP struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTP(cfg *config.MailConfig) *SMTP {
+	s := &SMTP{
+		addr: net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(int(cfg.SMTPPort))),
+		host: cfg.SMTPHost,
+		from: cfg.From,
+	}
+	if cfg.SMTPUser != "" {
+		s.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return s
+}
+
+// Send - smtp.SendMail has no context, sending is not canceled, but a canceled ctx is not sent
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, msg.format(s.from, time.Now())); err != nil {
+		return fmt.Errorf("mailer: smtp.SendMail error - {%w};", err)
+	}
+	return nil
+}
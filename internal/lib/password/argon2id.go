@@ -0,0 +1,131 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+// default parameters of argon2id (RFC 9106, second recommended option)
+const (
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Time    = 3
+	defaultArgon2Threads = 4
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// limits for imported hashes, verification must not block the server
+const (
+	maxArgon2Memory = 1024 * 1024
+	maxArgon2Time   = 64
+)
+
+// Argon2id - "$argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<hash>"
+type Argon2id struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// NewArgon2id - parameters from config, default for not set
+func NewArgon2id(cfg config.PasswordConfig) *Argon2id {
+	a := &Argon2id{
+		Memory:  cfg.Argon2Memory,
+		Time:    cfg.Argon2Time,
+		Threads: cfg.Argon2Threads,
+	}
+	if a.Memory == 0 {
+		a.Memory = defaultArgon2Memory
+	}
+	if a.Time == 0 {
+		a.Time = defaultArgon2Time
+	}
+	if a.Threads == 0 {
+		a.Threads = defaultArgon2Threads
+	}
+	return a
+}
+
+func (a *Argon2id) IDs() []string {
+	return []string{"argon2id"}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		a.Memory,
+		a.Time,
+		a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify - parameters are taken from hash, so hashes with old parameters stay valid
+func (a *Argon2id) Verify(hash, password string) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (a *Argon2id) Outdated(hash string) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	return err != nil || *params != *a
+}
+
+func (a *Argon2id) ValidHash(hash string) error {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	if params.Memory > maxArgon2Memory || params.Time > maxArgon2Time {
+		return ErrPasswordHashInvalid
+	}
+	return nil
+}
+
+func decodeArgon2id(hash string) (*Argon2id, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	version := 0
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	params := &Argon2id{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	if params.Memory == 0 || params.Time == 0 || params.Threads == 0 {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	salt, err := decodeBase64(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	key, err := decodeBase64(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	return params, salt, key, nil
+}
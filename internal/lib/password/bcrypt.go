@@ -0,0 +1,38 @@
+package password
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt - hashes created before argon2id, only verification
+// new hashes are not created - bcrypt uses only first 72 bytes of password
+type Bcrypt struct{}
+
+func (Bcrypt) IDs() []string {
+	return []string{"2a", "2b", "2y"}
+}
+
+func (Bcrypt) Hash(string) (string, error) {
+	return "", ErrPasswordAlgorithmUnknown
+}
+
+func (Bcrypt) Verify(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (Bcrypt) Outdated(string) bool {
+	return true
+}
+
+func (Bcrypt) ValidHash(hash string) error {
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return ErrPasswordHashInvalid
+	}
+	return nil
+}
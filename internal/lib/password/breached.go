@@ -0,0 +1,100 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+)
+
+// breachedFile - file of breached passwords in format of "Pwned Passwords" ordered by hash
+// every line is "<SHA-1 in upper hex>:<count>", lines are sorted by hash
+// file is not loaded in memory, a hash is found by binary search over offsets of the file
+// only SHA-1 of password is compared, the password itself never leaves the process
+type breachedFile struct {
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// openBreachedFile - open file for all time of application, closed by Configure with a new file
+func openBreachedFile(path string) (*breachedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &breachedFile{file: file, size: info.Size()}, nil
+}
+
+func (bf *breachedFile) close() error {
+	return bf.file.Close()
+}
+
+// contains - SHA-1 of password is in file
+// lines with start in [lo, hi) are candidates, lo is always a start of line
+func (bf *breachedFile) contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := bytes.ToUpper([]byte(hex.EncodeToString(sum[:])))
+
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+
+	lo, hi := int64(0), bf.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := bf.lineStart(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		line, err := bf.readLine(start)
+		if err != nil {
+			return false, err
+		}
+		switch cmp := bytes.Compare(lineHash(line), hash); {
+		case cmp == 0:
+			return true, nil
+		case cmp < 0:
+			lo = start + int64(len(line))
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineStart - offset of the first line which starts at offset or after it
+func (bf *breachedFile) lineStart(offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	rest, err := bf.readLine(offset - 1)
+	return offset - 1 + int64(len(rest)), err
+}
+
+// readLine - bytes from offset to the end of line with '\n'
+func (bf *breachedFile) readLine(offset int64) ([]byte, error) {
+	reader := bufio.NewReader(io.NewSectionReader(bf.file, offset, bf.size-offset))
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return line, nil
+}
+
+// lineHash - "<HASH>:<COUNT>" -> upper HASH
+func lineHash(line []byte) []byte {
+	hash, _, _ := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+	return bytes.ToUpper(hash)
+}
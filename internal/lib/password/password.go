@@ -0,0 +1,180 @@
+// contains global non-exported variables 'current' - the algorithm for new password hashes
+// and 'policy' - rules for new passwords
+// sets them during application startup from config.PasswordConfig
+// hashes are strings in PHC format "$<id>$<params>$<salt>$<hash>", the algorithm of a hash is found by its id
+package password
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password mismatch")
+
+	ErrPasswordHashInvalid = errors.New("invalid password hash")
+
+	ErrPasswordAlgorithmUnknown = errors.New("unknown password hash algorithm")
+)
+
+// Algorithm - hashing of passwords
+// IDs - identifiers of PHC string handled by the algorithm
+// Outdated - hash should be replaced by a hash with the current parameters
+// ValidHash - hash is well-formed (used for import of hashes from other systems)
+type Algorithm interface {
+	IDs() []string
+	Hash(password string) (string, error)
+	Verify(hash, password string) error
+	Outdated(hash string) bool
+	ValidHash(hash string) error
+}
+
+var (
+	mu sync.RWMutex
+
+	// current - algorithm for new hashes
+	current Algorithm = NewArgon2id(config.PasswordConfig{})
+
+	// algorithms - algorithm by id of PHC string, used for verification
+	algorithms = map[string]Algorithm{}
+)
+
+func init() {
+	Register(current)
+	Register(Bcrypt{})
+	Register(PBKDF2SHA256{})
+	Register(Scrypt{})
+}
+
+// Register - add algorithm for verification of hashes with its ids
+func Register(algorithm Algorithm) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range algorithm.IDs() {
+		algorithms[id] = algorithm
+	}
+}
+
+// Settings - algorithm for new hashes and policy built from config, not yet in use
+// made by Prepare, put in use by Apply
+type Settings struct {
+	algorithm *Argon2id
+	policy    *Policy
+}
+
+// Prepare - build argon2id with parameters from config and policy for new passwords, open file of breached passwords
+// current algorithm and policy are not changed
+func Prepare(cfg *config.PasswordConfig) (*Settings, error) {
+	var breached *breachedFile
+	if cfg.BreachedFile != "" {
+		var err error
+		if breached, err = openBreachedFile(cfg.BreachedFile); err != nil {
+			return nil, fmt.Errorf("password: open breached file error - {%w};", err)
+		}
+	}
+	return &Settings{
+		algorithm: NewArgon2id(*cfg),
+		policy:    NewPolicy(*cfg, breached),
+	}, nil
+}
+
+// Apply - set prepared algorithm for new hashes and policy for new passwords,
+// file of breached passwords of the previous policy is closed
+func Apply(settings *Settings) {
+	Register(settings.algorithm)
+
+	mu.Lock()
+	current = settings.algorithm
+	previous := policy
+	policy = settings.policy
+	mu.Unlock()
+
+	previous.closeBreached()
+}
+
+// Configure - set argon2id with parameters from config as algorithm for new hashes
+// and policy for new passwords, file of breached passwords of the previous policy is closed
+func Configure(cfg *config.PasswordConfig) error {
+	settings, err := Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	Apply(settings)
+	return nil
+}
+
+// Hash - create hash of password with the current algorithm
+func Hash(password string) (string, error) {
+	mu.RLock()
+	algorithm := current
+	mu.RUnlock()
+	return algorithm.Hash(password)
+}
+
+// Verify - compare password with hash, algorithm is chosen by id of hash
+func Verify(hash, password string) error {
+	algorithm, err := find(hash)
+	if err != nil {
+		return err
+	}
+	return algorithm.Verify(hash, password)
+}
+
+// ValidHash - hash is well-formed and its algorithm is known
+func ValidHash(hash string) error {
+	algorithm, err := find(hash)
+	if err != nil {
+		return err
+	}
+	return algorithm.ValidHash(hash)
+}
+
+// NeedsRehash - hash was created by other algorithm or with other parameters than the current
+func NeedsRehash(hash string) bool {
+	mu.RLock()
+	algorithm := current
+	mu.RUnlock()
+	if !hasID(algorithm, phcID(hash)) {
+		return true
+	}
+	return algorithm.Outdated(hash)
+}
+
+func find(hash string) (Algorithm, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	algorithm, ex := algorithms[phcID(hash)]
+	if !ex {
+		return nil, ErrPasswordAlgorithmUnknown
+	}
+	return algorithm, nil
+}
+
+// phcID - "$<id>$..." -> id
+func phcID(hash string) string {
+	if !strings.HasPrefix(hash, "$") {
+		return ""
+	}
+	id, _, _ := strings.Cut(hash[1:], "$")
+	return id
+}
+
+func hasID(algorithm Algorithm, id string) bool {
+	for _, algorithmID := range algorithm.IDs() {
+		if algorithmID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBase64 - base64 of PHC string without padding, '.' instead of '+' is accepted (passlib)
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.ReplaceAll(s, ".", "+"), "=")
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,245 @@
+package password
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+// testSalt - fixed salt of hashes made by the libraries for comparison with parsing of PHC strings
+var testSalt = []byte("saltsaltsaltsalt")
+
+func b64(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func TestVerify(t *testing.T) {
+	log.Printf("password_test: TestVerify - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	argonKey := argon2.IDKey([]byte("password1"), testSalt, 1, 1024, 1, 32)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password1"), bcrypt.MinCost)
+	requires.NoError(err)
+	pbkdf2Key := pbkdf2.Key([]byte("password1"), testSalt, 1000, 32, sha256.New)
+	scryptKey, err := scrypt.Key([]byte("password1"), testSalt, 1<<10, 8, 1, 32)
+	requires.NoError(err)
+
+	hashes := []struct {
+		algorithm string
+		hash      string
+	}{
+		{algorithm: "argon2id", hash: fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s", b64(testSalt), b64(argonKey))},
+		{algorithm: "bcrypt", hash: string(bcryptHash)},
+		{algorithm: "pbkdf2 phc", hash: fmt.Sprintf("$pbkdf2-sha256$i=1000,l=32$%s$%s", b64(testSalt), b64(pbkdf2Key))},
+		{algorithm: "pbkdf2 passlib", hash: fmt.Sprintf("$pbkdf2-sha256$1000$%s$%s",
+			strings.ReplaceAll(b64(testSalt), "+", "."), strings.ReplaceAll(b64(pbkdf2Key), "+", "."))},
+		{algorithm: "scrypt", hash: fmt.Sprintf("$scrypt$ln=10,r=8,p=1$%s$%s", b64(testSalt), b64(scryptKey))},
+	}
+	for _, test := range hashes {
+		asserts.NoError(ValidHash(test.hash), test.algorithm)
+		asserts.NoError(Verify(test.hash, "password1"), test.algorithm)
+		asserts.ErrorIs(Verify(test.hash, "password2"), ErrPasswordMismatch, test.algorithm)
+		asserts.True(NeedsRehash(test.hash), "%s with other parameters than current", test.algorithm)
+	}
+
+	log.Printf("password_test: TestVerify - END")
+}
+
+func TestValidHash(t *testing.T) {
+	log.Printf("password_test: TestValidHash - START")
+
+	asserts := assert.New(t)
+
+	salt, key := b64(testSalt), b64([]byte("keykeykeykeykeykeykeykeykeykeyke"))
+	tests := []struct {
+		description string
+		hash        string
+		err         error
+	}{
+		{description: "not phc", hash: "password1", err: ErrPasswordAlgorithmUnknown},
+		{description: "unknown id", hash: "$md5$abc$def", err: ErrPasswordAlgorithmUnknown},
+		{description: "argon2id other version", hash: fmt.Sprintf("$argon2id$v=16$m=1024,t=1,p=1$%s$%s", salt, key), err: ErrPasswordHashInvalid},
+		{description: "argon2id zero memory", hash: fmt.Sprintf("$argon2id$v=19$m=0,t=1,p=1$%s$%s", salt, key), err: ErrPasswordHashInvalid},
+		{description: "argon2id over limit", hash: fmt.Sprintf("$argon2id$v=19$m=%d,t=1,p=1$%s$%s", maxArgon2Memory+1, salt, key), err: ErrPasswordHashInvalid},
+		{description: "argon2id without key", hash: fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$", salt), err: ErrPasswordHashInvalid},
+		{description: "bcrypt broken", hash: "$2a$10$short", err: ErrPasswordHashInvalid},
+		{description: "pbkdf2 unknown param", hash: fmt.Sprintf("$pbkdf2-sha256$i=1000,x=1$%s$%s", salt, key), err: ErrPasswordHashInvalid},
+		{description: "pbkdf2 over limit", hash: fmt.Sprintf("$pbkdf2-sha256$%d$%s$%s", maxPBKDF2Iterations+1, salt, key), err: ErrPasswordHashInvalid},
+		{description: "pbkdf2 other length", hash: fmt.Sprintf("$pbkdf2-sha256$i=1000,l=16$%s$%s", salt, key), err: ErrPasswordHashInvalid},
+		{description: "scrypt over limit", hash: fmt.Sprintf("$scrypt$ln=%d,r=8,p=1$%s$%s", maxScryptLogN+1, salt, key), err: ErrPasswordHashInvalid},
+		{description: "scrypt not base64", hash: "$scrypt$ln=10,r=8,p=1$%%%$" + key, err: ErrPasswordHashInvalid},
+	}
+	for _, test := range tests {
+		asserts.ErrorIs(ValidHash(test.hash), test.err, test.description)
+	}
+
+	log.Printf("password_test: TestValidHash - END")
+}
+
+func TestHash(t *testing.T) {
+	log.Printf("password_test: TestHash - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	cfg := config.PasswordConfig{Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1}
+	requires.NoError(Configure(&cfg))
+	defer func() {
+		requires.NoError(Configure(&config.PasswordConfig{}))
+	}()
+
+	hash, err := Hash("password1")
+	requires.NoError(err)
+	asserts.True(strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+	asserts.NoError(Verify(hash, "password1"))
+	asserts.False(NeedsRehash(hash), "hash of current parameters")
+
+	cfg.Argon2Time = 2
+	requires.NoError(Configure(&cfg))
+	asserts.True(NeedsRehash(hash), "parameters are changed")
+	asserts.NoError(Verify(hash, "password1"), "hash of old parameters stays valid")
+
+	log.Printf("password_test: TestHash - END")
+}
+
+// writeBreachedFile - file in format of "Pwned Passwords" with SHA-1 of passwords, sorted by hash
+func writeBreachedFile(t *testing.T, passwords []string, eol string) string {
+	lines := make([]string, 0, len(passwords))
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, eol)+eol), 0o600))
+	return path
+}
+
+func TestBreachedFile_Contains(t *testing.T) {
+	log.Printf("password_test: TestBreachedFile_Contains - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	passwords := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		passwords = append(passwords, fmt.Sprintf("breached-%d", i))
+	}
+
+	log.Printf("\t1 every line of file, LF and CRLF")
+	for _, eol := range []string{"\n", "\r\n"} {
+		bf, err := openBreachedFile(writeBreachedFile(t, passwords, eol))
+		requires.NoError(err)
+
+		for _, password := range passwords {
+			found, err := bf.contains(password)
+			requires.NoError(err)
+			asserts.True(found, "every line is found - %q", password)
+		}
+		for i := 0; i < 1000; i++ {
+			found, err := bf.contains(fmt.Sprintf("safe-%d", i))
+			requires.NoError(err)
+			asserts.False(found, "not breached password")
+		}
+		requires.NoError(bf.close())
+	}
+
+	log.Printf("\t2 single line and empty file")
+	bf, err := openBreachedFile(writeBreachedFile(t, []string{"only"}, "\n"))
+	requires.NoError(err)
+	found, err := bf.contains("only")
+	requires.NoError(err)
+	asserts.True(found)
+	requires.NoError(bf.close())
+
+	bf, err = openBreachedFile(writeBreachedFile(t, nil, ""))
+	requires.NoError(err)
+	found, err = bf.contains("only")
+	requires.NoError(err)
+	asserts.False(found)
+	requires.NoError(bf.close())
+
+	log.Printf("password_test: TestBreachedFile_Contains - END")
+}
+
+func TestPolicy_Check(t *testing.T) {
+	log.Printf("password_test: TestPolicy_Check - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	breached, err := openBreachedFile(writeBreachedFile(t, []string{"Summer#2024"}, "\n"))
+	requires.NoError(err)
+	policy := NewPolicy(config.PasswordConfig{MinLength: 10, MaxLength: 20, MinClasses: 3, DisallowPersonal: true}, breached)
+	defer policy.closeBreached()
+
+	tests := []struct {
+		description string
+		password    string
+		personal    []string
+		reasons     []error
+	}{
+		{description: "valid", password: "Kx8#Policy$2vQ"},
+		{description: "short and classes", password: "abcdefg", reasons: []error{ErrPasswordTooShort, ErrPasswordClasses}},
+		{description: "long", password: strings.Repeat("Ab1", 7), reasons: []error{ErrPasswordTooLong}},
+		{description: "login", password: "Kx8#Tester$2vQ", personal: []string{"tester"}, reasons: []error{ErrPasswordPersonal}},
+		{description: "domain of email", password: "Kx8#Example$2vQ", personal: []string{"user@example.com"}, reasons: []error{ErrPasswordPersonal}},
+		{description: "short personal word", password: "Kx8#Ab$2vQxyz", personal: []string{"ab"}},
+		{description: "breached", password: "Summer#2024", reasons: []error{ErrPasswordBreached}},
+	}
+	for _, test := range tests {
+		err := policy.Check(test.password, test.personal...)
+		if len(test.reasons) == 0 {
+			asserts.NoError(err, test.description)
+			continue
+		}
+		var policyErr *PolicyError
+		requires.ErrorAs(err, &policyErr, test.description)
+		asserts.Equal(test.reasons, policyErr.Reasons, test.description)
+	}
+
+	log.Printf("password_test: TestPolicy_Check - END")
+}
+
+func TestPrepare(t *testing.T) {
+	log.Printf("password_test: TestPrepare - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	requires.NoError(Configure(&config.PasswordConfig{MinLength: 8}))
+	defer func() {
+		requires.NoError(Configure(&config.PasswordConfig{}))
+	}()
+
+	_, err := Prepare(&config.PasswordConfig{MinLength: 12, BreachedFile: filepath.Join(t.TempDir(), "missing.txt")})
+	requires.Error(err, "file of breached passwords is not found")
+	asserts.NoError(CheckPolicy("password"), "failed prepare keeps the current policy")
+
+	settings, err := Prepare(&config.PasswordConfig{MinLength: 12})
+	requires.NoError(err)
+	asserts.NoError(CheckPolicy("password"), "prepared policy is not in use")
+	Apply(settings)
+	asserts.ErrorIs(CheckPolicy("password"), ErrPasswordTooShort, "applied policy is in use")
+
+	log.Printf("password_test: TestPrepare - END")
+}
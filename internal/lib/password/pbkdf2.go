@@ -0,0 +1,90 @@
+package password
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// maxPBKDF2Iterations - limit for imported hashes, verification must not block the server
+const maxPBKDF2Iterations = 10_000_000
+
+// PBKDF2SHA256 - hashes imported from other systems, only verification
+// "$pbkdf2-sha256$i=<iterations>,l=<key length>$<salt>$<hash>" (PHC)
+// "$pbkdf2-sha256$<iterations>$<salt>$<hash>" (passlib, base64 with '.' instead of '+')
+type PBKDF2SHA256 struct{}
+
+func (PBKDF2SHA256) IDs() []string {
+	return []string{"pbkdf2-sha256"}
+}
+
+func (PBKDF2SHA256) Hash(string) (string, error) {
+	return "", ErrPasswordAlgorithmUnknown
+}
+
+func (PBKDF2SHA256) Verify(hash, password string) error {
+	iterations, salt, key, err := decodePBKDF2(hash)
+	if err != nil {
+		return err
+	}
+	other := pbkdf2.Key([]byte(password), salt, iterations, len(key), sha256.New)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (PBKDF2SHA256) Outdated(string) bool {
+	return true
+}
+
+func (PBKDF2SHA256) ValidHash(hash string) error {
+	_, _, _, err := decodePBKDF2(hash)
+	return err
+}
+
+func decodePBKDF2(hash string) (int, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != "pbkdf2-sha256" {
+		return 0, nil, nil, ErrPasswordHashInvalid
+	}
+	iterations, keyLen := 0, 0
+	if strings.HasPrefix(parts[2], "i=") {
+		for _, param := range strings.Split(parts[2], ",") {
+			name, value, _ := strings.Cut(param, "=")
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, nil, nil, ErrPasswordHashInvalid
+			}
+			switch name {
+			case "i":
+				iterations = n
+			case "l":
+				keyLen = n
+			default:
+				return 0, nil, nil, ErrPasswordHashInvalid
+			}
+		}
+	} else {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return 0, nil, nil, ErrPasswordHashInvalid
+		}
+		iterations = n
+	}
+	if iterations <= 0 || iterations > maxPBKDF2Iterations {
+		return 0, nil, nil, ErrPasswordHashInvalid
+	}
+	salt, err := decodeBase64(parts[3])
+	if err != nil || len(salt) == 0 {
+		return 0, nil, nil, ErrPasswordHashInvalid
+	}
+	key, err := decodeBase64(parts[4])
+	if err != nil || len(key) == 0 || (keyLen != 0 && keyLen != len(key)) {
+		return 0, nil, nil, ErrPasswordHashInvalid
+	}
+	return iterations, salt, key, nil
+}
@@ -0,0 +1,188 @@
+package password
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+// default length of password in characters
+const (
+	defaultMinLength = 8
+	defaultMaxLength = 128
+)
+
+// minPersonalLen - shorter parts of login, email or name are not searched in password
+const minPersonalLen = 3
+
+// reasons of PolicyError
+var (
+	ErrPasswordTooShort = errors.New("too short")
+
+	ErrPasswordTooLong = errors.New("too long")
+
+	ErrPasswordClasses = errors.New("too few character classes")
+
+	ErrPasswordPersonal = errors.New("contains personal data")
+
+	ErrPasswordWeak = errors.New("too weak")
+
+	ErrPasswordBreached = errors.New("found in breached passwords")
+)
+
+// PolicyError - all rules broken by password
+type PolicyError struct {
+	Reasons []error
+}
+
+func (pe *PolicyError) Error() string {
+	reasons := make([]string, 0, len(pe.Reasons))
+	for _, reason := range pe.Reasons {
+		reasons = append(reasons, reason.Error())
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Unwrap - errors.Is(err, ErrPasswordTooShort) finds a reason
+func (pe *PolicyError) Unwrap() []error {
+	return pe.Reasons
+}
+
+// Policy - rules for new passwords
+type Policy struct {
+	MinLength        int
+	MaxLength        int
+	MinClasses       int
+	MinStrength      int
+	DisallowPersonal bool
+
+	breached *breachedFile
+}
+
+// policy - rules for new passwords, replaced by Apply
+var policy = NewPolicy(config.PasswordConfig{}, nil)
+
+// NewPolicy - rules from config, default for not set, breached can be nil
+func NewPolicy(cfg config.PasswordConfig, breached *breachedFile) *Policy {
+	p := &Policy{
+		MinLength:        int(cfg.MinLength),
+		MaxLength:        int(cfg.MaxLength),
+		MinClasses:       int(cfg.MinClasses),
+		MinStrength:      int(cfg.MinStrength),
+		DisallowPersonal: cfg.DisallowPersonal,
+		breached:         breached,
+	}
+	if p.MinLength == 0 {
+		p.MinLength = defaultMinLength
+	}
+	if p.MaxLength == 0 {
+		p.MaxLength = defaultMaxLength
+	}
+	if p.MaxLength < p.MinLength {
+		p.MaxLength = p.MinLength
+	}
+	return p
+}
+
+// closeBreached - close file of breached passwords, checks which hold the lock finish first
+func (p *Policy) closeBreached() {
+	if p.breached == nil {
+		return
+	}
+	p.breached.mu.Lock()
+	_ = p.breached.close()
+	p.breached.mu.Unlock()
+}
+
+// CheckPolicy - check new password by the current policy
+// personal - login, email, names of user
+func CheckPolicy(password string, personal ...string) error {
+	mu.RLock()
+	p := policy
+	mu.RUnlock()
+	return p.Check(password, personal...)
+}
+
+// Check - return *PolicyError with all broken rules
+func (p *Policy) Check(password string, personal ...string) error {
+	reasons := []error{}
+	n := utf8.RuneCountInString(password)
+	if n < p.MinLength {
+		reasons = append(reasons, ErrPasswordTooShort)
+	}
+	if n > p.MaxLength {
+		reasons = append(reasons, ErrPasswordTooLong)
+	}
+	if characterClasses(password) < p.MinClasses {
+		reasons = append(reasons, ErrPasswordClasses)
+	}
+	words := personalWords(personal)
+	if p.DisallowPersonal && containsAny(password, words) {
+		reasons = append(reasons, ErrPasswordPersonal)
+	}
+	if p.MinStrength > 0 && Strength(password, words...) < p.MinStrength {
+		reasons = append(reasons, ErrPasswordWeak)
+	}
+	if p.breached != nil {
+		breached, err := p.breached.contains(password)
+		if err != nil {
+			// the password is not rejected, file of breached passwords is an additional check
+			log.Printf("password: breached file error - {%v};", err)
+		}
+		if breached {
+			reasons = append(reasons, ErrPasswordBreached)
+		}
+	}
+	if len(reasons) > 0 {
+		return &PolicyError{Reasons: reasons}
+	}
+	return nil
+}
+
+// characterClasses - number of classes: lower, upper, digit, symbol
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// personalWords - lowercase login, names and both parts of email
+func personalWords(personal []string) []string {
+	words := make([]string, 0, len(personal)+1)
+	for _, word := range personal {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if local, domain, ok := strings.Cut(word, "@"); ok {
+			words = append(words, local)
+			word, _, _ = strings.Cut(domain, ".")
+		}
+		if utf8.RuneCountInString(word) >= minPersonalLen {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func containsAny(password string, words []string) bool {
+	password = strings.ToLower(password)
+	for _, word := range words {
+		if utf8.RuneCountInString(word) >= minPersonalLen && strings.Contains(password, word) {
+			return true
+		}
+	}
+	return false
+}
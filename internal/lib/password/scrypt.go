@@ -0,0 +1,93 @@
+package password
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// limits for imported hashes, verification must not block the server
+const (
+	maxScryptLogN = 20
+	maxScryptRP   = 64
+)
+
+// Scrypt - hashes imported from other systems, only verification
+// "$scrypt$ln=<log2 N>,r=<block size>,p=<parallelism>$<salt>$<hash>"
+type Scrypt struct{}
+
+type scryptParams struct {
+	logN, r, p int
+}
+
+func (Scrypt) IDs() []string {
+	return []string{"scrypt"}
+}
+
+func (Scrypt) Hash(string) (string, error) {
+	return "", ErrPasswordAlgorithmUnknown
+}
+
+func (Scrypt) Verify(hash, password string) error {
+	params, salt, key, err := decodeScrypt(hash)
+	if err != nil {
+		return err
+	}
+	other, err := scrypt.Key([]byte(password), salt, 1<<params.logN, params.r, params.p, len(key))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (Scrypt) Outdated(string) bool {
+	return true
+}
+
+func (Scrypt) ValidHash(hash string) error {
+	_, _, _, err := decodeScrypt(hash)
+	return err
+}
+
+func decodeScrypt(hash string) (*scryptParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	params := &scryptParams{}
+	for _, param := range strings.Split(parts[2], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, nil, ErrPasswordHashInvalid
+		}
+		switch name {
+		case "ln":
+			params.logN = n
+		case "r":
+			params.r = n
+		case "p":
+			params.p = n
+		default:
+			return nil, nil, nil, ErrPasswordHashInvalid
+		}
+	}
+	if params.logN <= 0 || params.logN > maxScryptLogN ||
+		params.r <= 0 || params.p <= 0 || params.r*params.p > maxScryptRP {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	salt, err := decodeBase64(parts[3])
+	if err != nil || len(salt) == 0 {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	key, err := decodeBase64(parts[4])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrPasswordHashInvalid
+	}
+	return params, salt, key, nil
+}
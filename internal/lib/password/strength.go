@@ -0,0 +1,175 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// commonWords - frequent parts of passwords, found in password as a whole piece
+var commonWords = []string{
+	"password", "passwort", "pass", "qwerty", "qwertz", "azerty", "letmein", "welcome",
+	"admin", "login", "user", "guest", "root", "test", "secret", "changeme", "default",
+	"dragon", "monkey", "master", "shadow", "sunshine", "princess", "iloveyou", "love",
+	"football", "baseball", "soccer", "hockey", "freedom", "whatever", "trustno",
+	"starwars", "superman", "batman", "hello", "summer", "winter", "spring", "autumn",
+	"michael", "jordan", "computer", "internet", "killer", "flower", "cookie",
+}
+
+// keyboardRows - rows of keyboard, parts of them are typical patterns
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890"}
+
+// guesses for a piece of password matched by a pattern (per character for patterns)
+const (
+	wordGuesses    = 500
+	patternGuesses = 50
+	minPatternLen  = 3
+	minKeyboardLen = 4
+)
+
+// score by number of guesses, thresholds of zxcvbn
+const (
+	scoreThreshold0 = 1e3
+	scoreThreshold1 = 1e6
+	scoreThreshold2 = 1e8
+	scoreThreshold3 = 1e10
+)
+
+// Strength - score of password from 0 (guessed at once) to 4 (very hard to guess), idea of zxcvbn
+// password is split into pieces: words (common and personal), sequences, repeats, keyboard patterns
+// and single characters, guesses of pieces are multiplied
+func Strength(password string, personal ...string) int {
+	runes := []rune(strings.ToLower(password))
+	words := append(append([]string{}, commonWords...), personal...)
+	cardinality := float64(charsetSize(password))
+
+	log10Guesses := 0.0
+	for i := 0; i < len(runes); {
+		n, guesses := longestPattern(runes[i:], words)
+		if n == 0 {
+			n, guesses = 1, cardinality
+		}
+		log10Guesses += math.Log10(guesses)
+		i += n
+	}
+
+	switch guesses := math.Pow(10, log10Guesses); {
+	case guesses < scoreThreshold0:
+		return 0
+	case guesses < scoreThreshold1:
+		return 1
+	case guesses < scoreThreshold2:
+		return 2
+	case guesses < scoreThreshold3:
+		return 3
+	}
+	return 4
+}
+
+// longestPattern - length and guesses of the longest pattern at the start of runes, 0 if not found
+func longestPattern(runes []rune, words []string) (int, float64) {
+	best, guesses := 0, 0.0
+	s := string(runes)
+	for _, word := range words {
+		if n := utf8.RuneCountInString(word); n > best && n >= minPatternLen && strings.HasPrefix(s, word) {
+			best, guesses = n, wordGuesses
+		}
+	}
+	if n := sequenceLen(runes); n >= minPatternLen && n > best {
+		best, guesses = n, patternGuesses*float64(n)
+	}
+	if n := repeatLen(runes); n >= minPatternLen && n > best {
+		best, guesses = n, patternGuesses*float64(n)
+	}
+	if n := keyboardLen(s); n >= minKeyboardLen && n > best {
+		best, guesses = n, patternGuesses*float64(n)
+	}
+	return best, guesses
+}
+
+// sequenceLen - "abc", "987" - characters with step 1 or -1
+func sequenceLen(runes []rune) int {
+	if len(runes) < 2 {
+		return len(runes)
+	}
+	step := runes[1] - runes[0]
+	if step != 1 && step != -1 {
+		return 1
+	}
+	n := 2
+	for n < len(runes) && runes[n]-runes[n-1] == step {
+		n++
+	}
+	return n
+}
+
+// repeatLen - "aaa"
+func repeatLen(runes []rune) int {
+	n := 1
+	for n < len(runes) && runes[n] == runes[0] {
+		n++
+	}
+	return n
+}
+
+// keyboardLen - longest part of a keyboard row (in both directions) at the start of s
+func keyboardLen(s string) int {
+	best := 0
+	for _, row := range keyboardRows {
+		for _, r := range []string{row, reverse(row)} {
+			for i := range r {
+				n := 0
+				for n < len(s) && i+n < len(r) && s[n] == r[i+n] {
+					n++
+				}
+				best = max(best, n)
+			}
+		}
+	}
+	return best
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// charsetSize - number of characters a brute force must try for one character of password
+func charsetSize(password string) int {
+	size := 0
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r < utf8.RuneSelf && unicode.IsLower(r):
+			lower = true
+		case r < utf8.RuneSelf && unicode.IsUpper(r):
+			upper = true
+		case r < utf8.RuneSelf && unicode.IsDigit(r):
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	return max(size, 10)
+}
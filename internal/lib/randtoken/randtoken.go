@@ -0,0 +1,28 @@
+// contains functions for creating opaque random tokens and their hashes for storage
+package randtoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// size of a token in bytes before encoding
+const tokenSize = 32
+
+// New - create url-safe random token
+func New() (string, error) {
+	buf := make([]byte, tokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("randtoken: rand.Read error - {%w};", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash - sha256 of token in hex, only the hash gets into the store
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
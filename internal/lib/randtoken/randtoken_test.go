@@ -0,0 +1,41 @@
+package randtoken
+
+import (
+	"encoding/base64"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	log.Printf("randtoken_test: TestNew - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	token, err := New()
+	requires.NoError(err)
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	requires.NoError(err, "token is url-safe base64")
+	asserts.Len(raw, tokenSize)
+
+	other, err := New()
+	requires.NoError(err)
+	asserts.NotEqual(token, other)
+
+	log.Printf("randtoken_test: TestNew - END")
+}
+
+func TestHash(t *testing.T) {
+	log.Printf("randtoken_test: TestHash - START")
+
+	asserts := assert.New(t)
+
+	asserts.Equal("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", Hash("abc"), "sha256 of FIPS 180-2")
+	asserts.Equal(Hash("token"), Hash("token"))
+	asserts.NotEqual(Hash("token"), Hash("other"))
+
+	log.Printf("randtoken_test: TestHash - END")
+}
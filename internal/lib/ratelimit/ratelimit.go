@@ -0,0 +1,163 @@
+// token bucket for every pair of method and client
+// buckets live in process, every replica limits its own traffic
+package ratelimit
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+// default limits of methods, used if not set in config
+var (
+	defaultLimit = config.RateLimit{Requests: 100, Period: time.Second}
+
+	defaultMethodLimits = map[string]config.RateLimit{
+		"UserLogin":    {Requests: 10, Period: time.Minute},
+		"UserRegister": {Requests: 5, Period: time.Minute},
+		"RefreshToken": {Requests: 30, Period: time.Minute},
+
+		"SendEmailVerification": {Requests: 3, Period: time.Minute},
+		"VerifyEmail":           {Requests: 10, Period: time.Minute},
+		"RequestPasswordReset":  {Requests: 3, Period: time.Minute},
+		"ConfirmPasswordReset":  {Requests: 10, Period: time.Minute},
+		"ConfirmEmailChange":    {Requests: 10, Period: time.Minute},
+		"StepUp":                {Requests: 10, Period: time.Minute},
+		"EnrollMFA":             {Requests: 5, Period: time.Minute},
+		"ConfirmMFA":            {Requests: 10, Period: time.Minute},
+		"VerifyMFA":             {Requests: 10, Period: time.Minute},
+		"DisableMFA":            {Requests: 5, Period: time.Minute},
+
+		AuthFailure: {Requests: 10, Period: time.Minute},
+	}
+)
+
+// AuthFailure - name of bucket of rejected authorization (admin key, bearer token) per IP of client
+const AuthFailure = "AuthFailure"
+
+// sweepInterval - full buckets are removed not more often
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	limit     config.RateLimit
+}
+
+// refill - tokens come evenly during period, not more than 'Requests'
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updatedAt); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate()
+		b.tokens = min(b.tokens, float64(b.limit.Requests))
+		b.updatedAt = now
+	}
+}
+
+// wait - time after which a token will be in bucket
+func (b *bucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.rate() * float64(time.Second))
+}
+
+// rate - tokens per second
+func (b *bucket) rate() float64 {
+	return float64(b.limit.Requests) / b.limit.Period.Seconds()
+}
+
+type Limiter struct {
+	mu sync.Mutex
+
+	defaultLimit config.RateLimit
+	limits       map[string]config.RateLimit
+
+	// buckets - "<method> <client>" -> bucket
+	buckets map[string]*bucket
+
+	lastSweep time.Time
+}
+
+// NewLimiter - limits from config, invalid values are checked by config, default for not set
+func NewLimiter(cfg *config.RateLimitConfig) *Limiter {
+	l := &Limiter{
+		defaultLimit: defaultLimit,
+		limits:       make(map[string]config.RateLimit, len(defaultMethodLimits)+len(cfg.Methods)),
+		buckets:      make(map[string]*bucket),
+	}
+	if limit, err := config.ParseRateLimit(cfg.Default); err == nil {
+		l.defaultLimit = limit
+	}
+	for method, limit := range defaultMethodLimits {
+		l.limits[method] = limit
+	}
+	for method, value := range cfg.Methods {
+		limit, err := config.ParseRateLimit(value)
+		if err != nil {
+			log.Printf("ratelimit: invalid limit of method {%s} - {%v};", method, err)
+			continue
+		}
+		l.limits[method] = limit
+	}
+	return l
+}
+
+// Allow - take a token from bucket of method and client
+// false with time after which a token will be in bucket
+func (l *Limiter) Allow(method, client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(method, client, now)
+	if b.tokens < 1 {
+		return false, b.wait()
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Exhausted - true with time to wait if bucket of method and client has no token, the token is not taken
+func (l *Limiter) Exhausted(method, client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(method, client, now)
+	if b.tokens < 1 {
+		return true, b.wait()
+	}
+	return false, 0
+}
+
+// bucket - refilled bucket of method and client, a new one is full
+func (l *Limiter) bucket(method, client string, now time.Time) *bucket {
+	l.sweep(now)
+
+	key := method + " " + client
+	b, ex := l.buckets[key]
+	if !ex {
+		limit := l.limit(method)
+		b = &bucket{tokens: float64(limit.Requests), updatedAt: now, limit: limit}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+func (l *Limiter) limit(method string) config.RateLimit {
+	if limit, ex := l.limits[method]; ex {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+// sweep - full bucket is the same as a new one, remove it
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updatedAt) >= b.limit.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	log.Printf("ratelimit_test: TestLimiter_Allow - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	limiter := NewLimiter(&config.RateLimitConfig{Methods: map[string]string{"UserLogin": "3/m"}})
+	now := time.Unix(1_700_000_000, 0)
+
+	log.Printf("\t1 full bucket")
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("UserLogin", "10.0.0.1", now)
+		requires.True(ok, "request %d", i)
+	}
+	ok, wait := limiter.Allow("UserLogin", "10.0.0.1", now)
+	asserts.False(ok, "bucket is empty")
+	asserts.Equal(20*time.Second, wait, "one token comes every period/requests")
+
+	log.Printf("\t2 buckets of other client and method")
+	ok, _ = limiter.Allow("UserLogin", "10.0.0.2", now)
+	asserts.True(ok, "other client")
+	ok, _ = limiter.Allow("UserData", "10.0.0.1", now)
+	asserts.True(ok, "other method")
+
+	log.Printf("\t3 refill")
+	ok, wait = limiter.Allow("UserLogin", "10.0.0.1", now.Add(10*time.Second))
+	asserts.False(ok, "half of token")
+	asserts.Equal(10*time.Second, wait)
+	ok, _ = limiter.Allow("UserLogin", "10.0.0.1", now.Add(20*time.Second))
+	asserts.True(ok, "token came")
+	ok, _ = limiter.Allow("UserLogin", "10.0.0.1", now.Add(20*time.Second))
+	asserts.False(ok, "only one token came")
+
+	log.Printf("\t4 bucket is not filled over limit")
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("UserLogin", "10.0.0.1", later)
+		requires.True(ok, "request %d", i)
+	}
+	ok, _ = limiter.Allow("UserLogin", "10.0.0.1", later)
+	asserts.False(ok, "not more than limit after long idle")
+
+	log.Printf("ratelimit_test: TestLimiter_Allow - END")
+}
+
+func TestLimiter_Exhausted(t *testing.T) {
+	log.Printf("ratelimit_test: TestLimiter_Exhausted - START")
+
+	asserts := assert.New(t)
+
+	limiter := NewLimiter(&config.RateLimitConfig{Default: "1/s"})
+	now := time.Unix(1_700_000_000, 0)
+
+	exhausted, _ := limiter.Exhausted("UserData", "client", now)
+	asserts.False(exhausted)
+	exhausted, _ = limiter.Exhausted("UserData", "client", now)
+	asserts.False(exhausted, "token is not taken")
+
+	ok, _ := limiter.Allow("UserData", "client", now)
+	asserts.True(ok)
+	exhausted, wait := limiter.Exhausted("UserData", "client", now)
+	asserts.True(exhausted)
+	asserts.Equal(time.Second, wait)
+
+	log.Printf("ratelimit_test: TestLimiter_Exhausted - END")
+}
+
+func TestNewLimiter(t *testing.T) {
+	log.Printf("ratelimit_test: TestNewLimiter - START")
+
+	asserts := assert.New(t)
+
+	limiter := NewLimiter(&config.RateLimitConfig{
+		Default: "7/m",
+		Methods: map[string]string{"UserRegister": "2/h", "UserLogin": "wrong"},
+	})
+	asserts.Equal(config.RateLimit{Requests: 7, Period: time.Minute}, limiter.limit("UserData"), "default of config")
+	asserts.Equal(config.RateLimit{Requests: 2, Period: time.Hour}, limiter.limit("UserRegister"), "method of config")
+	asserts.Equal(defaultMethodLimits["UserLogin"], limiter.limit("UserLogin"), "invalid value keeps default of method")
+
+	log.Printf("ratelimit_test: TestNewLimiter - END")
+}
@@ -0,0 +1,99 @@
+// contains encryption of secrets at rest with AES-256-GCM keys of keyring
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrSealNoKeys = errors.New("no keys of encryption")
+
+	ErrSealKeyInvalid = errors.New("invalid key of encryption, want base64 of 32 bytes")
+
+	ErrSealKeyUnknown = errors.New("unknown key of encryption")
+
+	ErrSealDataInvalid = errors.New("invalid sealed data")
+)
+
+// KeySize - size of key of AES-256
+const KeySize = 32
+
+// Keyring - keys by "kid", the current key seals, all keys open
+// key is retired by removing it after all data is sealed by other key
+type Keyring struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// New - keys are "kid" -> base64 of key, currentKeyID may be empty if there is only one key
+func New(keys map[string]string, currentKeyID string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrSealNoKeys
+	}
+	kr := &Keyring{keys: make(map[string]cipher.AEAD, len(keys)), current: currentKeyID}
+	for kid, encoded := range keys {
+		aead, err := newAEAD(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w - %s", err, kid)
+		}
+		kr.keys[kid] = aead
+		if currentKeyID == "" && len(keys) == 1 {
+			kr.current = kid
+		}
+	}
+	if _, ex := kr.keys[kr.current]; !ex {
+		return nil, fmt.Errorf("%w - %s", ErrSealKeyUnknown, kr.current)
+	}
+	return kr, nil
+}
+
+func newAEAD(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != KeySize {
+		return nil, ErrSealKeyInvalid
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal - "<kid>:<base64 of nonce and ciphertext>"
+// additional data is not stored, it binds the data to its owner, Open with other data fails
+func (kr *Keyring) Seal(plain, additional []byte) (string, error) {
+	aead := kr.keys[kr.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("seal: rand.Read error - {%w};", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plain, additional)
+	return kr.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open - data of Seal by any key of keyring
+func (kr *Keyring) Open(sealed string, additional []byte) ([]byte, error) {
+	kid, encoded, found := strings.Cut(sealed, ":")
+	if !found {
+		return nil, ErrSealDataInvalid
+	}
+	aead, ex := kr.keys[kid]
+	if !ex {
+		return nil, fmt.Errorf("%w - %s", ErrSealKeyUnknown, kid)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, ErrSealDataInvalid
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrSealDataInvalid
+	}
+	return plain, nil
+}
@@ -0,0 +1,103 @@
+package seal
+
+import (
+	"encoding/base64"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), KeySize)))
+}
+
+func TestNew(t *testing.T) {
+	log.Printf("seal_test: TestNew - START")
+
+	asserts := assert.New(t)
+
+	tests := []struct {
+		description string
+		keys        map[string]string
+		current     string
+		err         error
+	}{
+		{description: "no keys", keys: nil, err: ErrSealNoKeys},
+		{description: "short key", keys: map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))}, err: ErrSealKeyInvalid},
+		{description: "not base64", keys: map[string]string{"k1": "%%%"}, err: ErrSealKeyInvalid},
+		{description: "unknown current", keys: map[string]string{"k1": newTestKey('a')}, current: "k2", err: ErrSealKeyUnknown},
+		{description: "current of many keys is required", keys: map[string]string{"k1": newTestKey('a'), "k2": newTestKey('b')}, err: ErrSealKeyUnknown},
+		{description: "single key is current", keys: map[string]string{"k1": newTestKey('a')}},
+	}
+	for _, test := range tests {
+		_, err := New(test.keys, test.current)
+		if test.err == nil {
+			asserts.NoError(err, test.description)
+			continue
+		}
+		asserts.ErrorIs(err, test.err, test.description)
+	}
+
+	log.Printf("seal_test: TestNew - END")
+}
+
+func TestKeyring_SealOpen(t *testing.T) {
+	log.Printf("seal_test: TestKeyring_SealOpen - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	old, err := New(map[string]string{"k1": newTestKey('a')}, "")
+	requires.NoError(err)
+	kr, err := New(map[string]string{"k1": newTestKey('a'), "k2": newTestKey('b')}, "k2")
+	requires.NoError(err)
+
+	log.Printf("\t1 round trip")
+	sealed, err := kr.Seal([]byte("secret"), []byte("user 1"))
+	requires.NoError(err)
+	asserts.True(strings.HasPrefix(sealed, "k2:"), "sealed by current key")
+	asserts.NotContains(sealed, "secret")
+	plain, err := kr.Open(sealed, []byte("user 1"))
+	requires.NoError(err)
+	asserts.Equal([]byte("secret"), plain)
+
+	other, err := kr.Seal([]byte("secret"), []byte("user 1"))
+	requires.NoError(err)
+	asserts.NotEqual(sealed, other, "nonce is random")
+
+	log.Printf("\t2 data of retired current key")
+	sealedByOld, err := old.Seal([]byte("secret"), []byte("user 1"))
+	requires.NoError(err)
+	plain, err = kr.Open(sealedByOld, []byte("user 1"))
+	requires.NoError(err, "all keys of keyring open")
+	asserts.Equal([]byte("secret"), plain)
+
+	log.Printf("\t3 wrong additional data")
+	_, err = kr.Open(sealed, []byte("user 2"))
+	asserts.ErrorIs(err, ErrSealDataInvalid)
+	_, err = kr.Open(sealed, nil)
+	asserts.ErrorIs(err, ErrSealDataInvalid)
+
+	log.Printf("\t4 unknown kid")
+	_, err = old.Open(sealed, []byte("user 1"))
+	asserts.ErrorIs(err, ErrSealKeyUnknown)
+
+	log.Printf("\t5 broken data")
+	_, err = kr.Open("k2", []byte("user 1"))
+	asserts.ErrorIs(err, ErrSealDataInvalid, "no kid")
+	_, err = kr.Open("k2:%%%", []byte("user 1"))
+	asserts.ErrorIs(err, ErrSealDataInvalid, "not base64")
+	_, err = kr.Open("k2:AAAA", []byte("user 1"))
+	asserts.ErrorIs(err, ErrSealDataInvalid, "shorter than nonce")
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+	_, err = kr.Open(tampered, []byte("user 1"))
+	asserts.ErrorIs(err, ErrSealDataInvalid, "changed ciphertext")
+
+	log.Printf("seal_test: TestKeyring_SealOpen - END")
+}
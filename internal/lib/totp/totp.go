@@ -0,0 +1,97 @@
+// contains time-based one-time passwords of RFC 6238 (HMAC-SHA1, 6 digits, 30 seconds)
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// parameters of codes, authenticator apps use them by default
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+// secretSize - 160 bits, size of output of SHA-1 (RFC 4226)
+const secretSize = 20
+
+// skew - steps before and after the current one, clocks of client and server are not equal
+const skew = 1
+
+// encoding - base32 of secret without padding, format of authenticator apps
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret - random secret of user
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("totp: rand.Read error - {%w};", err)
+	}
+	return secret, nil
+}
+
+// EncodeSecret - secret for manual entry in authenticator app
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI - otpauth:// link for QR code, account is shown under issuer in authenticator app
+func URI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period/time.Second)))
+	link := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return link.String()
+}
+
+// Step - number of period of time t since unix epoch
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code - code of secret for time t
+func Code(secret []byte, t time.Time) string {
+	return codeOfStep(secret, Step(t))
+}
+
+// Validate - step of code if it is a code of time t or of neighbouring steps
+// caller keeps the last used step, so a code is not accepted twice
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(t)
+	for step := current - skew; step <= current+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(codeOfStep(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// codeOfStep - HOTP of RFC 4226 with dynamic truncation
+func codeOfStep(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
@@ -0,0 +1,91 @@
+package totp
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret - secret of test vectors of RFC 6238 for HMAC-SHA1
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	log.Printf("totp_test: TestCode - START")
+
+	asserts := assert.New(t)
+
+	// RFC 6238 Appendix B, 8 digits of vectors are cut to the last 6 - value of 6 digits is value mod 10^6
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+	for _, vector := range vectors {
+		asserts.Equal(vector.code, Code(rfcSecret, time.Unix(vector.unix, 0)), "time %d", vector.unix)
+	}
+
+	log.Printf("totp_test: TestCode - END")
+}
+
+func TestValidate(t *testing.T) {
+	log.Printf("totp_test: TestValidate - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	now := time.Unix(1111111111, 0)
+
+	step, ok := Validate(rfcSecret, "050471", now)
+	requires.True(ok, "code of the current step")
+	asserts.Equal(Step(now), step)
+
+	step, ok = Validate(rfcSecret, Code(rfcSecret, now.Add(-Period)), now)
+	asserts.True(ok, "code of the previous step")
+	asserts.Equal(Step(now)-1, step)
+
+	_, ok = Validate(rfcSecret, Code(rfcSecret, now.Add(Period)), now)
+	asserts.True(ok, "code of the next step")
+
+	_, ok = Validate(rfcSecret, Code(rfcSecret, now.Add(-2*Period)), now)
+	asserts.False(ok, "code out of skew")
+
+	_, ok = Validate(rfcSecret, "50471", now)
+	asserts.False(ok, "short code")
+
+	_, ok = Validate([]byte("other secret of user!"), "050471", now)
+	asserts.False(ok, "code of other secret")
+
+	log.Printf("totp_test: TestValidate - END")
+}
+
+func TestURI(t *testing.T) {
+	log.Printf("totp_test: TestURI - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	secret, err := NewSecret()
+	requires.NoError(err)
+	asserts.Len(secret, secretSize)
+
+	encoded := EncodeSecret(secret)
+	asserts.NotContains(encoded, "=", "secret without padding")
+
+	uri := URI("user-dir", "test@example.com", secret)
+	asserts.True(strings.HasPrefix(uri, "otpauth://totp/user-dir:test@example.com?"), uri)
+	asserts.Contains(uri, "secret="+encoded)
+	asserts.Contains(uri, "digits=6")
+	asserts.Contains(uri, "period=30")
+
+	log.Printf("totp_test: TestURI - END")
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// IdempotencyKey - key of mutating request from metadata "idempotency-key" with response of the first request
+// Scope - method and client, the same key of other method or user is other key
+// Fingerprint - hash of request, the key can't be used for other request
+// Response - nil while the first request is in progress, Header - response header of the first request
+// LockedUntil - lease of the request in progress, after it the same request can take the key
+type IdempotencyKey struct {
+	Key         string
+	Scope       string
+	Fingerprint string
+	Response    []byte
+	Header      map[string][]string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	LockedUntil time.Time
+}
+
+// InProgress - the first request with the key is not finished
+func (ik *IdempotencyKey) InProgress() bool {
+	return ik.Response == nil
+}
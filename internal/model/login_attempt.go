@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// LoginAttempt - failed logins with one email in a row, email of not existing user is counted too
+type LoginAttempt struct {
+	Email        string
+	FailedCount  int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
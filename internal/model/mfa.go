@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// MFA - TOTP of user, empty Secret - user is not enrolled
+// Secret - encrypted secret (see lib/seal), it is never stored in plain text
+// ConfirmedAt - nil until the first code is confirmed, then login needs a code
+// LastUsedStep - time step of the last accepted code, a code is not accepted twice
+type MFA struct {
+	UserID       uint
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// Enabled - login of user needs a code
+func (m *MFA) Enabled() bool {
+	return m.ConfirmedAt != nil
+}
+
+// RecoveryCode - hash of one-time code for login without authenticator app, the code is not stored
+type RecoveryCode struct {
+	UserID    uint
+	Hash      string
+	CreatedAt time.Time
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// purposes of one-time tokens, the same as "purpose" of the signed token
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeChangeEmail   = "change_email"
+	PurposeMFAChallenge  = "mfa_challenge"
+)
+
+// PurposeStepUp - "purpose" of token of StepUp, it is not one-time and has no record, it lives a few minutes
+const PurposeStepUp = "step_up"
+
+// OneTimeToken - record of token sent to user by mail
+// ID - "jti" of signed token (verify_email, change_email, mfa_challenge) or hash of opaque code (reset_password), the code is not stored
+// token is valid only while its record exists, the record is removed by the first use
+// Email - address the token was sent to, token is not valid for other email of user
+// for change_email it is the new email, which is written to user only by the token
+type OneTimeToken struct {
+	ID      string
+	UserID  uint
+	Purpose string
+	Email   string
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
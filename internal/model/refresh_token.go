@@ -0,0 +1,32 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrModelRefreshTokenReused - token from the family was already spent
+var ErrModelRefreshTokenReused = errors.New("refresh token reused")
+
+// RefreshToken - opaque token for getting a new access token
+// only hash of the token is stored, all tokens issued by rotation from one login share FamilyID and AuthTime
+type RefreshToken struct {
+	ID uint
+
+	UserID   uint
+	FamilyID string
+
+	TokenHash string
+
+	// AuthTime - time of login, start of session
+	AuthTime  time.Time
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// Reused - token was spent earlier, presenting it again means that it was stolen
+func (rt *RefreshToken) Reused() bool {
+	return rt.UsedAt != nil
+}
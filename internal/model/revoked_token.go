@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// RevokedToken - access token (by "jti") which can't be used until its expiration
+type RevokedToken struct {
+	JTI    string
+	UserID uint
+
+	ExpiresAt time.Time
+	RevokedAt time.Time
+}
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// Session - one login of user on a device
+// ID of session is the family of its refresh tokens
+type Session struct {
+	ID     string
+	UserID uint
+
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+
+	ClientIP  string
+	UserAgent string
+
+	RevokedAt *time.Time
+}
+
+// Revoked - session was ended by the user (logout or revoke from other device)
+func (s *Session) Revoked() bool {
+	return s.RevokedAt != nil
+}
@@ -4,7 +4,7 @@ import (
 	"errors"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
 )
 
 var (
@@ -15,6 +15,24 @@ var (
 	ErrModelUserDateEarly = errors.New("earlier date of recording")
 )
 
+// fields of User which can be updated, names of columns and of fields of proto message
+const (
+	UserFieldLogin     = "login"
+	UserFieldFirstName = "first_name"
+	UserFieldLastName  = "last_name"
+	UserFieldEmail     = "email"
+	UserFieldPassword  = "password"
+)
+
+// UserUpdateFields - all fields of User which can be updated
+var UserUpdateFields = []string{
+	UserFieldLogin,
+	UserFieldFirstName,
+	UserFieldLastName,
+	UserFieldEmail,
+	UserFieldPassword,
+}
+
 type User struct {
 	ID uint
 
@@ -29,22 +47,49 @@ type User struct {
 
 	CreatedAt time.Time
 	UpdatedAt *time.Time
+
+	// TokensValidAfter - tokens issued before are invalid (logout from all devices)
+	TokensValidAfter *time.Time
+
+	// Version - 1 for a new user, incremented by every update of user data
+	Version uint
+
+	// EmailVerifiedAt - time of confirmation of email, nil - not verified, new email is not verified
+	EmailVerifiedAt *time.Time
 }
 
-// ValidPassword - compare passwords with help 'bcrypt'
-func (u *User) ValidPassword(password string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	return err
+// EmailVerified - user confirmed the current email
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// TokenIssuedBeforeValid - true if token was issued before 'TokensValidAfter'
+func (u *User) TokenIssuedBeforeValid(issuedAt time.Time) bool {
+	return u.TokensValidAfter != nil && issuedAt.Before(*u.TokensValidAfter)
+}
+
+// ValidPassword - compare password with hash, algorithm is chosen by hash (argon2id, bcrypt)
+func (u *User) ValidPassword(pass string) error {
+	return password.Verify(u.Password, pass)
+}
+
+// PasswordOutdated - hash of password should be replaced by hash of the current algorithm
+func (u *User) PasswordOutdated() bool {
+	return password.NeedsRehash(u.Password)
 }
 
 // ValidUpdate - !!! u -> old data, user -> new data !!!
 // compare u.ID and user.ID -> not equal -> error
 // then if u was created not before user update was submitted -> error
 // u last update not before user update -> error
+// user.UpdatedAt is nil -> time of update is assigned by database, it is not compared
 func (u *User) ValidUpdate(user *User) error {
 	if u.ID != user.ID {
 		return ErrModelUserDifferentID
 	}
+	if user.UpdatedAt == nil {
+		return nil
+	}
 	// user.UpdatedAt - check in (deserializer/user_update_decode.go)
 	if !u.CreatedAt.UTC().Before(user.UpdatedAt.UTC()) ||
 		(u.UpdatedAt != nil && !u.UpdatedAt.UTC().Before(user.UpdatedAt.UTC())) {
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// ConfirmEmailChange - rules for confirmation of the new email
+// decode token from request, check its signature, expiration and purpose
+// in one transaction: use record of token (once), write email of record to its user,
+// the email got the token, so it is verified
+// email taken by other user since the request -> *AlreadyExistsError, the token is kept
+func (s *service) ConfirmEmailChange(
+	ctx context.Context,
+	req *auth.ConfirmEmailChangeRequest) (*auth.ConfirmEmailChangeResponse, error) {
+	deserialize := deserializer.NewConfirmEmailChangeDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	claims, err := jwtsign.GetClaimsFromPurposeToken(deserialize.Token(), model.PurposeChangeEmail)
+	if err != nil {
+		log.Printf("service: ConfirmEmailChange GetClaimsFromPurposeToken error - {%v};", err)
+		return nil, ErrServiceTokenInvalid
+	}
+
+	now := time.Now().UTC()
+	err = s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		token, err := tx.UseOneTimeToken(ctx, claims.ID, model.PurposeChangeEmail, now)
+		if err != nil {
+			return err
+		}
+		if strconv.FormatUint(uint64(token.UserID), 10) != claims.Subject {
+			return ErrServiceTokenInvalid
+		}
+		u, err := tx.FindUserByID(ctx, token.UserID)
+		if err != nil {
+			return err
+		}
+		u.Email = token.Email
+		u.UpdatedAt = nil
+		if err := tx.UpdateUser(ctx, u, model.UserFieldEmail); err != nil {
+			return err
+		}
+		return tx.SetEmailVerified(ctx, u.ID, u.Email, now)
+	})
+	if err != nil {
+		log.Printf("service: ConfirmEmailChange error - {%v};", err)
+		return nil, emailChangeError(err)
+	}
+
+	return &auth.ConfirmEmailChangeResponse{}, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/totp"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// ConfirmMFA - rules for enabling TOTP of user
+// decode code from request
+// user without EnrollMFA -> ErrServiceMFANotEnabled, with enabled TOTP -> ErrServiceMFAEnabled
+// check code by the secret of enrollment, the step of code is the last used step
+// in one transaction: enable TOTP, write hashes of new recovery codes, the codes are only in the response
+func (s *service) ConfirmMFA(
+	ctx context.Context,
+	req *auth.ConfirmMFARequest) (*auth.ConfirmMFAResponse, error) {
+	deserialize := deserializer.NewConfirmMFADecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: ConfirmMFA IDDecode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	mfa, err := s.DBProvider.FindMFA(ctx, deserializeUserID.UserID())
+	if err != nil {
+		log.Printf("service: ConfirmMFA FindMFA error - {%v};", err)
+		return nil, mfaError(err, ErrServiceInternal)
+	}
+	switch {
+	case mfa.Enabled():
+		return nil, ErrServiceMFAEnabled
+	case mfa.Secret == "":
+		return nil, ErrServiceMFANotEnabled
+	}
+	secret, err := s.openSecret(mfa)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	step, ok := totp.Validate(secret, deserialize.Code(), now)
+	if !ok {
+		return nil, ErrServiceMFACodeInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes(s.mfa.recoveryCodes)
+	if err != nil {
+		log.Printf("service: ConfirmMFA newRecoveryCodes error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	err = s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		if err := tx.ConfirmMFA(ctx, mfa.UserID, step, now); err != nil {
+			// confirmed or replaced concurrently
+			return mfaError(err, ErrServiceMFAEnabled)
+		}
+		return tx.ReplaceRecoveryCodes(ctx, mfa.UserID, hashes, now)
+	})
+	if err != nil {
+		log.Printf("service: ConfirmMFA error - {%v};", err)
+		return nil, mfaError(err, ErrServiceInternal)
+	}
+
+	serialize := serializer.ConfirmMFAEncode{RecoveryCodes: codes}
+	return serialize.Response(), nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// ConfirmPasswordReset - rules for setting a new password by code from mail
+// decode code and new password from request, hash the password
+// in one transaction: use record of code (once), find its user, email of user changed after sending -> ErrServiceTokenInvalid
+// check policy of passwords with data of user, write the password,
+// the email got the code, so it is verified, failed logins of email are forgotten,
+// all sessions and tokens of user are revoked
+func (s *service) ConfirmPasswordReset(
+	ctx context.Context,
+	req *auth.ConfirmPasswordResetRequest) (*auth.ConfirmPasswordResetResponse, error) {
+	deserialize := deserializer.NewConfirmPasswordResetDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := password.Hash(deserialize.Password())
+	if err != nil {
+		log.Printf("service: ConfirmPasswordReset password.Hash error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	var (
+		userID     uint
+		sessionIDs []string
+	)
+	err = s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		token, err := tx.UseOneTimeToken(ctx, randtoken.Hash(deserialize.ResetCode()), model.PurposeResetPassword, now)
+		if err != nil {
+			return err
+		}
+		u, err := tx.FindUserByID(ctx, token.UserID)
+		if err != nil {
+			return err
+		}
+		if u.Email != token.Email {
+			return ErrServiceTokenInvalid
+		}
+		if err := deserialize.ValidPolicy(u); err != nil {
+			return err
+		}
+		u.Password = hashedPassword
+		u.UpdatedAt = nil
+		if err := tx.UpdateUser(ctx, u, model.UserFieldPassword); err != nil {
+			return err
+		}
+		if err := tx.SetEmailVerified(ctx, u.ID, u.Email, now); err != nil {
+			return err
+		}
+		if err := tx.RemoveLoginAttempts(ctx, foldEmail(u.Email)); err != nil {
+			return err
+		}
+		userID = u.ID
+		sessionIDs, err = writeAllTokensRevoked(ctx, tx, u.ID, now)
+		return err
+	})
+	if err != nil {
+		log.Printf("service: ConfirmPasswordReset error - {%v};", err)
+		return nil, passwordResetError(err)
+	}
+	s.forgetAllTokens(userID, sessionIDs, now)
+
+	return &auth.ConfirmPasswordResetResponse{}, nil
+}
+
+// passwordResetError - invalid password is shown to client, lost database -> ErrServiceUnavailable,
+// user changed concurrently -> ErrServiceVersionConflict, other errors mean that code can't be used
+func passwordResetError(err error) error {
+	var validationErr *deserializer.ValidationError
+	if errors.As(err, &validationErr) {
+		return err
+	}
+	if errors.Is(err, db.ErrDBVersionConflict) {
+		return ErrServiceVersionConflict
+	}
+	return oneTimeTokenError(err)
+}
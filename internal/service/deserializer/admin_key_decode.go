@@ -0,0 +1,42 @@
+package deserializer
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderAdminKey - key of metadata with key of admin API
+const HeaderAdminKey = "x-admin-key"
+
+var ErrDeserializerAdminKeyMissing = errors.New("missing admin key")
+
+type AdminKeyDecode struct {
+	key string
+}
+
+func NewAdminKeyDecode() *AdminKeyDecode {
+	return &AdminKeyDecode{}
+}
+
+func (akd *AdminKeyDecode) Key() string {
+	return akd.key
+}
+
+// Decode - get key of admin API from metadata "x-admin-key"
+func (akd *AdminKeyDecode) Decode(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ErrDeserializerMetadataEmpty
+	}
+	keys := md.Get(HeaderAdminKey)
+	if len(keys) == 0 {
+		return ErrDeserializerAdminKeyMissing
+	}
+	if akd.key = strings.TrimSpace(keys[0]); akd.key == "" {
+		return ErrDeserializerAdminKeyMissing
+	}
+	return nil
+}
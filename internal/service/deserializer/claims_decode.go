@@ -0,0 +1,29 @@
+package deserializer
+
+import (
+	"context"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+)
+
+type ClaimsDecode struct {
+	claims *jwtsign.Claims
+}
+
+func NewClaimsDecode() *ClaimsDecode {
+	return &ClaimsDecode{}
+}
+
+func (cd *ClaimsDecode) Claims() *jwtsign.Claims {
+	return cd.claims
+}
+
+// Decode - get claims of the current token from context
+func (cd *ClaimsDecode) Decode(ctx context.Context) error {
+	claims, ok := ctx.Value("claims").(*jwtsign.Claims)
+	if !ok || claims == nil {
+		return ErrDeserializerInvalid
+	}
+	cd.claims = claims
+	return nil
+}
@@ -0,0 +1,52 @@
+// rules for getting data of the client from context
+package deserializer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// maxUserAgentLen - size of column sessions.user_agent
+const maxUserAgentLen = 512
+
+type ClientDecode struct {
+	ClientIP  string
+	UserAgent string
+}
+
+func NewClientDecode() *ClientDecode {
+	return &ClientDecode{}
+}
+
+// Decode - get IP of client from the gRPC peer and "user-agent" from metadata
+// invalid UTF-8 of user-agent is dropped, long user-agent is cut on a rune, unknown values stay empty
+func (cd *ClientDecode) Decode(ctx context.Context) {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		cd.ClientIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(cd.ClientIP); err == nil {
+			cd.ClientIP = host
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if userAgent := md.Get("user-agent"); len(userAgent) > 0 {
+			cd.UserAgent = userAgent[0]
+		}
+	}
+	cd.UserAgent = truncateUTF8(strings.ToValidUTF8(cd.UserAgent, ""), maxUserAgentLen)
+}
+
+// truncateUTF8 - the first bytes of s up to size, cut on the start of rune, so the result is valid UTF-8
+func truncateUTF8(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	return s[:size]
+}
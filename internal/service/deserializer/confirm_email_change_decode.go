@@ -0,0 +1,43 @@
+// rules for parsing token of email change from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type ConfirmEmailChangeDecode struct {
+	ChangeToken string
+}
+
+func NewConfirmEmailChangeDecode() *ConfirmEmailChangeDecode {
+	return &ConfirmEmailChangeDecode{}
+}
+
+func (cecd *ConfirmEmailChangeDecode) Token() string {
+	return cecd.ChangeToken
+}
+
+func (cecd *ConfirmEmailChangeDecode) Decode(req *auth.ConfirmEmailChangeRequest) error {
+	cecd.parseReq(req)
+	return cecd.validReq()
+}
+
+func (cecd *ConfirmEmailChangeDecode) parseReq(req *auth.ConfirmEmailChangeRequest) {
+	cecd.ChangeToken = req.GetToken()
+}
+
+// validReq - check token
+func (cecd *ConfirmEmailChangeDecode) validReq() error {
+	msgErr := utils.Message{}
+	if cecd.ChangeToken = strings.TrimSpace(cecd.ChangeToken); cecd.ChangeToken == "" {
+		msgErr["token"] = ErrDeserializerEmpty
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("email change token", msgErr)
+	}
+	return nil
+}
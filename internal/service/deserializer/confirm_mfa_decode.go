@@ -0,0 +1,82 @@
+// rules for parsing the first code of authenticator app from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/totp"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type ConfirmMFADecode struct {
+	TOTPCode string
+}
+
+func NewConfirmMFADecode() *ConfirmMFADecode {
+	return &ConfirmMFADecode{}
+}
+
+func (cmd *ConfirmMFADecode) Code() string {
+	return cmd.TOTPCode
+}
+
+func (cmd *ConfirmMFADecode) Decode(req *auth.ConfirmMFARequest) error {
+	cmd.parseReq(req)
+	return cmd.validReq()
+}
+
+func (cmd *ConfirmMFADecode) parseReq(req *auth.ConfirmMFARequest) {
+	cmd.TOTPCode = req.GetCode()
+}
+
+// validReq - check code
+func (cmd *ConfirmMFADecode) validReq() error {
+	msgErr := utils.Message{}
+	cmd.TOTPCode = normalizeTOTPCode(cmd.TOTPCode)
+	if cmd.TOTPCode == "" {
+		msgErr["code"] = ErrDeserializerEmpty
+	} else if !validTOTPCode(cmd.TOTPCode) {
+		msgErr["code"] = ErrDeserializerInvalid
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("mfa confirmation", msgErr)
+	}
+	return nil
+}
+
+// normalizeTOTPCode - apps show code in groups ("123 456"), spaces are removed
+func normalizeTOTPCode(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+}
+
+// validTOTPCode - code has totp.Digits digits
+func validTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeRecoveryCode - recovery code without separators in lower case, as its hash is stored
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// validMFAProof - one of code of authenticator app or recovery code
+func validMFAProof(msgErr utils.Message, code, recoveryCode string) {
+	switch {
+	case code == "" && recoveryCode == "":
+		msgErr["code"] = ErrDeserializerEmpty
+	case code != "" && recoveryCode != "":
+		msgErr["recovery-code"] = ErrDeserializerInvalid
+	case code != "" && !validTOTPCode(code):
+		msgErr["code"] = ErrDeserializerInvalid
+	}
+}
@@ -0,0 +1,64 @@
+// rules for parsing code and new password of password reset from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type ConfirmPasswordResetDecode struct {
+	Code        string
+	NewPassword string
+}
+
+func NewConfirmPasswordResetDecode() *ConfirmPasswordResetDecode {
+	return &ConfirmPasswordResetDecode{}
+}
+
+func (cprd *ConfirmPasswordResetDecode) ResetCode() string {
+	return cprd.Code
+}
+
+func (cprd *ConfirmPasswordResetDecode) Password() string {
+	return cprd.NewPassword
+}
+
+func (cprd *ConfirmPasswordResetDecode) Decode(req *auth.ConfirmPasswordResetRequest) error {
+	cprd.parseReq(req)
+	return cprd.validReq()
+}
+
+func (cprd *ConfirmPasswordResetDecode) parseReq(req *auth.ConfirmPasswordResetRequest) {
+	cprd.Code = req.GetCode()
+	cprd.NewPassword = req.GetNewPassword()
+}
+
+// validReq - check code and password without user, rules with data of user are checked by ValidPolicy
+func (cprd *ConfirmPasswordResetDecode) validReq() error {
+	msgErr := utils.Message{}
+	if cprd.Code = strings.TrimSpace(cprd.Code); cprd.Code == "" {
+		msgErr["code"] = ErrDeserializerEmpty
+	}
+	if cprd.NewPassword = strings.TrimSpace(cprd.NewPassword); cprd.NewPassword == "" {
+		msgErr["new-password"] = ErrDeserializerEmpty
+	} else if err := password.CheckPolicy(cprd.NewPassword); err != nil {
+		msgErr["new-password"] = err
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("password reset", msgErr)
+	}
+	return nil
+}
+
+// ValidPolicy - new password is checked by the policy of passwords with login, email and names of user of the code
+func (cprd *ConfirmPasswordResetDecode) ValidPolicy(u *model.User) error {
+	if err := password.CheckPolicy(cprd.NewPassword, u.Login, u.Email, u.FirstName, u.LastName); err != nil {
+		return newValidationError("password reset", utils.Message{"new-password": err})
+	}
+	return nil
+}
@@ -4,7 +4,11 @@ package deserializer
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
 )
 
 // mark Errors to create a detailed problem object for the error response
@@ -18,3 +22,24 @@ var (
 
 // reEmail - regexp for check email from request
 var reEmail = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+// ValidationError - invalid fields of request with reasons
+// Request - name of request in message of error ("signup", "login" ...)
+// Fields - key is the name of field of proto message in kebab case (see FieldPath)
+type ValidationError struct {
+	Request string
+	Fields  utils.Message
+}
+
+func newValidationError(request string, fields utils.Message) error {
+	return &ValidationError{Request: request, Fields: fields}
+}
+
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("deserializer: invalid %s - %s", ve.Request, ve.Fields.String())
+}
+
+// FieldPath - key of ValidationError.Fields -> name of field of proto message ("first-name" -> "first_name")
+func FieldPath(key string) string {
+	return strings.ReplaceAll(key, "-", "_")
+}
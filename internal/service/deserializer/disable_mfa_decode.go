@@ -0,0 +1,49 @@
+// rules for parsing code of disabling of two-factor authentication from a request
+package deserializer
+
+import (
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type DisableMFADecode struct {
+	TOTPCode string
+	Recovery string
+}
+
+func NewDisableMFADecode() *DisableMFADecode {
+	return &DisableMFADecode{}
+}
+
+// Code - code of authenticator app, empty if RecoveryCode is set
+func (dmd *DisableMFADecode) Code() string {
+	return dmd.TOTPCode
+}
+
+// RecoveryCode - normalized recovery code, empty if Code is set
+func (dmd *DisableMFADecode) RecoveryCode() string {
+	return dmd.Recovery
+}
+
+func (dmd *DisableMFADecode) Decode(req *auth.DisableMFARequest) error {
+	dmd.parseReq(req)
+	return dmd.validReq()
+}
+
+func (dmd *DisableMFADecode) parseReq(req *auth.DisableMFARequest) {
+	dmd.TOTPCode = req.GetCode()
+	dmd.Recovery = req.GetRecoveryCode()
+}
+
+// validReq - check one of codes
+func (dmd *DisableMFADecode) validReq() error {
+	msgErr := utils.Message{}
+	dmd.TOTPCode = normalizeTOTPCode(dmd.TOTPCode)
+	dmd.Recovery = normalizeRecoveryCode(dmd.Recovery)
+	validMFAProof(msgErr, dmd.TOTPCode, dmd.Recovery)
+	if len(msgErr) > 0 {
+		return newValidationError("mfa disabling", msgErr)
+	}
+	return nil
+}
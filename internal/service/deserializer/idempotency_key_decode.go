@@ -0,0 +1,77 @@
+package deserializer
+
+import (
+	"context"
+	"unicode"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+// HeaderIdempotencyKey - key of metadata with unique value of client for a mutating request
+// retry with the same key gets response of the first request
+const HeaderIdempotencyKey = "idempotency-key"
+
+// HeaderClientID - key of metadata with opaque ID of not authorized client,
+// keys of clients with the same IP and other IDs never meet
+const HeaderClientID = "client-id"
+
+const (
+	// maxIdempotencyKeyLen - length of column 'key' of table idempotency_keys
+	maxIdempotencyKeyLen = 255
+
+	// maxClientIDLen - client ID, IP and method fit column 'scope' of table idempotency_keys
+	maxClientIDLen = 128
+)
+
+// IdempotencyKeyDecode - empty key if metadata has no "idempotency-key", then request is not idempotent
+// clientID - empty if metadata has no "client-id"
+type IdempotencyKeyDecode struct {
+	key      string
+	clientID string
+}
+
+func NewIdempotencyKeyDecode() *IdempotencyKeyDecode {
+	return &IdempotencyKeyDecode{}
+}
+
+func (ikd *IdempotencyKeyDecode) Key() string {
+	return ikd.key
+}
+
+func (ikd *IdempotencyKeyDecode) ClientID() string {
+	return ikd.clientID
+}
+
+// Decode - get key from metadata "idempotency-key", key is printable ASCII up to 255 characters
+// and "client-id" with the key, client ID is printable ASCII up to 128 characters
+func (ikd *IdempotencyKeyDecode) Decode(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(HeaderIdempotencyKey)
+	if len(values) == 0 {
+		return nil
+	}
+	key := values[0]
+	if key == "" || len(key) > maxIdempotencyKeyLen || !printableASCII(key) {
+		return newValidationError("idempotency key", utils.Message{HeaderIdempotencyKey: ErrDeserializerInvalid})
+	}
+	if values := md.Get(HeaderClientID); len(values) > 0 {
+		clientID := values[0]
+		if clientID == "" || len(clientID) > maxClientIDLen || !printableASCII(clientID) {
+			return newValidationError("client id", utils.Message{HeaderClientID: ErrDeserializerInvalid})
+		}
+		ikd.clientID = clientID
+	}
+	ikd.key = key
+	return nil
+}
+
+func printableASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package deserializer
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+// HeaderIfMatch - key of metadata with version of user (header "etag" of UserData, UserUpdate)
+// UserUpdate and UserDelete are done only if the user still has this version
+const HeaderIfMatch = "if-match"
+
+type IfMatchDecode struct {
+	version uint
+}
+
+func NewIfMatchDecode() *IfMatchDecode {
+	return &IfMatchDecode{}
+}
+
+func (imd *IfMatchDecode) Version() uint {
+	return imd.version
+}
+
+// Decode - get version from metadata "if-match", value can be quoted as etag of http ("3" or 3)
+func (imd *IfMatchDecode) Decode(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(HeaderIfMatch)
+	if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+		return newValidationError("precondition", utils.Message{"if-match": ErrDeserializerEmpty})
+	}
+	version, err := strconv.ParseUint(strings.Trim(strings.TrimSpace(values[0]), `"`), 10, 0)
+	if err != nil || version == 0 {
+		return newValidationError("precondition", utils.Message{"if-match": ErrDeserializerInvalid})
+	}
+	imd.version = uint(version)
+	return nil
+}
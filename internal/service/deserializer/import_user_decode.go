@@ -0,0 +1,112 @@
+// rules for import of users from other systems
+package deserializer
+
+import (
+	"strings"
+	"time"
+
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+// maxImportUsers - number of users in one request of import
+const maxImportUsers = 1000
+
+type ImportUsersDecode struct {
+	Users []*admin.ImportUser
+}
+
+func NewImportUsersDecode() *ImportUsersDecode {
+	return &ImportUsersDecode{}
+}
+
+// Decode - check number of users, every user is checked by ImportUserDecode
+func (iud *ImportUsersDecode) Decode(req *admin.ImportUsersRequest) error {
+	iud.Users = req.GetUsers()
+	msgErr := utils.Message{}
+	if len(iud.Users) == 0 {
+		msgErr["users"] = ErrDeserializerEmpty
+	} else if len(iud.Users) > maxImportUsers {
+		msgErr["users"] = ErrDeserializerInvalid
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("import", msgErr)
+	}
+	return nil
+}
+
+type ImportUserDecode struct {
+	Login        string
+	FirstName    string
+	LastName     string
+	Email        string
+	PasswordHash string
+	CreatedAt    time.Time
+
+	user model.User
+}
+
+func NewImportUserDecode() *ImportUserDecode {
+	return &ImportUserDecode{}
+}
+
+func (iud *ImportUserDecode) Model() *model.User {
+	return &iud.user
+}
+
+func (iud *ImportUserDecode) Decode(req *admin.ImportUser) error {
+	iud.parseReq(req)
+	if err := iud.validReq(); err != nil {
+		return err
+	}
+	iud.setUser()
+	return nil
+}
+
+func (iud *ImportUserDecode) setUser() {
+	iud.user.Login = iud.Login
+	iud.user.FirstName = iud.FirstName
+	iud.user.LastName = iud.LastName
+	iud.user.Email = iud.Email
+	iud.user.Password = iud.PasswordHash
+	iud.user.CreatedAt = iud.CreatedAt.UTC()
+}
+
+func (iud *ImportUserDecode) parseReq(req *admin.ImportUser) {
+	iud.Login = req.GetLogin()
+	iud.FirstName = req.GetFirstName()
+	iud.LastName = req.GetLastName()
+	iud.Email = req.GetEmail()
+	iud.PasswordHash = req.GetPasswordHash()
+	iud.CreatedAt = req.GetCreatedAt().AsTime()
+}
+
+// validReq - same rules as for registration, hash must be of a known algorithm
+func (iud *ImportUserDecode) validReq() error {
+	msgErr := utils.Message{}
+	if iud.Login = strings.TrimSpace(iud.Login); iud.Login == "" {
+		msgErr["login"] = ErrDeserializerEmpty
+	}
+	if iud.FirstName = strings.TrimSpace(iud.FirstName); iud.FirstName == "" {
+		msgErr["first-name"] = ErrDeserializerEmpty
+	}
+	iud.LastName = strings.TrimSpace(iud.LastName)
+	if iud.Email = strings.TrimSpace(iud.Email); !reEmail.MatchString(iud.Email) {
+		msgErr["email"] = ErrDeserializerInvalid
+	}
+	if iud.PasswordHash = strings.TrimSpace(iud.PasswordHash); iud.PasswordHash == "" {
+		msgErr["password-hash"] = ErrDeserializerEmpty
+	} else if password.ValidHash(iud.PasswordHash) != nil {
+		msgErr["password-hash"] = ErrDeserializerInvalid
+	}
+	if iud.CreatedAt.IsZero() || iud.CreatedAt.UTC().After(time.Now().UTC()) {
+		msgErr["created-at"] = ErrDeserializerInvalid
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("import user", msgErr)
+	}
+	return nil
+}
@@ -2,7 +2,6 @@
 package deserializer
 
 import (
-	"fmt"
 	"strings"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
@@ -55,7 +54,7 @@ func (ul *LoginDecode) validReq() error {
 		msgErr["password"] = ErrDeserializerEmpty
 	}
 	if len(msgErr) > 0 {
-		return fmt.Errorf("deserializer: invalid login - %s", msgErr.String())
+		return newValidationError("login", msgErr)
 	}
 	return nil
 }
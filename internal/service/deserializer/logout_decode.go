@@ -0,0 +1,26 @@
+// rules for parsing logout request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+)
+
+type LogoutDecode struct {
+	RefreshToken string
+}
+
+func NewLogoutDecode() *LogoutDecode {
+	return &LogoutDecode{}
+}
+
+// Token - refresh token from request, can be empty
+func (ld *LogoutDecode) Token() string {
+	return ld.RefreshToken
+}
+
+func (ld *LogoutDecode) Decode(req *auth.LogoutRequest) error {
+	ld.RefreshToken = strings.TrimSpace(req.GetRefreshToken())
+	return nil
+}
@@ -0,0 +1,43 @@
+// rules for parsing refresh token from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type RefreshTokenDecode struct {
+	RefreshToken string
+}
+
+func NewRefreshTokenDecode() *RefreshTokenDecode {
+	return &RefreshTokenDecode{}
+}
+
+func (rtd *RefreshTokenDecode) Token() string {
+	return rtd.RefreshToken
+}
+
+func (rtd *RefreshTokenDecode) Decode(req *auth.RefreshTokenRequest) error {
+	rtd.parseReq(req)
+	return rtd.validReq()
+}
+
+func (rtd *RefreshTokenDecode) parseReq(req *auth.RefreshTokenRequest) {
+	rtd.RefreshToken = req.GetRefreshToken()
+}
+
+// validReq - check refresh token
+func (rtd *RefreshTokenDecode) validReq() error {
+	msgErr := utils.Message{}
+	if rtd.RefreshToken = strings.TrimSpace(rtd.RefreshToken); rtd.RefreshToken == "" {
+		msgErr["refresh-token"] = ErrDeserializerEmpty
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("refresh token", msgErr)
+	}
+	return nil
+}
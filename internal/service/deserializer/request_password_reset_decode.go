@@ -0,0 +1,43 @@
+// rules for parsing email of password reset from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type RequestPasswordResetDecode struct {
+	Email string
+}
+
+func NewRequestPasswordResetDecode() *RequestPasswordResetDecode {
+	return &RequestPasswordResetDecode{}
+}
+
+func (rprd *RequestPasswordResetDecode) UserEmail() string {
+	return rprd.Email
+}
+
+func (rprd *RequestPasswordResetDecode) Decode(req *auth.RequestPasswordResetRequest) error {
+	rprd.parseReq(req)
+	return rprd.validReq()
+}
+
+func (rprd *RequestPasswordResetDecode) parseReq(req *auth.RequestPasswordResetRequest) {
+	rprd.Email = req.GetEmail()
+}
+
+// validReq - check format of email, existence of user is not shown
+func (rprd *RequestPasswordResetDecode) validReq() error {
+	msgErr := utils.Message{}
+	if rprd.Email = strings.TrimSpace(rprd.Email); !reEmail.MatchString(rprd.Email) {
+		msgErr["email"] = ErrDeserializerInvalid
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("password reset", msgErr)
+	}
+	return nil
+}
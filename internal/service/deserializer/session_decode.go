@@ -0,0 +1,34 @@
+// rules for parsing session from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type RevokeSessionDecode struct {
+	SessionID string
+}
+
+func NewRevokeSessionDecode() *RevokeSessionDecode {
+	return &RevokeSessionDecode{}
+}
+
+func (rsd *RevokeSessionDecode) ID() string {
+	return rsd.SessionID
+}
+
+func (rsd *RevokeSessionDecode) Decode(req *auth.RevokeSessionRequest) error {
+	rsd.SessionID = strings.TrimSpace(req.GetSessionId())
+	msgErr := utils.Message{}
+	if rsd.SessionID == "" {
+		msgErr["session-id"] = ErrDeserializerEmpty
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("session", msgErr)
+	}
+	return nil
+}
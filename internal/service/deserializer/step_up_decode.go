@@ -0,0 +1,41 @@
+// rules for parsing the current password of step-up from a request
+package deserializer
+
+import (
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type StepUpDecode struct {
+	CurrentPassword string
+}
+
+func NewStepUpDecode() *StepUpDecode {
+	return &StepUpDecode{}
+}
+
+func (sud *StepUpDecode) Password() string {
+	return sud.CurrentPassword
+}
+
+func (sud *StepUpDecode) Decode(req *auth.StepUpRequest) error {
+	sud.parseReq(req)
+	return sud.validReq()
+}
+
+func (sud *StepUpDecode) parseReq(req *auth.StepUpRequest) {
+	sud.CurrentPassword = req.GetPassword()
+}
+
+// validReq - check password, it is compared with hash as is (without trim)
+func (sud *StepUpDecode) validReq() error {
+	msgErr := utils.Message{}
+	if sud.CurrentPassword == "" {
+		msgErr["password"] = ErrDeserializerEmpty
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("step-up", msgErr)
+	}
+	return nil
+}
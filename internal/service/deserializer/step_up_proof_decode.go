@@ -0,0 +1,57 @@
+package deserializer
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+// keys of metadata with proof of user for sensitive changes (login, email, password, delete of user)
+// HeaderStepUpToken - token of StepUp, it lives a few minutes
+// HeaderCurrentPassword - the current password of user, if there is no step-up token
+const (
+	HeaderStepUpToken     = "x-step-up-token"
+	HeaderCurrentPassword = "x-current-password"
+)
+
+// StepUpProofDecode - both values are empty if metadata has no proof, then sensitive change is rejected
+type StepUpProofDecode struct {
+	token    string
+	password string
+}
+
+func NewStepUpProofDecode() *StepUpProofDecode {
+	return &StepUpProofDecode{}
+}
+
+func (supd *StepUpProofDecode) Token() string {
+	return supd.token
+}
+
+func (supd *StepUpProofDecode) Password() string {
+	return supd.password
+}
+
+// Decode - get the first value of every key, several values of one key are rejected
+func (supd *StepUpProofDecode) Decode(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	msgErr := utils.Message{}
+	tokens := md.Get(HeaderStepUpToken)
+	if len(tokens) > 1 {
+		msgErr[HeaderStepUpToken] = ErrDeserializerInvalid
+	} else if len(tokens) == 1 {
+		supd.token = tokens[0]
+	}
+	passwords := md.Get(HeaderCurrentPassword)
+	if len(passwords) > 1 {
+		msgErr[HeaderCurrentPassword] = ErrDeserializerInvalid
+	} else if len(passwords) == 1 {
+		supd.password = passwords[0]
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("step-up", msgErr)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package deserializer
+
+import (
+	"strings"
+
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type UnlockUserDecode struct {
+	Email string
+}
+
+func NewUnlockUserDecode() *UnlockUserDecode {
+	return &UnlockUserDecode{}
+}
+
+func (uud *UnlockUserDecode) Decode(req *admin.UnlockUserRequest) error {
+	uud.Email = strings.TrimSpace(req.GetEmail())
+	msgErr := utils.Message{}
+	if !reEmail.MatchString(uud.Email) {
+		msgErr["email"] = ErrDeserializerInvalid
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("unlock", msgErr)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package deserializer
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	user "github.com/Ekvo/go-grpc-apis/user/v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+// HeaderUpdateMask - key of metadata with google.protobuf.FieldMask of UserUpdateRequest
+// paths are names of fields of proto message separated by comma ("last_name,email")
+const HeaderUpdateMask = "x-update-mask"
+
+// UpdateMaskDecode - nil mask if metadata has no "x-update-mask", then all fields are updated
+type UpdateMaskDecode struct {
+	mask *fieldmaskpb.FieldMask
+}
+
+func NewUpdateMaskDecode() *UpdateMaskDecode {
+	return &UpdateMaskDecode{}
+}
+
+// Paths - normalized paths of mask, nil without mask
+func (umd *UpdateMaskDecode) Paths() []string {
+	return umd.mask.GetPaths()
+}
+
+// Decode - get mask from metadata "x-update-mask"
+// every path should be a field of model.UserUpdateFields, 'updated_at' is not a path of mask -
+// it is taken from request only with config.UserConfig.ClientTimestamps (see UserUpdateDecode), otherwise set by database
+func (umd *UpdateMaskDecode) Decode(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(HeaderUpdateMask)
+	if len(values) == 0 {
+		return nil
+	}
+	mask := &fieldmaskpb.FieldMask{}
+	for _, value := range values {
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				mask.Paths = append(mask.Paths, path)
+			}
+		}
+	}
+	if len(mask.Paths) == 0 || !mask.IsValid(&user.UserUpdateRequest{}) || !umd.updatable(mask) {
+		return newValidationError("update mask", utils.Message{"update-mask": ErrDeserializerInvalid})
+	}
+	mask.Normalize()
+	umd.mask = mask
+	return nil
+}
+
+func (umd *UpdateMaskDecode) updatable(mask *fieldmaskpb.FieldMask) bool {
+	for _, path := range mask.GetPaths() {
+		if !slices.Contains(model.UserUpdateFields, path) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,12 +2,12 @@
 package deserializer
 
 import (
-	"fmt"
 	"strings"
 	"time"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
 
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
 )
@@ -20,11 +20,14 @@ type UserDecode struct {
 	Password  string
 	CreatedAt time.Time
 
+	// clientTime - CreatedAt is taken from request, false - it is ignored and assigned by database
+	clientTime bool
+
 	user model.User
 }
 
-func NewUserDecode() *UserDecode {
-	return &UserDecode{}
+func NewUserDecode(clientTime bool) *UserDecode {
+	return &UserDecode{clientTime: clientTime}
 }
 
 func (ud *UserDecode) Model() *model.User {
@@ -55,10 +58,13 @@ func (ud *UserDecode) parseReq(req *user.UserRegisterRequest) {
 	ud.LastName = req.GetLastName()
 	ud.Email = req.GetEmail()
 	ud.Password = req.GetPassword()
-	ud.CreatedAt = req.GetCreatedAt().AsTime()
+	if ud.clientTime {
+		ud.CreatedAt = req.GetCreatedAt().AsTime()
+	}
 }
 
 // validReq - check critical fields for new user registration
+// password is checked by the policy of passwords with login, email and names of the user
 func (ud *UserDecode) validReq() error {
 	msgErr := utils.Message{}
 	if ud.Login = strings.TrimSpace(ud.Login); ud.Login == "" {
@@ -67,17 +73,20 @@ func (ud *UserDecode) validReq() error {
 	if ud.FirstName = strings.TrimSpace(ud.FirstName); ud.FirstName == "" {
 		msgErr["first-name"] = ErrDeserializerEmpty
 	}
+	ud.LastName = strings.TrimSpace(ud.LastName)
 	if ud.Email = strings.TrimSpace(ud.Email); !reEmail.MatchString(ud.Email) {
 		msgErr["email"] = ErrDeserializerInvalid
 	}
 	if ud.Password = strings.TrimSpace(ud.Password); ud.Password == "" {
 		msgErr["password"] = ErrDeserializerEmpty
+	} else if err := password.CheckPolicy(ud.Password, ud.Login, ud.Email, ud.FirstName, ud.LastName); err != nil {
+		msgErr["password"] = err
 	}
-	if ud.CreatedAt.IsZero() || ud.CreatedAt.UTC().After(time.Now().UTC()) {
+	if ud.clientTime && (ud.CreatedAt.IsZero() || ud.CreatedAt.UTC().After(time.Now().UTC())) {
 		msgErr["created-at"] = ErrDeserializerInvalid
 	}
 	if len(msgErr) > 0 {
-		return fmt.Errorf("deserializer: invalid signup - %s", msgErr.String())
+		return newValidationError("signup", msgErr)
 	}
 	return nil
 }
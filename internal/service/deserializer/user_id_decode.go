@@ -19,13 +19,13 @@ func (id *IDDecode) UserID() uint {
 	return uint(id.id)
 }
 
-// Decode - get user ID from context
+// Decode - get user ID from "sub" of claims in context
 func (id *IDDecode) Decode(ctx context.Context) (err error) {
-	content, ok := ctx.Value("content").(jwtsign.Content)
-	if !ok {
+	claims, ok := ctx.Value("claims").(*jwtsign.Claims)
+	if !ok || claims == nil {
 		return ErrDeserializerInvalid
 	}
-	id.id, err = strconv.ParseUint(content["user_id"], 10, 64)
+	id.id, err = strconv.ParseUint(claims.Subject, 10, 64)
 	if err != nil {
 		return err
 	}
@@ -2,12 +2,13 @@
 package deserializer
 
 import (
-	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
 
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
 )
@@ -20,11 +21,30 @@ type UserUpdateDecode struct {
 	Password  string
 	UpdatedAt time.Time
 
+	// clientTime - UpdatedAt is taken from request, false - it is ignored and assigned by database
+	clientTime bool
+
+	// mask - paths of update mask, nil - all fields, empty password keeps the old one
+	mask []string
+
 	user model.User
 }
 
-func NewUserUpdateDecode() *UserUpdateDecode {
-	return &UserUpdateDecode{}
+// NewUserUpdateDecode - mask from UpdateMaskDecode, only masked fields are checked and updated
+func NewUserUpdateDecode(clientTime bool, mask ...string) *UserUpdateDecode {
+	return &UserUpdateDecode{clientTime: clientTime, mask: mask}
+}
+
+// Fields - fields of user for update (model.UserFieldLogin ...)
+func (uud *UserUpdateDecode) Fields() []string {
+	if uud.mask != nil {
+		return uud.mask
+	}
+	fields := []string{model.UserFieldLogin, model.UserFieldFirstName, model.UserFieldLastName, model.UserFieldEmail}
+	if uud.Password != "" {
+		fields = append(fields, model.UserFieldPassword)
+	}
+	return fields
 }
 
 func (uud *UserUpdateDecode) Model() *model.User {
@@ -46,7 +66,9 @@ func (uud *UserUpdateDecode) setUser() {
 	uud.user.LastName = uud.LastName
 	uud.user.Email = uud.Email
 	uud.user.Password = uud.Password
-	uud.user.UpdatedAt = &uud.UpdatedAt
+	if uud.clientTime {
+		uud.user.UpdatedAt = &uud.UpdatedAt
+	}
 }
 
 func (uud *UserUpdateDecode) parseReq(req *user.UserUpdateRequest) {
@@ -58,25 +80,68 @@ func (uud *UserUpdateDecode) parseReq(req *user.UserUpdateRequest) {
 	uud.UpdatedAt = req.GetUpdatedAt().AsTime()
 }
 
-// validReq - check critical fields for update user data
+// validReq - check critical fields for update user data, fields out of mask are not checked
+// new password (if not empty) is checked by the policy of passwords without user, rules with data of user
+// are checked by ValidPolicy, masked password can not be empty
+// masked empty last name clears it
 func (uud *UserUpdateDecode) validReq() error {
 	msgErr := utils.Message{}
-	if uud.Login = strings.TrimSpace(uud.Login); uud.Login == "" {
+	uud.Login = strings.TrimSpace(uud.Login)
+	if uud.masked(model.UserFieldLogin) && uud.Login == "" {
 		msgErr["login"] = ErrDeserializerEmpty
 	}
-	if uud.FirstName = strings.TrimSpace(uud.FirstName); uud.FirstName == "" {
+	uud.FirstName = strings.TrimSpace(uud.FirstName)
+	if uud.masked(model.UserFieldFirstName) && uud.FirstName == "" {
 		msgErr["first-name"] = ErrDeserializerEmpty
 	}
-	if uud.Email = strings.TrimSpace(uud.Email); !reEmail.MatchString(uud.Email) {
+	uud.Email = strings.TrimSpace(uud.Email)
+	if uud.masked(model.UserFieldEmail) && !reEmail.MatchString(uud.Email) {
 		msgErr["email"] = ErrDeserializerInvalid
 	}
-	if uud.UpdatedAt.IsZero() {
+	uud.LastName = strings.TrimSpace(uud.LastName)
+	uud.Password = strings.TrimSpace(uud.Password)
+	if uud.mask != nil && uud.masked(model.UserFieldPassword) && uud.Password == "" {
+		msgErr["password"] = ErrDeserializerEmpty
+	}
+	if uud.masked(model.UserFieldPassword) && uud.Password != "" {
+		if err := password.CheckPolicy(uud.Password); err != nil {
+			msgErr["password"] = err
+		}
+	}
+	if uud.clientTime && uud.UpdatedAt.IsZero() {
 		msgErr["updated-at"] = ErrDeserializerInvalid
 	}
 	if len(msgErr) > 0 {
-		return fmt.Errorf("deserializer: invalid user update - %s", msgErr.String())
+		return newValidationError("user update", msgErr)
+	}
+	return nil
+}
+
+// ValidPolicy - new password is checked by the policy of passwords with login, email and names of user,
+// masked fields are taken from request, others from the stored user 'u'
+func (uud *UserUpdateDecode) ValidPolicy(u *model.User) error {
+	if !uud.masked(model.UserFieldPassword) || uud.Password == "" {
+		return nil
+	}
+	personal := *u
+	if uud.masked(model.UserFieldLogin) {
+		personal.Login = uud.Login
+	}
+	if uud.masked(model.UserFieldEmail) {
+		personal.Email = uud.Email
+	}
+	if uud.masked(model.UserFieldFirstName) {
+		personal.FirstName = uud.FirstName
+	}
+	if uud.masked(model.UserFieldLastName) {
+		personal.LastName = uud.LastName
+	}
+	if err := password.CheckPolicy(uud.Password, personal.Login, personal.Email, personal.FirstName, personal.LastName); err != nil {
+		return newValidationError("user update", utils.Message{"password": err})
 	}
-	uud.LastName = strings.TrimSpace(uud.LastName)
-	uud.Password = strings.TrimSpace(uud.Password)
 	return nil
 }
+
+func (uud *UserUpdateDecode) masked(field string) bool {
+	return uud.mask == nil || slices.Contains(uud.mask, field)
+}
@@ -0,0 +1,43 @@
+// rules for parsing token of email verification from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type VerifyEmailDecode struct {
+	VerifyToken string
+}
+
+func NewVerifyEmailDecode() *VerifyEmailDecode {
+	return &VerifyEmailDecode{}
+}
+
+func (ved *VerifyEmailDecode) Token() string {
+	return ved.VerifyToken
+}
+
+func (ved *VerifyEmailDecode) Decode(req *auth.VerifyEmailRequest) error {
+	ved.parseReq(req)
+	return ved.validReq()
+}
+
+func (ved *VerifyEmailDecode) parseReq(req *auth.VerifyEmailRequest) {
+	ved.VerifyToken = req.GetToken()
+}
+
+// validReq - check token
+func (ved *VerifyEmailDecode) validReq() error {
+	msgErr := utils.Message{}
+	if ved.VerifyToken = strings.TrimSpace(ved.VerifyToken); ved.VerifyToken == "" {
+		msgErr["token"] = ErrDeserializerEmpty
+	}
+	if len(msgErr) > 0 {
+		return newValidationError("verification token", msgErr)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// rules for parsing challenge token and code of two-factor login from a request
+package deserializer
+
+import (
+	"strings"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/pkg/utils"
+)
+
+type VerifyMFADecode struct {
+	ChallengeToken string
+	TOTPCode       string
+	Recovery       string
+}
+
+func NewVerifyMFADecode() *VerifyMFADecode {
+	return &VerifyMFADecode{}
+}
+
+func (vmd *VerifyMFADecode) Token() string {
+	return vmd.ChallengeToken
+}
+
+// Code - code of authenticator app, empty if RecoveryCode is set
+func (vmd *VerifyMFADecode) Code() string {
+	return vmd.TOTPCode
+}
+
+// RecoveryCode - normalized recovery code, empty if Code is set
+func (vmd *VerifyMFADecode) RecoveryCode() string {
+	return vmd.Recovery
+}
+
+func (vmd *VerifyMFADecode) Decode(req *auth.VerifyMFARequest) error {
+	vmd.parseReq(req)
+	return vmd.validReq()
+}
+
+func (vmd *VerifyMFADecode) parseReq(req *auth.VerifyMFARequest) {
+	vmd.ChallengeToken = req.GetChallengeToken()
+	vmd.TOTPCode = req.GetCode()
+	vmd.Recovery = req.GetRecoveryCode()
+}
+
+// validReq - check token and one of codes
+func (vmd *VerifyMFADecode) validReq() error {
+	msgErr := utils.Message{}
+	if vmd.ChallengeToken = strings.TrimSpace(vmd.ChallengeToken); vmd.ChallengeToken == "" {
+		msgErr["challenge-token"] = ErrDeserializerEmpty
+	}
+	vmd.TOTPCode = normalizeTOTPCode(vmd.TOTPCode)
+	vmd.Recovery = normalizeRecoveryCode(vmd.Recovery)
+	validMFAProof(msgErr, vmd.TOTPCode, vmd.Recovery)
+	if len(msgErr) > 0 {
+		return newValidationError("mfa verification", msgErr)
+	}
+	return nil
+}
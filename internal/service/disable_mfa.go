@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// DisableMFA - rules for disabling TOTP of user
+// decode code or recovery code from request
+// the current user confirms disabling (see requireStepUp)
+// in one transaction: use code, remove secret and recovery codes of user
+// wrong code -> ErrServiceMFACodeInvalid, nothing is removed
+func (s *service) DisableMFA(
+	ctx context.Context,
+	req *auth.DisableMFARequest) (*auth.DisableMFAResponse, error) {
+	deserialize := deserializer.NewDisableMFADecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+	if err := s.requireStepUp(ctx); err != nil {
+		return nil, err
+	}
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: DisableMFA IDDecode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	now := time.Now().UTC()
+	err := s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		mfa, err := tx.FindMFA(ctx, deserializeUserID.UserID())
+		if err != nil {
+			return err
+		}
+		if !mfa.Enabled() {
+			return ErrServiceMFANotEnabled
+		}
+		if err := s.useMFACode(ctx, tx, mfa, deserialize.Code(), deserialize.RecoveryCode(), now); err != nil {
+			return err
+		}
+		return tx.RemoveMFA(ctx, mfa.UserID)
+	})
+	if err != nil {
+		log.Printf("service: DisableMFA error - {%v};", err)
+		return nil, mfaError(err, ErrServiceInternal)
+	}
+
+	return &auth.DisableMFAResponse{}, nil
+}
@@ -0,0 +1,101 @@
+// contains change of email of user with confirmation by the new email
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// defaultChangeEmailTTL - value of config.UserConfig.ChangeEmailTTL if not set
+const defaultChangeEmailTTL = 24 * time.Hour
+
+// newChangeEmailTTL - TTL of config, default if not set
+func newChangeEmailTTL(cfg config.UserConfig) time.Duration {
+	if cfg.ChangeEmailTTL == 0 {
+		return defaultChangeEmailTTL
+	}
+	return cfg.ChangeEmailTTL
+}
+
+// emailChange - pending email of user with token of confirmation, mails are sent after commit of transaction
+type emailChange struct {
+	currentEmail string
+	newEmail     string
+	token        string
+}
+
+// writeEmailChange - new email is not written to user, it waits in record of token with purpose "change_email"
+// email of other user -> *AlreadyExistsError, it is checked again by unique index when the email is confirmed
+// record of the previous change is replaced, so only the last mail is valid
+func (s *service) writeEmailChange(ctx context.Context, tx db.Provider, u *model.User, email string) (*emailChange, error) {
+	other, err := tx.FindUserByEmail(ctx, email)
+	switch {
+	case err == nil && other.ID != u.ID:
+		return nil, &AlreadyExistsError{Field: "email"}
+	case errors.Is(err, db.ErrDBUnavailable), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil, err
+	}
+
+	token, jti, err := jwtsign.PurposeTokenGenerator(
+		strconv.FormatUint(uint64(u.ID), 10), model.PurposeChangeEmail, s.changeEmailTTL, nil)
+	if err != nil {
+		log.Printf("service: writeEmailChange PurposeTokenGenerator error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	now := time.Now().UTC()
+	if err := tx.CreateOneTimeToken(ctx, &model.OneTimeToken{
+		ID:        jti,
+		UserID:    u.ID,
+		Purpose:   model.PurposeChangeEmail,
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.changeEmailTTL),
+	}); err != nil {
+		return nil, err
+	}
+	return &emailChange{currentEmail: u.Email, newEmail: email, token: token}, nil
+}
+
+// sendEmailChange - token to the new email and notice to the current one
+// change is written, so mails are not a reason to reject the request, the user can repeat the change
+func (s *service) sendEmailChange(ctx context.Context, change *emailChange) {
+	serializeConfirm := serializer.ConfirmEmailChangeMailEncode{
+		To:    change.newEmail,
+		Token: change.token,
+		URL:   s.Mail.ConfirmEmailChangeURL,
+		TTL:   s.changeEmailTTL,
+	}
+	msg, err := serializeConfirm.Message()
+	if err != nil {
+		log.Printf("service: sendEmailChange ConfirmEmailChangeMailEncode error - {%v};", err)
+		return
+	}
+	if err := s.Mailer.Send(ctx, msg); err != nil {
+		log.Printf("service: sendEmailChange Send error - {%v};", err)
+		return
+	}
+
+	serializeNotice := serializer.EmailChangeNoticeMailEncode{To: change.currentEmail, NewEmail: change.newEmail}
+	if err := s.Mailer.Send(ctx, serializeNotice.Message()); err != nil {
+		log.Printf("service: sendEmailChange Send notice error - {%v};", err)
+	}
+}
+
+// emailChangeError - error of confirmation of email -> error for response
+// email taken by other user after the request -> *AlreadyExistsError, user changed concurrently -> ErrServiceVersionConflict,
+// other errors mean that token can't be used
+func emailChangeError(err error) error {
+	if errors.Is(err, db.ErrDBEmailTaken) || errors.Is(err, db.ErrDBVersionConflict) {
+		return userWriteError(err)
+	}
+	return oneTimeTokenError(err)
+}
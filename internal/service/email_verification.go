@@ -0,0 +1,83 @@
+// contains sending of signed one-time tokens for confirmation of email
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+var (
+	ErrServiceEmailVerified = errors.New("email already verified")
+
+	ErrServiceEmailNotVerified = errors.New("email not verified")
+
+	// ErrServiceTokenInvalid - one-time token is forged, expired, used or replaced by a newer one
+	ErrServiceTokenInvalid = errors.New("invalid or expired token")
+)
+
+// defaultVerifyEmailTTL - value of config.UserConfig.VerifyEmailTTL if not set
+const defaultVerifyEmailTTL = 24 * time.Hour
+
+// newVerifyEmailTTL - TTL of config, default if not set
+func newVerifyEmailTTL(cfg config.UserConfig) time.Duration {
+	if cfg.VerifyEmailTTL == 0 {
+		return defaultVerifyEmailTTL
+	}
+	return cfg.VerifyEmailTTL
+}
+
+// sendEmailVerification - sign a new token for the current email of user, write its record and send it by mail
+// record of the previous token is replaced, so only the last mail is valid
+func (s *service) sendEmailVerification(ctx context.Context, u *model.User) error {
+	token, jti, err := jwtsign.PurposeTokenGenerator(
+		strconv.FormatUint(uint64(u.ID), 10), model.PurposeVerifyEmail, s.verifyEmailTTL, nil)
+	if err != nil {
+		log.Printf("service: sendEmailVerification PurposeTokenGenerator error - {%v};", err)
+		return ErrServiceInternal
+	}
+	now := time.Now().UTC()
+	if err := s.DBProvider.CreateOneTimeToken(ctx, &model.OneTimeToken{
+		ID:        jti,
+		UserID:    u.ID,
+		Purpose:   model.PurposeVerifyEmail,
+		Email:     u.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.verifyEmailTTL),
+	}); err != nil {
+		log.Printf("service: sendEmailVerification CreateOneTimeToken error - {%v};", err)
+		return userWriteError(err)
+	}
+
+	serialize := serializer.VerifyEmailMailEncode{To: u.Email, Token: token, URL: s.Mail.VerifyEmailURL}
+	msg, err := serialize.Message()
+	if err != nil {
+		log.Printf("service: sendEmailVerification VerifyEmailMailEncode error - {%v};", err)
+		return ErrServiceInternal
+	}
+	if err := s.Mailer.Send(ctx, msg); err != nil {
+		log.Printf("service: sendEmailVerification Send error - {%v};", err)
+		return ErrServiceUnavailable
+	}
+	return nil
+}
+
+// oneTimeTokenError - error of UseOneTimeToken and of write by token -> error for response
+// lost database -> ErrServiceUnavailable, other errors mean that token can't be used
+func oneTimeTokenError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrDBUnavailable):
+		return ErrServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+	return ErrServiceTokenInvalid
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/totp"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// EnrollMFA - rules for creating TOTP secret of user
+// the current user confirms enrollment (see requireStepUp)
+// user with enabled TOTP -> ErrServiceMFAEnabled, disable it first
+// create a new secret, write it encrypted by the current key, the previous not confirmed secret is replaced
+// login needs a code only after ConfirmMFA, so the user can't be locked out by a lost secret
+func (s *service) EnrollMFA(
+	ctx context.Context,
+	_ *auth.EnrollMFARequest) (*auth.EnrollMFAResponse, error) {
+	if err := s.requireStepUp(ctx); err != nil {
+		return nil, err
+	}
+	if s.mfa.keyring == nil {
+		return nil, ErrServiceMFANotConfigured
+	}
+
+	deserialize := deserializer.NewIDDecode()
+	if err := deserialize.Decode(ctx); err != nil {
+		log.Printf("service: EnrollMFA IDDecode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	u, err := s.DBProvider.FindUserByID(ctx, deserialize.UserID())
+	if err != nil {
+		log.Printf("service: EnrollMFA FindUserByID error - {%v};", err)
+		return nil, ErrServiceNotFound
+	}
+	mfa, err := s.DBProvider.FindMFA(ctx, u.ID)
+	if err != nil {
+		log.Printf("service: EnrollMFA FindMFA error - {%v};", err)
+		return nil, mfaError(err, ErrServiceInternal)
+	}
+	if mfa.Enabled() {
+		return nil, ErrServiceMFAEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		log.Printf("service: EnrollMFA NewSecret error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	sealed, err := s.mfa.keyring.Seal(secret, secretData(u.ID))
+	if err != nil {
+		log.Printf("service: EnrollMFA Seal error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	// confirmed concurrently -> not replaced
+	if err := s.DBProvider.SaveMFA(ctx, &model.MFA{UserID: u.ID, Secret: sealed, CreatedAt: time.Now().UTC()}); err != nil {
+		log.Printf("service: EnrollMFA SaveMFA error - {%v};", err)
+		return nil, mfaError(err, ErrServiceMFAEnabled)
+	}
+
+	serialize := serializer.EnrollMFAEncode{Secret: secret, Issuer: s.mfa.issuer, Account: u.Email}
+	return serialize.Response(), nil
+}
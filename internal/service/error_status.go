@@ -0,0 +1,197 @@
+// contains mapping of errors of handlers to gRPC status
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// ErrorDomain - domain of errdetails.ErrorInfo in status of every error
+const ErrorDomain = "go-postgres-grpc-user-dir"
+
+// reasons of errdetails.ErrorInfo - stable codes for clients, message of status may change
+const (
+	ReasonInternal              = "INTERNAL"
+	ReasonNotFound              = "NOT_FOUND"
+	ReasonAlreadyExists         = "ALREADY_EXISTS"
+	ReasonAuthorizationInvalid  = "AUTHORIZATION_INVALID"
+	ReasonPasswordInvalid       = "PASSWORD_INVALID"
+	ReasonUpdateDataInvalid     = "UPDATE_DATA_INVALID"
+	ReasonValidationFailed      = "VALIDATION_FAILED"
+	ReasonLoginLocked           = "LOGIN_LOCKED"
+	ReasonRateLimited           = "RATE_LIMITED"
+	ReasonCanceled              = "CANCELED"
+	ReasonDeadlineExceeded      = "DEADLINE_EXCEEDED"
+	ReasonUnavailable           = "UNAVAILABLE"
+	ReasonVersionMismatch       = "VERSION_MISMATCH"
+	ReasonVersionConflict       = "VERSION_CONFLICT"
+	ReasonIdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+	ReasonIdempotencyInProgress = "IDEMPOTENCY_IN_PROGRESS"
+	ReasonEmailVerified         = "EMAIL_ALREADY_VERIFIED"
+	ReasonEmailNotVerified      = "EMAIL_NOT_VERIFIED"
+	ReasonTokenInvalid          = "TOKEN_INVALID"
+	ReasonStepUpRequired        = "STEP_UP_REQUIRED"
+	ReasonMFACodeInvalid        = "MFA_CODE_INVALID"
+	ReasonMFAEnabled            = "MFA_ALREADY_ENABLED"
+	ReasonMFANotEnabled         = "MFA_NOT_ENABLED"
+	ReasonMFANotConfigured      = "MFA_NOT_CONFIGURED"
+)
+
+// MetadataField - key of errdetails.ErrorInfo metadata with the field which is already taken
+const MetadataField = "field"
+
+type errorStatus struct {
+	code   codes.Code
+	reason string
+}
+
+// serviceErrors - errors of handlers and middleware with their status
+var serviceErrors = []struct {
+	err error
+	errorStatus
+}{
+	{ErrServiceInternal, errorStatus{codes.Internal, ReasonInternal}},
+	{ErrServiceNotFound, errorStatus{codes.NotFound, ReasonNotFound}},
+	{ErrServiceAlreadyExists, errorStatus{codes.AlreadyExists, ReasonAlreadyExists}},
+	{ErrServiceAuthorizationInvalid, errorStatus{codes.Unauthenticated, ReasonAuthorizationInvalid}},
+	{ErrServicePasswordInvalid, errorStatus{codes.Unauthenticated, ReasonPasswordInvalid}},
+	{ErrServiceUpdateDataInvalid, errorStatus{codes.FailedPrecondition, ReasonUpdateDataInvalid}},
+	{ErrServiceLoginLocked, errorStatus{codes.ResourceExhausted, ReasonLoginLocked}},
+	{ErrServiceRateLimited, errorStatus{codes.ResourceExhausted, ReasonRateLimited}},
+	{ErrServiceUnavailable, errorStatus{codes.Unavailable, ReasonUnavailable}},
+	{ErrServiceVersionMismatch, errorStatus{codes.FailedPrecondition, ReasonVersionMismatch}},
+	{ErrServiceVersionConflict, errorStatus{codes.Aborted, ReasonVersionConflict}},
+	{ErrServiceIdempotencyKeyReused, errorStatus{codes.InvalidArgument, ReasonIdempotencyKeyReused}},
+	{ErrServiceIdempotencyInProgress, errorStatus{codes.Aborted, ReasonIdempotencyInProgress}},
+	{ErrServiceEmailVerified, errorStatus{codes.FailedPrecondition, ReasonEmailVerified}},
+	{ErrServiceEmailNotVerified, errorStatus{codes.FailedPrecondition, ReasonEmailNotVerified}},
+	{ErrServiceTokenInvalid, errorStatus{codes.InvalidArgument, ReasonTokenInvalid}},
+	{ErrServiceStepUpRequired, errorStatus{codes.PermissionDenied, ReasonStepUpRequired}},
+	{ErrServiceMFACodeInvalid, errorStatus{codes.Unauthenticated, ReasonMFACodeInvalid}},
+	{ErrServiceMFAEnabled, errorStatus{codes.FailedPrecondition, ReasonMFAEnabled}},
+	{ErrServiceMFANotEnabled, errorStatus{codes.FailedPrecondition, ReasonMFANotEnabled}},
+	{ErrServiceMFANotConfigured, errorStatus{codes.FailedPrecondition, ReasonMFANotConfigured}},
+	{context.Canceled, errorStatus{codes.Canceled, ReasonCanceled}},
+	{context.DeadlineExceeded, errorStatus{codes.DeadlineExceeded, ReasonDeadlineExceeded}},
+}
+
+// ErrorStatus - middleware function, goes first, so errors of other middleware are mapped too
+// error of handler -> status with code and errdetails.ErrorInfo, message of error is kept
+// unknown error -> codes.Internal, its message is only logged
+func (s *service) ErrorStatus(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	next grpc.UnaryHandler) (any, error) {
+	resp, err := next(ctx, req)
+	if err != nil {
+		return nil, toStatus(err).Err()
+	}
+	return resp, nil
+}
+
+// toStatus - status of error, error which is already a status is returned as is
+func toStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	es, msg := findErrorStatus(err)
+	errorInfo := &errdetails.ErrorInfo{
+		Reason: es.reason,
+		Domain: ErrorDomain,
+	}
+	var alreadyExistsErr *AlreadyExistsError
+	if errors.As(err, &alreadyExistsErr) {
+		errorInfo.Metadata = map[string]string{MetadataField: alreadyExistsErr.Field}
+	}
+	st, errDetails := status.New(es.code, msg).WithDetails(errorInfo)
+	if errDetails != nil {
+		log.Printf("service: toStatus WithDetails error - {%v};", errDetails)
+		return status.New(es.code, msg)
+	}
+	var validationErr *deserializer.ValidationError
+	if errors.As(err, &validationErr) {
+		stBadRequest, errDetails := st.WithDetails(badRequest(validationErr))
+		if errDetails != nil {
+			log.Printf("service: toStatus WithDetails error - {%v};", errDetails)
+			return st
+		}
+		return stBadRequest
+	}
+	return st
+}
+
+// badRequest - every reason of invalid field is a violation, field is the name of field of proto message
+// reason of violation is the reason of field in upper snake case ("too short" -> "TOO_SHORT")
+func badRequest(validationErr *deserializer.ValidationError) *errdetails.BadRequest {
+	fields := make([]string, 0, len(validationErr.Fields))
+	for field := range validationErr.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	br := &errdetails.BadRequest{}
+	for _, field := range fields {
+		for _, description := range fieldReasons(validationErr.Fields[field]) {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       deserializer.FieldPath(field),
+				Description: description,
+				Reason:      strings.ToUpper(strings.ReplaceAll(description, " ", "_")),
+			})
+		}
+	}
+	return br
+}
+
+// fieldReasons - error with several reasons (password policy) gives a violation for each of them
+func fieldReasons(value any) []string {
+	err, ok := value.(error)
+	if !ok {
+		return []string{fmt.Sprint(value)}
+	}
+	if multi, ok := err.(interface{ Unwrap() []error }); ok {
+		reasons := []string{}
+		for _, reason := range multi.Unwrap() {
+			reasons = append(reasons, reason.Error())
+		}
+		return reasons
+	}
+	return []string{err.Error()}
+}
+
+func findErrorStatus(err error) (errorStatus, string) {
+	for _, serviceErr := range serviceErrors {
+		if errors.Is(err, serviceErr.err) {
+			return serviceErr.errorStatus, err.Error()
+		}
+	}
+	var validationErr *deserializer.ValidationError
+	if errors.As(err, &validationErr) {
+		return errorStatus{codes.InvalidArgument, ReasonValidationFailed}, err.Error()
+	}
+	// errors of database are classified by db.Provider and are not shown to client
+	switch {
+	case errors.Is(err, db.ErrDBNotFound):
+		return errorStatus{codes.NotFound, ReasonNotFound}, ErrServiceNotFound.Error()
+	case errors.Is(err, db.ErrDBLoginTaken), errors.Is(err, db.ErrDBEmailTaken):
+		return errorStatus{codes.AlreadyExists, ReasonAlreadyExists}, ErrServiceAlreadyExists.Error()
+	case errors.Is(err, db.ErrDBVersionConflict):
+		return errorStatus{codes.Aborted, ReasonVersionConflict}, ErrServiceVersionConflict.Error()
+	case errors.Is(err, db.ErrDBUnavailable):
+		return errorStatus{codes.Unavailable, ReasonUnavailable}, ErrServiceUnavailable.Error()
+	}
+	log.Printf("service: ErrorStatus unknown error - {%v};", err)
+	return errorStatus{codes.Internal, ReasonInternal}, ErrServiceInternal.Error()
+}
@@ -0,0 +1,247 @@
+// contains replay of responses of mutating requests with metadata "idempotency-key"
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+var (
+	ErrServiceIdempotencyKeyReused = errors.New("idempotency key is used with other request")
+
+	ErrServiceIdempotencyInProgress = errors.New("request with idempotency key is in progress, retry")
+)
+
+const (
+	// defaultIdempotencyTTL - value of config.IdempotencyConfig.TTL if not set
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	// idempotencyLease - the longest time of request in progress, it is the deadline of handler
+	idempotencyLease = time.Minute
+)
+
+// Idempotency - middleware function, goes after RateLimit
+// mutating request with metadata "idempotency-key" is done once for TTL of key
+// retry with the same key and request gets the stored response with header "idempotent-replayed: true"
+// the same key with other request -> ErrServiceIdempotencyKeyReused
+// failed request frees the key, so the request can be retried
+// key is reserved with lease till deadline of handler, after it (lost process, response is not stored)
+// the same request with the key takes the key, before it -> ErrServiceIdempotencyInProgress
+func (s *service) Idempotency(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	next grpc.UnaryHandler) (any, error) {
+	method, err := methodSuffix(info.FullMethod)
+	if err != nil {
+		log.Printf("service: Idempotency method error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	if !isMutating(method) {
+		return next(ctx, req)
+	}
+	deserialize := deserializer.NewIdempotencyKeyDecode()
+	if err := deserialize.Decode(ctx); err != nil {
+		log.Printf("service: Idempotency IdempotencyKeyDecode error - {%v};", err)
+		return nil, err
+	}
+	if deserialize.Key() == "" {
+		return next(ctx, req)
+	}
+
+	fingerprint, err := requestFingerprint(ctx, req)
+	if err != nil {
+		log.Printf("service: Idempotency requestFingerprint error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	now := time.Now().UTC()
+	key := &model.IdempotencyKey{
+		Key:         deserialize.Key(),
+		Scope:       idempotencyScope(ctx, info.FullMethod, method, deserialize.ClientID()),
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.idempotencyTTL),
+		LockedUntil: now.Add(idempotencyLease),
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(key.LockedUntil) {
+		key.LockedUntil = deadline.UTC()
+	}
+	stored, err := s.DBProvider.ReserveIdempotencyKey(ctx, key)
+	if err != nil {
+		log.Printf("service: Idempotency ReserveIdempotencyKey error - {%v};", err)
+		return nil, userWriteError(err)
+	}
+	if stored != nil {
+		return replayResponse(ctx, stored, fingerprint)
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, key.LockedUntil)
+	defer cancel()
+	stream := &recordHeaderStream{ServerTransportStream: grpc.ServerTransportStreamFromContext(ctx)}
+	if stream.ServerTransportStream != nil {
+		ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+	}
+	resp, err := next(ctx, req)
+	if err != nil {
+		if errRemove := s.DBProvider.RemoveIdempotencyKey(context.WithoutCancel(ctx), key.Key, key.Scope); errRemove != nil {
+			log.Printf("service: Idempotency RemoveIdempotencyKey error - {%v};", errRemove)
+		}
+		return nil, err
+	}
+	s.saveResponse(context.WithoutCancel(ctx), key, resp, stream.recorded())
+	return resp, nil
+}
+
+// saveResponse - response is done, it is returned even if it can't be stored
+// then the key stays in progress until its lease, retry after the lease is done again
+func (s *service) saveResponse(ctx context.Context, key *model.IdempotencyKey, resp any, header metadata.MD) {
+	message, ok := resp.(proto.Message)
+	if !ok {
+		log.Printf("service: Idempotency response is not proto - {%T};", resp)
+		return
+	}
+	packed, err := anypb.New(message)
+	if err != nil {
+		log.Printf("service: Idempotency anypb.New error - {%v};", err)
+		return
+	}
+	if key.Response, err = proto.Marshal(packed); err != nil {
+		log.Printf("service: Idempotency Marshal error - {%v};", err)
+		return
+	}
+	key.Header = header
+	if err := s.DBProvider.SaveIdempotencyResponse(ctx, key); err != nil {
+		log.Printf("service: Idempotency SaveIdempotencyResponse error - {%v};", err)
+		return
+	}
+	if err := s.DBProvider.RemoveExpiredIdempotencyKeys(ctx, key.CreatedAt); err != nil {
+		log.Printf("service: Idempotency RemoveExpiredIdempotencyKeys error - {%v};", err)
+	}
+}
+
+// replayResponse - stored response of the first request with the key
+func replayResponse(ctx context.Context, stored *model.IdempotencyKey, fingerprint string) (any, error) {
+	if stored.Fingerprint != fingerprint {
+		log.Printf("service: Idempotency key reused - {%s};", stored.Scope)
+		return nil, ErrServiceIdempotencyKeyReused
+	}
+	if stored.InProgress() {
+		return nil, ErrServiceIdempotencyInProgress
+	}
+	packed := &anypb.Any{}
+	if err := proto.Unmarshal(stored.Response, packed); err != nil {
+		log.Printf("service: Idempotency Unmarshal error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	resp, err := packed.UnmarshalNew()
+	if err != nil {
+		log.Printf("service: Idempotency UnmarshalNew error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	serialize := serializer.IdempotentReplayEncode{Stored: stored.Header}
+	if err := grpc.SetHeader(ctx, serialize.Header()); err != nil {
+		log.Printf("service: Idempotency SetHeader error - {%v};", err)
+	}
+	return resp, nil
+}
+
+// newIdempotencyTTL - TTL of config, default if not set
+func newIdempotencyTTL(cfg config.IdempotencyConfig) time.Duration {
+	if cfg.TTL == 0 {
+		return defaultIdempotencyTTL
+	}
+	return cfg.TTL
+}
+
+// isMutating - return true if method changes data and its response can be replayed
+// methods which issue tokens or secrets are not replayed, a token is not stored in database
+func isMutating(method string) bool {
+	switch method {
+	case "UserRegister", "UserUpdate", "UserDelete", "Logout", "LogoutAll",
+		"RevokeSession", "RevokeOtherSessions", "ImportUsers", "UnlockUser",
+		"SendEmailVerification", "VerifyEmail", "RequestPasswordReset", "ConfirmPasswordReset",
+		"ConfirmEmailChange", "DisableMFA":
+		return true
+	}
+	return false
+}
+
+// idempotencyScope - method with client, keys of other users never meet
+// client is ID of authorized user, "admin" for AdminService,
+// not authorized client (UserRegister, RequestPasswordReset, ...) is its IP with "client-id" of metadata
+func idempotencyScope(ctx context.Context, fullMethod, method, clientID string) string {
+	if isAdmin(fullMethod) {
+		return method + " admin"
+	}
+	if claims, ok := ctx.Value("claims").(*jwtsign.Claims); ok {
+		return method + " " + claims.Subject
+	}
+	return method + " anonymous " + clientIP(ctx) + " " + clientID
+}
+
+// requestFingerprint - sha256 of request with metadata which changes result of request
+func requestFingerprint(ctx context.Context, req any) (string, error) {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return "", ErrServiceInternal
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write(data)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range []string{deserializer.HeaderIfMatch, deserializer.HeaderUpdateMask} {
+		for _, value := range md.Get(header) {
+			hash.Write([]byte("\x00" + header + "=" + value))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// recordHeaderStream - header of handler is sent to client and kept for replay
+type recordHeaderStream struct {
+	grpc.ServerTransportStream
+
+	mu     sync.Mutex
+	header metadata.MD
+}
+
+func (rhs *recordHeaderStream) SetHeader(md metadata.MD) error {
+	rhs.record(md)
+	return rhs.ServerTransportStream.SetHeader(md)
+}
+
+func (rhs *recordHeaderStream) SendHeader(md metadata.MD) error {
+	rhs.record(md)
+	return rhs.ServerTransportStream.SendHeader(md)
+}
+
+func (rhs *recordHeaderStream) record(md metadata.MD) {
+	rhs.mu.Lock()
+	defer rhs.mu.Unlock()
+	rhs.header = metadata.Join(rhs.header, md)
+}
+
+func (rhs *recordHeaderStream) recorded() metadata.MD {
+	rhs.mu.Lock()
+	defer rhs.mu.Unlock()
+	return rhs.header.Copy()
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// ImportUsers - rules for import of users from other systems in Admin Service
+// decode every user from the request
+// write the user with the hash as is, hash of other algorithm is replaced on the first login
+// a bad user does not stop the import, its index and reason are returned
+func (s *service) ImportUsers(
+	ctx context.Context,
+	req *admin.ImportUsersRequest) (*admin.ImportUsersResponse, error) {
+	deserialize := deserializer.NewImportUsersDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	resp := &admin.ImportUsersResponse{}
+	for i, importUser := range deserialize.Users {
+		deserializeUser := deserializer.NewImportUserDecode()
+		if err := deserializeUser.Decode(importUser); err != nil {
+			resp.Errors = append(resp.Errors, &admin.ImportUserError{Index: uint32(i), Message: err.Error()})
+			continue
+		}
+		if _, err := s.DBProvider.CreateUser(ctx, deserializeUser.Model()); err != nil {
+			log.Printf("service: ImportUsers CreateUser - error {%v};", err)
+			resp.Errors = append(resp.Errors, &admin.ImportUserError{
+				Index:   uint32(i),
+				Message: userWriteError(err).Error(),
+			})
+			continue
+		}
+		resp.Imported++
+	}
+
+	return resp, nil
+}
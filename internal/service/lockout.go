@@ -0,0 +1,133 @@
+// contains protection against guessing of passwords
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// ErrServiceLoginLocked - same answer for existing and not existing email
+var ErrServiceLoginLocked = errors.New("too many failed logins, try later")
+
+// default values of config.LoginConfig
+const (
+	defaultMaxFailures  = 5
+	defaultLockDuration = 15 * time.Minute
+	defaultBackoff      = time.Second
+)
+
+// lockoutPolicy - failed logins in a row are delayed with doubled back-off, then email is locked
+type lockoutPolicy struct {
+	maxFailures  int
+	lockDuration time.Duration
+	backoff      time.Duration
+}
+
+func newLockoutPolicy(cfg config.LoginConfig) lockoutPolicy {
+	lp := lockoutPolicy{
+		maxFailures:  int(cfg.MaxFailures),
+		lockDuration: cfg.LockDuration,
+		backoff:      cfg.Backoff,
+	}
+	if lp.maxFailures == 0 {
+		lp.maxFailures = defaultMaxFailures
+	}
+	if lp.lockDuration == 0 {
+		lp.lockDuration = defaultLockDuration
+	}
+	if lp.backoff == 0 {
+		lp.backoff = defaultBackoff
+	}
+	return lp
+}
+
+// retryAt - next login with email is allowed from this time
+// the first failure is without delay (typo), then back-off grows up to the time of lock
+func (lp lockoutPolicy) retryAt(attempt *model.LoginAttempt) time.Time {
+	retry := time.Time{}
+	if attempt.LockedUntil != nil {
+		retry = *attempt.LockedUntil
+	}
+	if attempt.FailedCount > 1 {
+		delay := lp.lockDuration
+		if shift := attempt.FailedCount - 2; shift < 32 {
+			delay = min(lp.backoff<<shift, lp.lockDuration)
+		}
+		if backoffEnd := attempt.LastFailedAt.Add(delay); backoffEnd.After(retry) {
+			retry = backoffEnd
+		}
+	}
+	return retry
+}
+
+// foldEmail - case of email is not significant, failures are counted and user is found by the folded email
+func foldEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+// reserveLogin - reject login while email is locked or failed logins are delayed (attempts in this time are not counted),
+// then count the attempt as failed before the password is checked in one statement (AddLoginFailure),
+// so parallel logins can't pass 'maxFailures' all, attempt after 'maxFailures' -> ErrServiceLoginLocked
+// reserved attempt is finished by loginFailed, loginSucceeded or loginUndone
+func (s *service) reserveLogin(ctx context.Context, email string, now time.Time) (*model.LoginAttempt, error) {
+	attempt, err := s.DBProvider.FindLoginAttempt(ctx, email)
+	if err != nil {
+		log.Printf("service: reserveLogin FindLoginAttempt error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	if now.Before(s.lockout.retryAt(attempt)) {
+		log.Printf("service: reserveLogin login is delayed - {%d failures};", attempt.FailedCount)
+		return nil, ErrServiceLoginLocked
+	}
+	attempt, err = s.DBProvider.AddLoginFailure(ctx, email, now, now.Add(-s.lockout.lockDuration))
+	if err != nil {
+		log.Printf("service: reserveLogin AddLoginFailure error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	if attempt.FailedCount > s.lockout.maxFailures || (attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil)) {
+		log.Printf("service: reserveLogin login is locked - {%d failures};", attempt.FailedCount)
+		if attempt.LockedUntil == nil {
+			s.lockLogin(ctx, email, now)
+		}
+		return nil, ErrServiceLoginLocked
+	}
+	return attempt, nil
+}
+
+// loginFailed - reserved attempt is failed, lock email after 'maxFailures' in a row
+func (s *service) loginFailed(ctx context.Context, attempt *model.LoginAttempt, now time.Time) {
+	if attempt.FailedCount >= s.lockout.maxFailures {
+		log.Printf("service: loginFailed lock login - {%d failures};", attempt.FailedCount)
+		s.lockLogin(ctx, attempt.Email, now)
+	}
+	if err := s.DBProvider.RemoveExpiredLoginAttempts(ctx, now.Add(-s.lockout.lockDuration), now); err != nil {
+		log.Printf("service: loginFailed RemoveExpiredLoginAttempts error - {%v};", err)
+	}
+}
+
+// lockLogin - reject logins with email for 'lockDuration'
+func (s *service) lockLogin(ctx context.Context, email string, now time.Time) {
+	if err := s.DBProvider.LockLogin(ctx, email, now.Add(s.lockout.lockDuration)); err != nil {
+		log.Printf("service: lockLogin LockLogin error - {%v};", err)
+	}
+}
+
+// loginSucceeded - forget failures of email with the reserved attempt
+func (s *service) loginSucceeded(ctx context.Context, attempt *model.LoginAttempt) {
+	if err := s.DBProvider.RemoveLoginAttempts(ctx, attempt.Email); err != nil {
+		log.Printf("service: loginSucceeded RemoveLoginAttempts error - {%v};", err)
+	}
+}
+
+// loginUndone - reserved attempt is not a failure, but failures of email are kept (password of user with TOTP)
+func (s *service) loginUndone(ctx context.Context, attempt *model.LoginAttempt) {
+	if err := s.DBProvider.RemoveLoginFailure(ctx, attempt.Email); err != nil {
+		log.Printf("service: loginUndone RemoveLoginFailure error - {%v};", err)
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// Logout - rules for logout from the current session
+// decode claims of the current token and user ID from ctx
+// revoke the current access token and its session
+// refresh token from request belongs to the user -> revoke its family
+func (s *service) Logout(
+	ctx context.Context,
+	req *auth.LogoutRequest) (*auth.LogoutResponse, error) {
+	deserializeReq := deserializer.NewLogoutDecode()
+	if err := deserializeReq.Decode(req); err != nil {
+		return nil, err
+	}
+
+	deserializeClaims := deserializer.NewClaimsDecode()
+	if err := deserializeClaims.Decode(ctx); err != nil {
+		log.Printf("service: Logout Decode claims error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: Logout Decode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	userID := deserializeUserID.UserID()
+
+	claims := deserializeClaims.Claims()
+	if err := s.revocation.revokeToken(ctx, userID, claims); err != nil {
+		log.Printf("service: Logout revokeToken error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	if sessionID := claims.SessionID(); sessionID != "" {
+		now := time.Now().UTC()
+		if err := s.DBProvider.RevokeSession(ctx, userID, sessionID, now); err != nil {
+			log.Printf("service: Logout RevokeSession error - {%v};", err)
+		}
+		if err := s.revokeSessions(ctx, userID, []string{sessionID}, now); err != nil {
+			return nil, ErrServiceInternal
+		}
+	}
+
+	if refreshToken := deserializeReq.Token(); refreshToken != "" {
+		token, err := s.DBProvider.FindRefreshToken(ctx, randtoken.Hash(refreshToken))
+		if err != nil || token.UserID != userID {
+			log.Printf("service: Logout refresh token of user not found - {%v};", err)
+			return &auth.LogoutResponse{}, nil
+		}
+		if err := s.DBProvider.RevokeRefreshTokenFamily(ctx, token.FamilyID, time.Now().UTC()); err != nil {
+			log.Printf("service: Logout RevokeRefreshTokenFamily error - {%v};", err)
+			return nil, ErrServiceInternal
+		}
+	}
+
+	return &auth.LogoutResponse{}, nil
+}
+
+// LogoutAll - rules for logout from all devices
+// decode user ID from ctx
+// set 'tokens_valid_after' of user -> all issued access tokens are invalid
+// revoke all refresh tokens of user
+func (s *service) LogoutAll(
+	ctx context.Context,
+	_ *auth.LogoutAllRequest) (*auth.LogoutAllResponse, error) {
+	deserialize := deserializer.NewIDDecode()
+	if err := deserialize.Decode(ctx); err != nil {
+		log.Printf("service: LogoutAll Decode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	userID := deserialize.UserID()
+
+	if err := s.revokeAllTokens(ctx, userID); err != nil {
+		return nil, ErrServiceInternal
+	}
+
+	return &auth.LogoutAllResponse{}, nil
+}
+
+// revokeAllTokens - invalidate every session, access and refresh token of user issued until now
+// "iat" of access token has precision of milliseconds
+func (s *service) revokeAllTokens(ctx context.Context, userID uint) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	sessionIDs, err := writeAllTokensRevoked(ctx, s.DBProvider, userID, now)
+	s.forgetAllTokens(userID, sessionIDs, now)
+	return err
+}
+
+// writeAllTokensRevoked - database part of revokeAllTokens, 'provider' can be a provider of transaction
+// return IDs of revoked sessions for forgetAllTokens
+func writeAllTokensRevoked(ctx context.Context, provider db.Provider, userID uint, now time.Time) ([]string, error) {
+	if err := provider.SetTokensValidAfter(ctx, userID, now); err != nil {
+		log.Printf("service: revokeAllTokens SetTokensValidAfter error - {%v};", err)
+		return nil, err
+	}
+
+	sessionIDs, err := provider.RevokeSessionsByUserID(ctx, userID, "", now)
+	if err != nil {
+		log.Printf("service: revokeAllTokens RevokeSessionsByUserID error - {%v};", err)
+		return nil, err
+	}
+
+	if err := provider.RevokeRefreshTokensByUserID(ctx, userID, now); err != nil {
+		log.Printf("service: revokeAllTokens RevokeRefreshTokensByUserID error - {%v};", err)
+		return sessionIDs, err
+	}
+	return sessionIDs, nil
+}
+
+// forgetAllTokens - cache of revocation after writeAllTokensRevoked, the next check of user reads the database
+func (s *service) forgetAllTokens(userID uint, sessionIDs []string, now time.Time) {
+	s.revocation.forgetUser(userID)
+	s.revocation.markSessionsRevoked(userID, sessionIDs, now)
+}
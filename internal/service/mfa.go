@@ -0,0 +1,177 @@
+// contains two-factor authentication of user with TOTP and recovery codes
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/seal"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/totp"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// errors of two-factor authentication
+var (
+	ErrServiceMFACodeInvalid = errors.New("invalid mfa code")
+
+	ErrServiceMFAEnabled = errors.New("mfa already enabled")
+
+	ErrServiceMFANotEnabled = errors.New("mfa not enabled")
+
+	ErrServiceMFANotConfigured = errors.New("mfa not configured")
+)
+
+const (
+	// defaultMFAChallengeTTL - value of config.MFAConfig.ChallengeTTL if not set
+	defaultMFAChallengeTTL = 5 * time.Minute
+
+	// defaultMFAIssuer - value of config.MFAConfig.Issuer if not set
+	defaultMFAIssuer = "go-postgres-grpc-user-dir"
+
+	// defaultRecoveryCodes - value of config.MFAConfig.RecoveryCodes if not set
+	defaultRecoveryCodes = 10
+
+	// recoveryCodeSize - random bytes of recovery code, hex of them is 16 characters
+	recoveryCodeSize = 8
+)
+
+// mfaPolicy - values of config.MFAConfig, keyring is nil without keys of encryption
+type mfaPolicy struct {
+	keyring       *seal.Keyring
+	issuer        string
+	challengeTTL  time.Duration
+	recoveryCodes int
+}
+
+// newMFAPolicy - values of config, default if not set
+// invalid keys or unknown current key -> error of seal.New, service is not started
+func newMFAPolicy(cfg config.MFAConfig) (mfaPolicy, error) {
+	policy := mfaPolicy{
+		issuer:        cfg.Issuer,
+		challengeTTL:  cfg.ChallengeTTL,
+		recoveryCodes: int(cfg.RecoveryCodes),
+	}
+	if len(cfg.EncryptionKeys) > 0 {
+		keyring, err := seal.New(cfg.EncryptionKeys, cfg.CurrentKeyID)
+		if err != nil {
+			return mfaPolicy{}, err
+		}
+		policy.keyring = keyring
+	}
+	if policy.issuer == "" {
+		policy.issuer = defaultMFAIssuer
+	}
+	if policy.challengeTTL == 0 {
+		policy.challengeTTL = defaultMFAChallengeTTL
+	}
+	if policy.recoveryCodes == 0 {
+		policy.recoveryCodes = defaultRecoveryCodes
+	}
+	return policy, nil
+}
+
+// secretData - additional data of sealed secret, secret of one user can't be opened as secret of other user
+func secretData(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
+
+// openSecret - plain secret of enrolled user
+func (s *service) openSecret(mfa *model.MFA) ([]byte, error) {
+	if s.mfa.keyring == nil {
+		return nil, ErrServiceMFANotConfigured
+	}
+	secret, err := s.mfa.keyring.Open(mfa.Secret, secretData(mfa.UserID))
+	if err != nil {
+		log.Printf("service: openSecret Open error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	return secret, nil
+}
+
+// useMFACode - accept code of authenticator app or recovery code of user with enabled TOTP, each of them is accepted once
+// wrong, used or replayed code -> ErrServiceMFACodeInvalid
+func (s *service) useMFACode(ctx context.Context, tx db.Provider, mfa *model.MFA, code, recoveryCode string, now time.Time) error {
+	if recoveryCode != "" {
+		return mfaCodeError(tx.UseRecoveryCode(ctx, mfa.UserID, randtoken.Hash(recoveryCode)))
+	}
+	secret, err := s.openSecret(mfa)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, code, now)
+	if !ok {
+		return ErrServiceMFACodeInvalid
+	}
+	return mfaCodeError(tx.UseMFAStep(ctx, mfa.UserID, step))
+}
+
+// newRecoveryCodes - codes in hex for response and their hashes for store
+func newRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+	buf := make([]byte, recoveryCodeSize)
+	for range n {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(buf)
+		codes = append(codes, code)
+		hashes = append(hashes, randtoken.Hash(code))
+	}
+	return codes, hashes, nil
+}
+
+// createMFAChallenge - token of the second step of login, its record keeps email of login
+// record of the previous challenge is replaced, so only the last login can be finished
+func (s *service) createMFAChallenge(ctx context.Context, u *model.User) (string, error) {
+	token, jti, err := jwtsign.PurposeTokenGenerator(
+		strconv.FormatUint(uint64(u.ID), 10), model.PurposeMFAChallenge, s.mfa.challengeTTL, nil)
+	if err != nil {
+		log.Printf("service: createMFAChallenge PurposeTokenGenerator error - {%v};", err)
+		return "", ErrServiceInternal
+	}
+	now := time.Now().UTC()
+	if err := s.DBProvider.CreateOneTimeToken(ctx, &model.OneTimeToken{
+		ID:        jti,
+		UserID:    u.ID,
+		Purpose:   model.PurposeMFAChallenge,
+		Email:     u.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.mfa.challengeTTL),
+	}); err != nil {
+		log.Printf("service: createMFAChallenge CreateOneTimeToken error - {%v};", err)
+		return "", mfaError(err, ErrServiceInternal)
+	}
+	return token, nil
+}
+
+// mfaCodeError - error of store for code -> ErrServiceMFACodeInvalid, lost database and context errors are kept
+func mfaCodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return mfaError(err, ErrServiceMFACodeInvalid)
+}
+
+// mfaError - lost database -> ErrServiceUnavailable, errors of service and context are kept, other errors -> errDefault
+func mfaError(err, errDefault error) error {
+	switch {
+	case errors.Is(err, db.ErrDBUnavailable):
+		return ErrServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrServiceMFACodeInvalid), errors.Is(err, ErrServiceMFAEnabled),
+		errors.Is(err, ErrServiceMFANotEnabled), errors.Is(err, ErrServiceMFANotConfigured),
+		errors.Is(err, ErrServiceInternal), errors.Is(err, ErrServiceTokenInvalid):
+		return err
+	}
+	return errDefault
+}
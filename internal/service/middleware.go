@@ -3,28 +3,52 @@ package service
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"log"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
 )
 
-var ErrServiceMethodInvalid = errors.New("invalid method")
+var (
+	ErrServiceMethodInvalid = errors.New("invalid method")
+
+	ErrServiceAdminDisabled = errors.New("admin API is disabled")
+
+	ErrServiceAdminKeyInvalid = errors.New("invalid admin key")
+)
 
 // Authorization - middleware function
 // check method
-// 1. without auth -> next(ctx, req)
-// 2. otherwise check the bearer token -> next(ctx, req)
-func Authorization(
+// IP of client with too many rejected authorizations is rejected before the check (see checkAuthFailures)
+// 1. method of AdminService -> check the admin key -> next(ctx, req)
+// 2. without auth -> next(ctx, req)
+// 3. otherwise check the bearer token and its revocation -> next(ctx, req)
+// token of sliding session is renewed in response header when less than half of its life is left
+func (s *service) Authorization(
 	ctx context.Context,
 	req any,
 	info *grpc.UnaryServerInfo,
 	next grpc.UnaryHandler) (resp any, err error) {
 	log.Printf("service: request received for method - {%s};", info.FullMethod)
+	if isAdmin(info.FullMethod) {
+		if err := s.checkAuthFailures(ctx); err != nil {
+			return nil, err
+		}
+		if err := s.adminAuthorization(ctx); err != nil {
+			log.Printf("service: Authorization admin key error - {%v};", err)
+			return nil, s.authFailed(ctx)
+		}
+		return next(ctx, req)
+	}
 	method, err := methodSuffix(info.FullMethod)
 	if err != nil {
 		log.Printf("service: Authorization method error - {%v};", err)
@@ -33,26 +57,80 @@ func Authorization(
 	if !isAuth(method) {
 		return next(ctx, req)
 	}
+	if err := s.checkAuthFailures(ctx); err != nil {
+		return nil, err
+	}
 
 	deserialize := deserializer.NewTokenDecode()
 	if err := deserialize.Decode(ctx); err != nil {
 		log.Printf("service: Authorization token error - {%v};", err)
-		return nil, ErrServiceAuthorizationInvalid
+		return nil, s.authFailed(ctx)
 	}
 
-	content, err := jwtsign.GetContentFromToken(deserialize.Token())
+	claims, err := jwtsign.GetClaimsFromToken(deserialize.Token())
 	if err != nil {
 		log.Printf("service: parse token error - {%v};", err)
-		return nil, ErrServiceAuthorizationInvalid
+		return nil, s.authFailed(ctx)
+	}
+	ctx = context.WithValue(ctx, "content", claims.Content)
+	ctx = context.WithValue(ctx, "claims", claims)
+
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: Authorization user ID error - {%v};", err)
+		return nil, s.authFailed(ctx)
+	}
+	if err := s.revocation.check(ctx, deserializeUserID.UserID(), claims); err != nil {
+		log.Printf("service: Authorization revocation check error - {%v};", err)
+		return nil, s.authFailed(ctx)
+	}
+	if jwtsign.SessionLifetime().NeedRenew(time.Now().UTC(), claims) {
+		renewToken(ctx, claims)
 	}
-	ctx = context.WithValue(ctx, "content", content)
 
 	return next(ctx, req)
 }
 
+// renewToken - sliding session, set a new access token to response header
+// request is not rejected if the token can't be renewed, the current token is still valid
+func renewToken(ctx context.Context, claims *jwtsign.Claims) {
+	serialize := serializer.RenewedTokenEncode{Claims: claims}
+	header, err := serialize.Header()
+	if err != nil {
+		log.Printf("service: renewToken RenewedTokenEncode error - {%v};", err)
+		return
+	}
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		log.Printf("service: renewToken SetHeader error - {%v};", err)
+	}
+}
+
+// adminAuthorization - compare key from metadata with the configured key
+func (s *service) adminAuthorization(ctx context.Context) error {
+	if s.AdminKey == "" {
+		return ErrServiceAdminDisabled
+	}
+	deserialize := deserializer.NewAdminKeyDecode()
+	if err := deserialize.Decode(ctx); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(deserialize.Key()), []byte(s.AdminKey)) != 1 {
+		return ErrServiceAdminKeyInvalid
+	}
+	return nil
+}
+
+// isAdmin - return true if method of AdminService
+func isAdmin(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+admin.AdminService_ServiceDesc.ServiceName+"/")
+}
+
 // isAuth - return true if method with Authorization
 func isAuth(method string) bool {
-	if method == "UserData" || method == "UserUpdate" || method == "UserDelete" {
+	switch method {
+	case "UserData", "UserUpdate", "UserDelete", "Logout", "LogoutAll",
+		"ListSessions", "RevokeSession", "RevokeOtherSessions", "SendEmailVerification", "StepUp",
+		"EnrollMFA", "ConfirmMFA", "DisableMFA":
 		return true
 	}
 	return false
@@ -0,0 +1,58 @@
+// contains sending of one-time codes for reset of forgotten password
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// defaultResetPasswordTTL - value of config.UserConfig.ResetPasswordTTL if not set
+const defaultResetPasswordTTL = time.Hour
+
+// newResetPasswordTTL - TTL of config, default if not set
+func newResetPasswordTTL(cfg config.UserConfig) time.Duration {
+	if cfg.ResetPasswordTTL == 0 {
+		return defaultResetPasswordTTL
+	}
+	return cfg.ResetPasswordTTL
+}
+
+// sendPasswordReset - create opaque code for the current email of user, write its hash and send the code by mail
+// record of the previous code is replaced, so only the last mail is valid
+func (s *service) sendPasswordReset(ctx context.Context, u *model.User) error {
+	code, err := randtoken.New()
+	if err != nil {
+		log.Printf("service: sendPasswordReset randtoken.New error - {%v};", err)
+		return err
+	}
+	now := time.Now().UTC()
+	if err := s.DBProvider.CreateOneTimeToken(ctx, &model.OneTimeToken{
+		ID:        randtoken.Hash(code),
+		UserID:    u.ID,
+		Purpose:   model.PurposeResetPassword,
+		Email:     u.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.resetPasswordTTL),
+	}); err != nil {
+		log.Printf("service: sendPasswordReset CreateOneTimeToken error - {%v};", err)
+		return err
+	}
+
+	serialize := serializer.ResetPasswordMailEncode{To: u.Email, Code: code, URL: s.Mail.ResetPasswordURL, TTL: s.resetPasswordTTL}
+	msg, err := serialize.Message()
+	if err != nil {
+		log.Printf("service: sendPasswordReset ResetPasswordMailEncode error - {%v};", err)
+		return err
+	}
+	if err := s.Mailer.Send(ctx, msg); err != nil {
+		log.Printf("service: sendPasswordReset Send error - {%v};", err)
+		return err
+	}
+	return nil
+}
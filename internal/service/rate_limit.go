@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/ratelimit"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+var ErrServiceRateLimited = errors.New("too many requests")
+
+// RateLimit - middleware function, goes after Authorization, so limits of methods count only authorized requests
+// client is IP of peer with ID of user for methods with authorization
+// rejected authorization is limited per IP by Authorization itself (see checkAuthFailures)
+// request over the limit of method is rejected, seconds to wait are in trailer "retry-after"
+func (s *service) RateLimit(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	next grpc.UnaryHandler) (any, error) {
+	method, err := methodSuffix(info.FullMethod)
+	if err != nil {
+		log.Printf("service: RateLimit method error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	client := clientIP(ctx)
+	if claims, ok := ctx.Value("claims").(*jwtsign.Claims); ok {
+		client += " " + claims.Subject
+	}
+
+	allowed, wait := s.limiter.Allow(method, client, time.Now())
+	if !allowed {
+		log.Printf("service: RateLimit limit exceeded - {%s};", method)
+		return nil, rateLimited(ctx, wait)
+	}
+
+	return next(ctx, req)
+}
+
+// checkAuthFailures - called by Authorization before the admin key or token is checked
+// IP of client after too many rejected authorizations is rejected without check,
+// so a right guess of key or token is not seen until the bucket is refilled
+func (s *service) checkAuthFailures(ctx context.Context) error {
+	exhausted, wait := s.limiter.Exhausted(ratelimit.AuthFailure, clientIP(ctx), time.Now())
+	if exhausted {
+		log.Printf("service: checkAuthFailures limit exceeded - {%s};", ratelimit.AuthFailure)
+		return rateLimited(ctx, wait)
+	}
+	return nil
+}
+
+// authFailed - count rejected authorization of IP of client, returns error for response
+func (s *service) authFailed(ctx context.Context) error {
+	s.limiter.Allow(ratelimit.AuthFailure, clientIP(ctx), time.Now())
+	return ErrServiceAuthorizationInvalid
+}
+
+func clientIP(ctx context.Context) string {
+	deserialize := deserializer.NewClientDecode()
+	deserialize.Decode(ctx)
+	return deserialize.ClientIP
+}
+
+// rateLimited - seconds to wait go to trailer "retry-after"
+func rateLimited(ctx context.Context, wait time.Duration) error {
+	serialize := serializer.RetryAfterEncode{Wait: wait}
+	if err := grpc.SetTrailer(ctx, serialize.Trailer()); err != nil {
+		log.Printf("service: rateLimited SetTrailer error - {%v};", err)
+	}
+	return ErrServiceRateLimited
+}
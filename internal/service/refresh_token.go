@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// RefreshToken - rules for rotation of refresh token
+// decode refresh token from request
+// spend the token in database, if the token can't be spent -> check reuse
+// session of the token must be active
+// create a new refresh token in the same family and a new access token, the session keeps time of login
+// the token is spent and the next one is written in one transaction, failed write keeps the token for retry
+func (s *service) RefreshToken(
+	ctx context.Context,
+	req *auth.RefreshTokenRequest) (*auth.RefreshTokenResponse, error) {
+	deserialize := deserializer.NewRefreshTokenDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	tokenHash := randtoken.Hash(deserialize.Token())
+	var (
+		oldToken     *model.RefreshToken
+		refreshToken string
+	)
+	err := s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		token, err := tx.UseRefreshToken(ctx, tokenHash, now)
+		if err != nil {
+			log.Printf("service: RefreshToken UseRefreshToken error - {%v};", err)
+			return ErrServiceAuthorizationInvalid
+		}
+		oldToken = token
+		if err := s.resumeSession(ctx, token); err != nil {
+			log.Printf("service: RefreshToken resumeSession error - {%v};", err)
+			return err
+		}
+		refreshToken, err = s.issueRefreshToken(ctx, tx, token.UserID, token.FamilyID, token.AuthTime)
+		return err
+	})
+	switch {
+	case err != nil && oldToken == nil:
+		// reuse is checked after rollback, so revocation of the family is kept
+		s.revokeReusedRefreshToken(ctx, tokenHash, now)
+		return nil, ErrServiceAuthorizationInvalid
+	case errors.Is(err, jwtsign.ErrJWTSessionExpired):
+		return nil, ErrServiceAuthorizationInvalid
+	case err != nil:
+		log.Printf("service: RefreshToken error - {%v};", err)
+		return nil, sessionError(err)
+	}
+
+	serialize := serializer.RefreshTokenEncode{ID: oldToken.UserID, SessionID: oldToken.FamilyID, AuthTime: oldToken.AuthTime, RefreshToken: refreshToken}
+	refreshTokenResponse, err := serialize.Response()
+	if err != nil {
+		log.Printf("service: RefreshToken RefreshTokenEncode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	return refreshTokenResponse, nil
+}
+
+// revokeReusedRefreshToken - an already spent token was presented again
+// one of the holders is not the owner, so the whole family is revoked
+func (s *service) revokeReusedRefreshToken(ctx context.Context, tokenHash string, now time.Time) {
+	token, err := s.DBProvider.FindRefreshToken(ctx, tokenHash)
+	if err != nil || !token.Reused() {
+		return
+	}
+	log.Printf("service: refresh token error - {%v}; user_id - {%d};", model.ErrModelRefreshTokenReused, token.UserID)
+	if err := s.DBProvider.RevokeRefreshTokenFamily(ctx, token.FamilyID, now); err != nil {
+		log.Printf("service: RevokeRefreshTokenFamily error - {%v};", err)
+	}
+}
+
+// issueRefreshToken - create refresh token, write hash of the token to store (database or transaction)
+// token lives idle timeout, but not longer than the session started at authTime
+// return token for response
+func (s *service) issueRefreshToken(ctx context.Context, store db.Provider, userID uint, familyID string, authTime time.Time) (string, error) {
+	now := time.Now().UTC()
+	expiresAt, err := jwtsign.SessionLifetime().RefreshExpiresAt(now, authTime)
+	if err != nil {
+		log.Printf("service: issueRefreshToken RefreshExpiresAt error - {%v};", err)
+		return "", err
+	}
+
+	token, err := randtoken.New()
+	if err != nil {
+		log.Printf("service: issueRefreshToken randtoken.New error - {%v};", err)
+		return "", err
+	}
+
+	refreshToken := &model.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: randtoken.Hash(token),
+		AuthTime:  authTime,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := store.CreateRefreshToken(ctx, refreshToken); err != nil {
+		log.Printf("service: issueRefreshToken CreateRefreshToken error - {%v};", err)
+		return "", err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// RequestPasswordReset - rules for sending a code of password reset
+// decode email from request
+// find user by email and send code to the email in background (see service.background), after the answer
+// the answer is the same for existing and not existing email and for errors of sending, they are only logged,
+// time of answer doesn't depend on email either
+func (s *service) RequestPasswordReset(
+	ctx context.Context,
+	req *auth.RequestPasswordResetRequest) (*auth.RequestPasswordResetResponse, error) {
+	deserialize := deserializer.NewRequestPasswordResetDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	email := deserialize.UserEmail()
+	s.background(ctx, func(ctx context.Context) {
+		u, err := s.DBProvider.FindUserByEmail(ctx, email)
+		if err != nil {
+			log.Printf("service: RequestPasswordReset FindUserByEmail error - {%v};", err)
+			return
+		}
+		if err := s.sendPasswordReset(ctx, u); err != nil {
+			log.Printf("service: RequestPasswordReset sendPasswordReset error - {%v};", err)
+		}
+	})
+
+	return &auth.RequestPasswordResetResponse{}, nil
+}
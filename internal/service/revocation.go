@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+var ErrServiceTokenRevoked = errors.New("token revoked")
+
+// time during which an answer of the database is kept in process
+// revocation made by another replica is seen after this time at the latest
+const revocationCacheLife = 30 * time.Second
+
+// sessionTouchInterval - 'LastSeenAt' of session is written to the database not more often
+const sessionTouchInterval = time.Minute
+
+// revocationStore - answers whether an access token is still valid
+// source of truth is the database (all replicas agree), answers are cached in process
+type revocationStore struct {
+	dbProvider db.Provider
+
+	mu sync.RWMutex
+
+	// revoked - jti of revoked tokens -> exploration of token
+	revoked map[string]time.Time
+
+	// notRevoked - jti of checked tokens -> time of check
+	notRevoked map[string]time.Time
+
+	// users - 'TokensValidAfter' of user with time of check
+	users map[uint]cachedUser
+
+	// sessions - session of token with time of check
+	sessions map[string]cachedSession
+
+	lastSweep time.Time
+}
+
+type cachedUser struct {
+	user      *model.User
+	checkedAt time.Time
+}
+
+type cachedSession struct {
+	session   *model.Session
+	checkedAt time.Time
+}
+
+func newRevocationStore(dbProvider db.Provider) *revocationStore {
+	return &revocationStore{
+		dbProvider: dbProvider,
+		revoked:    make(map[string]time.Time),
+		notRevoked: make(map[string]time.Time),
+		users:      make(map[uint]cachedUser),
+		sessions:   make(map[string]cachedSession),
+		lastSweep:  time.Now().UTC(),
+	}
+}
+
+// check - token is rejected if
+// 1. jti of token is revoked
+// 2. user not found (deleted)
+// 3. token was issued before 'TokensValidAfter' of user
+// 4. session of token is revoked or belongs to other user
+// last seen time of session is updated
+func (rs *revocationStore) check(ctx context.Context, userID uint, claims *jwtsign.Claims) error {
+	now := time.Now().UTC()
+	rs.sweep(now)
+
+	revoked, err := rs.isRevoked(ctx, claims.ID, claims.ExpiresAt, now)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrServiceTokenRevoked
+	}
+
+	u, err := rs.findUser(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	if u.TokenIssuedBeforeValid(claims.IssuedAt) {
+		return ErrServiceTokenRevoked
+	}
+
+	sessionID := claims.SessionID()
+	if sessionID == "" {
+		return nil
+	}
+	session, err := rs.findSession(ctx, sessionID, now)
+	if err != nil {
+		return err
+	}
+	if session.Revoked() || session.UserID != userID {
+		return ErrServiceTokenRevoked
+	}
+	rs.touchSession(ctx, session, now)
+	return nil
+}
+
+func (rs *revocationStore) isRevoked(ctx context.Context, jti string, expiresAt, now time.Time) (bool, error) {
+	rs.mu.RLock()
+	_, revoked := rs.revoked[jti]
+	checkedAt, checked := rs.notRevoked[jti]
+	rs.mu.RUnlock()
+	if revoked {
+		return true, nil
+	}
+	if checked && now.Sub(checkedAt) < revocationCacheLife {
+		return false, nil
+	}
+
+	revoked, err := rs.dbProvider.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	rs.mu.Lock()
+	if revoked {
+		rs.revoked[jti] = expiresAt
+		delete(rs.notRevoked, jti)
+	} else {
+		rs.notRevoked[jti] = now
+	}
+	rs.mu.Unlock()
+
+	return revoked, nil
+}
+
+func (rs *revocationStore) findUser(ctx context.Context, userID uint, now time.Time) (*model.User, error) {
+	rs.mu.RLock()
+	cached, ex := rs.users[userID]
+	rs.mu.RUnlock()
+	if ex && now.Sub(cached.checkedAt) < revocationCacheLife {
+		if cached.user == nil {
+			return nil, ErrServiceNotFound
+		}
+		return cached.user, nil
+	}
+
+	u, err := rs.dbProvider.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.mu.Lock()
+	rs.users[userID] = cachedUser{user: u, checkedAt: now}
+	rs.mu.Unlock()
+
+	return u, nil
+}
+
+func (rs *revocationStore) findSession(ctx context.Context, sessionID string, now time.Time) (*model.Session, error) {
+	rs.mu.RLock()
+	cached, ex := rs.sessions[sessionID]
+	rs.mu.RUnlock()
+	if ex && (cached.session.Revoked() || now.Sub(cached.checkedAt) < revocationCacheLife) {
+		return cached.session, nil
+	}
+
+	session, err := rs.dbProvider.FindSessionByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.mu.Lock()
+	rs.sessions[sessionID] = cachedSession{session: session, checkedAt: now}
+	rs.mu.Unlock()
+
+	return session, nil
+}
+
+// touchSession - write last seen time of session, errors are only logged
+func (rs *revocationStore) touchSession(ctx context.Context, session *model.Session, now time.Time) {
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return
+	}
+	if err := rs.dbProvider.TouchSession(ctx, session.ID, now); err != nil {
+		log.Printf("service: TouchSession error - {%v};", err)
+		return
+	}
+
+	touched := *session
+	touched.LastSeenAt = now
+	rs.mu.Lock()
+	if cached, ex := rs.sessions[session.ID]; ex {
+		cached.session = &touched
+		rs.sessions[session.ID] = cached
+	}
+	rs.mu.Unlock()
+}
+
+// markSessionsRevoked - tokens of revoked sessions are rejected without waiting for the database
+func (rs *revocationStore) markSessionsRevoked(userID uint, sessionIDs []string, revokedAt time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, sessionID := range sessionIDs {
+		rs.sessions[sessionID] = cachedSession{
+			session:   &model.Session{ID: sessionID, UserID: userID, RevokedAt: &revokedAt},
+			checkedAt: revokedAt,
+		}
+	}
+}
+
+// revokeToken - write jti to the database and to the cache
+// the token is revoked after the write, so failed removal of expired records does not fail the request
+func (rs *revocationStore) revokeToken(ctx context.Context, userID uint, claims *jwtsign.Claims) error {
+	now := time.Now().UTC()
+	if err := rs.dbProvider.RevokeToken(ctx, &model.RevokedToken{
+		JTI:       claims.ID,
+		UserID:    userID,
+		ExpiresAt: claims.ExpiresAt,
+		RevokedAt: now,
+	}); err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	rs.revoked[claims.ID] = claims.ExpiresAt
+	delete(rs.notRevoked, claims.ID)
+	rs.mu.Unlock()
+
+	if err := rs.dbProvider.RemoveExpiredRevokedTokens(ctx, now); err != nil {
+		log.Printf("service: revokeToken RemoveExpiredRevokedTokens error - {%v};", err)
+	}
+	return nil
+}
+
+// forgetUser - remove user from cache, next check reads the database
+func (rs *revocationStore) forgetUser(userID uint) {
+	rs.mu.Lock()
+	delete(rs.users, userID)
+	rs.mu.Unlock()
+}
+
+// markUserDeleted - tokens of deleted user are rejected without waiting for the database
+func (rs *revocationStore) markUserDeleted(userID uint) {
+	rs.mu.Lock()
+	rs.users[userID] = cachedUser{checkedAt: time.Now().UTC()}
+	rs.mu.Unlock()
+}
+
+// sweep - remove stale entries from cache, not more than once per 'revocationCacheLife'
+func (rs *revocationStore) sweep(now time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if now.Sub(rs.lastSweep) < revocationCacheLife {
+		return
+	}
+	for jti, expiresAt := range rs.revoked {
+		if expiresAt.Before(now) {
+			delete(rs.revoked, jti)
+		}
+	}
+	for jti, checkedAt := range rs.notRevoked {
+		if now.Sub(checkedAt) >= revocationCacheLife {
+			delete(rs.notRevoked, jti)
+		}
+	}
+	for userID, cached := range rs.users {
+		if now.Sub(cached.checkedAt) >= revocationCacheLife {
+			delete(rs.users, userID)
+		}
+	}
+	for sessionID, cached := range rs.sessions {
+		if now.Sub(cached.checkedAt) >= revocationCacheLife {
+			delete(rs.sessions, sessionID)
+		}
+	}
+	rs.lastSweep = now
+}
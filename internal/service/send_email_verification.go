@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// SendEmailVerification - rules for sending a new mail of email verification
+// get userID from ctx
+// find user by ID from database, verified email -> ErrServiceEmailVerified
+// send token for the current email of user, earlier tokens are invalid
+func (s *service) SendEmailVerification(
+	ctx context.Context,
+	_ *auth.SendEmailVerificationRequest) (*auth.SendEmailVerificationResponse, error) {
+	deserialize := deserializer.NewIDDecode()
+	if err := deserialize.Decode(ctx); err != nil {
+		log.Printf("service: SendEmailVerification Decode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	u, err := s.DBProvider.FindUserByID(ctx, deserialize.UserID())
+	if err != nil {
+		log.Printf("service: SendEmailVerification FindUserByID error - {%v};", err)
+		return nil, ErrServiceNotFound
+	}
+	if u.EmailVerified() {
+		return nil, ErrServiceEmailVerified
+	}
+
+	if err := s.sendEmailVerification(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return &auth.SendEmailVerificationResponse{}, nil
+}
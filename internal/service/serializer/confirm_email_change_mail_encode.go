@@ -0,0 +1,54 @@
+// create mails of email change: confirmation to the new email and notice to the current one
+package serializer
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/mailer"
+)
+
+// ConfirmEmailChangeMailEncode - URL is link of client for confirmation, token is added as query "token"
+// empty URL - mail contains only token for ConfirmEmailChange, TTL - life of token for text of mail
+type ConfirmEmailChangeMailEncode struct {
+	To    string
+	Token string
+	URL   string
+	TTL   time.Duration
+}
+
+func (cecme *ConfirmEmailChangeMailEncode) Message() (mailer.Message, error) {
+	body := "Confirm your new email with this token:\n\n" + cecme.Token + "\n"
+	if cecme.URL != "" {
+		link, err := mailLink(cecme.URL, "token", cecme.Token)
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		body = "Confirm your new email by the link:\n\n" + link + "\n"
+	}
+	body += "\nThe token works once during " + cecme.TTL.String() + ", until then your email is not changed.\n" +
+		"If you did not ask for a change, ignore this mail.\n"
+	return mailer.Message{To: cecme.To, Subject: "Confirm your new email", Body: body}, nil
+}
+
+// EmailChangeNoticeMailEncode - notice to the current email, NewEmail is shown masked
+type EmailChangeNoticeMailEncode struct {
+	To       string
+	NewEmail string
+}
+
+func (ecnme *EmailChangeNoticeMailEncode) Message() mailer.Message {
+	body := "A change of email of your account to " + maskEmail(ecnme.NewEmail) + " was requested.\n" +
+		"The email is changed only after confirmation from the new address.\n\n" +
+		"If it was not you, change your password, your account may be used by others.\n"
+	return mailer.Message{To: ecnme.To, Subject: "Change of email", Body: body}
+}
+
+// maskEmail - first character of local part and domain ("alex@example.com" -> "a***@example.com")
+func maskEmail(email string) string {
+	local, domain, found := strings.Cut(email, "@")
+	if !found || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
@@ -0,0 +1,20 @@
+// create state of verification of email for response header
+package serializer
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderEmailVerified - key of response header of UserData, "true" if email of user is confirmed
+// 'User' of proto has no such field, so it is sent in metadata
+const HeaderEmailVerified = "email-verified"
+
+type EmailVerifiedEncode struct {
+	Verified bool
+}
+
+func (eve *EmailVerifiedEncode) Header() metadata.MD {
+	return metadata.Pairs(HeaderEmailVerified, strconv.FormatBool(eve.Verified))
+}
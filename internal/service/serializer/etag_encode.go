@@ -0,0 +1,20 @@
+// create version of user for response header
+package serializer
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderETag - key of response header with version of user, client sends it back in "if-match"
+// 'User' of proto has no version, so it is sent in metadata
+const HeaderETag = "etag"
+
+type ETagEncode struct {
+	Version uint
+}
+
+func (ete *ETagEncode) Header() metadata.MD {
+	return metadata.Pairs(HeaderETag, strconv.FormatUint(uint64(ete.Version), 10))
+}
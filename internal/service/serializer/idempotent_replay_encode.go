@@ -0,0 +1,20 @@
+// create response header of a replayed idempotent request
+package serializer
+
+import (
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderIdempotentReplayed - key of response header, "true" if response is of the first request with the same "idempotency-key"
+const HeaderIdempotentReplayed = "idempotent-replayed"
+
+// IdempotentReplayEncode - Stored is response header of the first request
+type IdempotentReplayEncode struct {
+	Stored map[string][]string
+}
+
+func (ire *IdempotentReplayEncode) Header() metadata.MD {
+	md := metadata.MD(ire.Stored).Copy()
+	md.Set(HeaderIdempotentReplayed, "true")
+	return md
+}
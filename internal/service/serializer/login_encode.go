@@ -3,19 +3,39 @@ package serializer
 
 import (
 	"strconv"
+	"time"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
 )
 
+// HeaderRefreshToken - key of response header with refresh token
+// 'UserLoginResponse' contains only 'token', so refresh token is sent in metadata
+const HeaderRefreshToken = "refresh-token"
+
+// LoginEncode - AuthTime is time of login, start of session with SessionID
 type LoginEncode struct {
-	ID uint
+	ID           uint
+	SessionID    string
+	AuthTime     time.Time
+	RefreshToken string
 }
 
 func (le *LoginEncode) Response() (*user.UserLoginResponse, error) {
-	content := jwtsign.Content{}
-	content["user_id"] = strconv.FormatUint(uint64(le.ID), 10)
-	token, err := jwtsign.TokenGenerator(content)
+	token, err := accessToken(le.ID, le.SessionID, le.AuthTime)
 	return &user.UserLoginResponse{Token: token}, err
 }
+
+// Header - metadata for response with refresh token
+func (le *LoginEncode) Header() metadata.MD {
+	return metadata.Pairs(HeaderRefreshToken, le.RefreshToken)
+}
+
+// accessToken - create jwt.Token with user ID as "sub" for session started at authTime
+func accessToken(id uint, sessionID string, authTime time.Time) (string, error) {
+	content := jwtsign.Content{}
+	content[jwtsign.SessionIDKey] = sessionID
+	return jwtsign.TokenGenerator(strconv.FormatUint(uint64(id), 10), authTime, content)
+}
@@ -0,0 +1,79 @@
+// create responses of two-factor authentication
+package serializer
+
+import (
+	"strings"
+
+	user "github.com/Ekvo/go-grpc-apis/user/v1"
+	"google.golang.org/grpc/metadata"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/totp"
+)
+
+// HeaderMFARequired - key of response header of UserLogin, "true" - 'token' is a challenge token of VerifyMFA
+const HeaderMFARequired = "mfa-required"
+
+// recoveryCodeGroup - length of group of recovery code between "-"
+const recoveryCodeGroup = 4
+
+// EnrollMFAEncode - plain secret of user with Account (email) in authenticator app of Issuer
+type EnrollMFAEncode struct {
+	Secret  []byte
+	Issuer  string
+	Account string
+}
+
+func (eme *EnrollMFAEncode) Response() *auth.EnrollMFAResponse {
+	return &auth.EnrollMFAResponse{
+		Secret:     totp.EncodeSecret(eme.Secret),
+		OtpauthUri: totp.URI(eme.Issuer, eme.Account, eme.Secret),
+	}
+}
+
+// ConfirmMFAEncode - recovery codes are shown in groups "xxxx-xxxx-xxxx-xxxx"
+type ConfirmMFAEncode struct {
+	RecoveryCodes []string
+}
+
+func (cme *ConfirmMFAEncode) Response() *auth.ConfirmMFAResponse {
+	codes := make([]string, 0, len(cme.RecoveryCodes))
+	for _, code := range cme.RecoveryCodes {
+		codes = append(codes, groupCode(code))
+	}
+	return &auth.ConfirmMFAResponse{RecoveryCodes: codes}
+}
+
+func groupCode(code string) string {
+	groups := make([]string, 0, len(code)/recoveryCodeGroup+1)
+	for len(code) > recoveryCodeGroup {
+		groups = append(groups, code[:recoveryCodeGroup])
+		code = code[recoveryCodeGroup:]
+	}
+	return strings.Join(append(groups, code), "-")
+}
+
+// MFAChallengeEncode - UserLogin of user with TOTP gets challenge token instead of access token
+type MFAChallengeEncode struct {
+	Token string
+}
+
+func (mce *MFAChallengeEncode) Response() *user.UserLoginResponse {
+	return &user.UserLoginResponse{Token: mce.Token}
+}
+
+// Header - metadata for response with mark of challenge
+func (mce *MFAChallengeEncode) Header() metadata.MD {
+	return metadata.Pairs(HeaderMFARequired, "true")
+}
+
+// VerifyMFAEncode - login finished by VerifyMFA, Header with refresh token is the same as of UserLogin
+type VerifyMFAEncode struct {
+	LoginEncode
+}
+
+func (vme *VerifyMFAEncode) Response() (*auth.VerifyMFAResponse, error) {
+	token, err := accessToken(vme.ID, vme.SessionID, vme.AuthTime)
+	return &auth.VerifyMFAResponse{Token: token}, err
+}
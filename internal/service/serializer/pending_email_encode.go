@@ -0,0 +1,18 @@
+// create new email waiting for confirmation for response header
+package serializer
+
+import (
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderPendingEmail - key of response header of UserUpdate with the new email, it is written to user after confirmation
+// 'UserUpdateResponse' of proto is empty, so it is sent in metadata
+const HeaderPendingEmail = "pending-email"
+
+type PendingEmailEncode struct {
+	Email string
+}
+
+func (pee *PendingEmailEncode) Header() metadata.MD {
+	return metadata.Pairs(HeaderPendingEmail, pee.Email)
+}
@@ -0,0 +1,20 @@
+// create a new pair of tokens for Response
+package serializer
+
+import (
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+)
+
+type RefreshTokenEncode struct {
+	ID           uint
+	SessionID    string
+	AuthTime     time.Time
+	RefreshToken string
+}
+
+func (rte *RefreshTokenEncode) Response() (*auth.RefreshTokenResponse, error) {
+	token, err := accessToken(rte.ID, rte.SessionID, rte.AuthTime)
+	return &auth.RefreshTokenResponse{Token: token, RefreshToken: rte.RefreshToken}, err
+}
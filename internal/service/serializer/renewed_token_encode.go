@@ -0,0 +1,25 @@
+// create a renewed access token of sliding session for response header
+package serializer
+
+import (
+	"google.golang.org/grpc/metadata"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+)
+
+// HeaderRenewedToken - key of response header with renewed access token
+// client replaces its token with the value of the header
+const HeaderRenewedToken = "renewed-token"
+
+// RenewedTokenEncode - Claims of the current token, the renewed token keeps subject, time of login and content
+type RenewedTokenEncode struct {
+	Claims *jwtsign.Claims
+}
+
+func (rte *RenewedTokenEncode) Header() (metadata.MD, error) {
+	token, err := jwtsign.TokenGenerator(rte.Claims.Subject, rte.Claims.AuthTime, rte.Claims.Content)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Pairs(HeaderRenewedToken, token), nil
+}
@@ -0,0 +1,31 @@
+// create mail with code of password reset
+package serializer
+
+import (
+	"time"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/mailer"
+)
+
+// ResetPasswordMailEncode - URL is link of client for reset, code is added as query "code"
+// empty URL - mail contains only code for ConfirmPasswordReset, TTL - life of code for text of mail
+type ResetPasswordMailEncode struct {
+	To   string
+	Code string
+	URL  string
+	TTL  time.Duration
+}
+
+func (rpme *ResetPasswordMailEncode) Message() (mailer.Message, error) {
+	body := "Set a new password with this code:\n\n" + rpme.Code + "\n"
+	if rpme.URL != "" {
+		link, err := mailLink(rpme.URL, "code", rpme.Code)
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		body = "Set a new password by the link:\n\n" + link + "\n"
+	}
+	body += "\nThe code works once during " + rpme.TTL.String() + ".\n" +
+		"If you did not ask for a reset, ignore this mail, your password is not changed.\n"
+	return mailer.Message{To: rpme.To, Subject: "Reset of password", Body: body}, nil
+}
@@ -0,0 +1,23 @@
+// create time to wait for response trailer of a rejected request
+package serializer
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// TrailerRetryAfter - key of response trailer with seconds to wait before the next request
+const TrailerRetryAfter = "retry-after"
+
+// RetryAfterEncode - Wait is rounded up to whole seconds, at least 1
+type RetryAfterEncode struct {
+	Wait time.Duration
+}
+
+func (rae *RetryAfterEncode) Trailer() metadata.MD {
+	seconds := max(int(math.Ceil(rae.Wait.Seconds())), 1)
+	return metadata.Pairs(TrailerRetryAfter, strconv.Itoa(seconds))
+}
@@ -0,0 +1,30 @@
+// create list of sessions for Response
+package serializer
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// SessionListEncode - CurrentID is session of the token from request
+type SessionListEncode struct {
+	Sessions  []*model.Session
+	CurrentID string
+}
+
+func (sle *SessionListEncode) Response() *auth.ListSessionsResponse {
+	sessions := make([]*auth.Session, 0, len(sle.Sessions))
+	for _, session := range sle.Sessions {
+		sessions = append(sessions, &auth.Session{
+			SessionId:  session.ID,
+			CreatedAt:  timestamppb.New(session.CreatedAt),
+			LastSeenAt: timestamppb.New(session.LastSeenAt),
+			ClientIp:   session.ClientIP,
+			UserAgent:  session.UserAgent,
+			Current:    session.ID == sle.CurrentID,
+		})
+	}
+	return &auth.ListSessionsResponse{Sessions: sessions}
+}
@@ -0,0 +1,30 @@
+// create step-up token for Response
+package serializer
+
+import (
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+)
+
+// StepUpEncode - token of user ID is valid only in session with SessionID for TTL
+type StepUpEncode struct {
+	ID        uint
+	SessionID string
+	TTL       time.Duration
+}
+
+func (sue *StepUpEncode) Response() (*auth.StepUpResponse, error) {
+	content := jwtsign.Content{}
+	content[jwtsign.SessionIDKey] = sue.SessionID
+	expiresAt := time.Now().UTC().Add(sue.TTL).Truncate(time.Second)
+	token, _, err := jwtsign.PurposeTokenGenerator(
+		strconv.FormatUint(uint64(sue.ID), 10), model.PurposeStepUp, sue.TTL, content)
+	return &auth.StepUpResponse{StepUpToken: token, ExpiresAt: timestamppb.New(expiresAt)}, err
+}
@@ -0,0 +1,41 @@
+// create mail with token of email verification
+package serializer
+
+import (
+	"net/url"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/mailer"
+)
+
+// VerifyEmailMailEncode - URL is link of client for verification, token is added as query "token"
+// empty URL - mail contains only token for VerifyEmail
+type VerifyEmailMailEncode struct {
+	To    string
+	Token string
+	URL   string
+}
+
+func (veme *VerifyEmailMailEncode) Message() (mailer.Message, error) {
+	body := "Confirm your email with this token:\n\n" + veme.Token + "\n"
+	if veme.URL != "" {
+		link, err := mailLink(veme.URL, "token", veme.Token)
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		body = "Confirm your email by the link:\n\n" + link + "\n"
+	}
+	body += "\nIf you did not register, ignore this mail.\n"
+	return mailer.Message{To: veme.To, Subject: "Confirm your email", Body: body}, nil
+}
+
+// mailLink - link of client with secret in query 'key'
+func mailLink(base, key, value string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set(key, value)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
@@ -1,11 +1,21 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"sync"
+	"time"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
+	"google.golang.org/grpc"
 
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/mailer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/ratelimit"
 )
 
 // errors for response
@@ -21,15 +31,87 @@ var (
 	ErrServicePasswordInvalid = errors.New("invalid password")
 
 	ErrServiceUpdateDataInvalid = errors.New("invalid update data")
+
+	ErrServiceUnavailable = errors.New("service unavailable")
+
+	ErrServiceVersionMismatch = errors.New("version mismatch")
+
+	ErrServiceVersionConflict = errors.New("user changed concurrently, retry")
 )
 
+// AlreadyExistsError - unique field of user is taken by other user
+// errors.Is(err, ErrServiceAlreadyExists) is true, Field is shown in metadata of errdetails.ErrorInfo
+type AlreadyExistsError struct {
+	Field string
+}
+
+func (ae *AlreadyExistsError) Error() string {
+	return ae.Field + " " + ErrServiceAlreadyExists.Error()
+}
+
+func (ae *AlreadyExistsError) Is(target error) bool {
+	return target == ErrServiceAlreadyExists
+}
+
+// userWriteError - error of CreateUser, UpdateUser -> error for response
+// taken login or email -> *AlreadyExistsError, lost database -> ErrServiceUnavailable
+// user changed between read and write -> ErrServiceVersionConflict
+func userWriteError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrDBVersionConflict):
+		return ErrServiceVersionConflict
+	case errors.Is(err, db.ErrDBLoginTaken):
+		return &AlreadyExistsError{Field: "login"}
+	case errors.Is(err, db.ErrDBEmailTaken):
+		return &AlreadyExistsError{Field: "email"}
+	case errors.Is(err, db.ErrDBUnavailable):
+		return ErrServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+	return ErrServiceInternal
+}
+
 type Service interface {
 	user.UserServiceServer
+	auth.AuthServiceServer
+	admin.AdminServiceServer
+
+	// ErrorStatus - grpc.UnaryServerInterceptor, chained first
+	ErrorStatus(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error)
+
+	// Authorization - grpc.UnaryServerInterceptor
+	Authorization(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error)
+
+	// RateLimit - grpc.UnaryServerInterceptor, chained after Authorization
+	RateLimit(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error)
+
+	// Idempotency - grpc.UnaryServerInterceptor, chained after RateLimit
+	Idempotency(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error)
+
+	// Wait - block until jobs started in background by requests are done, call before close of database
+	Wait()
 }
 
 // Depends- if necessary add another base
+// AdminKey - key of AdminService, empty - all methods of AdminService are rejected
+// Login - lockout after failed logins, default values if not set
+// RateLimit - limits of requests per client and method, default values if not set
+// User - timestamps of user from request or from database (default)
+// Idempotency - time while responses of requests with "idempotency-key" are replayed, default if not set
+// Mailer - sender of mail to users, messages are kept in memory if not set
+// Mail - links of email verification and of password reset
+// MFA - keys of encryption of TOTP secrets, two-factor authentication can't be enabled without keys
 type Depends struct {
-	DBProvider db.Provider
+	DBProvider  db.Provider
+	AdminKey    string
+	Login       config.LoginConfig
+	RateLimit   config.RateLimitConfig
+	User        config.UserConfig
+	Idempotency config.IdempotencyConfig
+	Mailer      mailer.Mailer
+	Mail        config.MailConfig
+	MFA         config.MFAConfig
 }
 
 func NewDepends(dbProvider db.Provider) Depends {
@@ -38,8 +120,68 @@ func NewDepends(dbProvider db.Provider) Depends {
 
 type service struct {
 	Depends
+
+	revocation *revocationStore
+
+	lockout lockoutPolicy
+
+	mfa mfaPolicy
+
+	limiter *ratelimit.Limiter
+
+	idempotencyTTL time.Duration
+
+	verifyEmailTTL time.Duration
+
+	resetPasswordTTL time.Duration
+
+	stepUpTTL time.Duration
+
+	changeEmailTTL time.Duration
+
+	jobs sync.WaitGroup
+}
+
+// backgroundTimeout - time of job started by request after the answer
+const backgroundTimeout = 30 * time.Second
+
+// background - run job after the answer, context of job keeps values of request but not its cancel
+// the job is limited by backgroundTimeout, Wait blocks until it is done
+func (s *service) background(ctx context.Context, job func(ctx context.Context)) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundTimeout)
+		defer cancel()
+		job(ctx)
+	}()
 }
 
-func NewService(dep Depends) *service {
-	return &service{Depends: dep}
+func (s *service) Wait() {
+	s.jobs.Wait()
+}
+
+// NewService - error of config that can't be checked before start (keys of MFA)
+func NewService(dep Depends) (*service, error) {
+	if dep.Mailer == nil {
+		dep.Mailer = mailer.NewMemory()
+	}
+	mfa, err := newMFAPolicy(dep.MFA)
+	if err != nil {
+		return nil, err
+	}
+	return &service{
+		Depends:    dep,
+		revocation: newRevocationStore(dep.DBProvider),
+		lockout:    newLockoutPolicy(dep.Login),
+		mfa:        mfa,
+		limiter:    ratelimit.NewLimiter(&dep.RateLimit),
+
+		idempotencyTTL: newIdempotencyTTL(dep.Idempotency),
+		verifyEmailTTL: newVerifyEmailTTL(dep.User),
+
+		resetPasswordTTL: newResetPasswordTTL(dep.User),
+		stepUpTTL:        newStepUpTTL(dep.User),
+		changeEmailTTL:   newChangeEmailTTL(dep.User),
+	}, nil
 }
@@ -2,40 +2,117 @@ package service
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db/mock"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/jwks"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/mailer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/ratelimit"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/seal"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/totp"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
 )
 
+const testIssuer = "user-dir"
+
+var testAudience = []string{"user-dir", "orders"}
+
+// testPasswordConfig - cheap parameters of argon2id for fast tests
+var testPasswordConfig = config.PasswordConfig{Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1}
+
+const testAdminKey = "admin-key"
+
+// testRateLimitConfig - limits are not reached by tests
+var testRateLimitConfig = config.RateLimitConfig{
+	Default: "1000/s",
+	Methods: map[string]string{
+		"UserLogin": "1000/s", "UserRegister": "1000/s", "RefreshToken": "1000/s",
+		"SendEmailVerification": "1000/s", "VerifyEmail": "1000/s",
+		"RequestPasswordReset": "1000/s", "ConfirmPasswordReset": "1000/s", "StepUp": "1000/s",
+		"ConfirmEmailChange": "1000/s", "EnrollMFA": "1000/s", "ConfirmMFA": "1000/s", "VerifyMFA": "1000/s",
+		"DisableMFA": "1000/s", ratelimit.AuthFailure: "1000/s",
+	},
+}
+
 type dataServer struct {
-	lis    *bufconn.Listener
-	srv    *grpc.Server
-	client user.UserServiceClient
+	lis         *bufconn.Listener
+	srv         *grpc.Server
+	provider    db.Provider
+	service     *service
+	client      user.UserServiceClient
+	authClient  auth.AuthServiceClient
+	adminClient admin.AdminServiceClient
 }
 
-// newDataServer - implemet and start server
-func newDataServer() (*dataServer, error) {
-	_ = jwtsign.NewSecretKey(&config.Config{JWTSecretKey: "secret"})
+// newDataServer - implemet and start server, options change Depends of service before start
+func newDataServer(options ...func(dep *Depends)) (*dataServer, error) {
+	_ = jwtsign.LoadKeyring(&config.JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: testAudience})
+	password.Configure(&testPasswordConfig)
 
 	listener := bufconn.Listen(1024 * 0124)
-	srv := grpc.NewServer(grpc.UnaryInterceptor(Authorization))
-	usecase := NewService(NewDepends(mock.NewMockProvider()))
+	provider := mock.NewMockProvider()
+	dep := NewDepends(provider)
+	dep.AdminKey = testAdminKey
+	dep.RateLimit = testRateLimitConfig
+	for _, option := range options {
+		option(&dep)
+	}
+	usecase, err := NewService(dep)
+	if err != nil {
+		return nil, err
+	}
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(usecase.ErrorStatus, usecase.Authorization, usecase.RateLimit, usecase.Idempotency))
 	user.RegisterUserServiceServer(srv, usecase)
+	auth.RegisterAuthServiceServer(srv, usecase)
+	admin.RegisterAdminServiceServer(srv, usecase)
 
 	go func() {
 		if err := srv.Serve(listener); err != nil {
@@ -54,9 +131,13 @@ func newDataServer() (*dataServer, error) {
 	}
 
 	return &dataServer{
-		lis:    listener,
-		srv:    srv,
-		client: user.NewUserServiceClient(conn),
+		lis:         listener,
+		srv:         srv,
+		provider:    provider,
+		service:     usecase,
+		client:      user.NewUserServiceClient(conn),
+		authClient:  auth.NewAuthServiceClient(conn),
+		adminClient: admin.NewAdminServiceClient(conn),
 	}, nil
 }
 
@@ -122,7 +203,7 @@ func Test_UserRegister_Service(t *testing.T) {
 				Login:     `fury`,
 				FirstName: `Mary`,
 				Email:     `fury1995@example.com`,
-				Password:  `Maryshy`,
+				Password:  `Maryshy95`,
 				CreatedAt: timestamppb.Now(),
 			},
 			expectedRes: 1,
@@ -135,11 +216,11 @@ func Test_UserRegister_Service(t *testing.T) {
 				Login:     `fury`,
 				FirstName: `Mary`,
 				Email:     `fury2000@example.com`,
-				Password:  `Maryshy`,
+				Password:  `Maryshy95`,
 				CreatedAt: timestamppb.Now(),
 			},
 			expectedRes: 0,
-			expectedErr: ErrServiceAlreadyExists,
+			expectedErr: &AlreadyExistsError{Field: "login"},
 			msg:         `wrong result, login already exists return error`,
 		},
 		{
@@ -152,7 +233,7 @@ func Test_UserRegister_Service(t *testing.T) {
 				CreatedAt: timestamppb.Now(),
 			},
 			expectedRes: 0,
-			expectedErr: ErrServiceAlreadyExists,
+			expectedErr: &AlreadyExistsError{Field: "email"},
 			msg:         `wrong result, email already exists return error`,
 		},
 		{
@@ -165,7 +246,7 @@ func Test_UserRegister_Service(t *testing.T) {
 				CreatedAt: timestamppb.Now(),
 			},
 			expectedRes: 0,
-			expectedErr: errors.New(`deserializer: invalid signup - {email:invalid}`),
+			expectedErr: errors.New(`deserializer: invalid signup - {email:invalid},{password:too short}`),
 			msg:         `invalid email, error is exist`,
 		},
 		{
@@ -287,13 +368,18 @@ func Test_UserLogin_Service(t *testing.T) {
 	log.Printf("service_test: Test_UserLogin_Service - END")
 }
 
+// withClientTimestamps - option of newDataServer, 'created_at', 'updated_at' are taken from request
+func withClientTimestamps(dep *Depends) {
+	dep.User.ClientTimestamps = true
+}
+
 func Test_UserData_Service(t *testing.T) {
 	log.Printf("service_test: Test_UserData_Service - START")
 
 	asserts := assert.New(t)
 	requires := require.New(t)
 
-	dataService, err := newDataServer()
+	dataService, err := newDataServer(withClientTimestamps)
 	if err != nil {
 		log.Printf("service_test: Test_UserData_Service newDataServer error - {%v};", err)
 		return
@@ -396,7 +482,7 @@ func Test_UserUpdate_Service(t *testing.T) {
 		},
 	}
 
-	dataService, err := newDataServer()
+	dataService, err := newDataServer(withClientTimestamps)
 	if err != nil {
 		log.Printf("service_test: Test_UserData_Service newDataServer error - {%v};", err)
 		return
@@ -408,11 +494,12 @@ func Test_UserUpdate_Service(t *testing.T) {
 		log.Printf("service_test: Test_UserUpdate_Service createDataFroAutirizationWithContext error - {%v};", err)
 		return
 	}
+	ctx = withStepUp(t, dataService, ctx, `testpassword`)
 
 	for i, test := range testData {
 		log.Printf("\t%d - %s", i+1, test.title)
 
-		res, err := dataService.client.UserUpdate(ctx, test.data)
+		res, err := dataService.client.UserUpdate(withVersion(t, dataService, ctx), test.data)
 
 		if err == nil {
 			requires.NotNil(res, "result should be not nil")
@@ -450,20 +537,2579 @@ func Test_UserDelete_Service(t *testing.T) {
 	}
 	log.Printf("service_test: Test_UserDelete_Service - valid test")
 
+	ctx = withVersion(t, dataService, withStepUp(t, dataService, ctx, `testpassword`))
+	dataService.service.DBProvider = &removeFailProvider{Provider: dataService.provider}
+	_, err = dataService.client.UserDelete(ctx, &user.UserDeleteRequest{})
+	dataService.service.DBProvider = dataService.provider
+	code, reason := statusReason(err)
+	asserts.Equal(codes.Unavailable, code, "unavailable store is not a missing user")
+	asserts.Equal(ReasonUnavailable, reason)
+
 	res, err := dataService.client.UserDelete(ctx, &user.UserDeleteRequest{})
 	asserts.NoError(err, "correct delete from store")
 	asserts.NotNil(res, "shouldn't be nil")
 
 	log.Printf("service_test: Test_UserDelete_Service - wrong test")
 
+	// token of deleted user is not valid
 	res, err = dataService.client.UserDelete(ctx, &user.UserDeleteRequest{})
 	requires.Error(err, "error shoud be not nil")
 	st, ok := status.FromError(err)
 	if !ok {
 		requires.FailNow("this is not a GRPC error it is ALIEN")
 	}
-	asserts.Equal(ErrServiceNotFound.Error(), st.Message(), "differen errors")
+	asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
 	asserts.Nil(res, "should be nil")
 
 	log.Printf("service_test: Test_UserDelete_Service - END")
 }
+
+type removeFailProvider struct {
+	db.Provider
+}
+
+func (rfp *removeFailProvider) RemoveUserByID(context.Context, uint, uint) error {
+	return db.ErrDBUnavailable
+}
+
+func Test_RefreshToken_Service(t *testing.T) {
+	log.Printf("service_test: Test_RefreshToken_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_RefreshToken_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	ctx := context.Background()
+
+	_, err = dataService.client.UserRegister(ctx, newUserRegisterRequest(time.Now().UTC()))
+	requires.NoError(err, "register should be valid")
+
+	var header metadata.MD
+	_, err = dataService.client.UserLogin(ctx, newUserLoginRequest(), grpc.Header(&header))
+	requires.NoError(err, "login should be valid")
+	refreshTokens := header.Get("refresh-token")
+	requires.Len(refreshTokens, 1, "refresh token should be in header")
+
+	log.Printf("service_test: Test_RefreshToken_Service - valid rotation")
+
+	res, err := dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{RefreshToken: refreshTokens[0]})
+	requires.NoError(err, "refresh should be valid")
+	asserts.NotEmpty(res.Token, "access token should be exist")
+	asserts.NotEmpty(res.RefreshToken, "refresh token should be exist")
+	asserts.NotEqual(refreshTokens[0], res.RefreshToken, "refresh token should be rotated")
+
+	log.Printf("service_test: Test_RefreshToken_Service - reuse of spent token")
+
+	_, err = dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{RefreshToken: refreshTokens[0]})
+	requires.Error(err, "spent token should be rejected")
+	st, ok := status.FromError(err)
+	if !ok {
+		requires.FailNow("this is not a GRPC error it is ALIEN")
+	}
+	asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
+
+	_, err = dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{RefreshToken: res.RefreshToken})
+	requires.Error(err, "family should be revoked after reuse")
+
+	log.Printf("service_test: Test_RefreshToken_Service - empty token")
+
+	_, err = dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{})
+	requires.Error(err, "empty token should be rejected")
+	st, _ = status.FromError(err)
+	asserts.Equal(`deserializer: invalid refresh token - {refresh-token:empty}`, st.Message(), "differen errors")
+
+	log.Printf("service_test: Test_RefreshToken_Service - lost database does not start a new session")
+
+	_, err = dataService.client.UserLogin(ctx, newUserLoginRequest(), grpc.Header(&header))
+	requires.NoError(err, "login should be valid")
+	failProvider := &sessionFailProvider{Provider: dataService.provider}
+	dataService.service.DBProvider = failProvider
+	_, err = dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{RefreshToken: header.Get("refresh-token")[0]})
+	dataService.service.DBProvider = dataService.provider
+	code, reason := statusReason(err)
+	asserts.Equal(codes.Unavailable, code)
+	asserts.Equal(ReasonUnavailable, reason)
+	asserts.Zero(failProvider.created, "session should not be created")
+
+	log.Printf("service_test: Test_RefreshToken_Service - failed write of the next token keeps the token")
+
+	header = nil
+	_, err = dataService.client.UserLogin(ctx, newUserLoginRequest(), grpc.Header(&header))
+	requires.NoError(err, "login should be valid")
+	refreshToken := header.Get("refresh-token")[0]
+	dataService.provider.(interface{ FailCommit(err error) }).FailCommit(db.ErrDBUnavailable)
+	_, err = dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{RefreshToken: refreshToken})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.Unavailable, code)
+	res, err = dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{RefreshToken: refreshToken})
+	requires.NoError(err, "retry is not reuse, the token is not spent")
+	_, err = dataService.authClient.RefreshToken(ctx, &auth.RefreshTokenRequest{RefreshToken: res.RefreshToken})
+	requires.NoError(err, "family should not be revoked")
+
+	log.Printf("service_test: Test_RefreshToken_Service - END")
+}
+
+// sessionFailProvider - store which can't read or revoke sessions, created sessions are counted
+type sessionFailProvider struct {
+	db.Provider
+	created int
+}
+
+func (sfp *sessionFailProvider) FindSessionByID(context.Context, string) (*model.Session, error) {
+	return nil, db.ErrDBUnavailable
+}
+
+func (sfp *sessionFailProvider) RevokeSession(context.Context, uint, string, time.Time) error {
+	return db.ErrDBUnavailable
+}
+
+func (sfp *sessionFailProvider) CreateSession(ctx context.Context, session *model.Session) error {
+	sfp.created++
+	return sfp.Provider.CreateSession(ctx, session)
+}
+
+func Test_Logout_Service(t *testing.T) {
+	log.Printf("service_test: Test_Logout_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_Logout_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	ctx, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC())
+	if err != nil {
+		log.Printf("service_test: Test_Logout_Service createDataFroAutirizationWithContext error - {%v};", err)
+		return
+	}
+
+	var header metadata.MD
+	token, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest(), grpc.Header(&header))
+	requires.NoError(err, "login should be valid")
+	secondCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+
+	log.Printf("service_test: Test_Logout_Service - logout of one session")
+
+	_, err = dataService.authClient.Logout(secondCtx, &auth.LogoutRequest{RefreshToken: header.Get("refresh-token")[0]})
+	requires.NoError(err, "logout should be valid")
+
+	_, err = dataService.client.UserData(secondCtx, &user.UserDataRequest{})
+	requires.Error(err, "revoked token should be rejected")
+	st, _ := status.FromError(err)
+	asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
+
+	_, err = dataService.authClient.RefreshToken(context.Background(), &auth.RefreshTokenRequest{RefreshToken: header.Get("refresh-token")[0]})
+	requires.Error(err, "refresh token of the session should be revoked")
+
+	_, err = dataService.client.UserData(ctx, &user.UserDataRequest{})
+	requires.NoError(err, "token of other session should be valid")
+
+	log.Printf("service_test: Test_Logout_Service - logout from all devices")
+
+	_, err = dataService.authClient.LogoutAll(ctx, &auth.LogoutAllRequest{})
+	requires.NoError(err, "logout all should be valid")
+
+	_, err = dataService.client.UserData(ctx, &user.UserDataRequest{})
+	requires.Error(err, "token issued before logout all should be rejected")
+
+	token, err = dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	requires.NoError(err, "login after logout all should be valid")
+	newCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+	_, err = dataService.client.UserData(newCtx, &user.UserDataRequest{})
+	asserts.NoError(err, "new token should be valid")
+
+	log.Printf("service_test: Test_Logout_Service - failed removal of expired records does not fail logout")
+
+	dataService.service.revocation.dbProvider = &cleanupFailProvider{Provider: dataService.provider}
+	_, err = dataService.authClient.Logout(newCtx, &auth.LogoutRequest{})
+	requires.NoError(err, "token is revoked before removal of expired records")
+	dataService.service.revocation.dbProvider = dataService.provider
+	_, err = dataService.client.UserData(newCtx, &user.UserDataRequest{})
+	asserts.Error(err, "token should be revoked")
+
+	log.Printf("service_test: Test_Logout_Service - END")
+}
+
+// cleanupFailProvider - store with failed removal of expired revoked tokens
+type cleanupFailProvider struct {
+	db.Provider
+}
+
+func (cfp *cleanupFailProvider) RemoveExpiredRevokedTokens(context.Context, time.Time) error {
+	return db.ErrDBUnavailable
+}
+
+func Test_KeyRotation_Service(t *testing.T) {
+	log.Printf("service_test: Test_KeyRotation_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_KeyRotation_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	oldCtx, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC())
+	if err != nil {
+		log.Printf("service_test: Test_KeyRotation_Service createDataFroAutirizationWithContext error - {%v};", err)
+		return
+	}
+
+	log.Printf("service_test: Test_KeyRotation_Service - new current key, old key verifies")
+
+	err = jwtsign.LoadKeyring(&config.JWTConfig{
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+		Secret:       "secret",
+		Keys:         map[string]string{"k2": "secret2"},
+		CurrentKeyID: "k2",
+	})
+	requires.NoError(err, "keyring should be loaded")
+
+	_, err = dataService.client.UserData(oldCtx, &user.UserDataRequest{})
+	asserts.NoError(err, "token of old key should be valid")
+
+	token, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	requires.NoError(err, "login should be valid")
+	newCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+
+	_, err = dataService.client.UserData(newCtx, &user.UserDataRequest{})
+	asserts.NoError(err, "token of new key should be valid")
+
+	log.Printf("service_test: Test_KeyRotation_Service - old key retired")
+
+	err = jwtsign.LoadKeyring(&config.JWTConfig{
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+		Keys:         map[string]string{"k2": "secret2"},
+		CurrentKeyID: "k2",
+	})
+	requires.NoError(err, "keyring should be loaded")
+
+	_, err = dataService.client.UserData(oldCtx, &user.UserDataRequest{})
+	requires.Error(err, "token of retired key should be rejected")
+	st, _ := status.FromError(err)
+	asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
+
+	_, err = dataService.client.UserData(newCtx, &user.UserDataRequest{})
+	asserts.NoError(err, "token of new key should be valid")
+
+	log.Printf("service_test: Test_KeyRotation_Service - wrong current key")
+
+	err = jwtsign.LoadKeyring(&config.JWTConfig{
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+		Keys:         map[string]string{"k2": "secret2"},
+		CurrentKeyID: "k3",
+	})
+	asserts.ErrorIs(err, jwtsign.ErrJWTKeyNotFound, "current key should be in keyring")
+
+	log.Printf("service_test: Test_KeyRotation_Service - END")
+}
+
+// writePEMKey - save key to temp PEM file (PKCS #8 for private, PKIX for public key)
+func writePEMKey(t *testing.T, key any) string {
+	var block *pem.Block
+	switch k := key.(type) {
+	case crypto.Signer:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		require.NoError(t, err)
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		require.NoError(t, err)
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	return path
+}
+
+func Test_AsymmetricKey_Service(t *testing.T) {
+	log.Printf("service_test: Test_AsymmetricKey_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_AsymmetricKey_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	hmacCtx, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC())
+	if err != nil {
+		log.Printf("service_test: Test_AsymmetricKey_Service createDataFroAutirizationWithContext error - {%v};", err)
+		return
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	requires.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	requires.NoError(err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	requires.NoError(err)
+
+	for _, kid := range []string{"ed", "ec", "rsa"} {
+		log.Printf("service_test: Test_AsymmetricKey_Service - current key {%s}", kid)
+
+		err = jwtsign.LoadKeyring(&config.JWTConfig{
+			Issuer:   testIssuer,
+			Audience: testAudience,
+			Secret:   "secret",
+			KeyFiles: map[string]string{
+				"ed":  writePEMKey(t, edKey),
+				"ec":  writePEMKey(t, ecKey),
+				"rsa": writePEMKey(t, rsaKey),
+			},
+			CurrentKeyID: kid,
+		})
+		requires.NoError(err, "keyring should be loaded")
+
+		token, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+		requires.NoError(err, "login should be valid")
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+
+		_, err = dataService.client.UserData(ctx, &user.UserDataRequest{})
+		asserts.NoError(err, "token of asymmetric key should be valid")
+
+		_, err = dataService.client.UserData(hmacCtx, &user.UserDataRequest{})
+		asserts.NoError(err, "token of HMAC key should be valid")
+	}
+
+	log.Printf("service_test: Test_AsymmetricKey_Service - jwks")
+
+	rec := httptest.NewRecorder()
+	jwks.Handler(rec, httptest.NewRequest(http.MethodGet, jwks.Path, nil))
+	requires.Equal(http.StatusOK, rec.Code)
+
+	var set jwtsign.JWKSet
+	requires.NoError(json.Unmarshal(rec.Body.Bytes(), &set))
+	requires.Len(set.Keys, 3, "secret of HMAC should not be published")
+	asserts.Equal("ec", set.Keys[0].KeyID)
+	asserts.Equal("ES256", set.Keys[0].Algorithm)
+	asserts.Equal("P-256", set.Keys[0].Curve)
+	asserts.Equal("ed", set.Keys[1].KeyID)
+	asserts.Equal("EdDSA", set.Keys[1].Algorithm)
+	asserts.Equal("OKP", set.Keys[1].KeyType)
+	asserts.Equal("rsa", set.Keys[2].KeyID)
+	asserts.Equal("RS256", set.Keys[2].Algorithm)
+	asserts.Equal("AQAB", set.Keys[2].E)
+
+	log.Printf("service_test: Test_AsymmetricKey_Service - public key can not sign")
+
+	err = jwtsign.LoadKeyring(&config.JWTConfig{
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+		KeyFiles:     map[string]string{"pub": writePEMKey(t, edKey.Public())},
+		CurrentKeyID: "pub",
+	})
+	asserts.ErrorIs(err, jwtsign.ErrJWTKeyCanNotSign, "public key should only verify")
+
+	log.Printf("service_test: Test_AsymmetricKey_Service - END")
+}
+
+func Test_RegisteredClaims_Service(t *testing.T) {
+	log.Printf("service_test: Test_RegisteredClaims_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_RegisteredClaims_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	ctx, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC())
+	if err != nil {
+		log.Printf("service_test: Test_RegisteredClaims_Service createDataFroAutirizationWithContext error - {%v};", err)
+		return
+	}
+
+	token, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	requires.NoError(err, "login should be valid")
+
+	claims, err := jwtsign.GetClaimsFromToken(token.Token)
+	requires.NoError(err, "token should be valid")
+	asserts.Equal("1", claims.Subject)
+	asserts.Equal(testIssuer, claims.Issuer)
+	asserts.Equal(testAudience, claims.Audience)
+	asserts.NotEmpty(claims.ID)
+	asserts.False(claims.NotBefore.After(claims.IssuedAt), "nbf should not be after iat")
+	asserts.NotEmpty(claims.SessionID(), "token should belong to session")
+
+	var testData = []struct {
+		title    string
+		issuer   string
+		audience []string
+	}{
+		{title: "other audience", issuer: testIssuer, audience: []string{"billing"}},
+		{title: "other issuer", issuer: "other", audience: testAudience},
+	}
+
+	for _, test := range testData {
+		log.Printf("service_test: Test_RegisteredClaims_Service - %s", test.title)
+
+		err = jwtsign.LoadKeyring(&config.JWTConfig{Secret: "secret", Issuer: test.issuer, Audience: test.audience})
+		requires.NoError(err, "keyring should be loaded")
+
+		_, err = dataService.client.UserData(ctx, &user.UserDataRequest{})
+		requires.Error(err, "token of other issuer or audience should be rejected")
+		st, _ := status.FromError(err)
+		asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
+	}
+
+	log.Printf("service_test: Test_RegisteredClaims_Service - other service of audience")
+
+	err = jwtsign.LoadKeyring(&config.JWTConfig{Secret: "secret", Issuer: testIssuer, Audience: []string{"orders"}})
+	requires.NoError(err, "keyring should be loaded")
+
+	_, err = jwtsign.GetClaimsFromToken(token.Token)
+	asserts.NoError(err, "token should be valid for every service of audience")
+
+	log.Printf("service_test: Test_RegisteredClaims_Service - END")
+}
+
+func Test_SessionLifetime_Service(t *testing.T) {
+	log.Printf("service_test: Test_SessionLifetime_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_SessionLifetime_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	if _, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC()); err != nil {
+		log.Printf("service_test: Test_SessionLifetime_Service createDataFroAutirizationWithContext error - {%v};", err)
+		return
+	}
+
+	log.Printf("service_test: Test_SessionLifetime_Service - sliding session")
+
+	err = jwtsign.LoadKeyring(&config.JWTConfig{
+		Secret:         "secret",
+		Issuer:         testIssuer,
+		Audience:       testAudience,
+		AccessTTL:      4 * time.Second,
+		SlidingSession: true,
+	})
+	requires.NoError(err, "keyring should be loaded")
+
+	token, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	requires.NoError(err, "login should be valid")
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+
+	var header metadata.MD
+	_, err = dataService.client.UserData(ctx, &user.UserDataRequest{}, grpc.Header(&header))
+	requires.NoError(err, "token should be valid")
+	asserts.Empty(header.Get("renewed-token"), "fresh token should not be renewed")
+
+	time.Sleep(2200 * time.Millisecond)
+
+	_, err = dataService.client.UserData(ctx, &user.UserDataRequest{}, grpc.Header(&header))
+	requires.NoError(err, "token should be valid")
+	requires.Len(header.Get("renewed-token"), 1, "token of active session should be renewed")
+
+	oldClaims, err := jwtsign.GetClaimsFromToken(token.Token)
+	requires.NoError(err)
+	newClaims, err := jwtsign.GetClaimsFromToken(header.Get("renewed-token")[0])
+	requires.NoError(err, "renewed token should be valid")
+	asserts.Equal(oldClaims.Subject, newClaims.Subject)
+	asserts.Equal(oldClaims.AuthTime, newClaims.AuthTime, "renewed token should keep time of login")
+	asserts.True(newClaims.ExpiresAt.After(oldClaims.ExpiresAt), "renewed token should live longer")
+
+	log.Printf("service_test: Test_SessionLifetime_Service - maximum length of session")
+
+	err = jwtsign.LoadKeyring(&config.JWTConfig{
+		Secret:        "secret",
+		Issuer:        testIssuer,
+		Audience:      testAudience,
+		SessionMaxAge: 2 * time.Second,
+	})
+	requires.NoError(err, "keyring should be loaded")
+
+	token, err = dataService.client.UserLogin(context.Background(), newUserLoginRequest(), grpc.Header(&header))
+	requires.NoError(err, "login should be valid")
+	claims, err := jwtsign.GetClaimsFromToken(token.Token)
+	requires.NoError(err)
+	asserts.False(claims.ExpiresAt.After(claims.AuthTime.Add(2*time.Second)), "token should not outlive session")
+
+	time.Sleep(2100 * time.Millisecond)
+
+	_, err = dataService.authClient.RefreshToken(context.Background(), &auth.RefreshTokenRequest{RefreshToken: header.Get("refresh-token")[0]})
+	requires.Error(err, "refresh token of expired session should be rejected")
+	st, _ := status.FromError(err)
+	asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
+
+	log.Printf("service_test: Test_SessionLifetime_Service - END")
+}
+
+func Test_Sessions_Service(t *testing.T) {
+	log.Printf("service_test: Test_Sessions_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_Sessions_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	firstCtx, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC())
+	if err != nil {
+		log.Printf("service_test: Test_Sessions_Service createDataFroAutirizationWithContext error - {%v};", err)
+		return
+	}
+
+	var header metadata.MD
+	token, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest(), grpc.Header(&header))
+	requires.NoError(err, "login should be valid")
+	secondCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+
+	log.Printf("service_test: Test_Sessions_Service - list sessions")
+
+	list, err := dataService.authClient.ListSessions(firstCtx, &auth.ListSessionsRequest{})
+	requires.NoError(err, "list should be valid")
+	requires.Len(list.Sessions, 2, "every login should create session")
+	current := 0
+	for _, session := range list.Sessions {
+		asserts.NotEmpty(session.SessionId)
+		asserts.NotEmpty(session.ClientIp, "ip of client should be set")
+		asserts.NotEmpty(session.UserAgent, "user-agent should be set")
+		asserts.NotNil(session.CreatedAt)
+		asserts.NotNil(session.LastSeenAt)
+		if session.Current {
+			current++
+		}
+	}
+	asserts.Equal(1, current, "only one session should be current")
+
+	log.Printf("service_test: Test_Sessions_Service - revoke other sessions")
+
+	revoked, err := dataService.authClient.RevokeOtherSessions(firstCtx, &auth.RevokeOtherSessionsRequest{})
+	requires.NoError(err, "revoke should be valid")
+	asserts.Equal(uint32(1), revoked.Revoked)
+
+	_, err = dataService.client.UserData(secondCtx, &user.UserDataRequest{})
+	requires.Error(err, "token of revoked session should be rejected")
+	st, _ := status.FromError(err)
+	asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
+
+	_, err = dataService.authClient.RefreshToken(context.Background(), &auth.RefreshTokenRequest{RefreshToken: header.Get("refresh-token")[0]})
+	requires.Error(err, "refresh token of revoked session should be rejected")
+
+	list, err = dataService.authClient.ListSessions(firstCtx, &auth.ListSessionsRequest{})
+	requires.NoError(err, "list should be valid")
+	requires.Len(list.Sessions, 1, "revoked session should not be listed")
+	asserts.True(list.Sessions[0].Current)
+
+	log.Printf("service_test: Test_Sessions_Service - revoke session")
+
+	var testData = []struct {
+		title       string
+		sessionID   string
+		expectedErr string
+	}{
+		{
+			title:       "empty session",
+			sessionID:   "",
+			expectedErr: `deserializer: invalid session - {session-id:empty}`,
+		},
+		{
+			title:       "unknown session",
+			sessionID:   "unknown",
+			expectedErr: ErrServiceNotFound.Error(),
+		},
+		{
+			title:     "current session",
+			sessionID: list.Sessions[0].SessionId,
+		},
+	}
+
+	for _, test := range testData {
+		log.Printf("service_test: Test_Sessions_Service - %s", test.title)
+
+		_, err := dataService.authClient.RevokeSession(firstCtx, &auth.RevokeSessionRequest{SessionId: test.sessionID})
+		if test.expectedErr != "" {
+			requires.Error(err)
+			st, _ := status.FromError(err)
+			asserts.Equal(test.expectedErr, st.Message(), "differen errors")
+			continue
+		}
+		requires.NoError(err, "revoke should be valid")
+	}
+
+	_, err = dataService.client.UserData(firstCtx, &user.UserDataRequest{})
+	requires.Error(err, "token of revoked session should be rejected")
+
+	log.Printf("service_test: Test_Sessions_Service - lost database is not not found")
+
+	token, err = dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	requires.NoError(err, "login should be valid")
+	thirdCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+	list, err = dataService.authClient.ListSessions(thirdCtx, &auth.ListSessionsRequest{})
+	requires.NoError(err)
+	requires.NotEmpty(list.Sessions)
+	dataService.service.DBProvider = &sessionFailProvider{Provider: dataService.provider}
+	_, err = dataService.authClient.RevokeSession(thirdCtx, &auth.RevokeSessionRequest{SessionId: list.Sessions[0].SessionId})
+	dataService.service.DBProvider = dataService.provider
+	code, reason := statusReason(err)
+	asserts.Equal(codes.Unavailable, code)
+	asserts.Equal(ReasonUnavailable, reason)
+
+	log.Printf("service_test: Test_Sessions_Service - long user-agent is cut on a rune")
+
+	decodeUserAgent := func(userAgent string) string {
+		deserialize := deserializer.NewClientDecode()
+		deserialize.Decode(metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", userAgent)))
+		return deserialize.UserAgent
+	}
+	for _, userAgent := range []string{
+		strings.Repeat("Браузер/", 100),
+		"a" + strings.Repeat("日本", 300),
+		strings.Repeat("😀", 200),
+	} {
+		decoded := decodeUserAgent(userAgent)
+		asserts.True(utf8.ValidString(decoded), "user-agent should be valid UTF-8")
+		asserts.LessOrEqual(len(decoded), 512, "user-agent should fit sessions.user_agent")
+		asserts.True(strings.HasPrefix(userAgent, decoded), "user-agent should be cut at the end")
+		asserts.Greater(len(decoded), 508, "only the last rune may be cut")
+	}
+	asserts.Equal("agent/1.0", decodeUserAgent("agent\xff\xfe/1.0"), "invalid UTF-8 should be dropped")
+
+	log.Printf("service_test: Test_Sessions_Service - END")
+}
+
+func Test_PasswordRehash_Service(t *testing.T) {
+	log.Printf("service_test: Test_PasswordRehash_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_PasswordRehash_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	ctx := context.Background()
+	login := newUserLoginRequest()
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(login.Password), bcrypt.MinCost)
+	requires.NoError(err)
+	_, err = dataService.provider.CreateUser(ctx, &model.User{
+		Login:     `avp`,
+		FirstName: `NameTest`,
+		Email:     login.Email,
+		Password:  string(bcryptHash),
+		CreatedAt: time.Now().UTC(),
+	})
+	requires.NoError(err, "user should be created")
+
+	hashOfUser := func() string {
+		u, err := dataService.provider.FindUserByEmail(ctx, login.Email)
+		requires.NoError(err, "user should be found")
+		return u.Password
+	}
+
+	var testData = []struct {
+		title       string
+		cfg         config.PasswordConfig
+		password    string
+		expectedErr error
+		expectedPHC string
+	}{
+		{
+			title:       "wrong password keeps bcrypt",
+			cfg:         testPasswordConfig,
+			password:    "wrongpassword",
+			expectedErr: ErrServicePasswordInvalid,
+			expectedPHC: "$2a$",
+		},
+		{
+			title:       "bcrypt upgraded to argon2id",
+			cfg:         testPasswordConfig,
+			password:    login.Password,
+			expectedPHC: "$argon2id$v=19$m=1024,t=1,p=1$",
+		},
+		{
+			title:       "argon2id with new parameters",
+			cfg:         config.PasswordConfig{Argon2Memory: 2048, Argon2Time: 1, Argon2Threads: 1},
+			password:    login.Password,
+			expectedPHC: "$argon2id$v=19$m=2048,t=1,p=1$",
+		},
+	}
+
+	for _, test := range testData {
+		log.Printf("service_test: Test_PasswordRehash_Service - %s", test.title)
+
+		password.Configure(&test.cfg)
+		_, err := dataService.client.UserLogin(ctx, &user.UserLoginRequest{Email: login.Email, Password: test.password})
+		if test.expectedErr != nil {
+			requires.Error(err)
+			st, _ := status.FromError(err)
+			asserts.Equal(test.expectedErr.Error(), st.Message(), "differen errors")
+		} else {
+			requires.NoError(err, "login should be valid")
+		}
+		asserts.True(strings.HasPrefix(hashOfUser(), test.expectedPHC), "wrong hash of password - %s", hashOfUser())
+	}
+
+	log.Printf("service_test: Test_PasswordRehash_Service - password longer than 72 bytes")
+
+	longPassword := strings.Repeat("p", 72)
+	authCtx := withStepUp(t, dataService, newAuthContext(t, dataService), login.Password)
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, authCtx), &user.UserUpdateRequest{
+		Login:     `avp`,
+		FirstName: `NameTest`,
+		Email:     login.Email,
+		Password:  longPassword + "1",
+		UpdatedAt: timestamppb.New(time.Now().UTC()),
+	})
+	requires.NoError(err, "update should be valid")
+
+	_, err = dataService.client.UserLogin(ctx, &user.UserLoginRequest{Email: login.Email, Password: longPassword + "2"})
+	asserts.Error(err, "all bytes of password should be checked")
+
+	password.Configure(&testPasswordConfig)
+
+	log.Printf("service_test: Test_PasswordRehash_Service - END")
+}
+
+// newAuthContext - login with default user and set token to context
+func newAuthContext(t *testing.T, ds *dataServer) context.Context {
+	token, err := ds.client.UserLogin(context.Background(), newUserLoginRequest())
+	require.NoError(t, err, "login should be valid")
+	return metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+}
+
+// withStepUp - ctx with "x-step-up-token" of StepUp by the current password of user
+func withStepUp(t *testing.T, ds *dataServer, ctx context.Context, pass string) context.Context {
+	resp, err := ds.authClient.StepUp(ctx, &auth.StepUpRequest{Password: pass})
+	require.NoError(t, err, "step-up should be valid")
+	return metadata.AppendToOutgoingContext(ctx, deserializer.HeaderStepUpToken, resp.GetStepUpToken())
+}
+
+// statusReason - code of status and reason of its errdetails.ErrorInfo
+func statusReason(err error) (codes.Code, string) {
+	st, _ := status.FromError(err)
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return st.Code(), info.GetReason()
+		}
+	}
+	return st.Code(), ""
+}
+
+// withVersion - ctx with "if-match" of the current version of user, got from header "etag" of UserData
+func withVersion(t *testing.T, ds *dataServer, ctx context.Context) context.Context {
+	var header metadata.MD
+	_, err := ds.client.UserData(ctx, &user.UserDataRequest{}, grpc.Header(&header))
+	require.NoError(t, err, "user data should be found")
+	require.Len(t, header.Get(serializer.HeaderETag), 1, "version should be in header")
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	md.Set(deserializer.HeaderIfMatch, header.Get(serializer.HeaderETag)[0])
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+func Test_ImportUsers_Service(t *testing.T) {
+	log.Printf("service_test: Test_ImportUsers_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_ImportUsers_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	const pass = `importedpassword`
+	salt := []byte("0123456789abcdef")
+	b64 := base64.RawStdEncoding
+
+	pbkdf2Key := pbkdf2.Key([]byte(pass), salt, 1000, 32, sha256.New)
+	pbkdf2Hash := "$pbkdf2-sha256$i=1000,l=32$" + b64.EncodeToString(salt) + "$" + b64.EncodeToString(pbkdf2Key)
+	passlibHash := "$pbkdf2-sha256$1000$" + strings.ReplaceAll(b64.EncodeToString(salt), "+", ".") +
+		"$" + strings.ReplaceAll(b64.EncodeToString(pbkdf2Key), "+", ".")
+
+	scryptKey, err := scrypt.Key([]byte(pass), salt, 1<<10, 8, 1, 32)
+	requires.NoError(err)
+	scryptHash := "$scrypt$ln=10,r=8,p=1$" + b64.EncodeToString(salt) + "$" + b64.EncodeToString(scryptKey)
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	requires.NoError(err)
+
+	date := time.Now().UTC().Add(-time.Hour)
+	importUser := func(login, hash string) *admin.ImportUser {
+		return &admin.ImportUser{
+			Login:        login,
+			FirstName:    `Imported`,
+			Email:        login + `@example.com`,
+			PasswordHash: hash,
+			CreatedAt:    timestamppb.New(date),
+		}
+	}
+	req := &admin.ImportUsersRequest{Users: []*admin.ImportUser{
+		importUser(`pbkdf2`, pbkdf2Hash),
+		importUser(`passlib`, passlibHash),
+		importUser(`scrypt`, scryptHash),
+		importUser(`bcrypt`, string(bcryptHash)),
+		importUser(`md5`, `$1$salt$hash`),
+		importUser(`pbkdf2`, pbkdf2Hash),
+	}}
+
+	log.Printf("service_test: Test_ImportUsers_Service - admin key")
+
+	for _, key := range []string{"", "wrong-key"} {
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("x-admin-key", key))
+		}
+		_, err := dataService.adminClient.ImportUsers(ctx, req)
+		requires.Error(err)
+		st, _ := status.FromError(err)
+		asserts.Equal(ErrServiceAuthorizationInvalid.Error(), st.Message(), "differen errors")
+	}
+
+	log.Printf("service_test: Test_ImportUsers_Service - import")
+
+	adminCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("x-admin-key", testAdminKey))
+	resp, err := dataService.adminClient.ImportUsers(adminCtx, req)
+	requires.NoError(err, "import should be valid")
+	asserts.Equal(uint32(4), resp.GetImported(), "wrong number of imported users")
+	requires.Len(resp.GetErrors(), 2)
+	asserts.Equal(uint32(4), resp.GetErrors()[0].GetIndex())
+	asserts.Contains(resp.GetErrors()[0].GetMessage(), "password-hash")
+	asserts.Equal(uint32(5), resp.GetErrors()[1].GetIndex())
+	asserts.Equal("login already exists", resp.GetErrors()[1].GetMessage())
+
+	_, err = dataService.adminClient.ImportUsers(adminCtx, &admin.ImportUsersRequest{})
+	asserts.Error(err, "empty import should be rejected")
+
+	log.Printf("service_test: Test_ImportUsers_Service - login with imported hashes")
+
+	for _, login := range []string{`pbkdf2`, `passlib`, `scrypt`, `bcrypt`} {
+		email := login + `@example.com`
+		_, err := dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{Email: email, Password: "wrong"})
+		asserts.Error(err, "wrong password should be rejected - %s", login)
+
+		_, err = dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{Email: email, Password: pass})
+		requires.NoError(err, "login should be valid - %s", login)
+
+		u, err := dataService.provider.FindUserByEmail(context.Background(), email)
+		requires.NoError(err, "user should be found")
+		asserts.True(strings.HasPrefix(u.Password, "$argon2id$"), "hash should be upgraded - %s", login)
+		asserts.True(u.CreatedAt.Equal(date), "created at should be kept - %s", login)
+	}
+
+	log.Printf("service_test: Test_ImportUsers_Service - END")
+}
+
+// writeBreachedFile - sorted file of SHA-1 of passwords in format "<HASH>:<COUNT>"
+func writeBreachedFile(t *testing.T, passwords ...string) string {
+	lines := make([]string, 0, len(passwords)+100)
+	for i := 0; i < 100; i++ {
+		passwords = append(passwords, fmt.Sprintf("breached-%d", i))
+	}
+	for i, pass := range passwords {
+		sum := sha1.Sum([]byte(pass))
+		lines = append(lines, fmt.Sprintf("%X:%d", sum, i+1))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600))
+	return path
+}
+
+func Test_PasswordPolicy_Service(t *testing.T) {
+	log.Printf("service_test: Test_PasswordPolicy_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_PasswordPolicy_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	const breachedPassword = `Xk9#mQ2$vL7!pR`
+
+	cfg := testPasswordConfig
+	cfg.MinLength = 10
+	cfg.MinClasses = 3
+	cfg.MinStrength = 3
+	cfg.DisallowPersonal = true
+	cfg.BreachedFile = writeBreachedFile(t, breachedPassword, "breached-first", "zzz")
+	requires.NoError(password.Configure(&cfg))
+	defer func() {
+		requires.NoError(password.Configure(&testPasswordConfig))
+	}()
+
+	var testData = []struct {
+		title       string
+		password    string
+		expectedErr error
+	}{
+		{
+			title:       "short and simple",
+			password:    "short",
+			expectedErr: errors.New(`deserializer: invalid signup - {password:too short; too few character classes; too weak}`),
+		},
+		{
+			title:       "common words",
+			password:    "Password1234",
+			expectedErr: errors.New(`deserializer: invalid signup - {password:too weak}`),
+		},
+		{
+			title:       "keyboard pattern",
+			password:    "Qwertyuiop1!",
+			expectedErr: errors.New(`deserializer: invalid signup - {password:too weak}`),
+		},
+		{
+			title:       "personal data",
+			password:    "Kx8#Policy$2vQ",
+			expectedErr: errors.New(`deserializer: invalid signup - {password:contains personal data}`),
+		},
+		{
+			title:       "breached password",
+			password:    breachedPassword,
+			expectedErr: errors.New(`deserializer: invalid signup - {password:found in breached passwords}`),
+		},
+		{
+			title:    "valid password",
+			password: `Xk9#mQ2$vL7!pZ`,
+		},
+	}
+
+	for _, test := range testData {
+		log.Printf("service_test: Test_PasswordPolicy_Service - %s", test.title)
+
+		_, err := dataService.client.UserRegister(context.Background(), &user.UserRegisterRequest{
+			Login:     `policy`,
+			FirstName: `Tester`,
+			Email:     `policy@example.com`,
+			Password:  test.password,
+			CreatedAt: timestamppb.Now(),
+		})
+		if test.expectedErr != nil {
+			requires.Error(err)
+			st, _ := status.FromError(err)
+			asserts.Equal(test.expectedErr.Error(), st.Message(), "differen errors")
+		} else {
+			requires.NoError(err, "register should be valid")
+		}
+	}
+
+	log.Printf("service_test: Test_PasswordPolicy_Service - update")
+
+	token, err := dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{
+		Email:    `policy@example.com`,
+		Password: `Xk9#mQ2$vL7!pZ`,
+	})
+	requires.NoError(err, "login should be valid")
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+	ctx = withStepUp(t, dataService, ctx, `Xk9#mQ2$vL7!pZ`)
+
+	update := &user.UserUpdateRequest{
+		Login:     `policy`,
+		FirstName: `Tester`,
+		Email:     `policy@example.com`,
+		Password:  "breached-first",
+		UpdatedAt: timestamppb.Now(),
+	}
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, ctx), update)
+	requires.Error(err)
+	st, _ := status.FromError(err)
+	asserts.Equal(`deserializer: invalid user update - {password:too few character classes; found in breached passwords}`, st.Message())
+
+	update.Password = ""
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, ctx), update)
+	asserts.NoError(err, "update without password should be valid")
+
+	log.Printf("service_test: Test_PasswordPolicy_Service - update of only password is checked with stored user")
+
+	maskCtx := metadata.AppendToOutgoingContext(ctx, deserializer.HeaderUpdateMask, "password")
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, maskCtx), &user.UserUpdateRequest{Password: "Kx8#Policy$2vQ"})
+	requires.Error(err)
+	st, _ = status.FromError(err)
+	asserts.Equal(`deserializer: invalid user update - {password:contains personal data}`, st.Message(), "login of stored user")
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, maskCtx), &user.UserUpdateRequest{Password: "Kx8#Tester$2vQ"})
+	requires.Error(err)
+	st, _ = status.FromError(err)
+	asserts.Equal(`deserializer: invalid user update - {password:contains personal data}`, st.Message(), "first name of stored user")
+
+	maskCtx = metadata.AppendToOutgoingContext(ctx, deserializer.HeaderUpdateMask, "login,password")
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, maskCtx), &user.UserUpdateRequest{Login: `zebrafish`, Password: "Kx8#Zebrafish$2vQ"})
+	requires.Error(err)
+	st, _ = status.FromError(err)
+	asserts.Equal(`deserializer: invalid user update - {password:contains personal data}`, st.Message(), "new login of request")
+
+	log.Printf("service_test: Test_PasswordPolicy_Service - END")
+}
+
+func Test_Lockout_Service(t *testing.T) {
+	log.Printf("service_test: Test_Lockout_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_Lockout_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	const backoff = 20 * time.Millisecond
+	dataService.service.lockout = newLockoutPolicy(config.LoginConfig{
+		MaxFailures:  3,
+		LockDuration: time.Hour,
+		Backoff:      backoff,
+	})
+
+	ctx := context.Background()
+	_, err = dataService.client.UserRegister(ctx, newUserRegisterRequest(time.Now().UTC()))
+	requires.NoError(err, "user should be registered")
+
+	login := func(email, pass string) error {
+		_, err := dataService.client.UserLogin(ctx, &user.UserLoginRequest{Email: email, Password: pass})
+		return err
+	}
+	requireLocked := func(err error, msg string) {
+		requires.Error(err, msg)
+		st, _ := status.FromError(err)
+		asserts.Equal(codes.ResourceExhausted, st.Code(), msg)
+		asserts.Equal(ErrServiceLoginLocked.Error(), st.Message(), msg)
+	}
+	valid := newUserLoginRequest()
+
+	log.Printf("service_test: Test_Lockout_Service - back-off and lock")
+
+	asserts.Error(login(valid.Email, "wrongpassword"), "first failure")
+	asserts.Error(login(valid.Email, "wrongpassword"), "first failure is without delay")
+	requireLocked(login(valid.Email, valid.Password), "back-off after the second failure")
+
+	time.Sleep(backoff + 5*time.Millisecond)
+	asserts.Error(login(strings.ToUpper(valid.Email), "wrongpassword"), "third failure with other case of email")
+	time.Sleep(4*backoff + 5*time.Millisecond)
+	requireLocked(login(valid.Email, valid.Password), "login is locked after 'MaxFailures'")
+
+	log.Printf("service_test: Test_Lockout_Service - not existing email")
+
+	const alien = `alien@example.com`
+	for i := 0; i < 3; i++ {
+		err := login(alien, "wrongpassword")
+		requires.Error(err)
+		st, _ := status.FromError(err)
+		asserts.NotEqual(codes.ResourceExhausted, st.Code(), "not locked yet")
+		time.Sleep(time.Duration(1<<i)*backoff + 5*time.Millisecond)
+	}
+	requireLocked(login(alien, "wrongpassword"), "not existing email is locked the same way")
+
+	log.Printf("service_test: Test_Lockout_Service - unlock")
+
+	_, err = dataService.adminClient.UnlockUser(ctx, &admin.UnlockUserRequest{Email: valid.Email})
+	requires.Error(err, "admin key is required")
+
+	adminCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("x-admin-key", testAdminKey))
+	_, err = dataService.adminClient.UnlockUser(adminCtx, &admin.UnlockUserRequest{Email: "invalid"})
+	requires.Error(err, "email should be valid")
+
+	_, err = dataService.adminClient.UnlockUser(adminCtx, &admin.UnlockUserRequest{Email: valid.Email})
+	requires.NoError(err, "unlock should be valid")
+	requires.NoError(login(valid.Email, valid.Password), "login after unlock")
+
+	attempt, err := dataService.provider.FindLoginAttempt(ctx, valid.Email)
+	requires.NoError(err)
+	asserts.Zero(attempt.FailedCount, "failures are forgotten")
+	asserts.Nil(attempt.LockedUntil, "lock is removed")
+
+	log.Printf("service_test: Test_Lockout_Service - parallel logins don't pass 'MaxFailures'")
+
+	// every login reads failures before the others are counted, as parallel logins do
+	dataService.service.DBProvider = &staleLockoutProvider{Provider: dataService.provider}
+	checked := 0
+	for i := 0; i < 5; i++ {
+		st, _ := status.FromError(login(valid.Email, "wrongpassword"))
+		if st.Code() != codes.ResourceExhausted {
+			checked++
+		}
+	}
+	requireLocked(login(valid.Email, valid.Password), "login is locked")
+	dataService.service.DBProvider = dataService.provider
+	asserts.Equal(3, checked, "only 'MaxFailures' passwords are checked")
+	attempt, err = dataService.provider.FindLoginAttempt(ctx, valid.Email)
+	requires.NoError(err)
+	asserts.NotNil(attempt.LockedUntil, "email is locked")
+	asserts.GreaterOrEqual(attempt.FailedCount, 3, "lock keeps failures")
+
+	log.Printf("service_test: Test_Lockout_Service - END")
+}
+
+// staleLockoutProvider - store which reads no failed logins
+type staleLockoutProvider struct {
+	db.Provider
+}
+
+func (slp *staleLockoutProvider) FindLoginAttempt(_ context.Context, email string) (*model.LoginAttempt, error) {
+	return &model.LoginAttempt{Email: email}, nil
+}
+
+func Test_RateLimit_Service(t *testing.T) {
+	log.Printf("service_test: Test_RateLimit_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_RateLimit_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	ctx, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC())
+	requires.NoError(err, "user should be created")
+
+	dataService.service.limiter = ratelimit.NewLimiter(&config.RateLimitConfig{
+		Default: "3/1h",
+		Methods: map[string]string{"UserLogin": "2/1m"},
+	})
+
+	requireLimited := func(err error, trailer metadata.MD, msg string) {
+		requires.Error(err, msg)
+		st, _ := status.FromError(err)
+		asserts.Equal(codes.ResourceExhausted, st.Code(), msg)
+		asserts.Equal(ErrServiceRateLimited.Error(), st.Message(), msg)
+		requires.Len(trailer.Get("retry-after"), 1, msg)
+	}
+
+	log.Printf("service_test: Test_RateLimit_Service - limit of method")
+
+	for i := 0; i < 2; i++ {
+		_, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+		requires.NoError(err, "login should be in limit")
+	}
+	var trailer metadata.MD
+	_, err = dataService.client.UserLogin(context.Background(), newUserLoginRequest(), grpc.Trailer(&trailer))
+	requireLimited(err, trailer, "third login in a minute")
+	asserts.Equal([]string{"30"}, trailer.Get("retry-after"), "token of '2/1m' comes in 30 seconds")
+
+	log.Printf("service_test: Test_RateLimit_Service - default limit, methods have own buckets")
+
+	for i := 0; i < 3; i++ {
+		_, err := dataService.client.UserData(ctx, &user.UserDataRequest{})
+		requires.NoError(err, "user data should be in limit")
+	}
+	_, err = dataService.client.UserData(ctx, &user.UserDataRequest{}, grpc.Trailer(&trailer))
+	requireLimited(err, trailer, "fourth user data in an hour")
+	asserts.Equal([]string{"1200"}, trailer.Get("retry-after"))
+
+	log.Printf("service_test: Test_RateLimit_Service - rejected authorization")
+
+	dataService.service.limiter = ratelimit.NewLimiter(&config.RateLimitConfig{
+		Default: "1000/s",
+		Methods: map[string]string{ratelimit.AuthFailure: "2/1m"},
+	})
+	wrongKeyCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(deserializer.HeaderAdminKey, "wrong-key"))
+	for i := 0; i < 2; i++ {
+		_, err := dataService.adminClient.UnlockUser(wrongKeyCtx, &admin.UnlockUserRequest{Email: `test@example.com`})
+		code, _ := statusReason(err)
+		requires.Equal(codes.Unauthenticated, code, "wrong admin key")
+	}
+	_, err = dataService.adminClient.UnlockUser(wrongKeyCtx, &admin.UnlockUserRequest{Email: `test@example.com`}, grpc.Trailer(&trailer))
+	requireLimited(err, trailer, "third wrong admin key in a minute")
+	rightKeyCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(deserializer.HeaderAdminKey, testAdminKey))
+	_, err = dataService.adminClient.UnlockUser(rightKeyCtx, &admin.UnlockUserRequest{Email: `test@example.com`}, grpc.Trailer(&trailer))
+	requireLimited(err, trailer, "right key is not checked while limited")
+	_, err = dataService.client.UserData(
+		metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer forged")),
+		&user.UserDataRequest{})
+	code, _ := statusReason(err)
+	asserts.Equal(codes.ResourceExhausted, code, "bearer token of limited IP is not checked")
+	_, err = dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	asserts.NoError(err, "methods without authorization are not limited by rejected authorization")
+
+	log.Printf("service_test: Test_RateLimit_Service - END")
+}
+
+func Test_ErrorStatus_Service(t *testing.T) {
+	log.Printf("service_test: Test_ErrorStatus_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_ErrorStatus_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	ctx := context.Background()
+	_, err = dataService.client.UserRegister(ctx, newUserRegisterRequest(time.Now().UTC()))
+	requires.NoError(err, "user should be registered")
+
+	reasonOf := func(st *status.Status) string {
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				asserts.Equal(ErrorDomain, info.GetDomain())
+				return info.GetReason()
+			}
+		}
+		return ""
+	}
+
+	var testData = []struct {
+		title          string
+		call           func() error
+		expectedCode   codes.Code
+		expectedReason string
+	}{
+		{
+			title: "validation of request",
+			call: func() error {
+				_, err := dataService.client.UserRegister(ctx, &user.UserRegisterRequest{})
+				return err
+			},
+			expectedCode:   codes.InvalidArgument,
+			expectedReason: ReasonValidationFailed,
+		},
+		{
+			title: "user already exists",
+			call: func() error {
+				_, err := dataService.client.UserRegister(ctx, newUserRegisterRequest(time.Now().UTC()))
+				return err
+			},
+			expectedCode:   codes.AlreadyExists,
+			expectedReason: ReasonAlreadyExists,
+		},
+		{
+			title: "email not found",
+			call: func() error {
+				_, err := dataService.client.UserLogin(ctx, &user.UserLoginRequest{Email: `alien@example.com`, Password: `somepass`})
+				return err
+			},
+			expectedCode:   codes.NotFound,
+			expectedReason: ReasonNotFound,
+		},
+		{
+			title: "wrong password",
+			call: func() error {
+				_, err := dataService.client.UserLogin(ctx, &user.UserLoginRequest{Email: `test@example.com`, Password: `wrongpass`})
+				return err
+			},
+			expectedCode:   codes.Unauthenticated,
+			expectedReason: ReasonPasswordInvalid,
+		},
+		{
+			title: "without token",
+			call: func() error {
+				_, err := dataService.client.UserData(ctx, &user.UserDataRequest{})
+				return err
+			},
+			expectedCode:   codes.Unauthenticated,
+			expectedReason: ReasonAuthorizationInvalid,
+		},
+	}
+
+	for _, test := range testData {
+		log.Printf("service_test: Test_ErrorStatus_Service - %s", test.title)
+
+		err := test.call()
+		requires.Error(err)
+		st, ok := status.FromError(err)
+		requires.True(ok, "error should be a status")
+		asserts.Equal(test.expectedCode, st.Code(), test.title)
+		asserts.Equal(test.expectedReason, reasonOf(st), test.title)
+	}
+
+	log.Printf("service_test: Test_ErrorStatus_Service - errors of database and unknown errors")
+
+	var testErrors = []struct {
+		err             error
+		expectedCode    codes.Code
+		expectedMessage string
+	}{
+		{fmt.Errorf("find: %w", db.ErrDBNotFound), codes.NotFound, ErrServiceNotFound.Error()},
+		{fmt.Errorf("%w: unique violation", db.ErrDBEmailTaken), codes.AlreadyExists, ErrServiceAlreadyExists.Error()},
+		{fmt.Errorf("%w: connection refused", db.ErrDBUnavailable), codes.Unavailable, ErrServiceUnavailable.Error()},
+		{errors.New("connection refused"), codes.Internal, ErrServiceInternal.Error()},
+		{status.Error(codes.Unavailable, "unavailable"), codes.Unavailable, "unavailable"},
+	}
+	for _, test := range testErrors {
+		st := toStatus(test.err)
+		asserts.Equal(test.expectedCode, st.Code(), test.err.Error())
+		asserts.Equal(test.expectedMessage, st.Message(), test.err.Error())
+	}
+
+	log.Printf("service_test: Test_ErrorStatus_Service - END")
+}
+
+func Test_BadRequest_Service(t *testing.T) {
+	log.Printf("service_test: Test_BadRequest_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_BadRequest_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	violationsOf := func(err error) [][3]string {
+		requires.Error(err)
+		st, _ := status.FromError(err)
+		asserts.Equal(codes.InvalidArgument, st.Code())
+		violations := [][3]string{}
+		for _, detail := range st.Details() {
+			if br, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range br.GetFieldViolations() {
+					violations = append(violations, [3]string{v.GetField(), v.GetReason(), v.GetDescription()})
+				}
+			}
+		}
+		return violations
+	}
+
+	log.Printf("service_test: Test_BadRequest_Service - register")
+
+	_, err = dataService.client.UserRegister(context.Background(), &user.UserRegisterRequest{
+		Email:     `invalid`,
+		Password:  `short`,
+		CreatedAt: timestamppb.New(time.Now().UTC().Add(time.Hour)),
+	})
+	asserts.Equal([][3]string{
+		{"email", "INVALID", "invalid"},
+		{"first_name", "EMPTY", "empty"},
+		{"login", "EMPTY", "empty"},
+		{"password", "TOO_SHORT", "too short"},
+	}, violationsOf(err))
+
+	log.Printf("service_test: Test_BadRequest_Service - login")
+
+	_, err = dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{Email: `test@example.com`})
+	asserts.Equal([][3]string{{"password", "EMPTY", "empty"}}, violationsOf(err))
+
+	log.Printf("service_test: Test_BadRequest_Service - update, every reason of password policy")
+
+	ctx, err := dataService.createDataFroAutirizationWithContext(time.Now().UTC())
+	requires.NoError(err, "user should be created")
+
+	cfg := testPasswordConfig
+	cfg.MinClasses = 3
+	requires.NoError(password.Configure(&cfg))
+	defer func() {
+		requires.NoError(password.Configure(&testPasswordConfig))
+	}()
+
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, ctx), &user.UserUpdateRequest{
+		Login:     `avp`,
+		FirstName: `NameTest`,
+		Email:     `test@example.com`,
+		Password:  `abc`,
+		UpdatedAt: timestamppb.Now(),
+	})
+	asserts.Equal([][3]string{
+		{"password", "TOO_SHORT", "too short"},
+		{"password", "TOO_FEW_CHARACTER_CLASSES", "too few character classes"},
+	}, violationsOf(err))
+
+	log.Printf("service_test: Test_BadRequest_Service - END")
+}
+
+func Test_AlreadyExists_Service(t *testing.T) {
+	log.Printf("service_test: Test_AlreadyExists_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_AlreadyExists_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC()))
+	requires.NoError(err, "user should be registered")
+	other := newUserRegisterRequest(time.Now().UTC())
+	other.Login, other.Email = `other`, `other@example.com`
+	_, err = dataService.client.UserRegister(context.Background(), other)
+	requires.NoError(err, "other user should be registered")
+
+	fieldOf := func(err error) string {
+		requires.Error(err)
+		st, _ := status.FromError(err)
+		asserts.Equal(codes.AlreadyExists, st.Code())
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				asserts.Equal(ReasonAlreadyExists, info.GetReason())
+				return info.GetMetadata()[MetadataField]
+			}
+		}
+		return ""
+	}
+
+	log.Printf("service_test: Test_AlreadyExists_Service - register")
+
+	req := newUserRegisterRequest(time.Now().UTC())
+	req.Email = `new@example.com`
+	_, err = dataService.client.UserRegister(context.Background(), req)
+	asserts.Equal("login", fieldOf(err), "login is taken")
+
+	req = newUserRegisterRequest(time.Now().UTC())
+	req.Login = `new`
+	_, err = dataService.client.UserRegister(context.Background(), req)
+	asserts.Equal("email", fieldOf(err), "email is taken")
+
+	log.Printf("service_test: Test_AlreadyExists_Service - update")
+
+	authCtx := withStepUp(t, dataService, newAuthContext(t, dataService), `testpassword`)
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, authCtx), &user.UserUpdateRequest{
+		Login:     `avp`,
+		FirstName: `NameTest`,
+		Email:     `other@example.com`,
+		UpdatedAt: timestamppb.Now(),
+	})
+	asserts.Equal("email", fieldOf(err), "email of other user is taken")
+
+	log.Printf("service_test: Test_AlreadyExists_Service - errors of database")
+
+	var testErrors = []struct {
+		err             error
+		expectedCode    codes.Code
+		expectedMessage string
+	}{
+		{fmt.Errorf("%w: unique violation", db.ErrDBLoginTaken), codes.AlreadyExists, "login already exists"},
+		{fmt.Errorf("%w: %w", db.ErrDBUnavailable, errors.New("connection refused")), codes.Unavailable, ErrServiceUnavailable.Error()},
+		{errors.New("bad query"), codes.Internal, ErrServiceInternal.Error()},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), codes.DeadlineExceeded, "query: context deadline exceeded"},
+	}
+	for _, test := range testErrors {
+		st := toStatus(userWriteError(test.err))
+		asserts.Equal(test.expectedCode, st.Code(), test.err.Error())
+		asserts.Equal(test.expectedMessage, st.Message(), test.err.Error())
+	}
+
+	log.Printf("service_test: Test_AlreadyExists_Service - END")
+}
+
+func Test_UpdateMask_Service(t *testing.T) {
+	log.Printf("service_test: Test_UpdateMask_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_UpdateMask_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	register := newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	register.LastName = `Doe`
+	_, err = dataService.client.UserRegister(context.Background(), register)
+	requires.NoError(err, "user should be registered")
+	authCtx := withStepUp(t, dataService, newAuthContext(t, dataService), `testpassword`)
+
+	userData := func() *user.User {
+		resp, err := dataService.client.UserData(authCtx, &user.UserDataRequest{})
+		requires.NoError(err, "user data should be found")
+		return resp.GetUser()
+	}
+	maskCtx := func(mask string) context.Context {
+		return metadata.AppendToOutgoingContext(withVersion(t, dataService, authCtx), deserializer.HeaderUpdateMask, mask)
+	}
+
+	log.Printf("service_test: Test_UpdateMask_Service - only last name, other fields are not sent")
+
+	_, err = dataService.client.UserUpdate(maskCtx("last_name"), &user.UserUpdateRequest{
+		LastName:  `Fox`,
+		UpdatedAt: timestamppb.New(time.Now().UTC().Add(-30 * time.Minute)),
+	})
+	requires.NoError(err, "masked update should be valid")
+	data := userData()
+	asserts.Equal(`Fox`, data.GetLastName())
+	asserts.Equal(register.Login, data.GetLogin(), "login is out of mask")
+	asserts.Equal(register.FirstName, data.GetFirstName(), "first name is out of mask")
+	asserts.Equal(register.Email, data.GetEmail(), "email is out of mask")
+
+	log.Printf("service_test: Test_UpdateMask_Service - clear last name, field out of mask is ignored")
+
+	_, err = dataService.client.UserUpdate(maskCtx("last_name"), &user.UserUpdateRequest{
+		FirstName: `Ignored`,
+		UpdatedAt: timestamppb.New(time.Now().UTC().Add(-20 * time.Minute)),
+	})
+	requires.NoError(err, "masked update should be valid")
+	data = userData()
+	asserts.Empty(data.GetLastName(), "last name should be cleared")
+	asserts.Equal(register.FirstName, data.GetFirstName(), "first name is out of mask")
+
+	log.Printf("service_test: Test_UpdateMask_Service - password")
+
+	_, err = dataService.client.UserUpdate(maskCtx("password"), &user.UserUpdateRequest{
+		Password:  `newpassword`,
+		UpdatedAt: timestamppb.New(time.Now().UTC().Add(-10 * time.Minute)),
+	})
+	requires.NoError(err, "masked update of password should be valid")
+	_, err = dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{Email: register.Email, Password: `newpassword`})
+	asserts.NoError(err, "new password should be valid")
+
+	log.Printf("service_test: Test_UpdateMask_Service - invalid")
+
+	var testData = []struct {
+		mask        string
+		data        *user.UserUpdateRequest
+		expectedErr string
+	}{
+		{
+			mask:        "middle_name",
+			data:        &user.UserUpdateRequest{UpdatedAt: timestamppb.Now()},
+			expectedErr: `deserializer: invalid update mask - {update-mask:invalid}`,
+		},
+		{
+			mask:        "updated_at",
+			data:        &user.UserUpdateRequest{UpdatedAt: timestamppb.Now()},
+			expectedErr: `deserializer: invalid update mask - {update-mask:invalid}`,
+		},
+		{
+			mask:        " , ",
+			data:        &user.UserUpdateRequest{UpdatedAt: timestamppb.Now()},
+			expectedErr: `deserializer: invalid update mask - {update-mask:invalid}`,
+		},
+		{
+			mask:        "login, email, password",
+			data:        &user.UserUpdateRequest{Email: `invalid`, UpdatedAt: timestamppb.Now()},
+			expectedErr: `deserializer: invalid user update - {email:invalid},{login:empty},{password:empty}`,
+		},
+	}
+	for _, test := range testData {
+		_, err := dataService.client.UserUpdate(maskCtx(test.mask), test.data)
+		requires.Error(err, test.mask)
+		st, _ := status.FromError(err)
+		asserts.Equal(codes.InvalidArgument, st.Code(), test.mask)
+		asserts.Equal(test.expectedErr, st.Message(), test.mask)
+	}
+
+	log.Printf("service_test: Test_UpdateMask_Service - END")
+}
+
+func Test_Version_Service(t *testing.T) {
+	log.Printf("service_test: Test_Version_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_Version_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC().Add(-time.Hour)))
+	requires.NoError(err, "user should be registered")
+	authCtx := withStepUp(t, dataService, newAuthContext(t, dataService), `testpassword`)
+
+	ifMatch := func(version string) context.Context {
+		return metadata.AppendToOutgoingContext(authCtx, deserializer.HeaderIfMatch, version)
+	}
+	update := func(ctx context.Context, header *metadata.MD) error {
+		_, err := dataService.client.UserUpdate(ctx, &user.UserUpdateRequest{
+			LastName:  `Doe`,
+			UpdatedAt: timestamppb.Now(),
+		}, grpc.Header(header))
+		return err
+	}
+
+	log.Printf("service_test: Test_Version_Service - version of new user")
+
+	var header metadata.MD
+	_, err = dataService.client.UserData(authCtx, &user.UserDataRequest{}, grpc.Header(&header))
+	requires.NoError(err)
+	asserts.Equal([]string{"1"}, header.Get(serializer.HeaderETag))
+
+	log.Printf("service_test: Test_Version_Service - update increments version")
+
+	authCtx = metadata.AppendToOutgoingContext(authCtx, deserializer.HeaderUpdateMask, "last_name")
+	header = nil
+	requires.NoError(update(ifMatch(`"1"`), &header), "update with the current version should be valid")
+	asserts.Equal([]string{"2"}, header.Get(serializer.HeaderETag))
+
+	log.Printf("service_test: Test_Version_Service - stale and missing version")
+
+	err = update(ifMatch("1"), &header)
+	code, reason := statusReason(err)
+	asserts.Equal(codes.FailedPrecondition, code, "stale version")
+	asserts.Equal(ReasonVersionMismatch, reason)
+
+	err = update(authCtx, &header)
+	code, reason = statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code, "missing version")
+	asserts.Equal(ReasonValidationFailed, reason)
+
+	err = update(ifMatch("two"), &header)
+	code, _ = statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code, "invalid version")
+
+	_, err = dataService.client.UserDelete(ifMatch("1"), &user.UserDeleteRequest{})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.FailedPrecondition, code, "delete with stale version")
+	asserts.Equal(ReasonVersionMismatch, reason)
+
+	log.Printf("service_test: Test_Version_Service - user changed between read and write")
+
+	code, reason = statusReason(toStatus(userWriteError(db.ErrDBVersionConflict)).Err())
+	asserts.Equal(codes.Aborted, code)
+	asserts.Equal(ReasonVersionConflict, reason)
+
+	_, err = dataService.client.UserDelete(ifMatch("2"), &user.UserDeleteRequest{})
+	asserts.NoError(err, "delete with the current version should be valid")
+
+	log.Printf("service_test: Test_Version_Service - END")
+}
+
+func Test_ServerTimestamps_Service(t *testing.T) {
+	log.Printf("service_test: Test_ServerTimestamps_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_ServerTimestamps_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	log.Printf("service_test: Test_ServerTimestamps_Service - created_at of request is ignored")
+
+	start := time.Now().UTC()
+	backdated := start.Add(-365 * 24 * time.Hour)
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(backdated))
+	requires.NoError(err, "user should be registered")
+	register := newUserRegisterRequest(start.Add(time.Hour))
+	register.Login, register.Email = `future`, `future@example.com`
+	_, err = dataService.client.UserRegister(context.Background(), register)
+	asserts.NoError(err, "created_at in future is ignored")
+
+	authCtx := newAuthContext(t, dataService)
+	resp, err := dataService.client.UserData(authCtx, &user.UserDataRequest{})
+	requires.NoError(err)
+	createdAt := resp.GetUser().GetCreatedAt().AsTime()
+	asserts.False(createdAt.Before(start), "created_at should be assigned by server")
+	asserts.Nil(resp.GetUser().GetUpdatedAt())
+
+	log.Printf("service_test: Test_ServerTimestamps_Service - updated_at of request is ignored")
+
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, authCtx), &user.UserUpdateRequest{
+		Login:     `avp`,
+		FirstName: `NameTest`,
+		Email:     `test@example.com`,
+		UpdatedAt: timestamppb.New(backdated),
+	})
+	requires.NoError(err, "update with old updated_at should be valid")
+	_, err = dataService.client.UserUpdate(withVersion(t, dataService, authCtx), &user.UserUpdateRequest{
+		Login:     `avp`,
+		FirstName: `NameTest`,
+		Email:     `test@example.com`,
+	})
+	requires.NoError(err, "update without updated_at should be valid")
+
+	resp, err = dataService.client.UserData(authCtx, &user.UserDataRequest{})
+	requires.NoError(err)
+	requires.NotNil(resp.GetUser().GetUpdatedAt())
+	asserts.False(resp.GetUser().GetUpdatedAt().AsTime().Before(createdAt), "updated_at should be assigned by server")
+	asserts.Equal(createdAt, resp.GetUser().GetCreatedAt().AsTime(), "created_at should not be changed")
+
+	log.Printf("service_test: Test_ServerTimestamps_Service - END")
+}
+
+func Test_Transaction_Service(t *testing.T) {
+	log.Printf("service_test: Test_Transaction_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_Transaction_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC()))
+	requires.NoError(err, "user should be registered")
+	authCtx := newAuthContext(t, dataService)
+	failCommit := dataService.provider.(interface{ FailCommit(err error) }).FailCommit
+
+	log.Printf("service_test: Test_Transaction_Service - error of fn rolls back all writes")
+
+	ctx := context.Background()
+	errFn := errors.New("fn error")
+	err = dataService.provider.WithTx(ctx, func(tx db.Provider) error {
+		id, err := tx.CreateUser(ctx, &model.User{Login: `tx`, FirstName: `Tx`, Email: `tx@example.com`})
+		if err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(nested db.Provider) error {
+			return nested.SetTokensValidAfter(ctx, id, time.Now().UTC())
+		})
+	})
+	requires.NoError(err, "transaction should be committed")
+	_, err = dataService.provider.FindUserByEmail(ctx, `tx@example.com`)
+	requires.NoError(err, "user of committed transaction should be found")
+
+	err = dataService.provider.WithTx(ctx, func(tx db.Provider) error {
+		if _, err := tx.CreateUser(ctx, &model.User{Login: `rollback`, FirstName: `Tx`, Email: `rollback@example.com`}); err != nil {
+			return err
+		}
+		return errFn
+	})
+	asserts.ErrorIs(err, errFn)
+	_, err = dataService.provider.FindUserByEmail(ctx, `rollback@example.com`)
+	asserts.Error(err, "user of rolled back transaction should not be found")
+
+	log.Printf("service_test: Test_Transaction_Service - failed commit of UserUpdate")
+
+	update := func() error {
+		_, err := dataService.client.UserUpdate(
+			metadata.AppendToOutgoingContext(withVersion(t, dataService, authCtx), deserializer.HeaderUpdateMask, "last_name"),
+			&user.UserUpdateRequest{LastName: `Doe`},
+		)
+		return err
+	}
+	lastName := func() string {
+		resp, err := dataService.client.UserData(authCtx, &user.UserDataRequest{})
+		requires.NoError(err)
+		return resp.GetUser().GetLastName()
+	}
+
+	failCommit(db.ErrDBUnavailable)
+	err = update()
+	st, _ := status.FromError(err)
+	asserts.Equal(codes.Unavailable, st.Code(), "lost database on commit")
+	asserts.Empty(lastName(), "update should be rolled back")
+
+	failCommit(errors.New("serialization failure"))
+	err = update()
+	st, _ = status.FromError(err)
+	asserts.Equal(codes.Internal, st.Code(), "serialization failure after all retries")
+	asserts.Empty(lastName(), "update should be rolled back")
+
+	requires.NoError(update(), "update should be committed")
+	asserts.Equal(`Doe`, lastName())
+
+	log.Printf("service_test: Test_Transaction_Service - END")
+}
+
+func Test_Idempotency_Service(t *testing.T) {
+	log.Printf("service_test: Test_Idempotency_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_Idempotency_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	withKey := func(ctx context.Context, key string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, deserializer.HeaderIdempotencyKey, key)
+	}
+
+	log.Printf("service_test: Test_Idempotency_Service - retry of register gets the first response")
+
+	register := newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	var header metadata.MD
+	first, err := dataService.client.UserRegister(withKey(context.Background(), "register-1"), register, grpc.Header(&header))
+	requires.NoError(err, "user should be registered")
+	asserts.Empty(header.Get(serializer.HeaderIdempotentReplayed), "first response is not replayed")
+
+	header = nil
+	retry, err := dataService.client.UserRegister(withKey(context.Background(), "register-1"), register, grpc.Header(&header))
+	requires.NoError(err, "retry should get the first response and not already exists")
+	asserts.Equal(first.GetUserId(), retry.GetUserId())
+	asserts.Equal([]string{"true"}, header.Get(serializer.HeaderIdempotentReplayed))
+
+	log.Printf("service_test: Test_Idempotency_Service - key with other request")
+
+	other := newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	other.Login, other.Email = `other`, `other@example.com`
+	_, err = dataService.client.UserRegister(withKey(context.Background(), "register-1"), other)
+	code, reason := statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code)
+	asserts.Equal(ReasonIdempotencyKeyReused, reason)
+
+	_, err = dataService.client.UserRegister(withKey(context.Background(), ""), other)
+	code, reason = statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code, "empty key")
+	asserts.Equal(ReasonValidationFailed, reason)
+
+	log.Printf("service_test: Test_Idempotency_Service - failed request frees the key")
+
+	_, err = dataService.client.UserRegister(withKey(context.Background(), "register-2"), register)
+	code, _ = statusReason(err)
+	asserts.Equal(codes.AlreadyExists, code, "login and email are taken")
+	second, err := dataService.client.UserRegister(withKey(context.Background(), "register-2"), other)
+	requires.NoError(err, "key of failed request can be used again")
+	asserts.NotEqual(first.GetUserId(), second.GetUserId())
+
+	log.Printf("service_test: Test_Idempotency_Service - retry of update gets header of the first response")
+
+	authCtx := withVersion(t, dataService, newAuthContext(t, dataService))
+	authCtx = metadata.AppendToOutgoingContext(authCtx, deserializer.HeaderUpdateMask, "last_name")
+	update := &user.UserUpdateRequest{LastName: `Doe`}
+	var firstHeader, retryHeader metadata.MD
+	_, err = dataService.client.UserUpdate(withKey(authCtx, "update-1"), update, grpc.Header(&firstHeader))
+	requires.NoError(err, "update with the current version should be valid")
+	_, err = dataService.client.UserUpdate(withKey(authCtx, "update-1"), update, grpc.Header(&retryHeader))
+	requires.NoError(err, "retry with the old version should be replayed and not rejected")
+	asserts.Equal(firstHeader.Get(serializer.HeaderETag), retryHeader.Get(serializer.HeaderETag))
+	asserts.Equal([]string{"true"}, retryHeader.Get(serializer.HeaderIdempotentReplayed))
+
+	log.Printf("service_test: Test_Idempotency_Service - key of not stored response is taken after lease")
+
+	third := newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	third.Login, third.Email = `third`, `third@example.com`
+	failProvider := &saveFailProvider{Provider: dataService.provider}
+	dataService.service.DBProvider = failProvider
+	_, err = dataService.client.UserRegister(withKey(context.Background(), "register-3"), third)
+	dataService.service.DBProvider = dataService.provider
+	requires.NoError(err, "response is returned even if it is not stored")
+	requires.NotNil(failProvider.reserved)
+	asserts.False(failProvider.reserved.LockedUntil.After(time.Now().UTC().Add(idempotencyLease)), "lease is not longer than idempotencyLease")
+	_, err = dataService.client.UserRegister(withKey(context.Background(), "register-3"), third)
+	code, reason = statusReason(err)
+	asserts.Equal(codes.Aborted, code, "key is in progress during lease")
+	asserts.Equal(ReasonIdempotencyInProgress, reason)
+
+	expired := *failProvider.reserved
+	expired.CreatedAt = expired.CreatedAt.Add(-2 * idempotencyLease)
+	expired.LockedUntil = expired.CreatedAt.Add(idempotencyLease)
+	requires.NoError(dataService.provider.RemoveIdempotencyKey(context.Background(), expired.Key, expired.Scope))
+	stored, err := dataService.provider.ReserveIdempotencyKey(context.Background(), &expired)
+	requires.NoError(err)
+	requires.Nil(stored)
+	_, err = dataService.client.UserRegister(withKey(context.Background(), "register-3"), third)
+	code, _ = statusReason(err)
+	asserts.Equal(codes.AlreadyExists, code, "request after lease takes the key and is done again")
+	fourth := newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	fourth.Login, fourth.Email = `fourth`, `fourth@example.com`
+	_, err = dataService.client.UserRegister(withKey(context.Background(), "register-3"), fourth)
+	requires.NoError(err, "failed request after lease frees the key")
+
+	log.Printf("service_test: Test_Idempotency_Service - keys of not authorized clients never meet")
+
+	calls := 0
+	registerHandler := func(ctx context.Context, req any) (any, error) {
+		calls++
+		return &user.UserRegisterResponse{UserId: uint64(calls)}, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/" + user.UserService_ServiceDesc.ServiceName + "/UserRegister"}
+	fromPeer := func(ip string, md ...string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000}})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(append([]string{deserializer.HeaderIdempotencyKey, "shared"}, md...)...))
+	}
+	register = newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	register.Login, register.Email = `peer`, `peer@example.com`
+	resp, err := dataService.service.Idempotency(fromPeer("10.0.0.1"), register, info, registerHandler)
+	requires.NoError(err)
+	asserts.Equal(uint64(1), resp.(*user.UserRegisterResponse).GetUserId())
+	resp, err = dataService.service.Idempotency(fromPeer("10.0.0.2"), register, info, registerHandler)
+	requires.NoError(err, "the same key of other peer is other key")
+	asserts.Equal(uint64(2), resp.(*user.UserRegisterResponse).GetUserId(), "response of other peer is not replayed")
+	_, err = dataService.service.Idempotency(fromPeer("10.0.0.3"), other, info, registerHandler)
+	requires.NoError(err, "other request of other peer is not key reused")
+	asserts.Equal(3, calls)
+	resp, err = dataService.service.Idempotency(fromPeer("10.0.0.1"), register, info, registerHandler)
+	requires.NoError(err)
+	asserts.Equal(uint64(1), resp.(*user.UserRegisterResponse).GetUserId(), "retry of the same peer is replayed")
+	resp, err = dataService.service.Idempotency(fromPeer("10.0.0.1", deserializer.HeaderClientID, "client-b"), register, info, registerHandler)
+	requires.NoError(err)
+	asserts.Equal(uint64(4), resp.(*user.UserRegisterResponse).GetUserId(), "other client behind the same IP is other key")
+	_, err = dataService.service.Idempotency(fromPeer("10.0.0.1", deserializer.HeaderClientID, "client\tb"), register, info, registerHandler)
+	var validation *deserializer.ValidationError
+	asserts.ErrorAs(err, &validation, "client id is printable ASCII")
+
+	log.Printf("service_test: Test_Idempotency_Service - END")
+}
+
+// saveFailProvider - store which can't save responses of idempotency keys, the last reserved key is kept
+type saveFailProvider struct {
+	db.Provider
+	reserved *model.IdempotencyKey
+}
+
+func (sfp *saveFailProvider) ReserveIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	keyCopy := *key
+	sfp.reserved = &keyCopy
+	return sfp.Provider.ReserveIdempotencyKey(ctx, key)
+}
+
+func (sfp *saveFailProvider) SaveIdempotencyResponse(context.Context, *model.IdempotencyKey) error {
+	return db.ErrDBUnavailable
+}
+
+// blockMailer - Send waits for close of release
+type blockMailer struct {
+	*mailer.Memory
+	release chan struct{}
+}
+
+func (bm *blockMailer) Send(ctx context.Context, msg mailer.Message) error {
+	select {
+	case <-bm.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return bm.Memory.Send(ctx, msg)
+}
+
+// mailToken - token or code of the last mail, body contains it on the third line
+func mailToken(t *testing.T, mail *mailer.Memory) string {
+	messages := mail.Messages()
+	require.NotEmpty(t, messages, "mail should be sent")
+	lines := strings.Split(messages[len(messages)-1].Body, "\n")
+	require.Greater(t, len(lines), 2, "mail should contain token")
+	return lines[2]
+}
+
+// mailTokenTo - token from the last mail sent to address
+func mailTokenTo(t *testing.T, mail *mailer.Memory, to string) string {
+	messages := mail.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To == to {
+			lines := strings.Split(messages[i].Body, "\n")
+			require.Greater(t, len(lines), 2, "mail should contain token")
+			return lines[2]
+		}
+	}
+	require.FailNow(t, "mail should be sent", to)
+	return ""
+}
+
+func Test_EmailVerification_Service(t *testing.T) {
+	log.Printf("service_test: Test_EmailVerification_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	mail := mailer.NewMemory()
+	dataService, err := newDataServer(func(dep *Depends) { dep.Mailer = mail })
+	if err != nil {
+		log.Printf("service_test: Test_EmailVerification_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	verify := func(token string) error {
+		_, err := dataService.authClient.VerifyEmail(context.Background(), &auth.VerifyEmailRequest{Token: token})
+		return err
+	}
+	emailVerified := func(ctx context.Context) []string {
+		var header metadata.MD
+		_, err := dataService.client.UserData(ctx, &user.UserDataRequest{}, grpc.Header(&header))
+		requires.NoError(err)
+		return header.Get(serializer.HeaderEmailVerified)
+	}
+
+	log.Printf("service_test: Test_EmailVerification_Service - register sends mail")
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC().Add(-time.Hour)))
+	requires.NoError(err, "user should be registered")
+	requires.Len(mail.Messages(), 1)
+	asserts.Equal(`test@example.com`, mail.Messages()[0].To)
+	firstToken := mailToken(t, mail)
+
+	authCtx := newAuthContext(t, dataService)
+	asserts.Equal([]string{"false"}, emailVerified(authCtx))
+
+	log.Printf("service_test: Test_EmailVerification_Service - resend replaces token")
+
+	_, err = dataService.authClient.SendEmailVerification(authCtx, &auth.SendEmailVerificationRequest{})
+	requires.NoError(err, "mail should be sent again")
+	requires.Len(mail.Messages(), 2)
+	token := mailToken(t, mail)
+	code, reason := statusReason(verify(firstToken))
+	asserts.Equal(codes.InvalidArgument, code, "token of the first mail is replaced")
+	asserts.Equal(ReasonTokenInvalid, reason)
+
+	log.Printf("service_test: Test_EmailVerification_Service - login requires verified email")
+
+	dataService.service.User.RequireVerifiedEmail = true
+	_, err = dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	code, reason = statusReason(err)
+	asserts.Equal(codes.FailedPrecondition, code)
+	asserts.Equal(ReasonEmailNotVerified, reason)
+
+	log.Printf("service_test: Test_EmailVerification_Service - token is used once")
+
+	requires.NoError(verify(token), "token of the last mail should be valid")
+	code, reason = statusReason(verify(token))
+	asserts.Equal(codes.InvalidArgument, code, "used token")
+	asserts.Equal(ReasonTokenInvalid, reason)
+
+	authCtx = newAuthContext(t, dataService)
+	asserts.Equal([]string{"true"}, emailVerified(authCtx))
+	_, err = dataService.authClient.SendEmailVerification(authCtx, &auth.SendEmailVerificationRequest{})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.FailedPrecondition, code)
+	asserts.Equal(ReasonEmailVerified, reason)
+
+	log.Printf("service_test: Test_EmailVerification_Service - tokens of other purpose")
+
+	md, _ := metadata.FromOutgoingContext(authCtx)
+	accessToken := strings.TrimPrefix(md.Get("authorization")[0], "bearer ")
+	code, _ = statusReason(verify(accessToken))
+	asserts.Equal(codes.InvalidArgument, code, "access token is not a token of verification")
+
+	verifyToken, _, err := jwtsign.PurposeTokenGenerator("1", model.PurposeVerifyEmail, time.Hour, nil)
+	requires.NoError(err)
+	_, err = dataService.client.UserData(
+		metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+verifyToken)),
+		&user.UserDataRequest{})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code, "token of verification is not an access token")
+
+	log.Printf("service_test: Test_EmailVerification_Service - confirmed new email is verified")
+
+	dataService.service.User.RequireVerifiedEmail = false
+	_, err = dataService.client.UserUpdate(
+		metadata.AppendToOutgoingContext(
+			withVersion(t, dataService, withStepUp(t, dataService, authCtx, `testpassword`)), deserializer.HeaderUpdateMask, "email"),
+		&user.UserUpdateRequest{Email: `new@example.com`},
+	)
+	requires.NoError(err, "email should be updated")
+	asserts.Equal([]string{"true"}, emailVerified(authCtx), "email is not changed before confirmation")
+	_, err = dataService.authClient.ConfirmEmailChange(context.Background(),
+		&auth.ConfirmEmailChangeRequest{Token: mailTokenTo(t, mail, `new@example.com`)})
+	requires.NoError(err, "new email should be confirmed")
+	asserts.Equal([]string{"true"}, emailVerified(authCtx), "new email got the token")
+	code, _ = statusReason(verify(token))
+	asserts.Equal(codes.InvalidArgument, code)
+
+	log.Printf("service_test: Test_EmailVerification_Service - END")
+}
+
+func Test_PasswordReset_Service(t *testing.T) {
+	log.Printf("service_test: Test_PasswordReset_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	mail := mailer.NewMemory()
+	dataService, err := newDataServer(func(dep *Depends) { dep.Mailer = mail })
+	if err != nil {
+		log.Printf("service_test: Test_PasswordReset_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	requestReset := func(email string) error {
+		_, err := dataService.authClient.RequestPasswordReset(context.Background(), &auth.RequestPasswordResetRequest{Email: email})
+		dataService.service.Wait()
+		return err
+	}
+	confirmReset := func(code, newPassword string) error {
+		_, err := dataService.authClient.ConfirmPasswordReset(context.Background(), &auth.ConfirmPasswordResetRequest{
+			Code:        code,
+			NewPassword: newPassword,
+		})
+		return err
+	}
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC().Add(-time.Hour)))
+	requires.NoError(err, "user should be registered")
+	authCtx := newAuthContext(t, dataService)
+	sent := len(mail.Messages())
+
+	log.Printf("service_test: Test_PasswordReset_Service - the same answer for unknown email")
+
+	requires.NoError(requestReset(`unknown@example.com`), "unknown email should not be shown")
+	asserts.Len(mail.Messages(), sent, "mail should not be sent to unknown email")
+	code, reason := statusReason(requestReset(`not an email`))
+	asserts.Equal(codes.InvalidArgument, code)
+	asserts.Equal(ReasonValidationFailed, reason)
+
+	log.Printf("service_test: Test_PasswordReset_Service - answer before mail")
+
+	blocked := &blockMailer{Memory: mail, release: make(chan struct{})}
+	dataService.service.Mailer = blocked
+	_, err = dataService.authClient.RequestPasswordReset(context.Background(), &auth.RequestPasswordResetRequest{Email: `test@example.com`})
+	requires.NoError(err, "answer should not wait for mail")
+	asserts.Len(mail.Messages(), sent, "mail is sent after the answer")
+	close(blocked.release)
+	dataService.service.Wait()
+	requires.Len(mail.Messages(), sent+1)
+	dataService.service.Mailer = mail
+	sent++
+
+	log.Printf("service_test: Test_PasswordReset_Service - code of the last mail")
+
+	requires.NoError(requestReset(`test@example.com`))
+	firstCode := mailToken(t, mail)
+	requires.NoError(requestReset(`test@example.com`))
+	requires.Len(mail.Messages(), sent+2)
+	resetCode := mailToken(t, mail)
+	asserts.NotEqual(firstCode, resetCode)
+	code, reason = statusReason(confirmReset(firstCode, `newpassword1`))
+	asserts.Equal(codes.InvalidArgument, code, "code of the first mail is replaced")
+	asserts.Equal(ReasonTokenInvalid, reason)
+
+	log.Printf("service_test: Test_PasswordReset_Service - invalid password keeps the code")
+
+	code, reason = statusReason(confirmReset(resetCode, `short`))
+	asserts.Equal(codes.InvalidArgument, code)
+	asserts.Equal(ReasonValidationFailed, reason)
+	cfg := testPasswordConfig
+	cfg.DisallowPersonal = true
+	requires.NoError(password.Configure(&cfg))
+	code, reason = statusReason(confirmReset(resetCode, `NameTest2024`))
+	requires.NoError(password.Configure(&testPasswordConfig))
+	asserts.Equal(codes.InvalidArgument, code, "password with name of user")
+	asserts.Equal(ReasonValidationFailed, reason)
+
+	log.Printf("service_test: Test_PasswordReset_Service - reset revokes sessions")
+
+	requires.NoError(confirmReset(resetCode, `newpassword1`), "code should be valid")
+	_, err = dataService.client.UserData(authCtx, &user.UserDataRequest{})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code, "tokens issued before reset are revoked")
+
+	_, err = dataService.client.UserLogin(context.Background(), newUserLoginRequest())
+	code, _ = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code, "old password")
+
+	token, err := dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{
+		Email:    `test@example.com`,
+		Password: `newpassword1`,
+	})
+	requires.NoError(err, "login with new password")
+	authCtx = metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token.Token))
+	var header metadata.MD
+	_, err = dataService.client.UserData(authCtx, &user.UserDataRequest{}, grpc.Header(&header))
+	requires.NoError(err)
+	asserts.Equal([]string{"true"}, header.Get(serializer.HeaderEmailVerified), "email got the code")
+
+	log.Printf("service_test: Test_PasswordReset_Service - code is used once")
+
+	code, reason = statusReason(confirmReset(resetCode, `otherpassword1`))
+	asserts.Equal(codes.InvalidArgument, code)
+	asserts.Equal(ReasonTokenInvalid, reason)
+
+	log.Printf("service_test: Test_PasswordReset_Service - END")
+}
+
+func Test_StepUp_Service(t *testing.T) {
+	log.Printf("service_test: Test_StepUp_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer()
+	if err != nil {
+		log.Printf("service_test: Test_StepUp_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC().Add(-time.Hour)))
+	requires.NoError(err, "user should be registered")
+	authCtx := newAuthContext(t, dataService)
+
+	updateEmail := func(ctx context.Context, email string) error {
+		_, err := dataService.client.UserUpdate(
+			metadata.AppendToOutgoingContext(withVersion(t, dataService, ctx), deserializer.HeaderUpdateMask, "email"),
+			&user.UserUpdateRequest{Email: email},
+		)
+		return err
+	}
+	withProof := func(ctx context.Context, key, value string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, key, value)
+	}
+
+	log.Printf("service_test: Test_StepUp_Service - sensitive change without proof")
+
+	code, reason := statusReason(updateEmail(authCtx, `new@example.com`))
+	asserts.Equal(codes.PermissionDenied, code)
+	asserts.Equal(ReasonStepUpRequired, reason)
+	requires.NoError(updateEmail(authCtx, `test@example.com`), "the same email is not a change")
+	_, err = dataService.client.UserUpdate(
+		metadata.AppendToOutgoingContext(withVersion(t, dataService, authCtx), deserializer.HeaderUpdateMask, "last_name"),
+		&user.UserUpdateRequest{LastName: `Doe`},
+	)
+	requires.NoError(err, "last name is not sensitive")
+	_, err = dataService.client.UserDelete(withVersion(t, dataService, authCtx), &user.UserDeleteRequest{})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.PermissionDenied, code, "delete needs proof")
+	asserts.Equal(ReasonStepUpRequired, reason)
+
+	log.Printf("service_test: Test_StepUp_Service - current password")
+
+	code, reason = statusReason(updateEmail(withProof(authCtx, deserializer.HeaderCurrentPassword, `wrongpassword`), `new@example.com`))
+	asserts.Equal(codes.Unauthenticated, code)
+	asserts.Equal(ReasonPasswordInvalid, reason)
+	requires.NoError(updateEmail(withProof(authCtx, deserializer.HeaderCurrentPassword, `testpassword`), `new@example.com`))
+
+	log.Printf("service_test: Test_StepUp_Service - step-up token")
+
+	_, err = dataService.authClient.StepUp(authCtx, &auth.StepUpRequest{Password: `wrongpassword`})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code)
+	asserts.Equal(ReasonPasswordInvalid, reason)
+	_, err = dataService.authClient.StepUp(authCtx, &auth.StepUpRequest{})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code)
+	_, err = dataService.authClient.StepUp(context.Background(), &auth.StepUpRequest{Password: `testpassword`})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code, "step-up needs access token")
+
+	resp, err := dataService.authClient.StepUp(authCtx, &auth.StepUpRequest{Password: `testpassword`})
+	requires.NoError(err)
+	asserts.WithinDuration(time.Now().Add(defaultStepUpTTL), resp.GetExpiresAt().AsTime(), 2*time.Second)
+	stepUpCtx := withProof(authCtx, deserializer.HeaderStepUpToken, resp.GetStepUpToken())
+	requires.NoError(updateEmail(stepUpCtx, `test@example.com`), "token is valid more than once")
+
+	log.Printf("service_test: Test_StepUp_Service - token of other session, purpose or expired")
+
+	otherSessionCtx := newAuthContext(t, dataService)
+	code, reason = statusReason(updateEmail(withProof(otherSessionCtx, deserializer.HeaderStepUpToken, resp.GetStepUpToken()), `new@example.com`))
+	asserts.Equal(codes.PermissionDenied, code, "token of other session")
+	asserts.Equal(ReasonStepUpRequired, reason)
+
+	md, _ := metadata.FromOutgoingContext(authCtx)
+	accessToken := strings.TrimPrefix(md.Get("authorization")[0], "bearer ")
+	code, _ = statusReason(updateEmail(withProof(authCtx, deserializer.HeaderStepUpToken, accessToken), `new@example.com`))
+	asserts.Equal(codes.PermissionDenied, code, "access token is not a step-up token")
+	_, err = dataService.client.UserData(
+		metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+resp.GetStepUpToken())),
+		&user.UserDataRequest{})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code, "step-up token is not an access token")
+
+	dataService.service.stepUpTTL = -time.Minute
+	expired, err := dataService.authClient.StepUp(authCtx, &auth.StepUpRequest{Password: `testpassword`})
+	requires.NoError(err)
+	dataService.service.stepUpTTL = defaultStepUpTTL
+	code, _ = statusReason(updateEmail(withProof(authCtx, deserializer.HeaderStepUpToken, expired.GetStepUpToken()), `new@example.com`))
+	asserts.Equal(codes.PermissionDenied, code, "expired token")
+
+	log.Printf("service_test: Test_StepUp_Service - delete with step-up token")
+
+	_, err = dataService.client.UserDelete(withVersion(t, dataService, stepUpCtx), &user.UserDeleteRequest{})
+	requires.NoError(err, "delete with proof should be valid")
+
+	log.Printf("service_test: Test_StepUp_Service - END")
+}
+
+func Test_EmailChange_Service(t *testing.T) {
+	log.Printf("service_test: Test_EmailChange_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	mail := mailer.NewMemory()
+	dataService, err := newDataServer(func(dep *Depends) { dep.Mailer = mail })
+	if err != nil {
+		log.Printf("service_test: Test_EmailChange_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC().Add(-time.Hour)))
+	requires.NoError(err, "user should be registered")
+	other := newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	other.Login, other.Email = `other`, `other@example.com`
+	_, err = dataService.client.UserRegister(context.Background(), other)
+	requires.NoError(err, "other user should be registered")
+	authCtx := withStepUp(t, dataService, newAuthContext(t, dataService), `testpassword`)
+
+	update := func(mask string, req *user.UserUpdateRequest, header *metadata.MD) error {
+		_, err := dataService.client.UserUpdate(
+			metadata.AppendToOutgoingContext(withVersion(t, dataService, authCtx), deserializer.HeaderUpdateMask, mask),
+			req, grpc.Header(header))
+		return err
+	}
+	confirm := func(token string) error {
+		_, err := dataService.authClient.ConfirmEmailChange(context.Background(), &auth.ConfirmEmailChangeRequest{Token: token})
+		return err
+	}
+	userData := func() (*user.User, metadata.MD) {
+		var header metadata.MD
+		resp, err := dataService.client.UserData(authCtx, &user.UserDataRequest{}, grpc.Header(&header))
+		requires.NoError(err, "user data should be found")
+		return resp.GetUser(), header
+	}
+
+	log.Printf("service_test: Test_EmailChange_Service - new email is pending")
+
+	_, before := userData()
+	var header metadata.MD
+	requires.NoError(update("email", &user.UserUpdateRequest{Email: `new@example.com`}, &header))
+	asserts.Equal([]string{`new@example.com`}, header.Get(serializer.HeaderPendingEmail))
+	asserts.Equal(before.Get(serializer.HeaderETag), header.Get(serializer.HeaderETag), "user is not written")
+	data, _ := userData()
+	asserts.Equal(`test@example.com`, data.GetEmail(), "email is changed only by confirmation")
+
+	messages := mail.Messages()
+	requires.GreaterOrEqual(len(messages), 2)
+	notice := messages[len(messages)-1]
+	asserts.Equal(`test@example.com`, notice.To, "notice to the current email")
+	asserts.Contains(notice.Body, `n***@example.com`)
+	firstToken := mailTokenTo(t, mail, `new@example.com`)
+	asserts.NotContains(notice.Body, firstToken, "token is sent only to the new email")
+
+	_, err = dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{Email: `new@example.com`, Password: `testpassword`})
+	requires.Error(err, "login by not confirmed email")
+
+	log.Printf("service_test: Test_EmailChange_Service - email of other user")
+
+	err = update("email", &user.UserUpdateRequest{Email: `other@example.com`}, &header)
+	code, reason := statusReason(err)
+	asserts.Equal(codes.AlreadyExists, code)
+	asserts.Equal(ReasonAlreadyExists, reason)
+
+	log.Printf("service_test: Test_EmailChange_Service - other fields are written, the last change is valid")
+
+	header = metadata.MD{}
+	requires.NoError(update("email,last_name", &user.UserUpdateRequest{Email: `new2@example.com`, LastName: `Doe`}, &header))
+	asserts.Equal([]string{`new2@example.com`}, header.Get(serializer.HeaderPendingEmail))
+	data, _ = userData()
+	asserts.Equal(`Doe`, data.GetLastName())
+	asserts.Equal(`test@example.com`, data.GetEmail())
+	code, reason = statusReason(confirm(firstToken))
+	asserts.Equal(codes.InvalidArgument, code, "token of replaced change")
+	asserts.Equal(ReasonTokenInvalid, reason)
+
+	log.Printf("service_test: Test_EmailChange_Service - email taken before confirmation")
+
+	secondToken := mailTokenTo(t, mail, `new2@example.com`)
+	taker := newUserRegisterRequest(time.Now().UTC().Add(-time.Hour))
+	taker.Login, taker.Email = `taker`, `new2@example.com`
+	_, err = dataService.client.UserRegister(context.Background(), taker)
+	requires.NoError(err, "pending email is not taken")
+	code, _ = statusReason(confirm(secondToken))
+	asserts.Equal(codes.AlreadyExists, code, "email is unique")
+
+	log.Printf("service_test: Test_EmailChange_Service - confirmation")
+
+	requires.NoError(update("email", &user.UserUpdateRequest{Email: `new3@example.com`}, &header))
+	thirdToken := mailTokenTo(t, mail, `new3@example.com`)
+	requires.NoError(confirm(thirdToken), "token should be valid")
+	data, header = userData()
+	asserts.Equal(`new3@example.com`, data.GetEmail())
+	asserts.Equal([]string{"true"}, header.Get(serializer.HeaderEmailVerified))
+	_, err = dataService.client.UserLogin(context.Background(), &user.UserLoginRequest{Email: `new3@example.com`, Password: `testpassword`})
+	requires.NoError(err, "login by the new email")
+	code, _ = statusReason(confirm(thirdToken))
+	asserts.Equal(codes.InvalidArgument, code, "token is used once")
+
+	verifyToken, _, err := jwtsign.PurposeTokenGenerator("1", model.PurposeVerifyEmail, time.Hour, nil)
+	requires.NoError(err)
+	code, _ = statusReason(confirm(verifyToken))
+	asserts.Equal(codes.InvalidArgument, code, "token of other purpose")
+	code, _ = statusReason(confirm(""))
+	asserts.Equal(codes.InvalidArgument, code)
+
+	log.Printf("service_test: Test_EmailChange_Service - END")
+}
+
+// testMFAConfig - one key of encryption of TOTP secrets
+var testMFAConfig = config.MFAConfig{
+	EncryptionKeys: map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))},
+}
+
+func Test_MFA_Service(t *testing.T) {
+	log.Printf("service_test: Test_MFA_Service - START")
+
+	asserts := assert.New(t)
+	requires := require.New(t)
+
+	dataService, err := newDataServer(func(dep *Depends) { dep.MFA = testMFAConfig })
+	if err != nil {
+		log.Printf("service_test: Test_MFA_Service newDataServer error - {%v};", err)
+		return
+	}
+	defer dataService.close(t)
+
+	_, err = dataService.client.UserRegister(context.Background(), newUserRegisterRequest(time.Now().UTC().Add(-time.Hour)))
+	requires.NoError(err, "user should be registered")
+	authCtx := newAuthContext(t, dataService)
+
+	login := func() (string, metadata.MD) {
+		var header metadata.MD
+		resp, err := dataService.client.UserLogin(context.Background(), newUserLoginRequest(), grpc.Header(&header))
+		requires.NoError(err, "password should be valid")
+		return resp.GetToken(), header
+	}
+	verify := func(req *auth.VerifyMFARequest) (*auth.VerifyMFAResponse, metadata.MD, error) {
+		var header metadata.MD
+		resp, err := dataService.authClient.VerifyMFA(context.Background(), req, grpc.Header(&header))
+		return resp, header, err
+	}
+
+	log.Printf("service_test: Test_MFA_Service - keys of encryption")
+
+	dep := NewDepends(dataService.provider)
+	dep.MFA = config.MFAConfig{EncryptionKeys: map[string]string{"k1": "short"}}
+	_, err = NewService(dep)
+	asserts.ErrorIs(err, seal.ErrSealKeyInvalid, "service should not start with invalid key")
+	dep.MFA = config.MFAConfig{EncryptionKeys: testMFAConfig.EncryptionKeys, CurrentKeyID: "k2"}
+	_, err = NewService(dep)
+	asserts.ErrorIs(err, seal.ErrSealKeyUnknown, "service should not start with unknown current key")
+
+	log.Printf("service_test: Test_MFA_Service - enroll")
+
+	keyring := dataService.service.mfa.keyring
+	dataService.service.mfa.keyring = nil
+	_, err = dataService.authClient.EnrollMFA(withStepUp(t, dataService, authCtx, `testpassword`), &auth.EnrollMFARequest{})
+	code, reason := statusReason(err)
+	asserts.Equal(codes.FailedPrecondition, code, "no keys of encryption")
+	asserts.Equal(ReasonMFANotConfigured, reason)
+	dataService.service.mfa.keyring = keyring
+
+	_, err = dataService.authClient.EnrollMFA(authCtx, &auth.EnrollMFARequest{})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.PermissionDenied, code, "enroll needs proof")
+	asserts.Equal(ReasonStepUpRequired, reason)
+	_, err = dataService.authClient.ConfirmMFA(authCtx, &auth.ConfirmMFARequest{Code: `123456`})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.FailedPrecondition, code, "confirm without enroll")
+	asserts.Equal(ReasonMFANotEnabled, reason)
+
+	enrolled, err := dataService.authClient.EnrollMFA(withStepUp(t, dataService, authCtx, `testpassword`), &auth.EnrollMFARequest{})
+	requires.NoError(err)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrolled.GetSecret())
+	requires.NoError(err, "secret should be base32")
+	asserts.Equal(`otpauth://totp/go-postgres-grpc-user-dir:test@example.com?algorithm=SHA1&digits=6&issuer=go-postgres-grpc-user-dir&period=30&secret=`+enrolled.GetSecret(),
+		enrolled.GetOtpauthUri())
+	stored, err := dataService.provider.FindMFA(context.Background(), 1)
+	requires.NoError(err)
+	asserts.True(strings.HasPrefix(stored.Secret, "k1:"), "secret is stored encrypted")
+	asserts.NotContains(stored.Secret, enrolled.GetSecret())
+
+	_, header := login()
+	asserts.Empty(header.Get(serializer.HeaderMFARequired), "not confirmed TOTP is not required")
+
+	log.Printf("service_test: Test_MFA_Service - confirm")
+
+	now := time.Now()
+	_, err = dataService.authClient.ConfirmMFA(authCtx, &auth.ConfirmMFARequest{Code: `12345`})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code, "code of 5 digits")
+	_, err = dataService.authClient.ConfirmMFA(authCtx, &auth.ConfirmMFARequest{Code: totp.Code(secret, now.Add(-time.Hour))})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code, "code of other time")
+	asserts.Equal(ReasonMFACodeInvalid, reason)
+
+	confirmed, err := dataService.authClient.ConfirmMFA(authCtx, &auth.ConfirmMFARequest{Code: totp.Code(secret, now)})
+	requires.NoError(err)
+	requires.Len(confirmed.GetRecoveryCodes(), defaultRecoveryCodes)
+	for _, recoveryCode := range confirmed.GetRecoveryCodes() {
+		asserts.Regexp(`^[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}$`, recoveryCode)
+	}
+	_, err = dataService.authClient.ConfirmMFA(authCtx, &auth.ConfirmMFARequest{Code: totp.Code(secret, now)})
+	_, reason = statusReason(err)
+	asserts.Equal(ReasonMFAEnabled, reason)
+	_, err = dataService.authClient.EnrollMFA(withStepUp(t, dataService, authCtx, `testpassword`), &auth.EnrollMFARequest{})
+	_, reason = statusReason(err)
+	asserts.Equal(ReasonMFAEnabled, reason, "enabled TOTP is not replaced")
+
+	log.Printf("service_test: Test_MFA_Service - login with code")
+
+	challenge, header := login()
+	requires.Equal([]string{"true"}, header.Get(serializer.HeaderMFARequired))
+	asserts.Empty(header.Get(serializer.HeaderRefreshToken), "session is not started")
+	_, err = dataService.client.UserData(
+		metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+challenge)),
+		&user.UserDataRequest{})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.Unauthenticated, code, "challenge is not an access token")
+
+	_, _, err = verify(&auth.VerifyMFARequest{ChallengeToken: challenge, Code: totp.Code(secret, now)})
+	_, reason = statusReason(err)
+	asserts.Equal(ReasonMFACodeInvalid, reason, "code of confirmation is used")
+	_, _, err = verify(&auth.VerifyMFARequest{ChallengeToken: challenge, Code: `123456`, RecoveryCode: confirmed.GetRecoveryCodes()[0]})
+	code, _ = statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code, "only one of codes")
+
+	nextCode := totp.Code(secret, now.Add(totp.Period))
+	verified, header, err := verify(&auth.VerifyMFARequest{ChallengeToken: challenge, Code: nextCode})
+	requires.NoError(err, "challenge is kept after wrong code")
+	asserts.Len(header.Get(serializer.HeaderRefreshToken), 1)
+	_, err = dataService.client.UserData(
+		metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "bearer "+verified.GetToken())),
+		&user.UserDataRequest{})
+	requires.NoError(err, "token of VerifyMFA is an access token")
+	_, _, err = verify(&auth.VerifyMFARequest{ChallengeToken: challenge, Code: nextCode})
+	code, reason = statusReason(err)
+	asserts.Equal(codes.InvalidArgument, code, "challenge is used once")
+	asserts.Equal(ReasonTokenInvalid, reason)
+
+	log.Printf("service_test: Test_MFA_Service - login with recovery code")
+
+	recoveryCode := strings.ToUpper(confirmed.GetRecoveryCodes()[0])
+	challenge, _ = login()
+	_, _, err = verify(&auth.VerifyMFARequest{ChallengeToken: challenge, RecoveryCode: recoveryCode})
+	requires.NoError(err, "recovery code in upper case")
+	challenge, _ = login()
+	_, _, err = verify(&auth.VerifyMFARequest{ChallengeToken: challenge, RecoveryCode: recoveryCode})
+	_, reason = statusReason(err)
+	asserts.Equal(ReasonMFACodeInvalid, reason, "recovery code is used once")
+
+	log.Printf("service_test: Test_MFA_Service - disable")
+
+	_, err = dataService.authClient.DisableMFA(authCtx, &auth.DisableMFARequest{RecoveryCode: confirmed.GetRecoveryCodes()[1]})
+	_, reason = statusReason(err)
+	asserts.Equal(ReasonStepUpRequired, reason, "disable needs proof")
+	stepUpCtx := withStepUp(t, dataService, authCtx, `testpassword`)
+	_, err = dataService.authClient.DisableMFA(stepUpCtx, &auth.DisableMFARequest{RecoveryCode: confirmed.GetRecoveryCodes()[0]})
+	_, reason = statusReason(err)
+	asserts.Equal(ReasonMFACodeInvalid, reason)
+	_, err = dataService.authClient.DisableMFA(stepUpCtx, &auth.DisableMFARequest{RecoveryCode: confirmed.GetRecoveryCodes()[1]})
+	requires.NoError(err)
+
+	_, header = login()
+	asserts.Empty(header.Get(serializer.HeaderMFARequired), "TOTP is disabled")
+	asserts.Len(header.Get(serializer.HeaderRefreshToken), 1)
+	_, _, err = verify(&auth.VerifyMFARequest{ChallengeToken: challenge, RecoveryCode: confirmed.GetRecoveryCodes()[2]})
+	_, reason = statusReason(err)
+	asserts.Equal(ReasonMFANotEnabled, reason)
+
+	log.Printf("service_test: Test_MFA_Service - END")
+}
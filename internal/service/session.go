@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// ListSessions - rules for getting active sessions of user
+// decode claims of the current token and user ID from ctx
+// find not revoked sessions in database, mark the session of the current token
+func (s *service) ListSessions(
+	ctx context.Context,
+	_ *auth.ListSessionsRequest) (*auth.ListSessionsResponse, error) {
+	deserializeClaims := deserializer.NewClaimsDecode()
+	if err := deserializeClaims.Decode(ctx); err != nil {
+		log.Printf("service: ListSessions Decode claims error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: ListSessions Decode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	sessions, err := s.DBProvider.ListSessionsByUserID(ctx, deserializeUserID.UserID())
+	if err != nil {
+		log.Printf("service: ListSessionsByUserID error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	serialize := serializer.SessionListEncode{Sessions: sessions, CurrentID: deserializeClaims.Claims().SessionID()}
+
+	return serialize.Response(), nil
+}
+
+// RevokeSession - rules for ending one session of user
+// decode session ID from request, user ID from ctx
+// revoke the session and the family of its refresh tokens, not active session of user -> ErrServiceNotFound
+func (s *service) RevokeSession(
+	ctx context.Context,
+	req *auth.RevokeSessionRequest) (*auth.RevokeSessionResponse, error) {
+	deserializeReq := deserializer.NewRevokeSessionDecode()
+	if err := deserializeReq.Decode(req); err != nil {
+		return nil, err
+	}
+
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: RevokeSession Decode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	now := time.Now().UTC()
+	userID := deserializeUserID.UserID()
+	err := s.DBProvider.RevokeSession(ctx, userID, deserializeReq.ID(), now)
+	switch {
+	case errors.Is(err, db.ErrDBNotFound):
+		return nil, ErrServiceNotFound
+	case err != nil:
+		log.Printf("service: RevokeSession error - {%v};", err)
+		return nil, sessionError(err)
+	}
+	if err := s.revokeSessions(ctx, userID, []string{deserializeReq.ID()}, now); err != nil {
+		return nil, ErrServiceInternal
+	}
+
+	return &auth.RevokeSessionResponse{}, nil
+}
+
+// RevokeOtherSessions - rules for ending all sessions of user except the current one
+// decode claims of the current token and user ID from ctx
+// revoke other sessions and families of their refresh tokens
+func (s *service) RevokeOtherSessions(
+	ctx context.Context,
+	_ *auth.RevokeOtherSessionsRequest) (*auth.RevokeOtherSessionsResponse, error) {
+	deserializeClaims := deserializer.NewClaimsDecode()
+	if err := deserializeClaims.Decode(ctx); err != nil {
+		log.Printf("service: RevokeOtherSessions Decode claims error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: RevokeOtherSessions Decode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	now := time.Now().UTC()
+	userID := deserializeUserID.UserID()
+	sessionIDs, err := s.DBProvider.RevokeSessionsByUserID(ctx, userID, deserializeClaims.Claims().SessionID(), now)
+	if err != nil {
+		log.Printf("service: RevokeSessionsByUserID error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	if err := s.revokeSessions(ctx, userID, sessionIDs, now); err != nil {
+		return nil, ErrServiceInternal
+	}
+
+	return &auth.RevokeOtherSessionsResponse{Revoked: uint32(len(sessionIDs))}, nil
+}
+
+// startSession - write a new session of user with IP and user-agent of client
+func (s *service) startSession(ctx context.Context, userID uint, sessionID string, authTime time.Time) error {
+	deserialize := deserializer.NewClientDecode()
+	deserialize.Decode(ctx)
+
+	if err := s.DBProvider.CreateSession(ctx, &model.Session{
+		ID:         sessionID,
+		UserID:     userID,
+		CreatedAt:  authTime,
+		LastSeenAt: time.Now().UTC(),
+		ClientIP:   deserialize.ClientIP,
+		UserAgent:  deserialize.UserAgent,
+	}); err != nil {
+		log.Printf("service: startSession CreateSession error - {%v};", err)
+		return err
+	}
+	return nil
+}
+
+// resumeSession - session of refresh token must be active, its last seen time is updated
+// family of refresh tokens issued before sessions has no session and gets one, other errors of store are returned
+func (s *service) resumeSession(ctx context.Context, token *model.RefreshToken) error {
+	session, err := s.DBProvider.FindSessionByID(ctx, token.FamilyID)
+	switch {
+	case errors.Is(err, db.ErrDBNotFound):
+		return s.startSession(ctx, token.UserID, token.FamilyID, token.AuthTime)
+	case err != nil:
+		return err
+	}
+	if session.Revoked() || session.UserID != token.UserID {
+		return ErrServiceTokenRevoked
+	}
+	if err := s.DBProvider.TouchSession(ctx, session.ID, time.Now().UTC()); err != nil {
+		log.Printf("service: resumeSession TouchSession error - {%v};", err)
+	}
+	return nil
+}
+
+// sessionError - error of resumeSession or of store of sessions -> error for response
+// revoked session -> ErrServiceAuthorizationInvalid, lost database -> ErrServiceUnavailable
+func sessionError(err error) error {
+	switch {
+	case errors.Is(err, ErrServiceTokenRevoked):
+		return ErrServiceAuthorizationInvalid
+	case errors.Is(err, db.ErrDBUnavailable):
+		return ErrServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+	return ErrServiceInternal
+}
+
+// revokeSessions - revoke families of refresh tokens of revoked sessions
+// access tokens of the sessions are rejected by 'Authorization'
+func (s *service) revokeSessions(ctx context.Context, userID uint, sessionIDs []string, now time.Time) error {
+	s.revocation.markSessionsRevoked(userID, sessionIDs, now)
+	for _, sessionID := range sessionIDs {
+		if err := s.DBProvider.RevokeRefreshTokenFamily(ctx, sessionID, now); err != nil {
+			log.Printf("service: revokeSessions RevokeRefreshTokenFamily error - {%v};", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// contains confirmation of user for sensitive changes of account
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/config"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// ErrServiceStepUpRequired - sensitive change without the current password or valid step-up token
+var ErrServiceStepUpRequired = errors.New("current password or step-up token required")
+
+// defaultStepUpTTL - value of config.UserConfig.StepUpTTL if not set
+const defaultStepUpTTL = 5 * time.Minute
+
+// newStepUpTTL - TTL of config, default if not set
+func newStepUpTTL(cfg config.UserConfig) time.Duration {
+	if cfg.StepUpTTL == 0 {
+		return defaultStepUpTTL
+	}
+	return cfg.StepUpTTL
+}
+
+// StepUp - rules for confirmation of the current password
+// decode password from request
+// decode claims of the current token from ctx
+// check password of user, failed attempt is counted as failed login of email (see lockout.go)
+// create step-up token of the user and the current session
+func (s *service) StepUp(
+	ctx context.Context,
+	req *auth.StepUpRequest) (*auth.StepUpResponse, error) {
+	deserialize := deserializer.NewStepUpDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: StepUp IDDecode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	deserializeClaims := deserializer.NewClaimsDecode()
+	if err := deserializeClaims.Decode(ctx); err != nil {
+		log.Printf("service: StepUp ClaimsDecode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	if err := s.checkCurrentPassword(ctx, deserializeUserID.UserID(), deserialize.Password()); err != nil {
+		return nil, err
+	}
+
+	serialize := serializer.StepUpEncode{
+		ID:        deserializeUserID.UserID(),
+		SessionID: deserializeClaims.Claims().SessionID(),
+		TTL:       s.stepUpTTL,
+	}
+	stepUpResponse, err := serialize.Response()
+	if err != nil {
+		log.Printf("service: StepUp StepUpEncode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	return stepUpResponse, nil
+}
+
+// requireStepUpOnChange - update of login, email or password is confirmed by requireStepUp
+// fields with the current values are not a change, values are compared with user of version of update,
+// the update is written only for this version, so the user can't be changed between check and write
+func (s *service) requireStepUpOnChange(ctx context.Context, userNewData *model.User, fields []string) error {
+	if !slices.ContainsFunc(fields, isSensitive) {
+		return nil
+	}
+	userOldData, err := s.DBProvider.FindUserByID(ctx, userNewData.ID)
+	if err == nil && userOldData.Version == userNewData.Version && !sensitiveChange(userOldData, userNewData, fields) {
+		return nil
+	}
+	return s.requireStepUp(ctx)
+}
+
+// isSensitive - return true if change of field needs confirmation of user
+func isSensitive(field string) bool {
+	return field == model.UserFieldLogin || field == model.UserFieldEmail || field == model.UserFieldPassword
+}
+
+// sensitiveChange - return true if one of sensitive fields gets other value, password is always a change
+func sensitiveChange(userOldData, userNewData *model.User, fields []string) bool {
+	for _, field := range fields {
+		switch field {
+		case model.UserFieldLogin:
+			if userOldData.Login != userNewData.Login {
+				return true
+			}
+		case model.UserFieldEmail:
+			if userOldData.Email != userNewData.Email {
+				return true
+			}
+		case model.UserFieldPassword:
+			return true
+		}
+	}
+	return false
+}
+
+// requireStepUp - the current user confirms the change by metadata
+// "x-step-up-token" - token of StepUp for the same user and session, not expired
+// "x-current-password" - the current password, checked as in StepUp
+// no proof -> ErrServiceStepUpRequired
+func (s *service) requireStepUp(ctx context.Context) error {
+	deserialize := deserializer.NewStepUpProofDecode()
+	if err := deserialize.Decode(ctx); err != nil {
+		return err
+	}
+	deserializeUserID := deserializer.NewIDDecode()
+	if err := deserializeUserID.Decode(ctx); err != nil {
+		log.Printf("service: requireStepUp IDDecode error - {%v};", err)
+		return ErrServiceInternal
+	}
+	deserializeClaims := deserializer.NewClaimsDecode()
+	if err := deserializeClaims.Decode(ctx); err != nil {
+		log.Printf("service: requireStepUp ClaimsDecode error - {%v};", err)
+		return ErrServiceInternal
+	}
+
+	switch {
+	case deserialize.Token() != "":
+		return validStepUpToken(deserializeClaims.Claims(), deserialize.Token())
+	case deserialize.Password() != "":
+		return s.checkCurrentPassword(ctx, deserializeUserID.UserID(), deserialize.Password())
+	}
+	return ErrServiceStepUpRequired
+}
+
+// validStepUpToken - step-up token is valid only for subject and session of the access token
+// revoked session rejects the access token, so its step-up token is not accepted too
+func validStepUpToken(claims *jwtsign.Claims, token string) error {
+	stepUp, err := jwtsign.GetClaimsFromPurposeToken(token, model.PurposeStepUp)
+	if err != nil {
+		log.Printf("service: validStepUpToken GetClaimsFromPurposeToken error - {%v};", err)
+		return ErrServiceStepUpRequired
+	}
+	if stepUp.Subject != claims.Subject || stepUp.SessionID() == "" || stepUp.SessionID() != claims.SessionID() {
+		log.Printf("service: validStepUpToken token of other session - {%s};", stepUp.Subject)
+		return ErrServiceStepUpRequired
+	}
+	return nil
+}
+
+// checkCurrentPassword - compare password with password of user
+// lockout of email of user is shared with UserLogin, so the password can't be guessed by a stolen access token
+func (s *service) checkCurrentPassword(ctx context.Context, userID uint, pass string) error {
+	u, err := s.DBProvider.FindUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("service: checkCurrentPassword FindUserByID error - {%v};", err)
+		return ErrServiceNotFound
+	}
+	now := time.Now().UTC()
+	attempt, err := s.reserveLogin(ctx, foldEmail(u.Email), now)
+	if err != nil {
+		return err
+	}
+	if err := u.ValidPassword(pass); err != nil {
+		log.Printf("service: checkCurrentPassword ValidPassword error - {%v};", err)
+		s.loginFailed(ctx, attempt, now)
+		return ErrServicePasswordInvalid
+	}
+	s.loginSucceeded(ctx, attempt)
+	return nil
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	admin "github.com/Ekvo/go-postgres-grpc-user-dir/api/admin/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// UnlockUser - rules for unlock of login in Admin Service
+// decode email from the request
+// remove lock and failed logins of email, answer is the same for not existing email
+func (s *service) UnlockUser(
+	ctx context.Context,
+	req *admin.UnlockUserRequest) (*admin.UnlockUserResponse, error) {
+	deserialize := deserializer.NewUnlockUserDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.DBProvider.RemoveLoginAttempts(ctx, foldEmail(deserialize.Email)); err != nil {
+		log.Printf("service: UnlockUser RemoveLoginAttempts error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	return &admin.UnlockUserResponse{}, nil
+}
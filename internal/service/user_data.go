@@ -5,6 +5,8 @@ import (
 	"log"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
@@ -13,7 +15,7 @@ import (
 // UserData - get user data from database
 // get userID from ctx
 // find user by ID from database
-// create and return response
+// create and return response, version of user is in header "etag", state of email in header "email-verified"
 func (s *service) UserData(
 	ctx context.Context, req *user.UserDataRequest) (*user.UserDataResponse, error) {
 	deserialize := deserializer.NewIDDecode()
@@ -28,6 +30,13 @@ func (s *service) UserData(
 		return nil, ErrServiceNotFound
 	}
 
+	serializeETag := serializer.ETagEncode{Version: u.Version}
+	serializeVerified := serializer.EmailVerifiedEncode{Verified: u.EmailVerified()}
+	if err := grpc.SetHeader(ctx, metadata.Join(serializeETag.Header(), serializeVerified.Header())); err != nil {
+		log.Printf("service: UserData SetHeader error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
 	serialize := serializer.UserEncode{User: *u}
 
 	return serialize.Response(), nil
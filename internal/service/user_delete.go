@@ -2,29 +2,49 @@ package service
 
 import (
 	"context"
+	"errors"
 	"log"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
 
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
 )
 
 // UserDelete - rules for delete User
+// decode version of user from metadata "if-match"
 // decode the user ID from the ctx
-// remove user by ID from database
+// the current password or step-up token in metadata is required (see step_up.go)
+// remove user by ID and version from database, tokens of the user are rejected from now
 func (s *service) UserDelete(
 	ctx context.Context,
 	_ *user.UserDeleteRequest) (*user.UserDeleteResponse, error) {
+	deserializeVersion := deserializer.NewIfMatchDecode()
+	if err := deserializeVersion.Decode(ctx); err != nil {
+		return nil, err
+	}
+
 	deserialize := deserializer.NewIDDecode()
 	if err := deserialize.Decode(ctx); err != nil {
 		log.Printf("service: UserDelete Decode error - {%v};", err)
 		return nil, ErrServiceInternal
 	}
 
-	if err := s.DBProvider.RemoveUserByID(ctx, deserialize.UserID()); err != nil {
+	if err := s.requireStepUp(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.DBProvider.RemoveUserByID(ctx, deserialize.UserID(), deserializeVersion.Version()); err != nil {
 		log.Printf("service: UserDelete RemoveUserByID error - {%v};", err)
-		return nil, ErrServiceNotFound
+		switch {
+		case errors.Is(err, db.ErrDBVersionConflict):
+			return nil, ErrServiceVersionMismatch
+		case errors.Is(err, db.ErrDBNotFound):
+			return nil, ErrServiceNotFound
+		}
+		return nil, userWriteError(err)
 	}
+	s.revocation.markUserDeleted(deserialize.UserID())
 
 	return &user.UserDeleteResponse{}, nil
 }
@@ -3,17 +3,27 @@ package service
 import (
 	"context"
 	"log"
+	"time"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
+	"google.golang.org/grpc"
 
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/randtoken"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
 )
 
 // UserLogin - rules for entering the User Service
 // decode user from request
-// find user by email in database, then check password
-// create bearer token for response
+// reject login while email is locked after failed logins, else the attempt is counted before password (see lockout.go)
+// find user by email in database, then check password, outdated hash of password is replaced
+// not verified email is rejected if config.UserConfig.RequireVerifiedEmail
+// failed login is counted for existing and not existing email
+// user with TOTP gets challenge token of VerifyMFA and metadata "mfa-required: true" instead of session
+// start a new session with IP and user-agent of client
+// create bearer token for response, refresh token of a new family goes to the response header
 func (s *service) UserLogin(
 	ctx context.Context,
 	req *user.UserLoginRequest) (*user.UserLoginResponse, error) {
@@ -23,22 +33,105 @@ func (s *service) UserLogin(
 	}
 
 	login := deserialize.Model()
-	u, err := s.DBProvider.FindUserByEmail(ctx, login.Email)
+	now := time.Now().UTC()
+	email := foldEmail(login.Email)
+	attempt, err := s.reserveLogin(ctx, email, now)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.DBProvider.FindUserByEmail(ctx, email)
 	if err != nil {
 		log.Printf("service: UserLogin FindUserByEmail error - {%v};", err)
+		s.loginFailed(ctx, attempt, now)
 		return nil, ErrServiceNotFound
 	}
 	if err := u.ValidPassword(login.Password); err != nil {
 		log.Printf("service: UserLogin ValidPassword error - {%v};", err)
+		s.loginFailed(ctx, attempt, now)
 		return nil, ErrServicePasswordInvalid
 	}
+	mfa, err := s.DBProvider.FindMFA(ctx, u.ID)
+	if err != nil {
+		log.Printf("service: UserLogin FindMFA error - {%v};", err)
+		s.loginUndone(ctx, attempt)
+		return nil, mfaError(err, ErrServiceInternal)
+	}
+	// failed codes of VerifyMFA are counted with failed passwords, so the counter is reset only by the code
+	if mfa.Enabled() {
+		s.loginUndone(ctx, attempt)
+	} else {
+		s.loginSucceeded(ctx, attempt)
+	}
+	if u.PasswordOutdated() {
+		s.rehashPassword(ctx, u.ID, u.Password, login.Password)
+	}
+	// checked after password, so the answer does not show the state of email to others
+	if s.User.RequireVerifiedEmail && !u.EmailVerified() {
+		return nil, ErrServiceEmailNotVerified
+	}
+	if mfa.Enabled() {
+		return s.mfaChallenge(ctx, u)
+	}
 
-	serialize := serializer.LoginEncode{ID: u.ID}
+	serialize, err := s.startLogin(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
 	userLoginResponse, err := serialize.Response()
 	if err != nil {
 		log.Printf("service: UserLogin LoginEncode error - {%v};", err)
 		return nil, ErrServiceInternal
 	}
+	if err := grpc.SetHeader(ctx, serialize.Header()); err != nil {
+		log.Printf("service: UserLogin SetHeader error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
 
 	return userLoginResponse, nil
 }
+
+// startLogin - start a new session of user, the answer has data for access token and refresh token of a new family
+func (s *service) startLogin(ctx context.Context, userID uint) (*serializer.LoginEncode, error) {
+	// ID of session is the family of its refresh tokens
+	sessionID, err := randtoken.New()
+	if err != nil {
+		log.Printf("service: startLogin randtoken.New error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	authTime := time.Now().UTC().Truncate(time.Second)
+	if err := s.startSession(ctx, userID, sessionID, authTime); err != nil {
+		return nil, ErrServiceInternal
+	}
+	refreshToken, err := s.issueRefreshToken(ctx, s.DBProvider, userID, sessionID, authTime)
+	if err != nil {
+		return nil, ErrServiceInternal
+	}
+	return &serializer.LoginEncode{ID: userID, SessionID: sessionID, AuthTime: authTime, RefreshToken: refreshToken}, nil
+}
+
+// mfaChallenge - answer of UserLogin for user with TOTP, session is started by VerifyMFA
+func (s *service) mfaChallenge(ctx context.Context, u *model.User) (*user.UserLoginResponse, error) {
+	token, err := s.createMFAChallenge(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	serialize := serializer.MFAChallengeEncode{Token: token}
+	if err := grpc.SetHeader(ctx, serialize.Header()); err != nil {
+		log.Printf("service: UserLogin SetHeader error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	return serialize.Response(), nil
+}
+
+// rehashPassword - replace outdated hash (bcrypt or old parameters) with hash of the current algorithm
+// login is not rejected if the hash can't be replaced, it is tried again on the next login
+func (s *service) rehashPassword(ctx context.Context, userID uint, oldHash, pass string) {
+	newHash, err := password.Hash(pass)
+	if err != nil {
+		log.Printf("service: rehashPassword password.Hash error - {%v};", err)
+		return
+	}
+	if err := s.DBProvider.UpdatePasswordHash(ctx, userID, oldHash, newHash); err != nil {
+		log.Printf("service: rehashPassword UpdatePasswordHash error - {%v};", err)
+	}
+}
@@ -6,36 +6,41 @@ import (
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
 
-	"golang.org/x/crypto/bcrypt"
-
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
 )
 
 // UserRegister - rules for creating a new user in User Srvice
 // decode the user from the request
 // create a hashed password for the user
-// write the user to the database
+// write the user to the database, send mail with token of email verification
 // return the new user ID
 func (s *service) UserRegister(
 	ctx context.Context,
 	req *user.UserRegisterRequest) (*user.UserRegisterResponse, error) {
-	deserialize := deserializer.NewUserDecode()
+	deserialize := deserializer.NewUserDecode(s.User.ClientTimestamps)
 	if err := deserialize.Decode(req); err != nil {
 		return nil, err
 	}
 
 	u := deserialize.Model()
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPassword, err := password.Hash(u.Password)
 	if err != nil {
-		log.Printf("service: UserRegister GenerateFromPassword - error {%v};", err)
+		log.Printf("service: UserRegister password.Hash - error {%v};", err)
 		return nil, ErrServiceInternal
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 
 	id, err := s.DBProvider.CreateUser(ctx, u)
 	if err != nil {
 		log.Printf("service: UserRegister CreateUser - error {%v};", err)
-		return nil, ErrServiceAlreadyExists
+		return nil, userWriteError(err)
+	}
+	u.ID = id
+
+	// user is registered even if mail is not sent, SendEmailVerification sends it again
+	if err := s.sendEmailVerification(ctx, u); err != nil {
+		log.Printf("service: UserRegister sendEmailVerification - error {%v};", err)
 	}
 
 	return &user.UserRegisterResponse{UserId: uint64(id)}, nil
@@ -2,24 +2,43 @@ package service
 
 import (
 	"context"
+	"errors"
 	"log"
-
-	"golang.org/x/crypto/bcrypt"
+	"slices"
 
 	user "github.com/Ekvo/go-grpc-apis/user/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/password"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
 	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
 )
 
 // UserUpdate - rules for update User data
+// decode version of user from metadata "if-match"
+// decode update mask from metadata, without mask all fields are updated
 // decode the new user data from request
 // decode user ID from ctx
-// call userUpdate
+// change of login, email or password needs the current password or step-up token in metadata (see step_up.go)
+// call userUpdate, new version of user is in header "etag"
+// new email waits for confirmation, it is in header "pending-email", mails are sent to the new and the current email
 func (s *service) UserUpdate(
 	ctx context.Context,
 	req *user.UserUpdateRequest) (*user.UserUpdateResponse, error) {
-	deserializeUserData := deserializer.NewUserUpdateDecode()
+	deserializeVersion := deserializer.NewIfMatchDecode()
+	if err := deserializeVersion.Decode(ctx); err != nil {
+		return nil, err
+	}
+
+	deserializeMask := deserializer.NewUpdateMaskDecode()
+	if err := deserializeMask.Decode(ctx); err != nil {
+		return nil, err
+	}
+
+	deserializeUserData := deserializer.NewUserUpdateDecode(s.User.ClientTimestamps, deserializeMask.Paths()...)
 	if err := deserializeUserData.Decode(req); err != nil {
 		return nil, err
 	}
@@ -32,46 +51,106 @@ func (s *service) UserUpdate(
 
 	userNewData := deserializeUserData.Model()
 	userNewData.ID = deserializeUserID.UserID()
+	userNewData.Version = deserializeVersion.Version()
 
-	if err := s.userUpdate(ctx, userNewData); err != nil {
+	if err := s.requireStepUpOnChange(ctx, userNewData, deserializeUserData.Fields()); err != nil {
 		return nil, err
 	}
-	return &user.UserUpdateResponse{}, nil
-}
 
-// userUpdate - prepares and writes user data to the database
-// gets user data from the database by ID
-// new password is not empty -> create hashedPassword
-// creates a user for writing to the database (NewData) -> (internal/model/user.go)
-// updates user data in the storage
-func (s *service) userUpdate(ctx context.Context, userNewData *model.User) error {
-	userOldData, err := s.DBProvider.FindUserByID(ctx, userNewData.ID)
+	change, err := s.userUpdate(ctx, userNewData, deserializeUserData.Fields(), deserializeUserData.ValidPolicy)
 	if err != nil {
-		log.Printf("service: userUpdate FindUserByID error - {%v};", err)
-		return ErrServiceNotFound
+		return nil, err
 	}
 
-	if err := userOldData.ValidUpdate(userNewData); err != nil {
-		log.Printf("service: userUpdate ValidUpdate error - {%v};", err)
-		return ErrServiceUpdateDataInvalid
+	serialize := serializer.ETagEncode{Version: userNewData.Version}
+	header := serialize.Header()
+	if change != nil {
+		s.sendEmailChange(ctx, change)
+		serializePending := serializer.PendingEmailEncode{Email: change.newEmail}
+		header = metadata.Join(header, serializePending.Header())
 	}
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		log.Printf("service: UserUpdate SetHeader error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	return &user.UserUpdateResponse{}, nil
+}
 
-	if userNewData.Password == "" {
-		userNewData.Password = userOldData.Password
-	} else {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userNewData.Password), bcrypt.DefaultCost)
+// userUpdate - prepares and writes user data to the database
+// password is one of fields -> create hashedPassword, before transaction, hash is slow
+// in one transaction:
+// gets user data from the database by ID, version of user should be version of userNewData
+// new password is checked by 'validPolicy' with data of the stored user
+// other email is not written, it is pending with token of confirmation (see email_change.go), the change is returned
+// updates only 'fields' of user data in the storage, if version was not changed since the user was read
+func (s *service) userUpdate(
+	ctx context.Context,
+	userNewData *model.User,
+	fields []string,
+	validPolicy func(u *model.User) error) (*emailChange, error) {
+	if slices.Contains(fields, model.UserFieldPassword) {
+		hashedPassword, err := password.Hash(userNewData.Password)
 		if err != nil {
-			log.Printf("service: userUpdate GenerateFromPassword - error {%v};", err)
-			return ErrServiceInternal
+			log.Printf("service: userUpdate password.Hash - error {%v};", err)
+			return nil, ErrServiceInternal
 		}
-		userNewData.Password = string(hashedPassword)
+		userNewData.Password = hashedPassword
 	}
-	userNewData.CreatedAt = userOldData.CreatedAt
 
-	if err := s.DBProvider.UpdateUser(ctx, userNewData); err != nil {
-		log.Printf("service: userUpdate UpdateUser error - {%v};", err)
-		return ErrServiceInternal
-	}
+	// errors of database are returned from transaction as is, so it can be repeated
+	notFound := false
+	var change *emailChange
+	err := s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		change = nil
+		userOldData, err := tx.FindUserByID(ctx, userNewData.ID)
+		if notFound = err != nil; notFound {
+			return err
+		}
+
+		if userOldData.Version != userNewData.Version {
+			log.Printf("service: userUpdate version error - {%d != %d};", userOldData.Version, userNewData.Version)
+			return ErrServiceVersionMismatch
+		}
+
+		if err := userOldData.ValidUpdate(userNewData); err != nil {
+			log.Printf("service: userUpdate ValidUpdate error - {%v};", err)
+			return ErrServiceUpdateDataInvalid
+		}
 
-	return nil
+		if err := validPolicy(userOldData); err != nil {
+			return err
+		}
+
+		writeFields := fields
+		if slices.Contains(fields, model.UserFieldEmail) && userNewData.Email != userOldData.Email {
+			if change, err = s.writeEmailChange(ctx, tx, userOldData, userNewData.Email); err != nil {
+				return err
+			}
+			userNewData.Email = userOldData.Email
+			writeFields = slices.DeleteFunc(slices.Clone(fields), func(field string) bool {
+				return field == model.UserFieldEmail
+			})
+			// UpdateUser without fields writes all of them, so only email is not a write of user
+			if len(writeFields) == 0 {
+				return nil
+			}
+		}
+
+		return tx.UpdateUser(ctx, userNewData, writeFields...)
+	})
+	var validationErr *deserializer.ValidationError
+	switch {
+	case err == nil:
+		return change, nil
+	case errors.As(err, &validationErr):
+		return nil, err
+	case notFound:
+		log.Printf("service: userUpdate FindUserByID error - {%v};", err)
+		return nil, ErrServiceNotFound
+	case errors.Is(err, ErrServiceVersionMismatch), errors.Is(err, ErrServiceUpdateDataInvalid),
+		errors.Is(err, ErrServiceAlreadyExists), errors.Is(err, ErrServiceInternal):
+		return nil, err
+	}
+	log.Printf("service: userUpdate UpdateUser error - {%v};", err)
+	return nil, userWriteError(err)
 }
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+)
+
+// VerifyEmail - rules for confirmation of email
+// decode token from request, check its signature, expiration and purpose
+// in one transaction: use record of token (once) and mark email of record as verified
+// email of user changed after sending -> ErrServiceTokenInvalid
+func (s *service) VerifyEmail(
+	ctx context.Context,
+	req *auth.VerifyEmailRequest) (*auth.VerifyEmailResponse, error) {
+	deserialize := deserializer.NewVerifyEmailDecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	claims, err := jwtsign.GetClaimsFromPurposeToken(deserialize.Token(), model.PurposeVerifyEmail)
+	if err != nil {
+		log.Printf("service: VerifyEmail GetClaimsFromPurposeToken error - {%v};", err)
+		return nil, ErrServiceTokenInvalid
+	}
+
+	now := time.Now().UTC()
+	err = s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		token, err := tx.UseOneTimeToken(ctx, claims.ID, model.PurposeVerifyEmail, now)
+		if err != nil {
+			return err
+		}
+		if strconv.FormatUint(uint64(token.UserID), 10) != claims.Subject {
+			return ErrServiceTokenInvalid
+		}
+		return tx.SetEmailVerified(ctx, token.UserID, token.Email, now)
+	})
+	if err != nil {
+		log.Printf("service: VerifyEmail error - {%v};", err)
+		return nil, oneTimeTokenError(err)
+	}
+
+	return &auth.VerifyEmailResponse{}, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+
+	auth "github.com/Ekvo/go-postgres-grpc-user-dir/api/auth/v1"
+
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/db"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/lib/jwtsign"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/model"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/deserializer"
+	"github.com/Ekvo/go-postgres-grpc-user-dir/internal/service/serializer"
+)
+
+// VerifyMFA - rules for the second step of login of user with TOTP
+// decode challenge token and code or recovery code from request, check signature, expiration and purpose of token
+// reject while email of user is locked after failed logins (see lockout.go)
+// in one transaction: use record of challenge (once), email of user is the email of login, use code
+// wrong code rolls back the transaction, so the challenge is kept, and is counted as failed login of email
+// start a new session as UserLogin, refresh token goes to the response header
+func (s *service) VerifyMFA(
+	ctx context.Context,
+	req *auth.VerifyMFARequest) (*auth.VerifyMFAResponse, error) {
+	deserialize := deserializer.NewVerifyMFADecode()
+	if err := deserialize.Decode(req); err != nil {
+		return nil, err
+	}
+
+	claims, err := jwtsign.GetClaimsFromPurposeToken(deserialize.Token(), model.PurposeMFAChallenge)
+	if err != nil {
+		log.Printf("service: VerifyMFA GetClaimsFromPurposeToken error - {%v};", err)
+		return nil, ErrServiceTokenInvalid
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 0)
+	if err != nil {
+		log.Printf("service: VerifyMFA ParseUint error - {%v};", err)
+		return nil, ErrServiceTokenInvalid
+	}
+	u, err := s.DBProvider.FindUserByID(ctx, uint(userID))
+	if err != nil {
+		log.Printf("service: VerifyMFA FindUserByID error - {%v};", err)
+		return nil, ErrServiceTokenInvalid
+	}
+
+	now := time.Now().UTC()
+	attempt, err := s.reserveLogin(ctx, foldEmail(u.Email), now)
+	if err != nil {
+		return nil, err
+	}
+	err = s.DBProvider.WithTx(ctx, func(tx db.Provider) error {
+		token, err := tx.UseOneTimeToken(ctx, claims.ID, model.PurposeMFAChallenge, now)
+		if err != nil {
+			return err
+		}
+		if token.UserID != u.ID || token.Email != u.Email {
+			return ErrServiceTokenInvalid
+		}
+		mfa, err := tx.FindMFA(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		if !mfa.Enabled() {
+			return ErrServiceMFANotEnabled
+		}
+		return s.useMFACode(ctx, tx, mfa, deserialize.Code(), deserialize.RecoveryCode(), now)
+	})
+	switch {
+	case errors.Is(err, ErrServiceMFACodeInvalid):
+		s.loginFailed(ctx, attempt, now)
+		return nil, err
+	case errors.Is(err, ErrServiceMFANotEnabled), errors.Is(err, ErrServiceMFANotConfigured), errors.Is(err, ErrServiceInternal):
+		s.loginUndone(ctx, attempt)
+		return nil, err
+	case err != nil:
+		log.Printf("service: VerifyMFA error - {%v};", err)
+		s.loginUndone(ctx, attempt)
+		return nil, oneTimeTokenError(err)
+	}
+	s.loginSucceeded(ctx, attempt)
+
+	login, err := s.startLogin(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	serialize := serializer.VerifyMFAEncode{LoginEncode: *login}
+	verifyMFAResponse, err := serialize.Response()
+	if err != nil {
+		log.Printf("service: VerifyMFA VerifyMFAEncode error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+	if err := grpc.SetHeader(ctx, serialize.Header()); err != nil {
+		log.Printf("service: VerifyMFA SetHeader error - {%v};", err)
+		return nil, ErrServiceInternal
+	}
+
+	return verifyMFAResponse, nil
+}
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_btree_index ON revoked_tokens (expires_at);
\ No newline at end of file
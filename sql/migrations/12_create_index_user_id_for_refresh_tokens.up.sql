@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_btree_index ON refresh_tokens (user_id);
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    client_ip VARCHAR(64) NOT NULL,
+    user_agent VARCHAR(512) NOT NULL,
+    revoked_at TIMESTAMP NULL
+);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS sessions_user_id_btree_index ON sessions (user_id);
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS login_attempts_last_failed_at_btree_index ON login_attempts (last_failed_at);
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(255) NOT NULL,
+    scope VARCHAR(512) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    response BYTEA NULL,
+    header JSONB NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ NULL,
+    PRIMARY KEY (key, scope)
+);
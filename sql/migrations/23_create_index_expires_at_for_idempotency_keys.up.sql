@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_btree_index ON idempotency_keys (expires_at);
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS one_time_tokens_user_id_purpose_btree_index ON one_time_tokens (user_id, purpose);
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    user_id INTEGER NOT NULL REFERENCES user_mfa (user_id) ON DELETE CASCADE,
+    hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS users_lower_email_btree_index ON users (lower(email));
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS family_id_btree_index ON refresh_tokens (family_id);
\ No newline at end of file